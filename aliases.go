@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+var (
+	// toolAliases maps an alias to the tool it stands for (--tool-alias).
+	toolAliases = map[string]string{}
+	// toolAliasMode is "add" to list aliases next to the tools they stand
+	// for, or "replace" to list only the aliases (--tool-alias-mode).
+	// Either name can be called in both modes.
+	toolAliasMode = "add"
+)
+
+// toolNamePattern is what MCP clients reliably accept as a tool name.
+var toolNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// parseToolAlias parses an --tool-alias ALIAS=TOOL value.
+func parseToolAlias(value string) (alias, tool string, err error) {
+	alias, tool, ok := strings.Cut(value, "=")
+	alias, tool = strings.TrimSpace(alias), strings.TrimSpace(tool)
+	if !ok || alias == "" || tool == "" {
+		return "", "", errors.New("expected ALIAS=TOOL")
+	}
+	if !toolNamePattern.MatchString(alias) {
+		return "", "", fmt.Errorf("alias %q must be 1 to 64 letters, digits, _ or -", alias)
+	}
+	return alias, tool, nil
+}
+
+// applyToolAliases checks the aliases against the server's tools and
+// installs the middleware that lists them and routes calls to them. Calls
+// are rewritten to the tool's own name, so the history records it, and
+// the alias shares that tool's schemas.
+func applyToolAliases(srv *mcp.Server) error {
+	tools, err := listTools(srv)
+	if err != nil {
+		return err
+	}
+	for alias, tool := range toolAliases {
+		if slices.ContainsFunc(tools, func(t *mcp.Tool) bool { return t.Name == alias }) {
+			return fmt.Errorf("alias %q is already the name of a tool", alias)
+		}
+		if !slices.ContainsFunc(tools, func(t *mcp.Tool) bool { return t.Name == tool }) {
+			return fmt.Errorf("alias %q: unknown tool %q", alias, tool)
+		}
+	}
+
+	srv.AddReceivingMiddleware(func(next mcp.MethodHandler[*mcp.ServerSession]) mcp.MethodHandler[*mcp.ServerSession] {
+		return func(ctx context.Context, ss *mcp.ServerSession, method string, params mcp.Params) (mcp.Result, error) {
+			if p, ok := params.(*mcp.CallToolParamsFor[json.RawMessage]); ok && method == "tools/call" {
+				if tool, ok := toolAliases[p.Name]; ok {
+					p.Name = tool
+				}
+			}
+			result, err := next(ctx, ss, method, params)
+			if r, ok := result.(*mcp.ListToolsResult); ok && err == nil {
+				r.Tools = aliasTools(r.Tools)
+			}
+			return result, err
+		}
+	})
+	return nil
+}
+
+// aliasTools adds an entry for every alias to a tools/list page, right
+// after the tool it stands for, and drops that tool in replace mode.
+func aliasTools(tools []*mcp.Tool) []*mcp.Tool {
+	var listed []*mcp.Tool
+	for _, t := range tools {
+		var aliases []string
+		for alias, tool := range toolAliases {
+			if tool == t.Name {
+				aliases = append(aliases, alias)
+			}
+		}
+		if len(aliases) == 0 || toolAliasMode == "add" {
+			listed = append(listed, t)
+		}
+		slices.Sort(aliases)
+		for _, alias := range aliases {
+			// A shallow copy shares the schemas and annotations.
+			a := *t
+			a.Name = alias
+			listed = append(listed, &a)
+		}
+	}
+	return listed
+}
+
+// listTools lists the server's tools through an in-memory session.
+func listTools(srv *mcp.Server) ([]*mcp.Tool, error) {
+	ctx := context.Background()
+	clientTransport, serverTransport := mcp.NewInMemoryTransports()
+	ss, err := srv.Connect(ctx, serverTransport)
+	if err != nil {
+		return nil, err
+	}
+	defer ss.Close()
+	cs, err := mcp.NewClient(&mcp.Implementation{Name: "tool-alias-check"}, nil).Connect(ctx, clientTransport)
+	if err != nil {
+		return nil, err
+	}
+	defer cs.Close()
+	var tools []*mcp.Tool
+	for t, err := range cs.Tools(ctx, nil) {
+		if err != nil {
+			return nil, err
+		}
+		tools = append(tools, t)
+	}
+	return tools, nil
+}
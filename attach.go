@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+const (
+	// DEFAULT_MAX_ATTACHMENT_BYTES and DEFAULT_MAX_ATTACHMENTS_TOTAL are the
+	// defaults for --max-attachment-bytes and --max-attachments-total.
+	DEFAULT_MAX_ATTACHMENT_BYTES  = 256 << 10
+	DEFAULT_MAX_ATTACHMENTS_TOTAL = 1 << 20
+
+	// BINARY_SNIFF_LENGTH is how much of a file is checked for NUL bytes.
+	BINARY_SNIFF_LENGTH = 8000
+
+	// CLIENT_ROOTS_TIMEOUT bounds the roots/list request; clients without
+	// roots support may never answer it.
+	CLIENT_ROOTS_TIMEOUT = 2 * time.Second
+)
+
+var (
+	// attachmentRoots are the directories attachments may be read from
+	// (--attachment-root, default the working directory).
+	attachmentRoots []string
+	// attachmentRootsDefaulted is set when attachmentRoots is just the
+	// working directory, which relative paths must not silently use.
+	attachmentRootsDefaulted = false
+	maxAttachmentBytes       = DEFAULT_MAX_ATTACHMENT_BYTES
+	maxAttachmentsTotal      = DEFAULT_MAX_ATTACHMENTS_TOTAL
+)
+
+// Attachment is a file, or a range of its lines, to append to the prompt.
+type Attachment struct {
+	Path      string `json:"path" jsonschema:"file to attach, absolute or relative to the first attachment root"`
+	Language  string `json:"language,omitempty" jsonschema:"code block language; guessed from the file extension when omitted"`
+	StartLine int    `json:"startLine,omitempty" jsonschema:"first line to include (1-based)"`
+	EndLine   int    `json:"endLine,omitempty" jsonschema:"last line to include"`
+}
+
+// attachmentLanguages maps file extensions to code block languages.
+var attachmentLanguages = map[string]string{
+	".go": "go", ".py": "python", ".js": "javascript", ".ts": "typescript", ".tsx": "tsx", ".jsx": "jsx",
+	".rs": "rust", ".java": "java", ".kt": "kotlin", ".c": "c", ".h": "c", ".cc": "cpp", ".cpp": "cpp",
+	".cs": "csharp", ".rb": "ruby", ".php": "php", ".swift": "swift", ".sh": "bash", ".sql": "sql",
+	".json": "json", ".yaml": "yaml", ".yml": "yaml", ".toml": "toml", ".xml": "xml", ".html": "html",
+	".css": "css", ".md": "markdown", ".diff": "diff", ".patch": "diff",
+}
+
+// resolveReadPath turns path into an absolute, symlink-free path inside one
+// of roots and returns it with the root that contains it.
+func resolveReadPath(path string, roots []string, what string) (string, string, error) {
+	if len(roots) == 0 {
+		return "", "", fmt.Errorf("no %s root configured", what)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(roots[0], path)
+	}
+	real, err := filepath.EvalSymlinks(filepath.Clean(path))
+	if err != nil {
+		return "", "", err
+	}
+	root := withinRoots(real, roots)
+	if root == "" {
+		return "", "", fmt.Errorf("outside the %s roots (%s)", what, strings.Join(roots, ", "))
+	}
+	return real, root, nil
+}
+
+// readTextFile reads a regular UTF-8 text file of at most limit bytes.
+func readTextFile(path string, limit int) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil {
+		return "", err
+	} else if !info.Mode().IsRegular() {
+		return "", errors.New("not a regular file")
+	}
+	data, err := io.ReadAll(io.LimitReader(f, int64(limit)+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > limit {
+		return "", fmt.Errorf("larger than the %d byte limit", limit)
+	}
+	if bytes.IndexByte(data[:min(len(data), BINARY_SNIFF_LENGTH)], 0) >= 0 || !utf8.Valid(data) {
+		return "", errors.New("looks like a binary file, not UTF-8 text")
+	}
+	return strings.ReplaceAll(string(data), "\r\n", "\n"), nil
+}
+
+// renderAttachments reads the attachments and formats them as fenced code
+// blocks, each under a header naming the file and line range. Any
+// unreadable attachment fails the whole set.
+func renderAttachments(ctx context.Context, attachments []Attachment) (string, error) {
+	s := settingsFor(ctx)
+	var b strings.Builder
+	total := 0
+	for _, a := range attachments {
+		fail := func(err error) (string, error) {
+			return "", fmt.Errorf("attachment %s: %w", a.Path, err)
+		}
+		if strings.TrimSpace(a.Path) == "" {
+			return "", errors.New("attachment path is required")
+		}
+		path, root, err := resolveReadPath(a.Path, attachmentRoots, "attachment")
+		if err != nil {
+			return fail(err)
+		}
+		text, err := readTextFile(path, s.MaxAttachmentBytes)
+		if err != nil {
+			return fail(err)
+		}
+
+		lines := strings.SplitAfter(strings.TrimSuffix(text, "\n"), "\n")
+		start, end := a.StartLine, a.EndLine
+		switch {
+		case start < 0 || end < 0:
+			return fail(errors.New("line numbers must be positive"))
+		case start == 0 && end == 0:
+		case start > len(lines):
+			return fail(fmt.Errorf("startLine %d is past the end of the file (%d lines)", start, len(lines)))
+		case end != 0 && end < max(start, 1):
+			return fail(fmt.Errorf("endLine %d is before startLine %d", end, start))
+		}
+		rangeNote := ""
+		if start != 0 || end != 0 {
+			start = max(start, 1)
+			if end == 0 || end > len(lines) {
+				end = len(lines)
+			}
+			text = strings.Join(lines[start-1:end], "")
+			rangeNote = fmt.Sprintf(" (lines %d-%d)", start, end)
+			if start == end {
+				rangeNote = fmt.Sprintf(" (line %d)", start)
+			}
+		}
+
+		total += len(text)
+		if total > s.MaxAttachmentsTotal {
+			return fail(fmt.Errorf("attachments exceed the %d byte total limit", s.MaxAttachmentsTotal))
+		}
+
+		lang := a.Language
+		if lang == "" {
+			lang = attachmentLanguages[strings.ToLower(filepath.Ext(path))]
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			name = path
+		}
+		fence := markdownFence(text)
+		fmt.Fprintf(&b, "\n\nFile: %s%s\n%s%s\n%s\n%s", filepath.ToSlash(name), rangeNote, fence, lang, strings.TrimRight(text, "\n"), fence)
+	}
+	return b.String(), nil
+}
+
+// clientRoots returns the client's file roots as local paths, or nil when
+// the client has none or does not support roots, or there is no client
+// (the send subcommand).
+func clientRoots(ctx context.Context, ss *mcp.ServerSession) []string {
+	if ss == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, CLIENT_ROOTS_TIMEOUT)
+	defer cancel()
+	res, err := ss.ListRoots(ctx, nil)
+	if err != nil {
+		return nil
+	}
+	var roots []string
+	for _, r := range res.Roots {
+		u, err := url.Parse(r.URI)
+		if err != nil || u.Scheme != "file" {
+			continue
+		}
+		roots = append(roots, rootPath(u.Path, runtime.GOOS))
+	}
+	return roots
+}
+
+// rootPath turns the path of a file:// URI into a local path. On Windows,
+// file:///C:/src has the path /C:/src, whose leading slash must go.
+func rootPath(p, goos string) string {
+	if goos == "windows" {
+		if len(p) >= 3 && p[0] == '/' && p[2] == ':' && 'a' <= p[1]|0x20 && p[1]|0x20 <= 'z' {
+			p = p[1:]
+		}
+		return strings.ReplaceAll(p, "/", `\`)
+	}
+	return p
+}
+
+// allowedRoots is what a call may read from: the client's roots, which
+// relative paths resolve against, and the attachment roots.
+func allowedRoots(client []string) []string {
+	return append(slices.Clone(client), attachmentRoots...)
+}
+
+// readPromptFile reads a promptFile with the attachment limits. A relative
+// path resolves against the client's first root, or else an explicit
+// --attachment-root, never silently against the working directory. The
+// file may lie in any client root or attachment root.
+func readPromptFile(ctx context.Context, ss *mcp.ServerSession, path string) (string, string, error) {
+	roots := clientRoots(ctx, ss)
+	if !filepath.IsAbs(path) {
+		base := ""
+		if len(roots) > 0 {
+			base = roots[0]
+		} else if !attachmentRootsDefaulted {
+			base = attachmentRoots[0]
+		} else {
+			return "", "", fmt.Errorf("promptFile %s is relative, but the client has no roots and no --attachment-root is set; pass an absolute path", path)
+		}
+		path = filepath.Join(base, path)
+	}
+	real, _, err := resolveReadPath(path, allowedRoots(roots), "client and attachment")
+	if err != nil {
+		return "", "", fmt.Errorf("promptFile %s: %w", path, err)
+	}
+	text, err := readTextFile(real, settingsFor(ctx).MaxAttachmentBytes)
+	if err != nil {
+		return "", "", fmt.Errorf("promptFile %s: %w", path, err)
+	}
+	return text, real, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestRootPath(t *testing.T) {
+	tests := []struct {
+		uri, goos, want string
+	}{
+		{"file:///home/u/src", "linux", "/home/u/src"},
+		{"file:///C:/x", "linux", "/C:/x"},
+		{"file:///C:/Users/u/src", "windows", `C:\Users\u\src`},
+		{"file:///c%3A/Users/u", "windows", `c:\Users\u`},
+		{"file:///d:", "windows", `d:`},
+		{"file:///1:/x", "windows", `\1:\x`},
+		{"file:///Users/u/My%20Project", "darwin", "/Users/u/My Project"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rootPath(u.Path, tt.goos); got != tt.want {
+			t.Errorf("rootPath(%s) on %s = %q, want %q", tt.uri, tt.goos, got, tt.want)
+		}
+	}
+}
+
+// withClientRoot connects a client whose only root is dir and returns the
+// server's side of the session.
+func withClientRoot(t *testing.T, dir string) *mcp.ServerSession {
+	t.Helper()
+	ctx := context.Background()
+	clientTransport, serverTransport := mcp.NewInMemoryTransports()
+	ss, err := buildServer().Connect(ctx, serverTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ss.Close() })
+	client := mcp.NewClient(&mcp.Implementation{Name: "test"}, nil)
+	client.AddRoots(&mcp.Root{URI: (&url.URL{Scheme: "file", Path: filepath.ToSlash(dir)}).String()})
+	cs, err := client.Connect(ctx, clientTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cs.Close() })
+	return ss
+}
+
+func TestClientRootsAreReadable(t *testing.T) {
+	oldRoots, oldDefaulted := attachmentRoots, attachmentRootsDefaulted
+	t.Cleanup(func() { attachmentRoots, attachmentRootsDefaulted = oldRoots, oldDefaulted })
+	// The server's working directory, unrelated to the client's project.
+	attachmentRoots, attachmentRootsDefaulted = []string{t.TempDir()}, true
+
+	project, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(project, "prompt.md"), []byte("from the project"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	elsewhere := filepath.Join(t.TempDir(), "other.md")
+	if err := os.WriteFile(elsewhere, []byte("not shared"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ss := withClientRoot(t, project)
+	ctx := context.Background()
+
+	for _, path := range []string{"prompt.md", filepath.Join(project, "prompt.md")} {
+		text, real, err := readPromptFile(ctx, ss, path)
+		if err != nil || text != "from the project" || real != filepath.Join(project, "prompt.md") {
+			t.Errorf("readPromptFile(%s) = %q, %s, %v", path, text, real, err)
+		}
+	}
+	if _, _, err := readPromptFile(ctx, ss, elsewhere); err == nil || !strings.Contains(err.Error(), "outside the client and attachment roots") {
+		t.Errorf("reading outside every root gave %v", err)
+	}
+
+	if dir, err := gitDiffDir(ctx, ss, ""); err != nil || dir != project {
+		t.Errorf("gitDiffDir = %s, %v; want the client root %s", dir, err, project)
+	}
+	if _, err := gitDiffDir(ctx, ss, filepath.Dir(elsewhere)); err == nil {
+		t.Error("gitDiffDir accepted a directory outside every root")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// AUTO_SUBMIT_SETTLE is how long to wait after activating the app before
+// checking that it is frontmost and sending keystrokes.
+const AUTO_SUBMIT_SETTLE = 700 * time.Millisecond
+
+// errAccessibility explains the one failure users can fix themselves.
+var errAccessibility = errors.New("macOS blocked the keystrokes because Accessibility permission is not granted; " +
+	"enable it for the app running this server (your terminal or MCP client) in System Settings > Privacy & Security > Accessibility")
+
+// autoSubmit activates the ChatGPT desktop app, pastes the clipboard, and
+// presses Return. It refuses to type anything unless the app is frontmost
+// after activation, so keystrokes never land in another window.
+func autoSubmit() error {
+	if runtime.GOOS != "darwin" {
+		return errors.New("auto-submit is only supported on macOS")
+	}
+	app := targetApp
+	if app == "" {
+		app = DEFAULT_MAC_APP
+	}
+	if !macAppInstalled(app) {
+		return fmt.Errorf("%s app is not installed", app)
+	}
+
+	if _, err := osascript(`tell application "` + app + `" to activate`); err != nil {
+		return fmt.Errorf("activating %s: %w", app, err)
+	}
+	time.Sleep(AUTO_SUBMIT_SETTLE)
+
+	front, err := osascript(`tell application "System Events" to get name of first application process whose frontmost is true`)
+	if err != nil {
+		return err
+	}
+	if front != app {
+		return fmt.Errorf("%s did not come to the front (frontmost app is %q); nothing was typed", app, front)
+	}
+
+	_, err = osascript(`tell application "System Events"
+	keystroke "v" using command down
+	delay 0.3
+	key code 36
+end tell`)
+	return err
+}
+
+// osascript runs an AppleScript snippet and returns its trimmed output,
+// mapping the Accessibility denial to errAccessibility.
+func osascript(script string) (string, error) {
+	out, err := commandOutput(context.Background(), 1, exec.Command("osascript", "-e", script))
+	if err != nil {
+		msg := commandStderr(err)
+		// -1719 and -25211 are the "assistive access" errors; older macOS
+		// versions report "not allowed to send keystrokes".
+		if strings.Contains(msg, "-1719") || strings.Contains(msg, "-25211") ||
+			strings.Contains(msg, "not allowed to send keystrokes") || strings.Contains(msg, "assistive access") {
+			return "", errAccessibility
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestAutoSubmitOnlyWhenOpening(t *testing.T) {
+	withFileClipboard(t)
+	t.Setenv("PATH", "")
+	submit := true
+
+	for _, deliver := range []string{"copy-link", "both"} {
+		res, err := handleHandoff(context.Background(), nil, &mcp.CallToolParamsFor[HandoffArgs]{
+			Name:      "handoff_to_chatgpt",
+			Arguments: HandoffArgs{Prompt: "submit me", Deliver: deliver, AutoSubmit: &submit},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, ok := res.StructuredContent.(*HandoffResult)
+		if !ok {
+			t.Fatalf("deliver %s: result is %T: %v", deliver, res.StructuredContent, res.Content)
+		}
+		if r.Submission != "" || r.DeeplinkStatus != "skipped-by-request" {
+			t.Errorf("deliver %s: submission %q, deeplinkStatus %q; want no submission and skipped-by-request", deliver, r.Submission, r.DeeplinkStatus)
+		}
+		for _, w := range r.Warnings {
+			if strings.Contains(w, "auto-submit") {
+				t.Errorf("deliver %s: %s", deliver, w)
+			}
+		}
+	}
+
+	for deliver, want := range map[string]string{
+		"open":      "skipped-auto-submit",
+		"copy-link": "skipped-by-request",
+		"both":      "skipped-by-request",
+	} {
+		r := dryRunHandoff(t, HandoffArgs{Prompt: "submit me", Deliver: deliver, AutoSubmit: &submit, DryRun: true})
+		if r.DeeplinkStatus != want {
+			t.Errorf("dry run with deliver %s: deeplinkStatus %q, want %q", deliver, r.DeeplinkStatus, want)
+		}
+	}
+}
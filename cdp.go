@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Filling the composer through the Chrome DevTools Protocol removes the
+// deeplink length ceiling: Chrome must be running with
+// --remote-debugging-port, and --cdp-endpoint points at it.
+
+const (
+	CDP_TIMEOUT = 10 * time.Second
+
+	// CDP_COMPOSER_WAIT bounds how long a freshly opened tab may take to
+	// render the composer.
+	CDP_COMPOSER_WAIT = 8 * time.Second
+)
+
+// cdpFillScript finds the ChatGPT composer (a ProseMirror editor with id
+// prompt-textarea, or a plain textarea in older layouts) and inserts the
+// prompt so the page's own input handlers see it. %s is the JSON-encoded
+// prompt.
+const cdpFillScript = `(() => {
+  const el = document.querySelector("#prompt-textarea") || document.querySelector("main textarea");
+  if (!el) return "no-composer";
+  el.focus();
+  const text = %s;
+  if (el instanceof HTMLTextAreaElement) {
+    const setter = Object.getOwnPropertyDescriptor(HTMLTextAreaElement.prototype, "value").set;
+    setter.call(el, text);
+    el.dispatchEvent(new Event("input", { bubbles: true }));
+  } else {
+    document.execCommand("selectAll", false);
+    document.execCommand("insertText", false, text);
+  }
+  return "ok";
+})()`
+
+// validateCDPEndpoint checks a --cdp-endpoint value. Both the ws:// URL
+// Chrome prints and the plain http://host:port form are accepted.
+func validateCDPEndpoint(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "ws", "wss", "http", "https":
+	default:
+		return fmt.Errorf("%q must be a ws:// or http:// URL", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", raw)
+	}
+	return nil
+}
+
+// cdpTarget is an entry of Chrome's /json/list.
+type cdpTarget struct {
+	Type                 string `json:"type"`
+	URL                  string `json:"url"`
+	WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+}
+
+// fillViaCDP puts prompt into the composer of a tab showing chatURL,
+// opening one when no matching tab exists.
+func fillViaCDP(ctx context.Context, endpoint, chatURL, prompt string) error {
+	ctx, cancel := context.WithTimeout(ctx, CDP_TIMEOUT)
+	defer cancel()
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	httpBase := "http://" + u.Host
+	if u.Scheme == "wss" || u.Scheme == "https" {
+		httpBase = "https://" + u.Host
+	}
+
+	var targets []cdpTarget
+	if err := cdpHTTP(ctx, http.MethodGet, httpBase+"/json/list", &targets); err != nil {
+		return fmt.Errorf("DevTools endpoint unreachable: %w", err)
+	}
+	target, found := findChatTab(targets, chatURL)
+	if !found {
+		// Chrome 111+ requires PUT for /json/new.
+		if err := cdpHTTP(ctx, http.MethodPut, httpBase+"/json/new?"+chatURL, &target); err != nil {
+			return fmt.Errorf("opening a ChatGPT tab: %w", err)
+		}
+	}
+	if target.WebSocketDebuggerURL == "" {
+		return errors.New("tab has no debugger URL (is another DevTools client attached?)")
+	}
+
+	conn, err := dialWebSocket(ctx, target.WebSocketDebuggerURL)
+	if err != nil {
+		return fmt.Errorf("connecting to tab: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.call("Page.bringToFront", nil); err != nil {
+		return err
+	}
+
+	promptJSON, err := json.Marshal(prompt)
+	if err != nil {
+		return err
+	}
+	script := fmt.Sprintf(cdpFillScript, promptJSON)
+	deadline := time.Now().Add(CDP_COMPOSER_WAIT)
+	for {
+		res, err := conn.call("Runtime.evaluate", map[string]any{
+			"expression":    script,
+			"returnByValue": true,
+			"userGesture":   true,
+		})
+		if err != nil {
+			return err
+		}
+		var eval struct {
+			Result struct {
+				Value string `json:"value"`
+			} `json:"result"`
+			ExceptionDetails *struct {
+				Text string `json:"text"`
+			} `json:"exceptionDetails"`
+		}
+		if err := json.Unmarshal(res, &eval); err != nil {
+			return err
+		}
+		if eval.ExceptionDetails != nil {
+			return fmt.Errorf("page script failed: %s", eval.ExceptionDetails.Text)
+		}
+		if eval.Result.Value == "ok" {
+			return nil
+		}
+		if time.Now().After(deadline) || ctx.Err() != nil {
+			return errors.New("ChatGPT composer not found in the tab (page not loaded, logged out, or the page layout changed)")
+		}
+		time.Sleep(300 * time.Millisecond)
+	}
+}
+
+// findChatTab prefers a tab already at chatURL, then any tab on its host.
+func findChatTab(targets []cdpTarget, chatURL string) (cdpTarget, bool) {
+	host := urlHost(chatURL)
+	var sameHost *cdpTarget
+	for i, t := range targets {
+		if t.Type != "page" {
+			continue
+		}
+		if strings.HasPrefix(t.URL, chatURL) {
+			return t, true
+		}
+		if sameHost == nil && urlHost(t.URL) == host {
+			sameHost = &targets[i]
+		}
+	}
+	if sameHost != nil {
+		return *sameHost, true
+	}
+	return cdpTarget{}, false
+}
+
+func cdpHTTP(ctx context.Context, method, rawURL string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", method, req.URL.Path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// cdpConn is a minimal WebSocket client speaking the DevTools protocol:
+// unfragmented masked text frames out, any text frames in.
+type cdpConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	nextID int
+}
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func dialWebSocket(ctx context.Context, rawURL string) (*cdpConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		conn, err = d.DialContext(ctx, "tcp", u.Host)
+	case "wss":
+		conn, err = (&tls.Dialer{NetDialer: &d}).DialContext(ctx, "tcp", u.Host)
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", u.RequestURI(), u.Host, key)
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		conn.Close()
+		return nil, fmt.Errorf("websocket handshake failed: %s", resp.Status)
+	}
+	return &cdpConn{conn: conn, r: r}, nil
+}
+
+func (c *cdpConn) Close() error {
+	return c.conn.Close()
+}
+
+// call sends a DevTools command and waits for its response, skipping the
+// events that arrive in between.
+func (c *cdpConn) call(method string, params any) (json.RawMessage, error) {
+	c.nextID++
+	msg, err := json.Marshal(map[string]any{"id": c.nextID, "method": method, "params": params})
+	if err != nil {
+		return nil, err
+	}
+	if err := c.writeFrame(0x1, msg); err != nil {
+		return nil, err
+	}
+	for {
+		data, err := c.readMessage()
+		if err != nil {
+			return nil, err
+		}
+		var resp struct {
+			ID     int             `json:"id"`
+			Result json.RawMessage `json:"result"`
+			Error  *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return nil, err
+		}
+		if resp.ID != c.nextID {
+			continue
+		}
+		if resp.Error != nil {
+			return nil, fmt.Errorf("%s: %s", method, resp.Error.Message)
+		}
+		return resp.Result, nil
+	}
+}
+
+func (c *cdpConn) writeFrame(opcode byte, payload []byte) error {
+	hdr := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		hdr = append(hdr, 0x80|byte(n))
+	case n <= 0xFFFF:
+		hdr = append(hdr, 0x80|126)
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr = append(hdr, 0x80|127)
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	mask := make([]byte, 4)
+	rand.Read(mask)
+	hdr = append(hdr, mask...)
+
+	masked := make([]byte, len(payload))
+	for i, b := range payload {
+		masked[i] = b ^ mask[i%4]
+	}
+	_, err := c.conn.Write(append(hdr, masked...))
+	return err
+}
+
+// readMessage returns the next complete text or binary message, answering
+// pings and joining continuation frames.
+func (c *cdpConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		var hdr [2]byte
+		if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+			return nil, err
+		}
+		fin, opcode := hdr[0]&0x80 != 0, hdr[0]&0x0F
+		n := uint64(hdr[1] & 0x7F)
+		switch n {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+				return nil, err
+			}
+			n = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+				return nil, err
+			}
+			n = binary.BigEndian.Uint64(ext[:])
+		}
+		var mask [4]byte
+		if hdr[1]&0x80 != 0 {
+			if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+				return nil, err
+			}
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(c.r, payload); err != nil {
+			return nil, err
+		}
+		if hdr[1]&0x80 != 0 {
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+		}
+
+		switch opcode {
+		case 0x8:
+			return nil, errors.New("connection closed by browser")
+		case 0x9:
+			if err := c.writeFrame(0xA, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case 0xA:
+			continue
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCDPFrameRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 125, 126, 0xFFFF, 0x10000} {
+		client, server := net.Pipe()
+		w := &cdpConn{conn: client}
+		r := &cdpConn{conn: server, r: bufio.NewReader(server)}
+		payload := bytes.Repeat([]byte("ab"), n/2+1)[:n]
+		go w.writeFrame(0x1, payload)
+		got, err := r.readMessage()
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("%d bytes: read back %d bytes that differ", n, len(got))
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestCDPReadMessage(t *testing.T) {
+	// Unmasked frames as a browser sends them: a text message split over a
+	// continuation frame, with a ping in between.
+	var in bytes.Buffer
+	in.Write([]byte{0x01, 3})
+	in.WriteString("hel")
+	in.Write([]byte{0x89, 2})
+	in.WriteString("pi")
+	in.Write([]byte{0x80, 2})
+	in.WriteString("lo")
+	in.Write([]byte{0x81, 126, 0x01, 0x00})
+	in.Write(bytes.Repeat([]byte("x"), 256))
+	in.Write([]byte{0x88, 0})
+
+	client, server := net.Pipe()
+	defer client.Close()
+	c := &cdpConn{conn: client, r: bufio.NewReader(&in)}
+	pong := make(chan []byte, 1)
+	go func() {
+		// A client's pong is masked: 2 header bytes, the mask, the data.
+		var frame [8]byte
+		if _, err := io.ReadFull(server, frame[:]); err != nil || frame[0] != 0x8A || frame[1] != 0x80|2 {
+			pong <- nil
+			return
+		}
+		mask, data := frame[2:6], frame[6:]
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+		pong <- data
+	}()
+
+	msg, err := c.readMessage()
+	if err != nil || string(msg) != "hello" {
+		t.Fatalf("first message = %q, %v", msg, err)
+	}
+	if p := <-pong; string(p) != "pi" {
+		t.Errorf("pong payload = %q, want %q", p, "pi")
+	}
+	if msg, err = c.readMessage(); err != nil || len(msg) != 256 {
+		t.Errorf("second message = %d bytes, %v", len(msg), err)
+	}
+	if _, err = c.readMessage(); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("close frame gave %v", err)
+	}
+}
+
+// fakeDevTools serves /json/list with one ChatGPT tab and answers its
+// DevTools commands with respond, which gets each command's method and
+// params and returns its result.
+func fakeDevTools(t *testing.T, respond func(method string, params json.RawMessage) any) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json/list":
+			json.NewEncoder(w).Encode([]cdpTarget{
+				{Type: "service_worker", URL: "https://chatgpt.com/sw.js", WebSocketDebuggerURL: "ws://unused"},
+				{Type: "page", URL: "https://chatgpt.com/c/1", WebSocketDebuggerURL: "ws://" + srv.Listener.Addr().String() + "/devtools/page/1"},
+			})
+		case "/devtools/page/1":
+			sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + websocketGUID))
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+				base64.StdEncoding.EncodeToString(sum[:]))
+			rw.Flush()
+			c := &cdpConn{conn: conn, r: rw.Reader}
+			for {
+				data, err := c.readMessage()
+				if err != nil {
+					return
+				}
+				var cmd struct {
+					ID     int             `json:"id"`
+					Method string          `json:"method"`
+					Params json.RawMessage `json:"params"`
+				}
+				json.Unmarshal(data, &cmd)
+				// An event first, which the client must skip.
+				c.writeFrame(0x1, []byte(`{"method":"Page.frameNavigated","params":{}}`))
+				reply := map[string]any{"id": cmd.ID}
+				if res := respond(cmd.Method, cmd.Params); res != nil {
+					if err, ok := res.(error); ok {
+						reply["error"] = map[string]string{"message": err.Error()}
+					} else {
+						reply["result"] = res
+					}
+				}
+				msg, _ := json.Marshal(reply)
+				c.writeFrame(0x1, msg)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFillViaCDP(t *testing.T) {
+	prompt := "Why does this leak?\n\"quoted\" </script> " + strings.Repeat("é", 40000)
+	var methods []string
+	var expression string
+	srv := fakeDevTools(t, func(method string, params json.RawMessage) any {
+		methods = append(methods, method)
+		if method != "Runtime.evaluate" {
+			return map[string]any{}
+		}
+		var p struct {
+			Expression string `json:"expression"`
+		}
+		json.Unmarshal(params, &p)
+		expression = p.Expression
+		return map[string]any{"result": map[string]any{"value": "ok"}}
+	})
+
+	if err := fillViaCDP(context.Background(), srv.URL, "https://chatgpt.com/", prompt); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(methods, " ") != "Page.bringToFront Runtime.evaluate" {
+		t.Errorf("commands = %v", methods)
+	}
+	want, _ := json.Marshal(prompt)
+	if !strings.Contains(expression, "const text = "+string(want)+";") {
+		t.Errorf("the script does not carry the JSON-encoded prompt")
+	}
+}
+
+func TestFillViaCDPErrors(t *testing.T) {
+	srv := fakeDevTools(t, func(method string, _ json.RawMessage) any {
+		if method == "Runtime.evaluate" {
+			return map[string]any{"exceptionDetails": map[string]any{"text": "Uncaught"}}
+		}
+		return fmt.Errorf("Not allowed")
+	})
+	err := fillViaCDP(context.Background(), srv.URL, "https://chatgpt.com/", "hi")
+	if err == nil || err.Error() != "Page.bringToFront: Not allowed" {
+		t.Errorf("DevTools error = %v", err)
+	}
+
+	srv = fakeDevTools(t, func(method string, _ json.RawMessage) any {
+		if method == "Runtime.evaluate" {
+			return map[string]any{"exceptionDetails": map[string]any{"text": "Uncaught"}}
+		}
+		return map[string]any{}
+	})
+	err = fillViaCDP(context.Background(), srv.URL, "https://chatgpt.com/", "hi")
+	if err == nil || !strings.Contains(err.Error(), "page script failed: Uncaught") {
+		t.Errorf("script exception = %v", err)
+	}
+
+	srv.Close()
+	err = fillViaCDP(context.Background(), srv.URL, "https://chatgpt.com/", "hi")
+	if err == nil || !strings.Contains(err.Error(), "DevTools endpoint unreachable") {
+		t.Errorf("closed endpoint = %v", err)
+	}
+}
+
+func TestFindChatTab(t *testing.T) {
+	targets := []cdpTarget{
+		{Type: "page", URL: "https://example.com/"},
+		{Type: "page", URL: "https://chatgpt.com/c/1"},
+		{Type: "iframe", URL: "https://chatgpt.com/?model=x"},
+		{Type: "page", URL: "https://chatgpt.com/?model=x"},
+	}
+	if got, ok := findChatTab(targets, "https://chatgpt.com/?model=x"); !ok || got.URL != "https://chatgpt.com/?model=x" || got.Type != "page" {
+		t.Errorf("exact match = %+v, %v", got, ok)
+	}
+	if got, ok := findChatTab(targets, "https://chatgpt.com/?model=y"); !ok || got.URL != "https://chatgpt.com/c/1" {
+		t.Errorf("same host = %+v, %v", got, ok)
+	}
+	if _, ok := findChatTab(targets, "https://claude.ai/new"); ok {
+		t.Error("matched a tab on another host")
+	}
+}
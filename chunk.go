@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// DEFAULT_CHUNK_SIZE is the default --chunk-size, in characters. It stays
+// well below the input limit of the assistants' chat boxes.
+const DEFAULT_CHUNK_SIZE = 15000
+
+// MIN_CHUNK_SIZE leaves room for the part header and a fenced line.
+const MIN_CHUNK_SIZE = 200
+
+var (
+	chunkSize = DEFAULT_CHUNK_SIZE
+	// autoChunkAbove chunks prompts longer than this many characters even
+	// without chunked: true (--auto-chunk-above); 0 disables it.
+	autoChunkAbove = 0
+)
+
+// promptBlocks splits a prompt into paragraphs, keeping each fenced code
+// block whole.
+func promptBlocks(prompt string) []string {
+	var blocks []string
+	var cur []string
+	fence := ""
+	flush := func() {
+		if len(cur) > 0 {
+			blocks = append(blocks, strings.Join(cur, "\n"))
+			cur = nil
+		}
+	}
+	for _, line := range strings.Split(prompt, "\n") {
+		marker := fenceMarker(line)
+		switch {
+		case fence != "":
+			cur = append(cur, line)
+			if marker != "" && closesFence(line, marker, fence) {
+				fence = ""
+				flush()
+			}
+		case marker != "":
+			flush()
+			fence = marker
+			cur = append(cur, line)
+		case strings.TrimSpace(line) == "":
+			flush()
+		default:
+			cur = append(cur, line)
+		}
+	}
+	flush()
+	return blocks
+}
+
+// splitBlock breaks a block longer than size at line boundaries, or inside
+// a line when it has to. A fenced block is closed at the end of each piece
+// and reopened at the start of the next.
+func splitBlock(block string, size int) []string {
+	lines := strings.Split(block, "\n")
+	open, close := "", ""
+	if marker := fenceMarker(lines[0]); marker != "" {
+		open, close = lines[0], marker
+		lines = lines[1:]
+		if last := len(lines) - 1; last >= 0 && fenceMarker(lines[last]) != "" && closesFence(lines[last], fenceMarker(lines[last]), close) {
+			lines = lines[:last]
+		}
+		size -= utf8.RuneCountInString(open) + utf8.RuneCountInString(close) + 2
+		size = max(size, 1)
+	}
+
+	var pieces []string
+	var cur []string
+	curLen := 0
+	emit := func() {
+		if len(cur) == 0 {
+			return
+		}
+		piece := strings.Join(cur, "\n")
+		if open != "" {
+			piece = open + "\n" + piece + "\n" + close
+		}
+		pieces = append(pieces, piece)
+		cur, curLen = nil, 0
+	}
+	for _, line := range lines {
+		for utf8.RuneCountInString(line) > size {
+			emit()
+			r := []rune(line)
+			cur, curLen = []string{string(r[:size])}, size
+			emit()
+			line = string(r[size:])
+		}
+		n := utf8.RuneCountInString(line)
+		if curLen > 0 && curLen+1+n > size {
+			emit()
+		}
+		if curLen > 0 {
+			curLen++
+		}
+		cur = append(cur, line)
+		curLen += n
+	}
+	emit()
+	return pieces
+}
+
+// splitPrompt cuts a prompt into chunks of at most size characters plus a
+// part header, preferring paragraph and code block boundaries, and numbers
+// them so the assistant waits for the last one.
+func splitPrompt(prompt string, size int) []string {
+	var parts []string
+	var cur strings.Builder
+	for _, block := range promptBlocks(prompt) {
+		pieces := []string{block}
+		if utf8.RuneCountInString(block) > size {
+			pieces = splitBlock(block, size)
+		}
+		for _, p := range pieces {
+			if cur.Len() > 0 && utf8.RuneCountInString(cur.String())+2+utf8.RuneCountInString(p) > size {
+				parts = append(parts, cur.String())
+				cur.Reset()
+			}
+			if cur.Len() > 0 {
+				cur.WriteString("\n\n")
+			}
+			cur.WriteString(p)
+		}
+	}
+	if cur.Len() > 0 {
+		parts = append(parts, cur.String())
+	}
+
+	if len(parts) < 2 {
+		return []string{prompt}
+	}
+	for i, p := range parts {
+		header := fmt.Sprintf("Part %d/%d — reply only \"ready\" until the final part.", i+1, len(parts))
+		if i == len(parts)-1 {
+			header = fmt.Sprintf("Part %d/%d — this is the final part; please answer now, taking all parts into account.", i+1, len(parts))
+		}
+		parts[i] = header + "\n\n" + p
+	}
+	return parts
+}
+
+// chunks recomputes the parts of a chunked handoff from its prompt.
+func (r HandoffRecord) chunks() []string {
+	return splitPrompt(r.Prompt, r.ChunkSize)
+}
+
+type NextChunkArgs struct {
+	HandoffID int `json:"handoffId,omitempty" jsonschema:"id of the chunked handoff (default: the most recent handoff)"`
+}
+
+// NextChunkResult is the structured content returned by next_handoff_chunk.
+type NextChunkResult struct {
+	HandoffID        int    `json:"handoffId"`
+	Chunk            int    `json:"chunk"`
+	Chunks           int    `json:"chunks"`
+	Remaining        int    `json:"remaining"`
+	ClipboardBackend string `json:"clipboardBackend,omitempty"`
+	Done             bool   `json:"done,omitempty"`
+}
+
+func handleNextChunk(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[NextChunkArgs]) (*mcp.CallToolResultFor[any], error) {
+	if noHistory {
+		return toolError("chunked handoffs need the handoff history, which is disabled (--no-history)"), nil
+	}
+	id := params.Arguments.HandoffID
+	if id == 0 {
+		if id = history.latestID(); id == 0 {
+			return toolError("no handoffs recorded yet"), nil
+		}
+	}
+	r, ok := history.get(id)
+	if !ok {
+		return toolError(fmt.Sprintf("unknown handoff id %d", id)), nil
+	}
+	if r.ChunkCount == 0 {
+		return toolError(fmt.Sprintf("handoff %d was not split into parts; it was copied whole", id)), nil
+	}
+
+	result := &NextChunkResult{HandoffID: id, Chunk: r.ChunksCopied, Chunks: r.ChunkCount}
+	if r.ChunksCopied >= r.ChunkCount {
+		result.Done = true
+		return &mcp.CallToolResultFor[any]{
+			Content: []mcp.Content{&mcp.TextContent{Text: fmt.Sprintf(
+				"All %d parts of handoff %d have already been copied. Wait for the user to share the response.", r.ChunkCount, id)}},
+			StructuredContent: result,
+		}, nil
+	}
+
+	chunks := r.chunks()
+	if len(chunks) != r.ChunkCount {
+		return toolError(fmt.Sprintf("handoff %d no longer splits into %d parts", id, r.ChunkCount)), nil
+	}
+	text := chunks[r.ChunksCopied]
+	cb, err := copyToClipboard(normalizeLineEndings(text, clipboardLineEnding(), preserveCodeLineEndings), clipboardBackendName)
+	if err != nil {
+		playSound(true)
+		return toolError(fmt.Sprintf("failed to copy part %d to clipboard: %v", r.ChunksCopied+1, err)), nil
+	}
+	rememberCopied(text)
+	playSound(false)
+
+	next := r.ChunksCopied + 1
+	if _, err := history.rewrite(func(h *HandoffRecord) (bool, bool) {
+		if h.ID != id {
+			return false, true
+		}
+		h.ChunksCopied = next
+		return true, true
+	}); err != nil {
+		return toolError("failed to save chunk progress: " + err.Error()), nil
+	}
+
+	result.Chunk, result.Remaining, result.ClipboardBackend = next, r.ChunkCount-next, cb.Backend
+	msg := fmt.Sprintf("Part %d of %d copied. Ask the user to paste and send it, then call next_handoff_chunk again.", next, r.ChunkCount)
+	if next == r.ChunkCount {
+		msg = fmt.Sprintf("Final part %d of %d copied. Ask the user to paste and send it, then wait for the response.", next, r.ChunkCount)
+	}
+	return &mcp.CallToolResultFor[any]{
+		Content:           []mcp.Content{&mcp.TextContent{Text: msg}},
+		StructuredContent: result,
+	}, nil
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+)
+
+// clipboardBackend is one way of putting text on the user's clipboard.
+// Backends are probed in order and the first available one that succeeds
+// handles the copy.
+type clipboardBackend struct {
+	Name      string
+	Available func() bool
+	// Copy writes s to the clipboard and returns a short human-readable
+	// detail (selection used, buffer name, ...) for diagnostics.
+	Copy func(s string) (string, error)
+	// Paste reads the clipboard's text. It is nil for backends that cannot
+	// read the clipboard back.
+	Paste func() (string, error)
+}
+
+// ClipboardCopy records which backend handled a successful copy.
+type ClipboardCopy struct {
+	Backend string
+	Detail  string
+}
+
+// BackendInfo describes a clipboard backend for diagnostics output.
+type BackendInfo struct {
+	Name      string `json:"name"`
+	Available bool   `json:"available"`
+	Selected  bool   `json:"selected"`
+}
+
+// nativeBackends holds in-process clipboard implementations compiled in
+// with the nativeclip build tag. They are probed after the external tools.
+var nativeBackends []clipboardBackend
+
+// clipboardBackends returns the probe order for the current platform.
+func clipboardBackends() []clipboardBackend {
+	var backends []clipboardBackend
+	if clipboardCommand != "" {
+		backends = append(backends, customBackend)
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		backends = append(backends, pbcopyBackend)
+		backends = append(backends, nativeBackends...)
+		backends = append(backends, tmuxBackend, osc52Backend)
+	case "windows":
+		backends = append(backends, powershellBackend, clipExeBackend)
+		backends = append(backends, nativeBackends...)
+	default:
+		backends = append(backends, wlCopyBackend, xclipBackend, xselBackend, clipExeBackend)
+		backends = append(backends, nativeBackends...)
+		backends = append(backends, tmuxBackend, osc52Backend)
+	}
+	if fallbackFile != "" {
+		backends = append(backends, fileBackend)
+	}
+	return backends
+}
+
+// ClipboardDiagnosis explains the clipboard situation of the current
+// session. Problem is empty when a graphical clipboard should be usable.
+type ClipboardDiagnosis struct {
+	Session string `json:"session"` // "wayland", "x11", "none", or the OS name
+	Problem string `json:"problem,omitempty"`
+}
+
+// diagnoseClipboard inspects the environment to tell a headless session
+// apart from a graphical one that is merely missing clipboard tools.
+func diagnoseClipboard() ClipboardDiagnosis {
+	switch runtime.GOOS {
+	case "darwin", "windows":
+		return ClipboardDiagnosis{Session: runtime.GOOS}
+	}
+
+	hasX := commandExists("xclip")() || commandExists("xsel")()
+	switch {
+	case commandExists("clip.exe")():
+		return ClipboardDiagnosis{Session: "wsl"}
+	case os.Getenv("WAYLAND_DISPLAY") != "":
+		if commandExists("wl-copy")() || (hasX && os.Getenv("DISPLAY") != "") {
+			return ClipboardDiagnosis{Session: "wayland"}
+		}
+		return ClipboardDiagnosis{Session: "wayland", Problem: "Wayland session detected but wl-clipboard not installed (install wl-clipboard)"}
+	case os.Getenv("DISPLAY") != "":
+		if hasX {
+			return ClipboardDiagnosis{Session: "x11"}
+		}
+		return ClipboardDiagnosis{Session: "x11", Problem: "X11 session detected but xclip/xsel not installed (install xclip or xsel)"}
+	default:
+		return ClipboardDiagnosis{Session: "none", Problem: "no graphical session detected — consider --clipboard-backend osc52 or --fallback-file"}
+	}
+}
+
+// DescribeBackends reports every known backend in probe order, whether it
+// is usable on this machine, and which one would be tried first (the pinned
+// --clipboard-backend, if any).
+func DescribeBackends() []BackendInfo {
+	var infos []BackendInfo
+	selected := false
+	for _, b := range clipboardBackends() {
+		info := BackendInfo{Name: b.Name, Available: b.Available()}
+		if clipboardBackendName != "" {
+			info.Selected = b.Name == clipboardBackendName
+		} else if info.Available && !selected {
+			info.Selected = true
+			selected = true
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// lookupClipboardBackend finds a backend by name and checks that it can be
+// used on this machine.
+func lookupClipboardBackend(name string) (clipboardBackend, error) {
+	var names []string
+	for _, b := range clipboardBackends() {
+		if b.Name == name {
+			if !b.Available() {
+				return clipboardBackend{}, fmt.Errorf("clipboard backend %q is not available on this machine", name)
+			}
+			return b, nil
+		}
+		names = append(names, b.Name)
+	}
+	return clipboardBackend{}, fmt.Errorf("unknown clipboard backend %q (known: %s)", name, strings.Join(names, ", "))
+}
+
+// copyToClipboard copies s using the named backend, or the first working
+// backend in probe order when backend is empty.
+func copyToClipboard(s, backend string) (ClipboardCopy, error) {
+	if backend != "" {
+		b, err := lookupClipboardBackend(backend)
+		if err != nil {
+			return ClipboardCopy{}, err
+		}
+		detail, err := b.Copy(s)
+		if err != nil {
+			return ClipboardCopy{}, fmt.Errorf("%s: %w", b.Name, err)
+		}
+		return ClipboardCopy{Backend: b.Name, Detail: detail}, nil
+	}
+
+	var failures []string
+	for _, b := range clipboardBackends() {
+		if !b.Available() {
+			continue
+		}
+		detail, err := b.Copy(s)
+		if err != nil {
+			failures = append(failures, b.Name+": "+err.Error())
+			continue
+		}
+		return ClipboardCopy{Backend: b.Name, Detail: detail}, nil
+	}
+
+	if len(failures) > 0 {
+		return ClipboardCopy{}, errors.New(strings.Join(failures, "; "))
+	}
+	if d := diagnoseClipboard(); d.Problem != "" {
+		return ClipboardCopy{}, errors.New(d.Problem)
+	}
+	return ClipboardCopy{}, errors.New("no clipboard utility found")
+}
+
+var (
+	pbcopyBackend = clipboardBackend{
+		Name:      "pbcopy",
+		Available: commandExists("pbcopy"),
+		Copy: func(s string) (string, error) {
+			return "general pasteboard", runWithStdin(s, "pbcopy")
+		},
+		Paste: func() (string, error) {
+			return outputLimited("pbpaste", "-Prefer", "txt")
+		},
+	}
+
+	powershellBackend = clipboardBackend{
+		Name:      "powershell",
+		Available: commandExists("powershell"),
+		Copy: func(s string) (string, error) {
+			if windowsInlineClipboard(s) {
+				return "inline", copyToClipboardWindows(s)
+			}
+			return "temp file", copyToClipboardWindows(s)
+		},
+		Paste: func() (string, error) {
+			return pasteWindows("powershell")
+		},
+	}
+
+	clipExeBackend = clipboardBackend{
+		Name:      "clip.exe",
+		Available: commandExists("clip.exe"),
+		Copy: func(s string) (string, error) {
+			return "windows clipboard", runWithStdin(s, "clip.exe")
+		},
+		// Under WSL clip.exe can only write; PowerShell reads.
+		Paste: func() (string, error) {
+			return pasteWindows("powershell.exe")
+		},
+	}
+
+	wlCopyBackend = clipboardBackend{
+		Name: "wl-copy",
+		Available: func() bool {
+			return os.Getenv("WAYLAND_DISPLAY") != "" && commandExists("wl-copy")()
+		},
+		Copy: func(s string) (string, error) {
+			return "clipboard", runWithStdin(s, "wl-copy")
+		},
+		Paste: func() (string, error) {
+			return outputLimited("wl-paste", "--no-newline", "--type", "text")
+		},
+	}
+
+	xclipBackend = clipboardBackend{
+		Name: "xclip",
+		Available: func() bool {
+			return os.Getenv("DISPLAY") != "" && commandExists("xclip")()
+		},
+		Copy: func(s string) (string, error) {
+			return "selection clipboard", runWithStdin(s, "xclip", "-selection", "clipboard")
+		},
+		Paste: func() (string, error) {
+			return outputLimited("xclip", "-selection", "clipboard", "-out", "-target", "UTF8_STRING")
+		},
+	}
+
+	xselBackend = clipboardBackend{
+		Name: "xsel",
+		Available: func() bool {
+			return os.Getenv("DISPLAY") != "" && commandExists("xsel")()
+		},
+		Copy: func(s string) (string, error) {
+			return "selection clipboard", runWithStdin(s, "xsel", "--clipboard", "--input")
+		},
+		Paste: func() (string, error) {
+			return outputLimited("xsel", "--clipboard", "--output")
+		},
+	}
+
+	tmuxBackend = clipboardBackend{
+		Name: "tmux",
+		Available: func() bool {
+			return os.Getenv("TMUX") != "" && commandExists("tmux")()
+		},
+		Copy: func(s string) (string, error) {
+			return "buffer chatgpt-handoff", runWithStdin(s, "tmux", "load-buffer", "-b", "chatgpt-handoff", "-")
+		},
+	}
+
+	osc52Backend = clipboardBackend{
+		Name: "osc52",
+		Available: func() bool {
+			tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+			if err != nil {
+				return false
+			}
+			tty.Close()
+			return true
+		},
+		Copy:  copyOSC52,
+		Paste: pasteOSC52,
+	}
+
+	// fileBackend is the last resort: it writes the prompt to a private
+	// file the user can open by hand.
+	fileBackend = clipboardBackend{
+		Name:      "file",
+		Available: func() bool { return fallbackFile != "" },
+		Copy: func(s string) (string, error) {
+			path, err := autoPath(fallbackFile, defaultFallbackFile)
+			if err == nil {
+				err = makeParentDir(path)
+			}
+			if err != nil {
+				return "", err
+			}
+			return path, os.WriteFile(path, []byte(s), 0o600)
+		},
+	}
+
+	customBackend = clipboardBackend{
+		Name:      "custom",
+		Available: func() bool { return len(strings.Fields(clipboardCommand)) > 0 },
+		Copy: func(s string) (string, error) {
+			fields := strings.Fields(clipboardCommand)
+			return clipboardCommand, runWithStdin(s, fields[0], fields[1:]...)
+		},
+	}
+)
+
+func commandExists(name string) func() bool {
+	return func() bool {
+		_, err := exec.LookPath(name)
+		return err == nil
+	}
+}
+
+func runWithStdin(s, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(s)
+	return runCommand(context.Background(), 1, cmd)
+}
+
+// copyOSC52 writes an OSC 52 escape sequence to the controlling terminal,
+// which most modern terminal emulators turn into a clipboard write even
+// over SSH. Inside tmux the sequence is wrapped in a DCS passthrough.
+func copyOSC52(s string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+
+	seq := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(s)) + "\a"
+	detail := "/dev/tty"
+	if os.Getenv("TMUX") != "" {
+		seq = "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
+		detail = "/dev/tty (tmux passthrough)"
+	}
+	if _, err := tty.WriteString(seq); err != nil {
+		return "", fmt.Errorf("writing to terminal: %w", err)
+	}
+	return detail, nil
+}
+
+// windowsInlineClipboard reports whether s can be passed to Set-Clipboard
+// in a here-string on the command line: it is at most
+// MAX_WINDOWS_INLINE_CLIPBOARD bytes and has no line starting with '@,
+// which would end the here-string early.
+func windowsInlineClipboard(s string) bool {
+	return len(s) <= MAX_WINDOWS_INLINE_CLIPBOARD && !strings.HasPrefix(s, "'@") && !strings.Contains(s, "\n'@")
+}
+
+// writeClipboardTemp stages s in a UTF-8 file with BOM, so Windows
+// PowerShell 5.1 decodes it correctly, and returns its path.
+func writeClipboardTemp(s string) (string, error) {
+	f, err := os.CreateTemp("", "chatgpt-handoff-*.txt")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.WriteString("\ufeff" + s)
+	if err = errors.Join(err, f.Close()); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func copyToClipboardWindows(s string) error {
+	if windowsInlineClipboard(s) {
+		cmd := exec.Command("powershell", "-NoProfile", "-Command", "Set-Clipboard -Value @'\n"+s+"\n'@")
+		return runCommand(withPromptArgs(context.Background()), 1, cmd)
+	}
+
+	// Large prompts go through a temp file piped into Set-Clipboard.
+	name, err := writeClipboardTemp(s)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(name)
+
+	path := strings.ReplaceAll(name, "'", "''")
+	cmd := exec.Command("powershell", "-NoProfile", "-Command",
+		"Get-Content -Raw -Encoding UTF8 -LiteralPath '"+path+"' | Set-Clipboard")
+	return runCommand(context.Background(), 1, cmd)
+}
+
+// printClipboardBackends writes the backend table shown by
+// --list-clipboard-backends.
+func printClipboardBackends(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "BACKEND\tAVAILABLE\tSELECTED")
+	for _, info := range DescribeBackends() {
+		available, selected := "no", ""
+		if info.Available {
+			available = "yes"
+		}
+		if info.Selected {
+			selected = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", info.Name, available, selected)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestWindowsInlineClipboard(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want bool
+	}{
+		{"empty", "", true},
+		{"at the limit", strings.Repeat("a", MAX_WINDOWS_INLINE_CLIPBOARD), true},
+		{"one byte over", strings.Repeat("a", MAX_WINDOWS_INLINE_CLIPBOARD+1), false},
+		// The limit counts bytes: é is two.
+		{"multi-byte at the limit", strings.Repeat("é", MAX_WINDOWS_INLINE_CLIPBOARD/2), true},
+		{"multi-byte over", strings.Repeat("é", MAX_WINDOWS_INLINE_CLIPBOARD/2) + "a", false},
+		{"here-string end on a line", "a\n'@\nb", false},
+		{"here-string end first", "'@ b", false},
+		{"here-string end mid-line", "a '@ b", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := windowsInlineClipboard(tt.s); got != tt.want {
+				t.Errorf("windowsInlineClipboard(%d bytes) = %v, want %v", len(tt.s), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteClipboardTemp(t *testing.T) {
+	s := strings.Repeat("naïve 日本語 🎉\r\n", MAX_WINDOWS_INLINE_CLIPBOARD/8)
+	path, err := writeClipboardTemp(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := strings.CutPrefix(string(data), "\ufeff")
+	if !ok {
+		t.Error("the file has no UTF-8 BOM")
+	}
+	if got != s {
+		t.Error("the text didn't survive the round trip")
+	}
+}
+
+func TestWaitForClipboardSkipsOSC52(t *testing.T) {
+	old := clipboardBackendName
+	t.Cleanup(func() { clipboardBackendName = old })
+	clipboardBackendName = ""
+	oldNative, oldFallback := nativeBackends, fallbackFile
+	t.Cleanup(func() { nativeBackends, fallbackFile = oldNative, oldFallback })
+	fallbackFile = ""
+
+	// Make osc52 the only reader: an available stand-in for it is probed
+	// before the real one, and PATH has no paste tools.
+	polled := 0
+	nativeBackends = []clipboardBackend{{
+		Name:      osc52Backend.Name,
+		Available: func() bool { return true },
+		Paste:     func() (string, error) { polled++; return "x", nil },
+	}}
+	if len(clipboardReaders()) == 0 {
+		t.Skip("no clipboard readers to replace")
+	}
+	for _, b := range clipboardReaders() {
+		if b.Name != osc52Backend.Name {
+			t.Skipf("%s can read the clipboard here", b.Name)
+		}
+	}
+
+	res, err := handleWaitForClipboard(context.Background(), nil, &mcp.CallToolParamsFor[WaitForClipboardArgs]{
+		Arguments: WaitForClipboardArgs{TimeoutSeconds: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.IsError || !strings.Contains(res.Content[0].(*mcp.TextContent).Text, "not supported with the osc52") {
+		t.Errorf("result = %v", res.Content)
+	}
+	if polled != 0 {
+		t.Errorf("the terminal was queried %d times", polled)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+const (
+	// DEFAULT_CLIPBOARD_READ_LIMIT is the default --clipboard-read-limit.
+	DEFAULT_CLIPBOARD_READ_LIMIT = 1 << 20
+
+	// OSC52_READ_TIMEOUT bounds the wait for the terminal to answer an OSC
+	// 52 query; many terminals ignore it for security reasons.
+	OSC52_READ_TIMEOUT = time.Second
+
+	CLIPBOARD_POLL_INTERVAL = time.Second
+
+	// DEFAULT_CLIPBOARD_WAIT and MAX_CLIPBOARD_WAIT bound how long
+	// wait_for_clipboard_change blocks, in seconds.
+	DEFAULT_CLIPBOARD_WAIT = 120
+	MAX_CLIPBOARD_WAIT     = 600
+
+	// DEFAULT_MIN_RESPONSE_LENGTH filters out stray copies (a word, a URL
+	// fragment) made while the user waits for the real answer.
+	DEFAULT_MIN_RESPONSE_LENGTH = 20
+)
+
+// lastCopied is the text most recently put on the clipboard by a handoff,
+// which wait_for_clipboard_change must not mistake for a response.
+var lastCopied struct {
+	mu   sync.Mutex
+	text string
+}
+
+func rememberCopied(text string) {
+	lastCopied.mu.Lock()
+	defer lastCopied.mu.Unlock()
+	lastCopied.text = text
+}
+
+func copiedByUs(text string) bool {
+	lastCopied.mu.Lock()
+	defer lastCopied.mu.Unlock()
+	return lastCopied.text != "" && sameClipboardText(text, lastCopied.text)
+}
+
+// sameClipboardText ignores the line ending and trailing newline changes
+// clipboard tools make on the way through.
+func sameClipboardText(a, b string) bool {
+	norm := func(s string) string { return strings.TrimSpace(strings.ReplaceAll(s, "\r\n", "\n")) }
+	return norm(a) == norm(b)
+}
+
+var (
+	errClipboardEmpty   = errors.New("the clipboard is empty or doesn't hold text")
+	errClipboardNotText = errors.New("the clipboard doesn't hold UTF-8 text")
+)
+
+// clipboardReaders are the backends that can read the clipboard: the
+// pinned --clipboard-backend when it can, otherwise every available one in
+// the usual probe order.
+func clipboardReaders() []clipboardBackend {
+	if clipboardBackendName != "" {
+		if b, err := lookupClipboardBackend(clipboardBackendName); err == nil && b.Paste != nil {
+			return []clipboardBackend{b}
+		}
+	}
+	var readers []clipboardBackend
+	for _, b := range clipboardBackends() {
+		if b.Paste != nil && b.Available() {
+			readers = append(readers, b)
+		}
+	}
+	return readers
+}
+
+// readClipboard returns the clipboard's text and the first of readers that
+// could read it.
+func readClipboard(readers []clipboardBackend) (string, string, error) {
+	if len(readers) == 0 {
+		return "", "", errors.New("no clipboard backend on this machine can read the clipboard")
+	}
+
+	var failures []string
+	for _, b := range readers {
+		text, err := b.Paste()
+		if errors.Is(err, errClipboardEmpty) {
+			return "", b.Name, err
+		} else if err != nil {
+			failures = append(failures, b.Name+": "+err.Error())
+			continue
+		}
+		if !utf8.ValidString(text) {
+			return "", b.Name, errClipboardNotText
+		}
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+		if strings.TrimSpace(text) == "" {
+			return "", b.Name, errClipboardEmpty
+		}
+		return text, b.Name, nil
+	}
+	return "", "", errors.New(strings.Join(failures, "; "))
+}
+
+// verifyCopy reads the clipboard back through the backend that copied
+// text and reports whether it holds that text. It returns nil when the
+// backend can't be read back, or not without asking the terminal (osc52).
+func verifyCopy(cb ClipboardCopy, text string) *bool {
+	if cb.Backend == "osc52" || len(text) > clipboardReadLimit {
+		return nil
+	}
+	b, err := lookupClipboardBackend(cb.Backend)
+	if err != nil || b.Paste == nil {
+		return nil
+	}
+	got, err := b.Paste()
+	ok := err == nil && sameClipboardText(got, text)
+	if !ok {
+		slog.Debug("clipboard read-back doesn't match", logBackend(cb.Backend), "error", err)
+	}
+	return &ok
+}
+
+// outputLimited runs a paste command and returns its output, failing
+// rather than buffering more than --clipboard-read-limit bytes.
+func outputLimited(name string, args ...string) (string, error) {
+	out := &limitedBuffer{limit: clipboardReadLimit}
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = out
+	err := runCommand(context.Background(), 1, cmd)
+	if out.over {
+		return "", clipboardTooLarge()
+	}
+	// wl-paste and xclip fail when the clipboard holds no text at all.
+	if msg := commandStderr(err); strings.Contains(msg, "No selection") || strings.Contains(msg, "No suitable type") ||
+		strings.Contains(msg, "target UTF8_STRING not available") {
+		return "", errClipboardEmpty
+	} else if err != nil {
+		return "", err
+	}
+	return out.buf.String(), nil
+}
+
+// limitedBuffer refuses writes past limit bytes, which stops the copy
+// from the command and leaves it writing to a closed pipe. The buffer
+// isn't embedded, since io.Copy would use its ReadFrom instead of Write.
+type limitedBuffer struct {
+	buf   bytes.Buffer
+	limit int
+	over  bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.buf.Len()+len(p) > b.limit {
+		b.over = true
+		return 0, errors.New("output too large")
+	}
+	return b.buf.Write(p)
+}
+
+func clipboardTooLarge() error {
+	return fmt.Errorf("the clipboard holds more than %d bytes (raise --clipboard-read-limit to read it)", clipboardReadLimit)
+}
+
+// pasteWindows reads the clipboard through PowerShell, which also works
+// from WSL via powershell.exe.
+func pasteWindows(exe string) (string, error) {
+	out, err := outputLimited(exe, "-NoProfile", "-Command",
+		"[Console]::OutputEncoding = [Text.Encoding]::UTF8; Get-Clipboard -Raw")
+	// PowerShell terminates its output with a newline of its own.
+	return strings.TrimSuffix(out, "\r\n"), err
+}
+
+// pasteOSC52 asks the terminal for the clipboard with an OSC 52 query.
+// Only some terminals answer, and most only after the user allows it.
+func pasteOSC52() (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+
+	// The reply arrives as terminal input, so switch off line buffering and
+	// echo while waiting for it. stty doesn't go through runCommand, which
+	// would put it in a background process group that SIGTTOU stops when
+	// it changes the terminal mode.
+	stty := func(args ...string) ([]byte, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = tty
+		return cmd.Output()
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return "", fmt.Errorf("reading terminal mode: %w", err)
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return "", fmt.Errorf("setting terminal mode: %w", err)
+	}
+	defer stty(strings.TrimSpace(string(saved)))
+
+	query := "\x1b]52;c;?\a"
+	if os.Getenv("TMUX") != "" {
+		query = "\x1bPtmux;" + strings.ReplaceAll(query, "\x1b", "\x1b\x1b") + "\x1b\\"
+	}
+	if _, err := tty.WriteString(query); err != nil {
+		return "", fmt.Errorf("writing to terminal: %w", err)
+	}
+
+	tty.SetReadDeadline(time.Now().Add(OSC52_READ_TIMEOUT))
+	maxReply := base64.StdEncoding.EncodedLen(clipboardReadLimit) + 16
+	var reply []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := tty.Read(buf)
+		reply = append(reply, buf[:n]...)
+		if i := bytes.IndexByte(reply, '\a'); i >= 0 {
+			reply = reply[:i]
+			break
+		}
+		if i := bytes.Index(reply, []byte("\x1b\\")); i >= 0 {
+			reply = reply[:i]
+			break
+		}
+		if len(reply) > maxReply {
+			return "", clipboardTooLarge()
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return "", errors.New("terminal did not answer the OSC 52 query (clipboard reading is usually disabled in the terminal's settings)")
+		} else if err != nil {
+			return "", err
+		}
+	}
+
+	_, payload, ok := bytes.Cut(reply, []byte("]52;"))
+	if !ok {
+		return "", errors.New("unexpected reply to the OSC 52 query")
+	}
+	if _, data, ok := bytes.Cut(payload, []byte(";")); ok {
+		payload = data
+	}
+	text, err := base64.StdEncoding.DecodeString(string(payload))
+	if err != nil {
+		return "", fmt.Errorf("decoding OSC 52 reply: %w", err)
+	}
+	if len(text) > clipboardReadLimit {
+		return "", clipboardTooLarge()
+	}
+	return string(text), nil
+}
+
+// ReadClipboardResult is the structured content returned by read_clipboard.
+type ReadClipboardResult struct {
+	Text    string `json:"text"`
+	Length  int    `json:"length"`
+	Backend string `json:"backend"`
+}
+
+func handleReadClipboard(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[struct{}]) (*mcp.CallToolResultFor[any], error) {
+	text, backend, err := readClipboard(clipboardReaders())
+	if err != nil {
+		return toolError("Couldn't read the clipboard: " + err.Error()), nil
+	}
+	return &mcp.CallToolResultFor[any]{
+		Content:           []mcp.Content{&mcp.TextContent{Text: text}},
+		StructuredContent: &ReadClipboardResult{Text: text, Length: len(text), Backend: backend},
+	}, nil
+}
+
+type WaitForClipboardArgs struct {
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty" jsonschema:"how long to wait in seconds (default 120, at most 600)"`
+	MinLength      int `json:"minLength,omitempty" jsonschema:"ignore copies shorter than this many characters (default 20)"`
+}
+
+// ClipboardChangeResult is the structured content returned by
+// wait_for_clipboard_change.
+type ClipboardChangeResult struct {
+	Text          string  `json:"text"`
+	Length        int     `json:"length"`
+	Backend       string  `json:"backend"`
+	WaitedSeconds float64 `json:"waitedSeconds"`
+}
+
+func handleWaitForClipboard(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[WaitForClipboardArgs]) (*mcp.CallToolResultFor[any], error) {
+	timeout, minLength := params.Arguments.TimeoutSeconds, params.Arguments.MinLength
+	switch {
+	case timeout < 0 || timeout > MAX_CLIPBOARD_WAIT:
+		return toolError(fmt.Sprintf("invalid params: timeoutSeconds must be between 1 and %d", MAX_CLIPBOARD_WAIT)), nil
+	case timeout == 0:
+		timeout = DEFAULT_CLIPBOARD_WAIT
+	}
+	if minLength < 0 {
+		return toolError("invalid params: minLength must not be negative"), nil
+	} else if minLength == 0 {
+		minLength = DEFAULT_MIN_RESPONSE_LENGTH
+	}
+
+	// Each OSC 52 read puts the terminal in raw mode for a moment, so
+	// polling with it would swallow the user's keystrokes.
+	all := clipboardReaders()
+	readers := slices.DeleteFunc(slices.Clone(all), func(b clipboardBackend) bool { return b.Name == osc52Backend.Name })
+	if len(readers) == 0 && len(all) > 0 {
+		return toolError("wait_for_clipboard_change is not supported with the osc52 clipboard backend: polling the terminal for the clipboard would swallow the user's keystrokes. Ask the user to copy ChatGPT's response and call read_clipboard once, or to paste the response here."), nil
+	}
+
+	// Whatever is on the clipboard now, usually the prompt itself, is not
+	// the response.
+	baseline, _, err := readClipboard(readers)
+	if err != nil && !errors.Is(err, errClipboardEmpty) && !errors.Is(err, errClipboardNotText) {
+		return toolError("Couldn't read the clipboard: " + err.Error()), nil
+	}
+
+	start := time.Now()
+	deadline := start.Add(time.Duration(timeout) * time.Second)
+	ticker := time.NewTicker(CLIPBOARD_POLL_INTERVAL)
+	defer ticker.Stop()
+	token := params.GetProgressToken()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		text, backend, err := readClipboard(readers)
+		switch {
+		case err != nil:
+			slog.Debug("clipboard poll failed", "error", err)
+		case sameClipboardText(text, baseline) || copiedByUs(text):
+		case utf8.RuneCountInString(strings.TrimSpace(text)) < minLength:
+			slog.Debug("ignoring short clipboard change", "length", len(text), "minLength", minLength)
+		default:
+			waited := time.Since(start)
+			return &mcp.CallToolResultFor[any]{
+				Content: []mcp.Content{&mcp.TextContent{Text: text}},
+				StructuredContent: &ClipboardChangeResult{
+					Text: text, Length: len(text), Backend: backend, WaitedSeconds: waited.Round(time.Second).Seconds(),
+				},
+			}, nil
+		}
+
+		elapsed := time.Since(start)
+		if time.Now().After(deadline) {
+			return toolError(fmt.Sprintf("No new text was copied within %ds. Ask the user to copy ChatGPT's response, then call this tool again.", timeout)), nil
+		}
+		if token != nil {
+			ss.NotifyProgress(ctx, &mcp.ProgressNotificationParams{
+				ProgressToken: token,
+				Progress:      elapsed.Round(time.Second).Seconds(),
+				Total:         float64(timeout),
+				Message:       "Waiting for ChatGPT's response to be copied",
+			})
+		}
+	}
+}
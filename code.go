@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+)
+
+// MAX_CODE_BLOCKS bounds the code argument.
+const MAX_CODE_BLOCKS = 50
+
+// CodeBlock is one entry of the code argument.
+type CodeBlock struct {
+	Filename string `json:"filename,omitempty" jsonschema:"file the code comes from, shown above the block"`
+	Language string `json:"language,omitempty" jsonschema:"code block language; guessed from the filename when omitted"`
+	Content  string `json:"content" jsonschema:"the code, exactly as it is; the server fences it"`
+}
+
+// CodeBlocks is the code argument: a single string of code, or a list of
+// blocks.
+type CodeBlocks []CodeBlock
+
+func (c *CodeBlocks) UnmarshalJSON(data []byte) error {
+	var content string
+	if err := json.Unmarshal(data, &content); err == nil {
+		*c = CodeBlocks{{Content: content}}
+		return nil
+	}
+	var blocks []CodeBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return err
+	}
+	*c = blocks
+	return nil
+}
+
+// allowStringCode lets code be given as a plain string, which the schema
+// inferred from CodeBlocks does not allow.
+func allowStringCode(schema *jsonschema.Schema) {
+	arr := schema.Properties["code"]
+	if arr == nil {
+		return
+	}
+	desc := arr.Description
+	arr.Description = ""
+	arr.Types = nil
+	arr.Type = "array"
+	schema.Properties["code"] = &jsonschema.Schema{
+		Description: desc,
+		AnyOf:       []*jsonschema.Schema{{Type: "string"}, arr},
+	}
+}
+
+// codeLanguagePattern is what may follow a fence as the info string.
+var codeLanguagePattern = regexp.MustCompile(`^[A-Za-z0-9_+#.-]{0,32}$`)
+
+// assemblePrompt puts the context argument before the prompt and the code
+// blocks after it, each fenced with more backticks than any run inside
+// it, so the agent never has to escape code itself. The context's line
+// endings are normalized to LF; the code's are kept as they are, and left
+// to the clipboard copy's normalization and --preserve-code-line-endings.
+func assemblePrompt(context, prompt string, code CodeBlocks) (string, error) {
+	if len(code) > MAX_CODE_BLOCKS {
+		return "", fmt.Errorf("code has %d blocks, the limit is %d", len(code), MAX_CODE_BLOCKS)
+	}
+	var b strings.Builder
+	if context = strings.TrimSpace(strings.ReplaceAll(context, "\r\n", "\n")); context != "" {
+		b.WriteString("Context:\n" + context + "\n\n")
+	}
+	b.WriteString(prompt)
+	for i, block := range code {
+		// Leading blank lines are dropped but indentation is kept.
+		content := strings.TrimRight(strings.TrimLeft(block.Content, "\r\n"), " \t\r\n")
+		if strings.TrimSpace(content) == "" {
+			return "", fmt.Errorf("code block %d is empty", i+1)
+		}
+		name := strings.TrimSpace(block.Filename)
+		if strings.ContainsAny(name, "\r\n") {
+			return "", fmt.Errorf("code block %d: filename must be a single line", i+1)
+		}
+		lang := strings.TrimSpace(block.Language)
+		if lang == "" && name != "" {
+			lang = attachmentLanguages[strings.ToLower(filepath.Ext(name))]
+		}
+		if !codeLanguagePattern.MatchString(lang) {
+			return "", fmt.Errorf("code block %d: language %q must be a single word such as go or c++", i+1, lang)
+		}
+
+		b.WriteString("\n\n")
+		if name != "" {
+			b.WriteString("File: " + name + "\n")
+		}
+		fence := markdownFence(content)
+		fmt.Fprintf(&b, "%s%s\n%s\n%s", fence, lang, content, fence)
+	}
+	return b.String(), nil
+}
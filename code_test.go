@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestAssemblePrompt(t *testing.T) {
+	tests := []struct {
+		name    string
+		context string
+		code    CodeBlocks
+		want    string
+		wantErr string
+	}{
+		{
+			name: "prompt only",
+			want: "Review this.",
+		},
+		{
+			name:    "context first",
+			context: "\r\nWe use Go 1.23.\r\n",
+			want:    "Context:\nWe use Go 1.23.\n\nReview this.",
+		},
+		{
+			name: "filename and guessed language",
+			code: CodeBlocks{{Filename: "main.go", Content: "\n\n\tpackage main\n\n"}},
+			want: "Review this.\n\nFile: main.go\n```go\n\tpackage main\n```",
+		},
+		{
+			name: "explicit language",
+			code: CodeBlocks{{Language: "c++", Content: "int x;"}},
+			want: "Review this.\n\n```c++\nint x;\n```",
+		},
+		{
+			name: "nested fence",
+			code: CodeBlocks{{Filename: "README.md", Language: "markdown", Content: "```go\nx := 1\n```"}},
+			want: "Review this.\n\nFile: README.md\n````markdown\n```go\nx := 1\n```\n````",
+		},
+		{
+			name: "longest run wins",
+			code: CodeBlocks{{Content: "a `b` ``c`` `````d"}},
+			want: "Review this.\n\n``````\na `b` ``c`` `````d\n``````",
+		},
+		{
+			name: "CRLF kept",
+			code: CodeBlocks{{Content: "a\r\nb\r\n\r\n"}},
+			want: "Review this.\n\n```\na\r\nb\n```",
+		},
+		{
+			name: "several blocks",
+			code: CodeBlocks{{Content: "one"}, {Content: "two"}},
+			want: "Review this.\n\n```\none\n```\n\n```\ntwo\n```",
+		},
+		{
+			name:    "empty content",
+			code:    CodeBlocks{{Content: "one"}, {Content: " \r\n\t"}},
+			wantErr: "code block 2 is empty",
+		},
+		{
+			name:    "multi-line filename",
+			code:    CodeBlocks{{Filename: "a\nb", Content: "x"}},
+			wantErr: "single line",
+		},
+		{
+			name:    "bad language",
+			code:    CodeBlocks{{Language: "go\n```", Content: "x"}},
+			wantErr: "single word",
+		},
+		{
+			name:    "too many blocks",
+			code:    make(CodeBlocks, MAX_CODE_BLOCKS+1),
+			wantErr: "the limit is",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := assemblePrompt(tt.context, "Review this.", tt.code)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%q\nwant:\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodeBlocksUnmarshal(t *testing.T) {
+	var code CodeBlocks
+	if err := json.Unmarshal([]byte(`"x := 1"`), &code); err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 1 || code[0].Content != "x := 1" {
+		t.Errorf("string code = %+v", code)
+	}
+	if err := json.Unmarshal([]byte(`[{"filename": "a.go", "content": "x"}, {"content": "y"}]`), &code); err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 2 || code[0].Filename != "a.go" || code[1].Content != "y" {
+		t.Errorf("list code = %+v", code)
+	}
+	if err := json.Unmarshal([]byte(`42`), &code); err == nil {
+		t.Error("accepted a number")
+	}
+}
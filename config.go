@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// configFile is --config; empty means the default location, which may be
+// missing.
+var configFile = ""
+
+// loadedConfig is what resolveSettings made of the config file, for
+// doctor and reloadConfig: the file read, if any, its values, its unknown
+// keys, and the error that would otherwise have stopped startup.
+var loadedConfig struct {
+	path    string
+	values  map[string][]string
+	unknown []string
+	err     error
+}
+
+// repeatableFlags add a value each time they are given, so a config list
+// sets each entry in turn instead of one comma-separated value.
+var repeatableFlags = []string{
+	"webhook-header", "smtp-to", "redact-pattern", "priority-behavior",
+	"tool-alias", "export-root", "attachment-root",
+}
+
+// commandLineOnlyFlags can't be set from the environment or config file.
+var commandLineOnlyFlags = []string{"version", "list-clipboard-backends", "json", "limit", "since", "daemon", "timeout"}
+
+func commandLineOnly(flag string) bool {
+	return slices.Contains(commandLineOnlyFlags, flag) || slices.Contains(sendOnlyFlags, flag)
+}
+
+// loadConfig reads a JSON object whose keys are flag names without the
+// dashes, e.g. {"http": true, "port": 9000, "tools": ["chatgpt", "claude"]},
+// and returns each key's values as flag arguments.
+func loadConfig(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	values := make(map[string][]string, len(raw))
+	for key, msg := range raw {
+		var v any
+		dec := json.NewDecoder(bytes.NewReader(msg))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		switch v := v.(type) {
+		case []any:
+			list := []string{}
+			for _, item := range v {
+				s, err := configScalar(item)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", key, err)
+				}
+				list = append(list, s)
+			}
+			values[key] = list
+		default:
+			s, err := configScalar(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+			values[key] = []string{s}
+		}
+	}
+	return values, nil
+}
+
+func configScalar(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	}
+	return "", errors.New("expected a string, number, boolean or list")
+}
+
+// envName is the environment variable for a flag, e.g. CHATGPT_HANDOFF_PORT.
+func envName(flag string) string {
+	return "CHATGPT_HANDOFF_" + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// parseEnvBool accepts 1/true/yes and 0/false/no in any case.
+func parseEnvBool(v string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "yes":
+		return "true", nil
+	case "0", "false", "no":
+		return "false", nil
+	}
+	return "", errors.New("expected 1, true, yes, 0, false or no")
+}
+
+// resolveSettings applies the command line over the environment over the
+// config file over the built-in defaults. Repeatable flags take one value
+// per line from the environment. Each layer only sets what no
+// higher layer has, and every value goes through its flag's validation.
+func resolveSettings(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		v := os.Getenv(name)
+		if v == "" || set[f.Name] || commandLineOnly(f.Name) {
+			return
+		}
+		list := []string{v}
+		if isBoolFlag(f) {
+			b, err := parseEnvBool(v)
+			if err != nil {
+				log.Fatalf("invalid %s %q: %v", name, v, err)
+			}
+			list[0] = b
+		} else if slices.Contains(repeatableFlags, f.Name) {
+			list = strings.Split(strings.TrimSpace(v), "\n")
+		}
+		for _, v := range list {
+			if err := fs.Set(f.Name, v); err != nil {
+				log.Fatalf("invalid %s %q: %v", name, v, err)
+			}
+		}
+		set[f.Name] = true
+	})
+	if v := os.Getenv("OPENAI_API_KEY"); v != "" && !set["openai-api-key"] {
+		fs.Set("openai-api-key", v)
+		set["openai-api-key"] = true
+	}
+
+	baseSettings, settingsFlags, settingsSet = captureSettings(), fs, set
+	if err := applyConfigFile(fs, set); err != nil {
+		if command != "doctor" {
+			log.Fatal(err)
+		}
+		loadedConfig.err = err
+	}
+	liveSettings.Store(captureSettings())
+}
+
+// applyConfigFile sets the flags in the config file that aren't in set.
+func applyConfigFile(fs *flag.FlagSet, set map[string]bool) error {
+	path := configFile
+	if path == "" {
+		var err error
+		if path, err = defaultConfigFile(); err != nil {
+			return nil
+		}
+	}
+	values, err := loadConfig(path)
+	if errors.Is(err, os.ErrNotExist) && configFile == "" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	loadedConfig.path, loadedConfig.values = path, values
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	settings := settingNames()
+	for _, key := range keys {
+		f := fs.Lookup(key)
+		if f == nil && slices.Contains(settings, key) && key != "config" {
+			continue // a setting this command doesn't use
+		}
+		if f == nil || key == "config" || commandLineOnly(key) {
+			slog.Warn("ignoring unknown config key", "key", key, "file", path)
+			loadedConfig.unknown = append(loadedConfig.unknown, key)
+			continue
+		}
+		if set[key] {
+			continue
+		}
+		list := values[key]
+		if !slices.Contains(repeatableFlags, key) {
+			list = []string{strings.Join(list, ",")}
+		}
+		for _, v := range list {
+			if err := fs.Set(key, v); err != nil {
+				return fmt.Errorf("invalid %s in config file %s: %v", key, path, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// withSettings restores the globals resolveSettings writes in these tests.
+func withSettings(t *testing.T) {
+	t.Helper()
+	port, retry, maxPrompt, tokens := httpPort, portRetry, maxPromptLength, tokenWarning
+	headers, config, loaded, cmd := slices.Clone(webhookHeaders), configFile, loadedConfig, command
+	live, base, flags, set := liveSettings.Load(), baseSettings, settingsFlags, settingsSet
+	t.Cleanup(func() {
+		httpPort, portRetry, maxPromptLength, tokenWarning = port, retry, maxPrompt, tokens
+		webhookHeaders, configFile, loadedConfig, command = headers, config, loaded, cmd
+		liveSettings.Store(live)
+		baseSettings, settingsFlags, settingsSet = base, flags, set
+	})
+	loadedConfig.path, loadedConfig.values, loadedConfig.unknown, loadedConfig.err = "", nil, nil, nil
+}
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return &buf
+}
+
+func TestResolveSettingsLayers(t *testing.T) {
+	withSettings(t)
+	logged := captureLog(t)
+	configFile = writeConfig(t, `{
+		"port": 9001,
+		"max-prompt-length": 5000,
+		"token-warning": 1234,
+		"webhook-header": ["A: 1", "B: 2"],
+		"no-such-setting": true
+	}`)
+	t.Setenv(envName("port"), "9002")
+	t.Setenv(envName("max-prompt-length"), "6000")
+	defaultRetry := portRetry
+
+	resolveSettings(newFlagSet("serve"), []string{"--port", "9003"})
+
+	if httpPort != 9003 {
+		t.Errorf("port = %d, want the command line's 9003", httpPort)
+	}
+	if maxPromptLength != 6000 {
+		t.Errorf("max-prompt-length = %d, want the environment's 6000", maxPromptLength)
+	}
+	if tokenWarning != 1234 {
+		t.Errorf("token-warning = %d, want the config file's 1234", tokenWarning)
+	}
+	if portRetry != defaultRetry {
+		t.Errorf("port-retry = %d, want the default %d", portRetry, defaultRetry)
+	}
+	if want := []string{"A: 1", "B: 2"}; !slices.Equal(webhookHeaders, want) {
+		t.Errorf("webhook-header = %q, want each config entry: %q", webhookHeaders, want)
+	}
+	if !slices.Equal(loadedConfig.unknown, []string{"no-such-setting"}) {
+		t.Errorf("unknown keys = %q", loadedConfig.unknown)
+	}
+	if !strings.Contains(logged.String(), "ignoring unknown config key") || !strings.Contains(logged.String(), "no-such-setting") {
+		t.Errorf("no warning about the unknown key in the log: %s", logged)
+	}
+}
+
+func TestResolveSettingsRepeatableEnv(t *testing.T) {
+	withSettings(t)
+	captureLog(t)
+	configFile = writeConfig(t, `{"webhook-header": ["A: 1", "B: 2"]}`)
+	t.Setenv(envName("webhook-header"), "C: 3\nD: 4")
+
+	resolveSettings(newFlagSet("serve"), nil)
+
+	// The environment replaces the config file's list rather than adding to it.
+	if want := []string{"C: 3", "D: 4"}; !slices.Equal(webhookHeaders, want) {
+		t.Errorf("webhook-header = %q, want %q", webhookHeaders, want)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	values, err := loadConfig(writeConfig(t, `{"port": 9000, "http": true, "tools": ["chatgpt", "claude"], "tool-description": "x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"port":             {"9000"},
+		"http":             {"true"},
+		"tools":            {"chatgpt", "claude"},
+		"tool-description": {"x"},
+	}
+	for key, v := range want {
+		if !slices.Equal(values[key], v) {
+			t.Errorf("%s = %q, want %q", key, values[key], v)
+		}
+	}
+
+	for _, body := range []string{`{"port": {"nested": 1}}`, `{"tools": [["a"]]}`, `[1, 2]`, `{"port": 1`} {
+		if _, err := loadConfig(writeConfig(t, body)); err == nil {
+			t.Errorf("loadConfig accepted %s", body)
+		}
+	}
+}
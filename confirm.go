@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_CONFIRM_TIMEOUT = time.Minute
+
+	// CONFIRM_PREVIEW_LENGTH caps the prompt preview in the dialog, in
+	// characters.
+	CONFIRM_PREVIEW_LENGTH = 400
+)
+
+var (
+	// confirmHandoffs asks the user in a local dialog before each handoff
+	// touches the clipboard, browser or network (--confirm).
+	confirmHandoffs = false
+	// confirmTimeout is how long the dialog waits; no answer counts as
+	// declining (--confirm-timeout).
+	confirmTimeout = DEFAULT_CONFIRM_TIMEOUT
+)
+
+// The outcomes of a confirmation dialog, as recorded in the history.
+const (
+	CONFIRM_ACCEPTED  = "confirmed"
+	CONFIRM_DECLINED  = "declined"
+	CONFIRM_TIMED_OUT = "timed-out"
+)
+
+// confirmText is the dialog's message: the title and the start of the
+// prompt, with secrets redacted since the dialog is on screen.
+func confirmText(title, label, prompt string) string {
+	preview := strings.TrimSpace(prompt)
+	if r := []rune(preview); len(r) > CONFIRM_PREVIEW_LENGTH {
+		preview = string(r[:CONFIRM_PREVIEW_LENGTH]) + "…"
+	}
+	found := map[string]int{}
+	return fmt.Sprintf("Hand off %q to %s?\n\n%s", redact(title, found), label, redact(preview, found))
+}
+
+// confirmHandoff shows a native yes/no dialog and reports how the user
+// answered. It only waits on the dialog process, so other requests go on
+// while it is open. The error is for a dialog that couldn't be shown.
+func confirmHandoff(ctx context.Context, label, text string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, confirmTimeout)
+	defer cancel()
+	seconds := strconv.Itoa(max(1, int(confirmTimeout.Seconds())))
+	title := "Hand off to " + label
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.CommandContext(ctx, "osascript",
+			"-e", "on run argv",
+			"-e", `display dialog (item 2 of argv) with title (item 1 of argv) buttons {"Cancel", "Hand off"} default button "Hand off" cancel button "Cancel" giving up after (item 3 of argv as integer)`,
+			"-e", "end run",
+			title, text, seconds)
+	case "windows":
+		cmd = exec.CommandContext(ctx, "powershell", "-NoProfile", "-Command",
+			"Add-Type -AssemblyName System.Windows.Forms; "+
+				"[System.Windows.Forms.MessageBox]::Show("+powershellQuote(text)+", "+powershellQuote(title)+", 'OKCancel', 'Question')")
+	default:
+		if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			return "", errors.New("no DISPLAY or WAYLAND_DISPLAY set")
+		}
+		if _, err := exec.LookPath("zenity"); err == nil {
+			cmd = exec.CommandContext(ctx, "zenity", "--question", "--no-markup", "--title", title, "--text", text,
+				"--ok-label", "Hand off", "--cancel-label", "Cancel", "--timeout", seconds)
+		} else if _, err := exec.LookPath("kdialog"); err == nil {
+			cmd = exec.CommandContext(ctx, "kdialog", "--title", title, "--yesno", text)
+		} else {
+			return "", errors.New("neither zenity nor kdialog is installed")
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	out := strings.TrimSpace(stdout.String())
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return CONFIRM_TIMED_OUT, nil
+	case ctx.Err() != nil:
+		return "", ctx.Err()
+	case err == nil && runtime.GOOS == "windows":
+		if out == "OK" {
+			return CONFIRM_ACCEPTED, nil
+		}
+		return CONFIRM_DECLINED, nil
+	case err == nil && strings.Contains(out, "gave up:true"):
+		return CONFIRM_TIMED_OUT, nil
+	case err == nil:
+		return CONFIRM_ACCEPTED, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 5 && runtime.GOOS != "darwin":
+		// zenity exits 5 when --timeout runs out.
+		return CONFIRM_TIMED_OUT, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		// Cancel, or closing the dialog.
+		return CONFIRM_DECLINED, nil
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return "", fmt.Errorf("%w: %s", err, msg)
+	}
+	return "", err
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// CLIPBOARD_KIND marks copy_to_clipboard entries in the history, which
+// are not handoffs: stats and "the most recent handoff" skip them.
+const CLIPBOARD_KIND = "clipboard"
+
+// copyToolEnabled registers copy_to_clipboard; a --tools list without it
+// turns it off.
+var copyToolEnabled = true
+
+type CopyArgs struct {
+	Text             string `json:"text" jsonschema:"the text to put on the user's clipboard, e.g. a commit message or a URL"`
+	Label            string `json:"label,omitempty" jsonschema:"short description of the text for the history, at most 120 characters, e.g. commit message"`
+	Redact           *bool  `json:"redact,omitempty" jsonschema:"replace API keys, tokens and private keys with [REDACTED:<type>] before copying; defaults to the server's --redact-secrets setting"`
+	ClipboardBackend string `json:"clipboardBackend,omitempty" jsonschema:"force a specific clipboard backend for this call (for testing)"`
+}
+
+// CopyResult is the structured content returned by copy_to_clipboard.
+type CopyResult struct {
+	ClipboardBackend  string `json:"clipboardBackend"`
+	ClipboardDetail   string `json:"clipboardDetail,omitempty"`
+	ClipboardVerified *bool  `json:"clipboardVerified,omitempty"`
+	FallbackFile      string `json:"fallbackFile,omitempty"`
+	Length            int    `json:"length"`
+	// HistoryID is the history entry of the copy, listed with target
+	// and kind "clipboard"; it is 0 with --no-history.
+	HistoryID  int            `json:"historyId,omitempty"`
+	Redactions map[string]int `json:"redactions,omitempty"`
+}
+
+// handleCopyToClipboard puts arbitrary text on the clipboard through the
+// same backends, limits and checks as a handoff, without opening anything.
+func handleCopyToClipboard(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[CopyArgs]) (*mcp.CallToolResultFor[any], error) {
+	text := params.Arguments.Text
+	if strings.TrimSpace(text) == "" {
+		return toolError("invalid params: text is empty"), nil
+	}
+	if err := checkPromptLength(ctx, len(text)); err != nil {
+		return toolError("invalid params: " + err.Error()), nil
+	}
+	label := strings.Join(strings.Fields(params.Arguments.Label), " ")
+	if utf8.RuneCountInString(label) > MAX_TITLE_LENGTH {
+		return toolError(fmt.Sprintf("invalid params: label is longer than %d characters", MAX_TITLE_LENGTH)), nil
+	}
+
+	wantRedact := redactSecrets
+	if params.Arguments.Redact != nil {
+		wantRedact = *params.Arguments.Redact
+	}
+	var redactions map[string]int
+	var warnings []string
+	if wantRedact {
+		redactions = map[string]int{}
+		text = redact(text, redactions)
+		label = redact(label, map[string]int{})
+		if len(redactions) > 0 {
+			warnings = append(warnings, "redacted secrets ("+describeRedactions(redactions)+"); check the text before using it")
+		} else {
+			redactions = nil
+		}
+	}
+
+	backend := cmp.Or(params.Arguments.ClipboardBackend, clipboardBackendName)
+	clipText := normalizeLineEndings(text, clipboardLineEnding(), preserveCodeLineEndings)
+	cb, err := copyToClipboard(clipText, backend)
+	if err != nil {
+		return toolError("failed to copy the text to the clipboard: " + err.Error()), nil
+	}
+	slog.Debug("copied text to clipboard", logBackend(cb.Backend), "detail", cb.Detail)
+	rememberCopied(clipText)
+
+	result := &CopyResult{
+		ClipboardBackend:  cb.Backend,
+		ClipboardDetail:   cb.Detail,
+		ClipboardVerified: verifyCopy(cb, clipText),
+		Length:            len(text),
+		Redactions:        redactions,
+	}
+	if cb.Backend == fileBackend.Name {
+		result.FallbackFile = cb.Detail
+	}
+	if !noHistory {
+		result.HistoryID = history.add(HandoffRecord{
+			Prompt:           text,
+			PromptLength:     len(text),
+			Title:            cmp.Or(label, deriveTitle(text)),
+			Tool:             params.Name,
+			Kind:             CLIPBOARD_KIND,
+			Target:           CLIPBOARD_KIND,
+			ClipboardBackend: cb.Backend,
+			DeeplinkStatus:   "copied",
+		}).ID
+	}
+
+	var msg string
+	switch v := result.ClipboardVerified; {
+	case result.FallbackFile != "":
+		msg = fmt.Sprintf("The text (%d characters) was written to %s because no clipboard was available.", len(text), result.FallbackFile)
+	case v == nil:
+		msg = fmt.Sprintf("The text (%d characters) is on the clipboard (%s).", len(text), cb.Backend)
+	case *v:
+		msg = fmt.Sprintf("The text (%d characters) is on the clipboard (%s, checked by reading it back).", len(text), cb.Backend)
+	default:
+		msg = fmt.Sprintf("The text (%d characters) was copied with %s, but reading the clipboard back gave different text; the user should check it before pasting.", len(text), cb.Backend)
+	}
+	content := []mcp.Content{&mcp.TextContent{Text: msg}}
+	for _, w := range warnings {
+		content = append(content, &mcp.TextContent{Text: "Note: " + w})
+	}
+	return &mcp.CallToolResultFor[any]{Content: content, StructuredContent: result}, nil
+}
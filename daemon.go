@@ -0,0 +1,230 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// DAEMON_CHILD_ENV marks the re-executed server, so it writes the PID
+	// file and logs only to the file its stderr already goes to.
+	DAEMON_CHILD_ENV = "CHATGPT_HANDOFF_DAEMON_CHILD"
+
+	DAEMON_START_TIMEOUT = 10 * time.Second
+	DEFAULT_STOP_TIMEOUT = 10 * time.Second
+)
+
+// daemonMode is serve --daemon; daemonChild is set in the process it
+// starts. stopTimeout is stop --timeout.
+var (
+	daemonMode  = false
+	daemonChild = os.Getenv(DAEMON_CHILD_ENV) == "1"
+	stopTimeout = DEFAULT_STOP_TIMEOUT
+)
+
+// PIDFile is what the daemon records about itself. Identity is the
+// process's start time and name as ps reports them, so a PID reused by
+// another process after a crash isn't mistaken for the daemon.
+type PIDFile struct {
+	PID      int       `json:"pid"`
+	Identity string    `json:"identity"`
+	Port     int       `json:"port"`
+	LogFile  string    `json:"logFile"`
+	Started  time.Time `json:"started"`
+}
+
+func registerStopFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&stopTimeout, "timeout", stopTimeout, "how long to wait for the server to exit")
+}
+
+// runningDaemon reads the PID file and checks that its process is still
+// the daemon. A PID file for a process that is gone, or whose PID now
+// belongs to something else, is removed and reported as not running.
+func runningDaemon() (*PIDFile, error) {
+	path, err := defaultPIDFile()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var p PIDFile
+	if err := json.Unmarshal(data, &p); err != nil || p.PID <= 0 {
+		slog.Warn("removing unreadable PID file", "path", path)
+		os.Remove(path)
+		return nil, nil
+	}
+	if id, err := processIdentity(p.PID); err != nil || id != p.Identity {
+		slog.Debug("removing stale PID file", "path", path, "pid", p.PID)
+		os.Remove(path)
+		return nil, nil
+	}
+	return &p, nil
+}
+
+// startDaemon re-executes serve without --daemon, detached from the
+// terminal with stdout and stderr going to the log file, and waits until
+// the new server has written its PID file. It returns the exit status.
+func startDaemon() int {
+	if !httpMode {
+		fmt.Fprintln(os.Stderr, "serve: --daemon needs --http; MCP clients start stdio servers themselves")
+		return 2
+	}
+	if _, err := processIdentity(os.Getpid()); err != nil {
+		fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+		return 1
+	}
+	if p, err := runningDaemon(); err != nil {
+		fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+		return 1
+	} else if p != nil {
+		fmt.Fprintf(os.Stderr, "serve: already running (pid %d, port %d); run chatgpt-handoff stop first\n", p.PID, p.Port)
+		return 1
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+		return 1
+	}
+	path, err := autoPath(cmp.Or(logFile, "auto"), defaultLogFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+		return 1
+	}
+	out, err := openRotatingFile(path, int64(logMaxSize)<<20, logMaxFiles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "serve: can't open the log file: %v\n", err)
+		return 1
+	}
+	defer out.f.Close()
+
+	// serve takes no positional arguments, so --log-file can go last,
+	// where it overrides an earlier one.
+	var args []string
+	for _, a := range os.Args[1:] {
+		if name, _, _ := strings.Cut(strings.TrimLeft(a, "-"), "="); !strings.HasPrefix(a, "-") || name != "daemon" {
+			args = append(args, a)
+		}
+	}
+	args = append(args, "--log-file", path)
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), DAEMON_CHILD_ENV+"=1")
+	cmd.Stdout, cmd.Stderr = out.f, out.f
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+		return 1
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.After(DAEMON_START_TIMEOUT)
+	for {
+		select {
+		case <-exited:
+			fmt.Fprintf(os.Stderr, "serve: the server exited during startup; see %s\n", path)
+			return 1
+		case <-deadline:
+			fmt.Fprintf(os.Stderr, "serve: the server (pid %d) didn't start listening within %s; see %s\n", cmd.Process.Pid, DAEMON_START_TIMEOUT, path)
+			return 1
+		case <-time.After(100 * time.Millisecond):
+		}
+		if p, _ := runningDaemon(); p != nil && p.PID == cmd.Process.Pid {
+			fmt.Printf("chatgpt-handoff is running on port %d (pid %d), logging to %s\n", p.Port, p.PID, path)
+			return 0
+		}
+	}
+}
+
+// announceDaemon writes the PID file once the daemon is listening, and
+// removes it when the daemon is told to stop.
+func announceDaemon() {
+	path, err := defaultPIDFile()
+	if err != nil {
+		slog.Warn("not writing a PID file", "error", err)
+		return
+	}
+	id, err := processIdentity(os.Getpid())
+	if err != nil {
+		slog.Warn("not writing a PID file", "error", err)
+		return
+	}
+	data, _ := json.Marshal(PIDFile{PID: os.Getpid(), Identity: id, Port: httpPort, LogFile: logFilePath, Started: time.Now()})
+	if err := makeParentDir(path); err != nil {
+		slog.Warn("not writing a PID file", "error", err)
+		return
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		slog.Warn("not writing a PID file", "error", err)
+		return
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-stop
+		slog.Info("stopping", "signal", sig)
+		os.Remove(path)
+		os.Exit(0)
+	}()
+}
+
+// stopDaemon sends the daemon SIGTERM and waits for it to exit. It
+// returns the exit status.
+func stopDaemon() int {
+	p, err := runningDaemon()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "stop: %v\n", err)
+		return 1
+	}
+	if p == nil {
+		fmt.Println("chatgpt-handoff is not running")
+		return 0
+	}
+	if err := terminateProcess(p.PID); err != nil {
+		fmt.Fprintf(os.Stderr, "stop: %v\n", err)
+		return 1
+	}
+	deadline := time.Now().Add(stopTimeout)
+	for time.Now().Before(deadline) {
+		if _, err := processIdentity(p.PID); err != nil {
+			fmt.Printf("stopped chatgpt-handoff (pid %d)\n", p.PID)
+			return 0
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	fmt.Fprintf(os.Stderr, "stop: chatgpt-handoff (pid %d) is still running after %s\n", p.PID, stopTimeout)
+	return 1
+}
+
+// daemonStatus reports whether the daemon is running, with status 3 when
+// it isn't, as init scripts do.
+func daemonStatus() int {
+	p, err := runningDaemon()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "status: %v\n", err)
+		return 1
+	}
+	if p == nil {
+		fmt.Println("chatgpt-handoff is not running")
+		return 3
+	}
+	fmt.Printf("chatgpt-handoff is running on port %d (pid %d, since %s)\n", p.Port, p.PID, p.Started.Format(time.RFC3339))
+	if p.LogFile != "" {
+		fmt.Printf("log: %s\n", p.LogFile)
+	}
+	return 0
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// processIdentity is the start time and command name ps reports for pid,
+// or an error when there is no such process.
+func processIdentity(pid int) (string, error) {
+	out, err := commandOutput(context.Background(), EXEC_SCALE_LOOKUP, exec.Command("ps", "-o", "lstart=", "-o", "comm=", "-p", strconv.Itoa(pid)))
+	id := strings.TrimSpace(string(out))
+	if err != nil || id == "" {
+		return "", fmt.Errorf("no process %d", pid)
+	}
+	return id, nil
+}
+
+func terminateProcess(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
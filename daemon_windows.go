@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import "errors"
+
+var errNoDaemon = errors.New("--daemon, stop and status aren't supported on Windows; run the server as a scheduled task or service instead")
+
+func processIdentity(pid int) (string, error) {
+	return "", errNoDaemon
+}
+
+func terminateProcess(pid int) error {
+	return errNoDaemon
+}
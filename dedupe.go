@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+const DEFAULT_DUPLICATE_WINDOW = 10 * time.Minute
+
+// duplicateWindow is how long an opened prompt counts as a duplicate of a
+// new one (--duplicate-window); 0 turns the check off.
+var duplicateWindow = DEFAULT_DUPLICATE_WINDOW
+
+// recentPrompt is a prompt handed off within the duplicate window.
+type recentPrompt struct {
+	hash [sha256.Size]byte
+	time time.Time
+	// id is the handoff's history id, 0 with --no-history.
+	id int
+}
+
+// recentPrompts remembers the hashes of recently handed off prompts, so
+// an agent retrying the same handoff doesn't open a chat each time.
+type recentPrompts struct {
+	mu      sync.Mutex
+	entries []recentPrompt
+	// now is the clock the window is measured by.
+	now func() time.Time
+}
+
+var recent = &recentPrompts{now: time.Now}
+
+// promptHash hashes the final prompt together with the target, since the
+// same prompt to another assistant is not a retry.
+func promptHash(target, prompt string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(target + "\x00" + prompt))
+}
+
+// prune drops entries older than the window; the caller holds r.mu.
+func (r *recentPrompts) prune(now time.Time) {
+	keep := r.entries[:0]
+	for _, e := range r.entries {
+		if now.Sub(e.time) < duplicateWindow {
+			keep = append(keep, e)
+		}
+	}
+	r.entries = keep
+}
+
+// find returns the earlier handoff of the same prompt within the window.
+func (r *recentPrompts) find(hash [sha256.Size]byte) (recentPrompt, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(r.now())
+	for _, e := range r.entries {
+		if e.hash == hash {
+			return e, true
+		}
+	}
+	return recentPrompt{}, false
+}
+
+// remember records a handoff of the prompt with hash.
+func (r *recentPrompts) remember(hash [sha256.Size]byte, id int) {
+	if duplicateWindow <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	r.prune(now)
+	r.entries = append(r.entries, recentPrompt{hash: hash, time: now, id: id})
+}
+
+// age is how long ago e was handed off.
+func (r *recentPrompts) age(e recentPrompt) time.Duration {
+	return r.now().Sub(e.time)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// fakeClock replaces the duplicate window's clock for a test and returns
+// a pointer to the time it reports.
+func fakeClock(t *testing.T) *time.Time {
+	t.Helper()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	old, oldWindow := recent, duplicateWindow
+	t.Cleanup(func() { recent, duplicateWindow = old, oldWindow })
+	recent = &recentPrompts{now: func() time.Time { return now }}
+	duplicateWindow = DEFAULT_DUPLICATE_WINDOW
+	return &now
+}
+
+func TestRecentPrompts(t *testing.T) {
+	now := fakeClock(t)
+	hash := promptHash("chatgpt", "hello")
+	recent.remember(hash, 7)
+
+	*now = now.Add(90 * time.Second)
+	e, ok := recent.find(hash)
+	if !ok || e.id != 7 {
+		t.Fatalf("find = %+v, %v; want id 7", e, ok)
+	}
+	if age := recent.age(e); age != 90*time.Second {
+		t.Errorf("age = %s, want 1m30s", age)
+	}
+	if _, ok := recent.find(promptHash("claude", "hello")); ok {
+		t.Error("the same prompt to another target counted as a duplicate")
+	}
+
+	*now = now.Add(DEFAULT_DUPLICATE_WINDOW)
+	if _, ok := recent.find(hash); ok {
+		t.Error("found a prompt after the window expired")
+	}
+	if len(recent.entries) != 0 {
+		t.Errorf("expired entries weren't pruned: %d left", len(recent.entries))
+	}
+
+	duplicateWindow = 0
+	recent.remember(hash, 8)
+	if len(recent.entries) != 0 {
+		t.Error("remembered a prompt with the check off")
+	}
+}
+
+// dryRunHandoff runs handoff_to_chatgpt with args, which should ask for a
+// dry run, and returns its result.
+func dryRunHandoff(t *testing.T, args HandoffArgs) *HandoffResult {
+	t.Helper()
+	res, err := handleHandoff(context.Background(), nil, &mcp.CallToolParamsFor[HandoffArgs]{
+		Name:      "handoff_to_chatgpt",
+		Arguments: args,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, ok := res.StructuredContent.(*HandoffResult)
+	if !ok {
+		t.Fatalf("result is %T: %v", res.StructuredContent, res.Content)
+	}
+	return result
+}
+
+func TestHandoffDuplicateWindow(t *testing.T) {
+	now := fakeClock(t)
+	recent.remember(promptHash("chatgpt", "review this"), 42)
+	*now = now.Add(2 * time.Minute)
+
+	r := dryRunHandoff(t, HandoffArgs{Prompt: "review this", DryRun: true})
+	if r.DeeplinkStatus != "skipped-duplicate" || r.DuplicateOf != 42 {
+		t.Errorf("repeat in the window: status %q, duplicateOf %d; want skipped-duplicate of 42", r.DeeplinkStatus, r.DuplicateOf)
+	}
+	if decisions := strings.Join(r.Decisions, "\n"); !strings.Contains(decisions, "120 seconds ago") {
+		t.Errorf("decisions don't give the earlier handoff's age:\n%s", decisions)
+	}
+
+	r = dryRunHandoff(t, HandoffArgs{Prompt: "review this", DryRun: true, Force: true})
+	if r.DeeplinkStatus == "skipped-duplicate" || r.DuplicateOf != 0 {
+		t.Errorf("force: status %q, duplicateOf %d", r.DeeplinkStatus, r.DuplicateOf)
+	}
+
+	r = dryRunHandoff(t, HandoffArgs{Prompt: "something else", DryRun: true})
+	if r.DeeplinkStatus == "skipped-duplicate" {
+		t.Error("a different prompt was skipped as a duplicate")
+	}
+
+	*now = now.Add(DEFAULT_DUPLICATE_WINDOW)
+	r = dryRunHandoff(t, HandoffArgs{Prompt: "review this", DryRun: true})
+	if r.DeeplinkStatus == "skipped-duplicate" || r.DuplicateOf != 0 {
+		t.Errorf("repeat after the window: status %q, duplicateOf %d", r.DeeplinkStatus, r.DuplicateOf)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const DEFAULT_CHATGPT_URL = "https://chatgpt.com/"
+
+// deeplinkDropOrder lists optional query parameters in the order they are
+// removed when a deeplink exceeds the length limit. The prompt (q) is never
+// dropped; parameters not listed here are never dropped either.
+var deeplinkDropOrder = []string{"temporary-chat", "hints", "model"}
+
+// deeplinkParam is an extra query parameter appended after the prompt.
+type deeplinkParam struct {
+	Key   string
+	Value string
+}
+
+// Deeplink is the outcome of fitting a prompt and its parameters into the
+// configured length limit.
+type Deeplink struct {
+	URL     string
+	Fits    bool
+	Dropped []string
+}
+
+// validateChatGPTURL checks a --chatgpt-url value: it must be an absolute
+// https URL. Any path (e.g. a team workspace) is kept as given.
+func validateChatGPTURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("%q must use https", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", raw)
+	}
+	if u.Fragment != "" {
+		return errors.New("URL must not contain a fragment")
+	}
+	return nil
+}
+
+// gptIDPattern matches custom GPT ids such as g-abc123XYZ-code-reviewer.
+var gptIDPattern = regexp.MustCompile(`^g-[A-Za-z0-9]+(-[A-Za-z0-9-]+)?$`)
+
+func validateGPTID(id string) error {
+	if !gptIDPattern.MatchString(id) {
+		return fmt.Errorf("malformed custom GPT id %q (expected g- followed by an alphanumeric slug, e.g. g-abc123-code-review)", id)
+	}
+	return nil
+}
+
+// customGPTURL points base (the ChatGPT URL, possibly with a workspace path)
+// at a custom GPT.
+func customGPTURL(base, gptID string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return base
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/g/" + gptID + "/"
+	return u.String()
+}
+
+// escapeQueryComponent percent-encodes s for a query string. Spaces become
+// %20 rather than "+", which some intermediaries (link unfurlers, the
+// desktop app's scheme handler) pass through literally; newlines are sent
+// as %0A, which ChatGPT keeps as line breaks in the prefilled prompt.
+func escapeQueryComponent(s string) string {
+	// QueryEscape already encodes a literal "+" as %2B, so every remaining
+	// "+" stands for a space.
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// buildDeeplink assembles the full URL: the prompt first, then params in
+// the order given, so the query string is stable for a given input. This
+// is why url.Values, which sorts keys, isn't used.
+func buildDeeplink(base, prompt string, params []deeplinkParam) string {
+	var b strings.Builder
+	b.WriteString(base)
+	if strings.Contains(base, "?") {
+		b.WriteString("&q=")
+	} else {
+		b.WriteString("?q=")
+	}
+	b.WriteString(escapeQueryComponent(prompt))
+	for _, p := range params {
+		b.WriteString("&")
+		b.WriteString(escapeQueryComponent(p.Key))
+		b.WriteString("=")
+		b.WriteString(escapeQueryComponent(p.Value))
+	}
+	return b.String()
+}
+
+// fitDeeplink builds the deeplink and, while the final encoded URL is longer
+// than limit, drops optional parameters in deeplinkDropOrder. Fits is false
+// when the URL is still too long (or limit is 0, which disables deeplinks);
+// URL then holds the smallest URL that was tried.
+func fitDeeplink(base, prompt string, params []deeplinkParam, limit int) Deeplink {
+	dl := Deeplink{URL: buildDeeplink(base, prompt, params)}
+	if limit <= 0 {
+		return dl
+	}
+	for _, key := range deeplinkDropOrder {
+		if len(dl.URL) <= limit {
+			break
+		}
+		i := slices.IndexFunc(params, func(p deeplinkParam) bool { return p.Key == key })
+		if i < 0 {
+			continue
+		}
+		params = slices.Delete(slices.Clone(params), i, i+1)
+		dl.Dropped = append(dl.Dropped, key)
+		dl.URL = buildDeeplink(base, prompt, params)
+	}
+	dl.Fits = len(dl.URL) <= limit
+	return dl
+}
+
+// looksLikeResearch is the opt-in --auto-web-search heuristic: prompts that
+// start with "Research" or mention "latest" or "2025" get web search.
+func looksLikeResearch(prompt string) bool {
+	lower := strings.ToLower(prompt)
+	return strings.HasPrefix(lower, "research") ||
+		strings.Contains(lower, "latest") ||
+		strings.Contains(lower, "2025")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFitDeeplinkBoundary(t *testing.T) {
+	const base = "https://chatgpt.com/"
+	// Each é is two bytes and six characters once percent-encoded.
+	prompt := strings.Repeat("é", 100)
+	exact := len(base) + len("?q=") + 600
+	if got := len(buildDeeplink(base, prompt, nil)); got != exact {
+		t.Fatalf("the URL is %d characters, want %d", got, exact)
+	}
+	params := []deeplinkParam{{"model", "gpt-5"}, {"temporary-chat", "true"}, {"hints", "search"}}
+	full := exact + len("&model=gpt-5&temporary-chat=true&hints=search")
+
+	tests := []struct {
+		name    string
+		params  []deeplinkParam
+		limit   int
+		fits    bool
+		dropped []string
+	}{
+		{"prompt at the limit", nil, exact, true, nil},
+		{"prompt one over", nil, exact - 1, false, nil},
+		{"everything at the limit", params, full, true, nil},
+		{"one over drops temporary-chat", params, full - 1, true, []string{"temporary-chat"}},
+		{"then hints", params, exact + len("&model=gpt-5"), true, []string{"temporary-chat", "hints"}},
+		{"then model", params, exact, true, []string{"temporary-chat", "hints", "model"}},
+		{"never the prompt", params, exact - 1, false, []string{"temporary-chat", "hints", "model"}},
+		{"disabled", params, 0, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dl := fitDeeplink(base, prompt, tt.params, tt.limit)
+			if dl.Fits != tt.fits || !slices.Equal(dl.Dropped, tt.dropped) {
+				t.Errorf("limit %d: fits %v, dropped %q; want %v, %q", tt.limit, dl.Fits, dl.Dropped, tt.fits, tt.dropped)
+			}
+			if dl.Fits && len(dl.URL) > tt.limit {
+				t.Errorf("the URL is %d characters, over the %d limit", len(dl.URL), tt.limit)
+			}
+		})
+	}
+	// The caller's parameters are left alone.
+	if len(params) != 3 {
+		t.Errorf("fitDeeplink changed its params to %v", params)
+	}
+}
+
+func TestFitDeeplinkMultiByte(t *testing.T) {
+	const base = "https://chatgpt.com/"
+	for _, c := range []struct {
+		s       string
+		encoded int
+	}{{"a", 1}, {"é", 6}, {"日", 9}, {"🎉", 12}} {
+		prompt := strings.Repeat(c.s, 50)
+		exact := len(base) + len("?q=") + 50*c.encoded
+		if dl := fitDeeplink(base, prompt, nil, exact); !dl.Fits || len(dl.URL) != exact {
+			t.Errorf("%q: %d characters, fits %v; want exactly %d", c.s, len(dl.URL), dl.Fits, exact)
+		}
+		if dl := fitDeeplink(base, prompt, nil, exact-1); dl.Fits {
+			t.Errorf("%q fits a limit one below its length", c.s)
+		}
+	}
+}
+
+func TestDeeplinkQueryOrder(t *testing.T) {
+	yes := true
+	args := HandoffArgs{Prompt: "Why?", DryRun: true, Model: "gpt-5", TemporaryChat: &yes, WebSearch: &yes}
+	want := "https://chatgpt.com/?q=Why%3F&model=gpt-5&temporary-chat=true&hints=search"
+	if got := dryRunHandoff(t, args).Deeplink; got != want {
+		t.Errorf("deeplink = %s, want %s", got, want)
+	}
+
+	if got := buildDeeplink("https://chatgpt.com/?workspace=x", "a b+c", []deeplinkParam{{"z", "1"}, {"a", "2"}}); got != "https://chatgpt.com/?workspace=x&q=a%20b%2Bc&z=1&a=2" {
+		t.Errorf("buildDeeplink = %s", got)
+	}
+}
+
+func TestEscapeQueryComponent(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"plain",
+		"a b  c",
+		"1 + 1 = 2",
+		"a+b%20c%2B",
+		"line one\nline two\r\n\ttabbed",
+		"?q=x&model=y#frag",
+		"naïve 日本語 🎉",
+		"quotes \"'` and <html>",
+		"100% ~sure~ (a/b)*",
+	} {
+		escaped := escapeQueryComponent(s)
+		if strings.Contains(escaped, "+") {
+			t.Errorf("%q escapes to %s, which has a +", s, escaped)
+		}
+		if strings.ContainsAny(escaped, " \n&#?=") {
+			t.Errorf("%q escapes to %q, which isn't a safe query component", s, escaped)
+		}
+		// Both query decoders must read it back as s.
+		if got, err := url.QueryUnescape(escaped); err != nil || got != s {
+			t.Errorf("QueryUnescape(%s) = %q, %v; want %q", escaped, got, err, s)
+		}
+		if got, err := url.PathUnescape(escaped); err != nil || got != s {
+			t.Errorf("PathUnescape(%s) = %q, %v; want %q", escaped, got, err, s)
+		}
+		u, err := url.Parse(buildDeeplink("https://chatgpt.com/", s, []deeplinkParam{{"model", s}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q := u.Query(); q.Get("q") != s || q.Get("model") != s {
+			t.Errorf("the deeplink for %q reads back as %q", s, q)
+		}
+	}
+	if got := escapeQueryComponent("a b+c\n"); got != "a%20b%2Bc%0A" {
+		t.Errorf("escapeQueryComponent = %s, want a%%20b%%2Bc%%0A", got)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	CHECK_PASS = "pass"
+	CHECK_WARN = "warn"
+	CHECK_FAIL = "fail"
+)
+
+// DoctorCheck is one line of the doctor report. Only failures make the
+// command exit non-zero; warnings degrade handoffs without breaking them.
+type DoctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+type DoctorReport struct {
+	OK          bool              `json:"ok"`
+	Version     string            `json:"version"`
+	Checks      []DoctorCheck     `json:"checks"`
+	Paths       []ResolvedPath    `json:"paths"`
+	Environment EnvironmentReport `json:"environment"`
+}
+
+// doctor runs the same probes as check_environment and /health, plus a
+// clipboard round trip and the checks only startup would otherwise make.
+func doctor() DoctorReport {
+	var checks []DoctorCheck
+	add := func(name, status, format string, args ...any) {
+		checks = append(checks, DoctorCheck{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	switch path := doctorConfigPath(); {
+	case loadedConfig.err != nil:
+		add("config", CHECK_FAIL, "%v", loadedConfig.err)
+	case loadedConfig.path == "":
+		add("config", CHECK_PASS, "no config file at %s; using flags, environment and defaults", path)
+	case len(loadedConfig.unknown) > 0:
+		add("config", CHECK_WARN, "%s has unknown keys, which are ignored: %s", loadedConfig.path, strings.Join(loadedConfig.unknown, ", "))
+	default:
+		add("config", CHECK_PASS, "read %s", loadedConfig.path)
+	}
+
+	var historyErr error
+	if !noHistory {
+		historyErr = openHistorySoftly()
+	}
+	env := checkEnvironment()
+
+	var available []string
+	for _, b := range env.ClipboardBackends {
+		if b.Available {
+			available = append(available, b.Name)
+		}
+	}
+	switch {
+	case clipboardBackendName != "" && env.ClipboardBackend == "":
+		add("clipboard backends", CHECK_FAIL, "--clipboard-backend %s is not available on this machine (available: %s)", clipboardBackendName, listOrNone(available))
+	case env.ClipboardBackend == "":
+		add("clipboard backends", CHECK_FAIL, "no clipboard backend is available%s", problemSuffix(env.Clipboard.Problem))
+	case env.Clipboard.Problem != "":
+		add("clipboard backends", CHECK_WARN, "using %s (available: %s); %s", env.ClipboardBackend, strings.Join(available, ", "), env.Clipboard.Problem)
+	default:
+		add("clipboard backends", CHECK_PASS, "using %s (available: %s)", env.ClipboardBackend, strings.Join(available, ", "))
+	}
+
+	if env.ClipboardBackend != "" {
+		text := "chatgpt-handoff doctor " + time.Now().Format(time.RFC3339Nano)
+		cb, err := copyToClipboard(text, clipboardBackendName)
+		if err != nil {
+			add("clipboard round trip", CHECK_FAIL, "copying failed: %v", err)
+		} else if ok := verifyCopy(cb, text); ok == nil {
+			add("clipboard round trip", CHECK_WARN, "copied with %s, which can't be read back to check", cb.Backend)
+		} else if !*ok {
+			add("clipboard round trip", CHECK_FAIL, "copied with %s, but the clipboard doesn't hold the text afterwards", cb.Backend)
+		} else {
+			add("clipboard round trip", CHECK_PASS, "copied and read back with %s", cb.Backend)
+		}
+	}
+
+	if env.Display {
+		add("display", CHECK_PASS, "a display is available")
+	} else {
+		add("display", CHECK_WARN, "%s, so chats can't be opened (--force-deeplink opens them anyway)", env.DisplayReason)
+	}
+
+	var openers []string
+	for _, o := range env.Openers {
+		if o.Available {
+			openers = append(openers, o.Name)
+		}
+	}
+	if len(openers) > 0 {
+		add("url opener", CHECK_PASS, "links open with %s", openers[0])
+	} else {
+		add("url opener", CHECK_WARN, "no URL opener is installed, so the user has to open chats")
+	}
+
+	switch {
+	case !env.HistoryEnabled:
+		add("history", CHECK_PASS, "disabled (--no-history)")
+	case historyErr != nil:
+		add("history", CHECK_WARN, "not persisted: %v", historyErr)
+	case env.HistoryWritable:
+		add("history", CHECK_PASS, "writable: %s", env.HistoryFile)
+	default:
+		add("history", CHECK_WARN, "not persisted: %s", env.HistoryError)
+	}
+
+	switch {
+	case logFileErr != nil:
+		add("log file", CHECK_WARN, "logging to stderr only: %v", logFileErr)
+	case logFilePath != "":
+		add("log file", CHECK_PASS, "writing to %s", logFilePath)
+	default:
+		add("log file", CHECK_PASS, "off; --log-file auto keeps a log for bug reports")
+	}
+
+	if httpMode {
+		port := httpPort
+		switch l, err := listenHTTP(); {
+		case err != nil:
+			add("http port", CHECK_FAIL, "%v", err)
+		case port == 0:
+			l.Close()
+			add("http port", CHECK_PASS, "--port 0 picks a free port at startup")
+		case httpPort != port:
+			l.Close()
+			add("http port", CHECK_WARN, "port %d is in use, so --port-retry would use %d", port, httpPort)
+		default:
+			l.Close()
+			add("http port", CHECK_PASS, "port %d is free", port)
+		}
+	}
+
+	r := DoctorReport{OK: true, Version: buildInfo().Version, Checks: checks, Paths: resolvedPaths(), Environment: env}
+	for _, c := range checks {
+		r.OK = r.OK && c.Status != CHECK_FAIL
+	}
+	return r
+}
+
+// doctorConfigPath is the config file doctor reports on.
+func doctorConfigPath() string {
+	if configFile != "" {
+		return configFile
+	}
+	path, err := defaultConfigFile()
+	if err != nil {
+		return "the default location"
+	}
+	return path
+}
+
+// openHistorySoftly opens the history like startup does, but returns a
+// broken --history-file as an error instead of exiting.
+func openHistorySoftly() error {
+	path := historyFile
+	if path == "" {
+		var err error
+		if path, err = defaultHistoryFile(); err != nil {
+			return err
+		}
+	}
+	return history.open(path)
+}
+
+func listOrNone(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// printDoctor writes the report as text or, with --json, as JSON.
+func printDoctor(w io.Writer, r DoctorReport) {
+	if jsonOutput {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(r)
+		return
+	}
+	fmt.Fprintf(w, "chatgpt-handoff %s\n\n", r.Version)
+	for _, c := range r.Checks {
+		fmt.Fprintf(w, "[%s] %s: %s\n", strings.ToUpper(c.Status), c.Name, c.Detail)
+	}
+	fmt.Fprintln(w, "\nFiles:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, p := range r.Paths {
+		fmt.Fprintf(tw, "  %s\t%s", p.Name, p.Path)
+		if p.Note != "" {
+			fmt.Fprintf(tw, " (%s)", p.Note)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	if !r.OK {
+		fmt.Fprintln(w, "\nSome required checks failed; handoffs won't work until they are fixed.")
+	}
+}
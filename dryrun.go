@@ -0,0 +1,240 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// DRY_RUN_TEXT_LIMIT caps how much of the would-be clipboard text a dry
+// run puts in its text content; the structured result has all of it.
+const DRY_RUN_TEXT_LIMIT = 2000
+
+// dryRun is what handleHandoff has worked out by the time it would touch
+// the clipboard.
+type dryRun struct {
+	prompt      string
+	promptFile  string
+	parentID    int
+	followUp    string
+	target      handoffTarget
+	base        string
+	gptID       string
+	deliver     string
+	model       string
+	title       string
+	tags        []string
+	tool        string
+	language    string
+	limit       int
+	dl          Deeplink
+	preview     bool
+	clipText    string
+	clipContent string
+	chunks      int
+	backend     string
+	dlParams    []deeplinkParam
+	// tooLong is set when the prompt was too long to deeplink, even if a
+	// preview fits.
+	tooLong    bool
+	redactions map[string]int
+	priority   string
+	behavior   PriorityBehavior
+	// duplicate is the earlier handoff of the same prompt, when
+	// isDuplicate.
+	duplicate   recentPrompt
+	isDuplicate bool
+}
+
+// dryRunResult reports what a handoff would do without copying or opening
+// anything. The decisions follow the same order as handleHandoff, but
+// steps whose outcome depends on side effects (focusing a tab, DevTools,
+// auto-submit) are reported as attempts.
+func dryRunResult(ctx context.Context, args HandoffArgs, d dryRun) *mcp.CallToolResultFor[any] {
+	var decisions []string
+	if args.Direct {
+		decisions = append(decisions, fmt.Sprintf("send the prompt to the OpenAI API (%s) and return its answer; only if that fails would the handoff go on as below", cmp.Or(d.model, openaiModel)))
+	}
+	backendName := d.backend
+	if backendName != "" {
+		if _, err := lookupClipboardBackend(backendName); err != nil {
+			return toolError(err.Error())
+		}
+	} else {
+		for _, b := range clipboardBackends() {
+			if b.Available() {
+				backendName = b.Name
+				break
+			}
+		}
+	}
+	switch {
+	case remoteDelivery(d.deliver):
+		backendName = ""
+	case backendName == "":
+		decisions = append(decisions, "copy: no clipboard backend is available, so the handoff would fail")
+	default:
+		decisions = append(decisions, fmt.Sprintf("copy the %s (%d characters) with the %s clipboard backend", d.clipContent, len(d.clipText), backendName))
+	}
+	if d.deliver == "webhook" || (webhookAlways && webhookURL != "") {
+		decisions = append(decisions, fmt.Sprintf("post the prompt to the webhook on %s (%s format)", urlHost(webhookURL), webhookFormat))
+	}
+	if d.deliver == "slack" {
+		decisions = append(decisions, "post the prompt to Slack through --slack-webhook-url")
+	}
+	if d.deliver == "email" {
+		decisions = append(decisions, fmt.Sprintf("email the prompt to %s through %s:%d (%s)", strings.Join(smtpTo, ", "), smtpHost, smtpPort, smtpSecurity()))
+	}
+
+	if len(d.redactions) > 0 {
+		decisions = append(decisions, "copy the prompt with secrets redacted: "+describeRedactions(d.redactions))
+	}
+	if d.chunks > 0 {
+		decisions = append(decisions, fmt.Sprintf("split the prompt into %d parts of at most %d characters; next_handoff_chunk would copy parts 2 to %d", d.chunks, chunkSize, d.chunks))
+	}
+
+	openChat := d.behavior.Open
+	wantSubmit := autoSubmitPrompt
+	if args.AutoSubmit != nil {
+		wantSubmit = *args.AutoSubmit
+	}
+	headless := ""
+	if !forceDeeplink {
+		headless = headlessReason()
+	}
+
+	var status string
+	switch {
+	case d.isDuplicate:
+		status = "skipped-duplicate"
+		decisions = append(decisions, fmt.Sprintf("don't open a chat: an identical prompt was already handed off %d seconds ago (pass force: true to open it anyway)", int(recent.age(d.duplicate).Seconds())))
+	case wantSubmit && d.target.Name == "chatgpt" && openChat && d.deliver == "open":
+		status = "skipped-auto-submit"
+		decisions = append(decisions, "try to paste and submit the prompt in the ChatGPT desktop app (--auto-submit)")
+	case !openChat, d.deliver != "open":
+		status = "skipped-by-request"
+		decisions = append(decisions, "don't open a chat (deliver "+d.deliver+", openChat "+fmt.Sprint(openChat)+", priority "+d.priority+")")
+	case d.limit == 0:
+		status = "skipped-disabled"
+		decisions = append(decisions, "don't open a deeplink: deeplinks are disabled (max length 0)")
+	case !d.dl.Fits:
+		status = "skipped-too-long"
+		decisions = append(decisions, fmt.Sprintf("don't open a deeplink: it would be %d characters, over the %d limit", len(d.dl.URL), d.limit))
+		if cdpEndpoint != "" && d.target.Name == "chatgpt" {
+			decisions = append(decisions, "try to fill the prompt into Chrome via DevTools at "+cdpEndpoint)
+		}
+		if relayEnabled {
+			decisions = append(decisions, "serve the prompt on a one-time relay page")
+		}
+	case headless != "":
+		status = "skipped-headless"
+		decisions = append(decisions, "don't open a deeplink: "+headless)
+	default:
+		status = "opened"
+		if d.preview {
+			status = "preview"
+			decisions = append(decisions, "open a deeplink with a truncated preview of the prompt")
+		}
+		if reuseTab {
+			decisions = append(decisions, "try to focus an open "+d.target.Label+" tab before opening a new one (--reuse-tab)")
+		}
+		if deeplinkDelay > 0 {
+			decisions = append(decisions, "wait "+deeplinkDelay.String()+" before opening")
+		}
+		decisions = append(decisions, "open the deeplink on "+urlHost(d.base))
+	}
+	if d.behavior.Notify {
+		decisions = append(decisions, "show a desktop notification")
+	}
+	if d.behavior.Sound && soundEnabled && !remoteDelivery(d.deliver) {
+		decisions = append(decisions, "play the --sound cue")
+	}
+	if len(d.dl.Dropped) > 0 {
+		decisions = append(decisions, "drop deeplink parameters to fit the limit: "+strings.Join(d.dl.Dropped, ", "))
+	}
+
+	var b strings.Builder
+	tokens := estimateTokens(d.prompt)
+	if w := promptSizeWarning(ctx, tokens); w != "" {
+		b.WriteString(w + "\n\n")
+	}
+	b.WriteString("Dry run — nothing was copied or opened. The handoff would:\n")
+	for _, dec := range decisions {
+		b.WriteString("- " + dec + "\n")
+	}
+	shown := d.clipText
+	if r := []rune(shown); len(r) > DRY_RUN_TEXT_LIMIT {
+		shown = string(r[:DRY_RUN_TEXT_LIMIT]) + fmt.Sprintf("\n…(%d more characters)", len(r)-DRY_RUN_TEXT_LIMIT)
+	}
+	fmt.Fprintf(&b, "\nText that would be copied:\n\n%s", shown)
+	content := []mcp.Content{&mcp.TextContent{Text: b.String()}}
+
+	link := d.dl.URL
+	if omitDeeplink {
+		link = ""
+	} else {
+		content = append(content, &mcp.TextContent{Text: "Deeplink: " + link})
+	}
+
+	applied := func(key string) bool {
+		return slices.ContainsFunc(d.dlParams, func(p deeplinkParam) bool { return p.Key == key }) &&
+			!slices.Contains(d.dl.Dropped, key)
+	}
+
+	if !noHistory {
+		history.add(HandoffRecord{
+			Prompt:           d.prompt,
+			PromptLength:     len(d.prompt),
+			PromptFile:       d.promptFile,
+			ParentID:         d.parentID,
+			FollowUp:         d.followUp,
+			ExpectedFormat:   strings.TrimSpace(args.ExpectedFormat),
+			ResponseLanguage: d.language,
+			Title:            d.title,
+			Tags:             d.tags,
+			Tool:             d.tool,
+			Target:           d.target.Name,
+			ClipboardBackend: backendName,
+			DeeplinkStatus:   status,
+			DryRun:           true,
+		})
+	}
+
+	return &mcp.CallToolResultFor[any]{
+		Content: content,
+		StructuredContent: &HandoffResult{
+			ClipboardBackend:  backendName,
+			ClipboardContent:  d.clipContent,
+			DeeplinkStatus:    status,
+			Deeplink:          link,
+			DeeplinkLength:    len(d.dl.URL),
+			MaxDeeplinkLength: d.limit,
+			DroppedParams:     d.dl.Dropped,
+			DeeplinkExceeded:  d.tooLong,
+			Model:             d.model,
+			TemporaryChat:     applied("temporary-chat"),
+			WebSearch:         applied("hints"),
+			Target:            d.target.Name,
+			Host:              urlHost(d.base),
+			GPTID:             d.gptID,
+			Title:             d.title,
+			Tags:              d.tags,
+			DryRun:            true,
+			Copied:            d.clipText,
+			Decisions:         decisions,
+			PromptLength:      len(d.prompt),
+			CharCount:         utf8.RuneCountInString(d.prompt),
+			EstimatedTokens:   tokens,
+			Chunks:            d.chunks,
+			Redactions:        d.redactions,
+			DuplicateOf:       d.duplicate.id,
+			Priority:          d.priority,
+			PriorityBehavior:  d.behavior,
+		},
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SMTP_TIMEOUT bounds the whole SMTP session, from dialing to QUIT.
+	SMTP_TIMEOUT = 30 * time.Second
+
+	// MAX_EMAIL_SIZE caps the encoded message; many mail servers reject
+	// anything over 10MB. A smaller SIZE advertised by the server wins.
+	MAX_EMAIL_SIZE = 10 << 20
+)
+
+var (
+	smtpHost = ""
+	// smtpPort 465 uses implicit TLS; any other port upgrades with
+	// STARTTLS.
+	smtpPort = 587
+	smtpUser = ""
+	smtpPass = ""
+	smtpFrom = ""
+	smtpTo   []string
+	// smtpInsecure allows relays that don't offer STARTTLS, sending the
+	// prompt and any password in the clear (--smtp-insecure).
+	smtpInsecure = false
+)
+
+// validateSMTPConfig checks the --smtp-* flags once --smtp-host is set.
+func validateSMTPConfig() error {
+	if smtpFrom == "" || len(smtpTo) == 0 {
+		return errors.New("--smtp-host needs --smtp-from and --smtp-to")
+	}
+	if _, err := mail.ParseAddress(smtpFrom); err != nil {
+		return fmt.Errorf("--smtp-from %q: %v", smtpFrom, err)
+	}
+	for _, to := range smtpTo {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("--smtp-to %q: %v", to, err)
+		}
+	}
+	if (smtpUser == "") != (smtpPass == "") {
+		return errors.New("--smtp-user and --smtp-pass must be set together")
+	}
+	return nil
+}
+
+// smtpSecurity describes how the connection to --smtp-host is protected.
+func smtpSecurity() string {
+	switch {
+	case smtpPort == 465:
+		return "TLS"
+	case smtpInsecure:
+		return "STARTTLS when offered, otherwise no TLS"
+	default:
+		return "STARTTLS"
+	}
+}
+
+// buildEmail formats a handoff as a MIME message: the title as the subject,
+// the prompt and deeplink as a text/plain body, and, with richText, an
+// HTML alternative.
+func buildEmail(title, prompt, deeplink, label string, richText bool) ([]byte, error) {
+	text := prompt
+	if deeplink != "" {
+		text += "\n\nOpen in " + label + ": " + deeplink + "\n"
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", smtpFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(smtpTo, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", title))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+
+	if !richText {
+		msg.WriteString("Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&msg, text); err != nil {
+			return nil, err
+		}
+		return msg.Bytes(), nil
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+
+	htmlText := `<pre style="white-space: pre-wrap">` + html.EscapeString(prompt) + "</pre>\n"
+	if deeplink != "" {
+		htmlText += `<p><a href="` + html.EscapeString(deeplink) + `">Open in ` + html.EscapeString(label) + "</a></p>\n"
+	}
+	for _, part := range []struct{ contentType, text string }{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", htmlText},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.text); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, text string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(text, "\n", "\r\n"))); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// sendEmail delivers msg through --smtp-host. Errors never include the
+// password.
+func sendEmail(ctx context.Context, msg []byte) error {
+	if len(msg) > MAX_EMAIL_SIZE {
+		return fmt.Errorf("the email would be %d bytes, over the %d byte limit; send a shorter prompt", len(msg), MAX_EMAIL_SIZE)
+	}
+	addr := net.JoinHostPort(smtpHost, strconv.Itoa(smtpPort))
+	ctx, cancel := context.WithTimeout(ctx, SMTP_TIMEOUT)
+	defer cancel()
+
+	tlsConfig := &tls.Config{ServerName: smtpHost}
+	var conn net.Conn
+	var err error
+	if smtpPort == 465 {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return smtpError(addr, "connect", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, smtpHost)
+	if err != nil {
+		return smtpError(addr, "greeting", err)
+	}
+	defer c.Close()
+
+	if smtpPort != 465 {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return smtpError(addr, "STARTTLS", err)
+			}
+		} else if !smtpInsecure {
+			return fmt.Errorf("%s doesn't offer STARTTLS; use --smtp-insecure only for an internal relay you trust", addr)
+		}
+	}
+	if ok, size := c.Extension("SIZE"); ok {
+		if n, err := strconv.Atoi(size); err == nil && n > 0 && len(msg) > n {
+			return fmt.Errorf("the email would be %d bytes, over the %d bytes %s accepts; send a shorter prompt", len(msg), n, addr)
+		}
+	}
+	if smtpUser != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("%s doesn't offer authentication; drop --smtp-user and --smtp-pass for a relay that needs none", addr)
+		}
+		var auth smtp.Auth = smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
+		if _, isTLS := c.TLSConnectionState(); !isTLS {
+			// smtp.PlainAuth refuses to send a password without TLS.
+			auth = insecurePlainAuth{smtpUser, smtpPass}
+		}
+		if err := c.Auth(auth); err != nil {
+			return smtpError(addr, "login", err)
+		}
+	}
+
+	if err := c.Mail(smtpFrom); err != nil {
+		return smtpError(addr, "sender", err)
+	}
+	for _, to := range smtpTo {
+		if err := c.Rcpt(to); err != nil {
+			return smtpError(addr, "recipient "+to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return smtpError(addr, "data", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return smtpError(addr, "data", err)
+	}
+	if err := w.Close(); err != nil {
+		return smtpError(addr, "data", err)
+	}
+	return c.Quit()
+}
+
+// smtpError explains a failed SMTP step, calling out the failures a user
+// can act on.
+func smtpError(addr, step string, err error) error {
+	var perr *textproto.Error
+	var nerr net.Error
+	switch {
+	case errors.As(err, &perr) && (perr.Code == 535 || perr.Code == 534 || perr.Code == 530):
+		return fmt.Errorf("%s rejected the login for %s (%d %s); check --smtp-user and --smtp-pass", addr, smtpUser, perr.Code, perr.Msg)
+	case errors.As(err, &perr) && perr.Code == 552:
+		return fmt.Errorf("%s rejected the email as too large (%d %s); send a shorter prompt", addr, perr.Code, perr.Msg)
+	case errors.As(err, &nerr) && nerr.Timeout(), errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%s timed out during %s after %s", addr, step, SMTP_TIMEOUT)
+	}
+	return fmt.Errorf("%s failed during %s: %w", addr, step, err)
+}
+
+// insecurePlainAuth is PLAIN authentication without smtp.PlainAuth's TLS
+// check, for --smtp-insecure relays.
+type insecurePlainAuth struct {
+	user, pass string
+}
+
+func (a insecurePlainAuth) Start(*smtp.ServerInfo) (string, []byte, error) {
+	return "PLAIN", []byte("\x00" + a.user + "\x00" + a.pass), nil
+}
+
+func (a insecurePlainAuth) Next(_ []byte, more bool) ([]byte, error) {
+	if more {
+		return nil, errors.New("unexpected server challenge")
+	}
+	return nil, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// OpenerInfo is one entry of the URL opener chain and whether it is
+// installed.
+type OpenerInfo struct {
+	Name      string `json:"name"`
+	Available bool   `json:"available"`
+}
+
+// EnvironmentReport is what the server can deliver through on this
+// machine, as probed without copying or opening anything. It is shared by
+// check_environment and /health.
+type EnvironmentReport struct {
+	OS string `json:"os"`
+	// Display is false in a headless session, with DisplayReason saying
+	// why; chats can't be opened then.
+	Display       bool   `json:"display"`
+	DisplayReason string `json:"displayReason,omitempty"`
+
+	Clipboard         ClipboardDiagnosis `json:"clipboard"`
+	ClipboardBackends []BackendInfo      `json:"clipboardBackends"`
+	// ClipboardBackend is the backend a handoff would use, "" if none is
+	// available.
+	ClipboardBackend string `json:"clipboardBackend,omitempty"`
+
+	Openers    []OpenerInfo `json:"openers"`
+	CanOpenURL bool         `json:"canOpenUrl"`
+
+	HistoryEnabled  bool   `json:"historyEnabled"`
+	HistoryFile     string `json:"historyFile,omitempty"`
+	HistoryWritable bool   `json:"historyWritable"`
+	HistoryError    string `json:"historyError,omitempty"`
+
+	Webhook   bool `json:"webhook"`
+	Slack     bool `json:"slack"`
+	Email     bool `json:"email"`
+	DirectAPI bool `json:"directApi"`
+}
+
+// urlOpeners lists the commands openURL would try on this OS.
+func urlOpeners() []OpenerInfo {
+	if browser != "" {
+		return []OpenerInfo{{Name: browser, Available: validateBrowser(browser) == nil}}
+	}
+	var names []string
+	switch runtime.GOOS {
+	case "darwin":
+		names = []string{"open"}
+	case "windows":
+		names = []string{"rundll32", "cmd", "powershell"}
+	default:
+		sandboxed := inSandbox()
+		for _, o := range linuxOpeners {
+			if !o.Sandboxed || sandboxed {
+				names = append(names, o.Args[0])
+			}
+		}
+	}
+	var openers []OpenerInfo
+	for _, name := range names {
+		_, err := exec.LookPath(name)
+		openers = append(openers, OpenerInfo{Name: name, Available: err == nil})
+	}
+	return openers
+}
+
+// checkEnvironment runs the availability probes. It has no side effects:
+// the history file is opened for appending but not written.
+func checkEnvironment() EnvironmentReport {
+	r := EnvironmentReport{
+		OS:                runtime.GOOS,
+		Clipboard:         diagnoseClipboard(),
+		ClipboardBackends: DescribeBackends(),
+		Openers:           urlOpeners(),
+		HistoryEnabled:    !noHistory,
+		Webhook:           webhookURL != "",
+		Slack:             slackWebhookURL != "",
+		Email:             smtpHost != "",
+		DirectAPI:         openaiAPIKey != "",
+	}
+	r.DisplayReason = headlessReason()
+	r.Display = r.DisplayReason == ""
+	for _, b := range r.ClipboardBackends {
+		if b.Selected && b.Available {
+			r.ClipboardBackend = b.Name
+		}
+	}
+	for _, o := range r.Openers {
+		r.CanOpenURL = r.CanOpenURL || o.Available
+	}
+
+	if !noHistory {
+		r.HistoryFile = history.filePath()
+		if r.HistoryFile == "" {
+			r.HistoryError = "no history file could be opened, so handoffs are kept in memory only"
+		} else if f, err := os.OpenFile(r.HistoryFile, os.O_WRONLY|os.O_APPEND, 0); err != nil {
+			r.HistoryError = err.Error()
+		} else {
+			f.Close()
+			r.HistoryWritable = true
+		}
+	}
+	return r
+}
+
+// problems lists what would stop or degrade a handoff, one line each.
+func (r EnvironmentReport) problems() []string {
+	var problems []string
+	if r.ClipboardBackend == "" {
+		problems = append(problems, "clipboard: no clipboard backend is available"+problemSuffix(r.Clipboard.Problem))
+	} else if r.Clipboard.Problem != "" {
+		problems = append(problems, "clipboard: "+r.Clipboard.Problem)
+	}
+	switch {
+	case !r.Display:
+		problems = append(problems, "browser: "+r.DisplayReason+", so chats can't be opened")
+	case !r.CanOpenURL:
+		problems = append(problems, "browser: no URL opener is installed")
+	}
+	if r.HistoryError != "" {
+		problems = append(problems, "history: "+r.HistoryError)
+	}
+	return problems
+}
+
+func problemSuffix(problem string) string {
+	if problem == "" {
+		return ""
+	}
+	return " (" + problem + ")"
+}
+
+// summary describes r for people and agents.
+func (r EnvironmentReport) summary() string {
+	var b strings.Builder
+	if r.ClipboardBackend != "" {
+		var others []string
+		for _, info := range r.ClipboardBackends {
+			if info.Available && info.Name != r.ClipboardBackend {
+				others = append(others, info.Name)
+			}
+		}
+		fmt.Fprintf(&b, "Clipboard: ready, using %s", r.ClipboardBackend)
+		if len(others) > 0 {
+			fmt.Fprintf(&b, " (also available: %s)", strings.Join(others, ", "))
+		}
+		b.WriteString(".\n")
+	} else {
+		fmt.Fprintf(&b, "Clipboard: unavailable, so handoffs will fail%s.\n", problemSuffix(r.Clipboard.Problem))
+	}
+
+	var openers []string
+	for _, o := range r.Openers {
+		if o.Available {
+			openers = append(openers, o.Name)
+		}
+	}
+	switch {
+	case !r.Display:
+		fmt.Fprintf(&b, "Browser: no display (%s); handoffs will copy the prompt, but the user has to open the chat.\n", r.DisplayReason)
+	case len(openers) == 0:
+		b.WriteString("Browser: no URL opener found; handoffs will copy the prompt, but the user has to open the chat.\n")
+	default:
+		fmt.Fprintf(&b, "Browser: ready (%s).\n", strings.Join(openers, ", "))
+	}
+
+	switch {
+	case !r.HistoryEnabled:
+		b.WriteString("History: disabled (--no-history).\n")
+	case r.HistoryWritable:
+		fmt.Fprintf(&b, "History: saved to %s.\n", r.HistoryFile)
+	default:
+		fmt.Fprintf(&b, "History: not persisted: %s.\n", r.HistoryError)
+	}
+
+	var remote []string
+	for _, d := range []struct {
+		name string
+		ok   bool
+	}{{"webhook", r.Webhook}, {"slack", r.Slack}, {"email", r.Email}, {"direct API", r.DirectAPI}} {
+		if d.ok {
+			remote = append(remote, d.name)
+		}
+	}
+	if len(remote) == 0 {
+		remote = []string{"none configured"}
+	}
+	fmt.Fprintf(&b, "Other delivery: %s.", strings.Join(remote, ", "))
+	return b.String()
+}
+
+func handleCheckEnvironment(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[struct{}]) (*mcp.CallToolResultFor[any], error) {
+	r := checkEnvironment()
+	return &mcp.CallToolResultFor[any]{
+		Content:           []mcp.Content{&mcp.TextContent{Text: r.summary()}},
+		StructuredContent: &r,
+	}, nil
+}
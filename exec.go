@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_EXEC_TIMEOUT = 5 * time.Second
+
+	// EXEC_WAIT_DELAY is how long a finished command's output pipes may
+	// stay open. Helpers like xclip fork a process that keeps serving the
+	// selection, and it would otherwise hold the command's stderr open.
+	EXEC_WAIT_DELAY = 100 * time.Millisecond
+
+	// Multiples of --exec-timeout for commands that should take much less
+	// or much more time than most. Everything else uses 1.
+	EXEC_SCALE_LOOKUP = 0.4 // ps, lsof, pgrep, window searches, env probes
+	EXEC_SCALE_LAUNCH = 0.6 // open, rundll32 and notifications, which hand off and exit
+	EXEC_SCALE_GIT    = 2   // git diff of a large tree
+)
+
+// execTimeout is --exec-timeout, the time limit for the helper commands
+// the server runs. The --confirm dialog waits for --confirm-timeout
+// instead, and browsers started by an opener aren't limited at all.
+var execTimeout = DEFAULT_EXEC_TIMEOUT
+
+var errExecTimeout = errors.New("timed out")
+
+type promptArgsKey struct{}
+
+// withPromptArgs marks the commands run with ctx as carrying the prompt in
+// their arguments, which are then only logged with --log-prompts.
+func withPromptArgs(ctx context.Context) context.Context {
+	return context.WithValue(ctx, promptArgsKey{}, true)
+}
+
+// commandError is a command that failed, with what it wrote to stderr.
+type commandError struct {
+	err    error
+	stderr string
+}
+
+func (e *commandError) Error() string {
+	if e.stderr == "" {
+		return e.err.Error()
+	}
+	return e.err.Error() + ": " + e.stderr
+}
+
+func (e *commandError) Unwrap() error { return e.err }
+
+// commandStderr returns the stderr of a command runCommand reported as
+// failed, or "".
+func commandStderr(err error) string {
+	var cerr *commandError
+	if errors.As(err, &cerr) {
+		return cerr.stderr
+	}
+	return ""
+}
+
+// runCommand runs cmd to completion, allowing it --exec-timeout times
+// scale. The command gets a process group of its own, which is killed as
+// a whole when time runs out, so nothing it started is left behind. Its
+// stderr, unless cmd already sends it somewhere, is captured into the
+// error, and stdout goes wherever cmd sends it.
+func runCommand(ctx context.Context, scale float64, cmd *exec.Cmd) error {
+	timeout := time.Duration(float64(execTimeout) * scale)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	if cmd.Stderr == nil {
+		cmd.Stderr = &stderr
+	}
+	cmd.WaitDelay = EXEC_WAIT_DELAY
+	newProcessGroup(cmd)
+	args := cmd.Args
+	if ctx.Value(promptArgsKey{}) != nil && !logPrompts {
+		args = []string{cmd.Args[0], "[prompt omitted]"}
+	}
+	slog.Debug("running command", "command", args, "timeout", timeout)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		killProcessGroup(cmd.Process)
+		<-done
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return ctx.Err()
+		}
+		err = fmt.Errorf("%s %w after %s (raise --exec-timeout if it needs longer)", filepath.Base(cmd.Args[0]), errExecTimeout, timeout)
+		slog.Debug("command timed out", "command", cmd.Args[0], "timeout", timeout)
+		return err
+	}
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// The command succeeded; something it left running kept the pipes.
+		err = nil
+	}
+	if err != nil {
+		err = &commandError{err: err, stderr: strings.TrimSpace(stderr.String())}
+		slog.Debug("command failed", "command", cmd.Args[0], "error", err)
+	}
+	return err
+}
+
+// commandOutput is runCommand returning what the command wrote to stdout.
+func commandOutput(ctx context.Context, scale float64, cmd *exec.Cmd) ([]byte, error) {
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := runCommand(ctx, scale, cmd)
+	return stdout.Bytes(), err
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// newProcessGroup does nothing on Windows, which has no process groups to
+// signal; killProcessGroup walks the process tree instead.
+func newProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(p *os.Process) {
+	if exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(p.Pid)).Run() != nil {
+		p.Kill()
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// exportRoots are the directories export_handoffs may write into
+// (--export-root, default the working directory).
+var exportRoots []string
+
+type ExportHandoffsArgs struct {
+	Path      string `json:"path" jsonschema:"file to write, absolute or relative to the first export root"`
+	Format    string `json:"format,omitempty" jsonschema:"markdown (default) or json"`
+	Since     string `json:"since,omitempty" jsonschema:"only handoffs at or after this time: an RFC 3339 timestamp or a duration such as 8h"`
+	IDs       []int  `json:"ids,omitempty" jsonschema:"only these handoff ids"`
+	Overwrite bool   `json:"overwrite,omitempty" jsonschema:"replace the file if it already exists"`
+}
+
+// ExportHandoffsResult is the structured content returned by
+// export_handoffs.
+type ExportHandoffsResult struct {
+	Path      string `json:"path"`
+	Format    string `json:"format"`
+	Handoffs  int    `json:"handoffs"`
+	Responses int    `json:"responses"`
+}
+
+// resolveExportPath turns path into an absolute path inside one of the
+// export roots. Symlinks in the existing part of the path are resolved
+// first, so a link cannot lead outside a root.
+func resolveExportPath(path string) (string, error) {
+	if len(exportRoots) == 0 {
+		return "", errors.New("no export root configured")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(exportRoots[0], path)
+	}
+	path = filepath.Clean(path)
+	dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return "", fmt.Errorf("directory of %s: %w", path, err)
+	}
+	path = filepath.Join(dir, filepath.Base(path))
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("%s is a symlink", path)
+	}
+
+	if withinRoots(path, exportRoots) == "" {
+		return "", fmt.Errorf("%s is outside the export roots (%s)", path, strings.Join(exportRoots, ", "))
+	}
+	return path, nil
+}
+
+// withinRoots returns the root (with symlinks resolved) that strictly
+// contains path, or "" if none does. path must already be resolved.
+func withinRoots(path string, roots []string) string {
+	for _, root := range roots {
+		real, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(real, path)
+		if err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return real
+		}
+	}
+	return ""
+}
+
+// markdownFence returns a backtick fence longer than any backtick run in s,
+// so fenced blocks inside the prompt cannot close the export's own fence.
+func markdownFence(s string) string {
+	longest, run := 0, 0
+	for _, c := range s {
+		if c == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+func writeFenced(b *strings.Builder, s string) {
+	fence := markdownFence(s)
+	fmt.Fprintf(b, "%s\n%s\n%s\n", fence, strings.TrimRight(s, "\n"), fence)
+}
+
+// exportMarkdown renders records as a Markdown transcript, one section per
+// handoff.
+func exportMarkdown(records []HandoffRecord, now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Handoff export\n\nExported %s, %d handoff(s).\n", now.Format(time.DateTime), len(records))
+	for _, r := range records {
+		fmt.Fprintf(&b, "\n## Handoff %d — %s\n\n", r.ID, r.title())
+		fmt.Fprintf(&b, "- Time: %s\n- Target: %s\n- Outcome: %s\n- Prompt length: %d\n", r.Time.Format(time.DateTime), r.Target, r.DeeplinkStatus, r.PromptLength)
+		if len(r.Tags) > 0 {
+			fmt.Fprintf(&b, "- Tags: %s\n", strings.Join(r.Tags, ", "))
+		}
+		if r.ParentID != 0 {
+			fmt.Fprintf(&b, "- Follow-up to: Handoff %d\n", r.ParentID)
+		}
+		b.WriteString("\n### Prompt\n\n")
+		writeFenced(&b, r.Prompt)
+		for i, resp := range r.Responses {
+			title := "Response"
+			if i > 0 {
+				title = fmt.Sprintf("Response (revision %d)", i+1)
+			}
+			fmt.Fprintf(&b, "\n### %s\n\nRecorded %s.\n\n", title, resp.Time.Format(time.DateTime))
+			writeFenced(&b, resp.Text)
+		}
+	}
+	return b.String()
+}
+
+func handleExportHandoffs(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[ExportHandoffsArgs]) (*mcp.CallToolResultFor[any], error) {
+	args := params.Arguments
+	format := strings.ToLower(args.Format)
+	switch format {
+	case "":
+		format = "markdown"
+	case "markdown", "json":
+	default:
+		return toolError(fmt.Sprintf("invalid params: format %q must be markdown or json", args.Format)), nil
+	}
+	if strings.TrimSpace(args.Path) == "" {
+		return toolError("invalid params: path is required"), nil
+	}
+	since, err := parseSince(args.Since, time.Now())
+	if err != nil {
+		return toolError("invalid params: " + err.Error()), nil
+	}
+	path, err := resolveExportPath(args.Path)
+	if err != nil {
+		return toolError("invalid params: " + err.Error()), nil
+	}
+
+	records := []HandoffRecord{}
+	err = history.scan(func(r HandoffRecord) bool {
+		if !r.Time.Before(since) && (len(args.IDs) == 0 || slices.Contains(args.IDs, r.ID)) {
+			records = append(records, r)
+		}
+		return true
+	})
+	if err != nil {
+		return toolError("reading history: " + err.Error()), nil
+	}
+	if len(records) == 0 {
+		return toolError("no handoffs match; nothing was written"), nil
+	}
+
+	var data []byte
+	if format == "json" {
+		if data, err = json.MarshalIndent(records, "", "  "); err != nil {
+			return nil, err
+		}
+		data = append(data, '\n')
+	} else {
+		data = []byte(exportMarkdown(records, time.Now()))
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !args.Overwrite {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return toolError(path + " already exists; pass overwrite: true to replace it"), nil
+	} else if err != nil {
+		return toolError("writing export: " + err.Error()), nil
+	}
+	_, err = f.Write(data)
+	if err = errors.Join(err, f.Close()); err != nil {
+		return toolError("writing export: " + err.Error()), nil
+	}
+
+	result := &ExportHandoffsResult{Path: path, Format: format, Handoffs: len(records)}
+	for _, r := range records {
+		result.Responses += len(r.Responses)
+	}
+	return &mcp.CallToolResultFor[any]{
+		Content: []mcp.Content{&mcp.TextContent{Text: fmt.Sprintf("Exported %d handoff(s) and %d response(s) to %s.",
+			result.Handoffs, result.Responses, path)}},
+		StructuredContent: result,
+	}, nil
+}
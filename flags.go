@@ -0,0 +1,599 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// flagFunc is a flag.Value that parses and stores its argument through
+// set; def is what --help shows as the default.
+type flagFunc struct {
+	def string
+	set func(string) error
+}
+
+func (f flagFunc) String() string     { return f.def }
+func (f flagFunc) Set(s string) error { return f.set(s) }
+
+// intFlag accepts an integer from min to max.
+func intFlag(p *int, min, max int, expect string) flagFunc {
+	return flagFunc{strconv.Itoa(*p), func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return errors.New("expected " + expect)
+		}
+		*p = n
+		return nil
+	}}
+}
+
+// durationFlag accepts a duration that is positive, or non-negative when
+// zero is allowed.
+func durationFlag(p *time.Duration, allowZero bool, expect string) flagFunc {
+	return flagFunc{p.String(), func(s string) error {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 || (d == 0 && !allowZero) {
+			return errors.New("expected " + expect)
+		}
+		*p = d
+		return nil
+	}}
+}
+
+// textFlag accepts text or @file, which must not be empty when required.
+func textFlag(p *string, required bool) flagFunc {
+	return flagFunc{*p, func(s string) error {
+		text, err := readTextFlag(s)
+		if err != nil {
+			return err
+		}
+		if required {
+			if text = strings.TrimSpace(text); text == "" {
+				return errors.New("the text must not be empty")
+			}
+		}
+		*p = text
+		return nil
+	}}
+}
+
+// dirFlag appends an existing directory, made absolute, to *p.
+func dirFlag(p *[]string) flagFunc {
+	return flagFunc{"", func(s string) error {
+		root, err := filepath.Abs(s)
+		if err != nil {
+			return err
+		}
+		if info, err := os.Stat(root); err != nil || !info.IsDir() {
+			return errors.New("not a directory")
+		}
+		*p = append(*p, root)
+		return nil
+	}}
+}
+
+func checkHTTPURL(s string, httpsOnly bool) error {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && (httpsOnly || u.Scheme != "http")) {
+		if httpsOnly {
+			return errors.New("expected an https URL")
+		}
+		return errors.New("expected an http or https URL")
+	}
+	return nil
+}
+
+// registerGlobalFlags adds the flags every command accepts.
+func registerGlobalFlags(fs *flag.FlagSet) {
+	fs.StringVar(&configFile, "config", configFile, "config file `path` (default $XDG_CONFIG_HOME/chatgpt-handoff/config.json)")
+	fs.Var(flagFunc{"info", func(s string) error {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(s)); err != nil {
+			return errors.New("expected debug, info, warn or error")
+		}
+		logLevel.Set(level)
+		slog.SetLogLoggerLevel(level)
+		return nil
+	}}, "log-level", "log `level`: debug, info, warn or error")
+}
+
+// registerJSONFlag adds --json to the commands that can print JSON.
+func registerJSONFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&jsonOutput, "json", jsonOutput, "print the result as JSON")
+}
+
+// registerServeFlags adds serve's flags: the server settings plus the
+// switches that predate the subcommands.
+func registerServeFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&showVersion, "version", showVersion, "print the version and build information and exit (same as the version command)")
+	fs.BoolVar(&listClipboardBackends, "list-clipboard-backends", listClipboardBackends, "print the clipboard backends and exit (same as the clipboard-backends command)")
+	fs.BoolVar(&daemonMode, "daemon", daemonMode, "with --http, run in the background, logging to --log-file (default auto); see the stop and status commands")
+	registerServerFlags(fs)
+}
+
+// registerDataDirFlag is separate so stop and status, which only need
+// the PID file, accept it too.
+func registerDataDirFlag(fs *flag.FlagSet) {
+	fs.Var(flagFunc{"", func(s string) error {
+		if strings.TrimSpace(s) == "" {
+			return errors.New("expected a directory path")
+		}
+		dir, err := filepath.Abs(s)
+		if err != nil {
+			return err
+		}
+		dataDir = dir
+		return nil
+	}}, "data-dir", "keep the history, logs, PID file, templates and other files in this `dir` instead of the XDG directories")
+}
+
+// registerServerFlags adds every server setting, each of which is also a
+// config file key. Defaults are taken from the globals' current values,
+// so it must run before any setting is applied.
+func registerServerFlags(fs *flag.FlagSet) {
+	// Server
+	fs.BoolVar(&httpMode, "http", httpMode, "serve MCP over HTTP instead of stdio")
+	fs.Var(intFlag(&httpPort, 0, 65535, "a port number from 0 to 65535"), "port", "HTTP server `port` (only with --http; 0 picks a free one)")
+	fs.Var(intFlag(&portRetry, 0, 100, "a number of ports from 0 to 100"), "port-retry", "when --port is in use, try up to this many following `ports`")
+	fs.Var(flagFunc{logFormat, func(s string) error {
+		if s != "text" && s != "json" {
+			return errors.New("expected text or json")
+		}
+		logFormat = s
+		return nil
+	}}, "log-format", "log `format`: text or json")
+	fs.BoolVar(&logPrompts, "log-prompts", logPrompts, "include prompt text in the log, under the prompt field")
+	registerDataDirFlag(fs)
+	fs.StringVar(&logFile, "log-file", logFile, "also write the log to this `path`, or to chatgpt-handoff.log in the state directory with auto")
+	fs.Var(intFlag(&logMaxSize, 1, 1<<20, "a positive number of megabytes"), "log-max-size", "rotate the log file at this many `megabytes`")
+	fs.Var(intFlag(&logMaxFiles, 0, 100, "a number of files from 0 to 100"), "log-max-files", "rotated log `files` to keep")
+	fs.Var(durationFlag(&execTimeout, false, "a positive duration such as 10s"), "exec-timeout", "time limit for helper commands such as clipboard tools, notifications and git (`duration`)")
+
+	// Clipboard
+	fs.StringVar(&clipboardBackendName, "clipboard-backend", clipboardBackendName, "always use this clipboard backend `name`")
+	fs.StringVar(&clipboardCommand, "clipboard-command", clipboardCommand, "custom clipboard `command` that reads the prompt from stdin")
+	fs.BoolVar(&allowClipboardRead, "allow-clipboard-read", allowClipboardRead, "enable the read_clipboard and wait_for_clipboard_change tools")
+	fs.Var(intFlag(&clipboardReadLimit, 1, math.MaxInt, "a positive number of bytes"), "clipboard-read-limit", "largest clipboard content read_clipboard returns, in `bytes`")
+	fs.StringVar(&fallbackFile, "fallback-file", fallbackFile, "write the prompt to this `path` when no clipboard is usable, or to fallback-prompt.md in the data directory with auto")
+	fs.BoolVar(&clipboardWrapper, "clipboard-wrapper", clipboardWrapper, "surround the copied prompt with start/end markers")
+	fs.StringVar(&clipboardWrapperStart, "clipboard-wrapper-start", clipboardWrapperStart, "start marker `template`; {timestamp} and {title} are substituted")
+	fs.StringVar(&clipboardWrapperEnd, "clipboard-wrapper-end", clipboardWrapperEnd, "end marker `template`")
+	fs.BoolVar(&preserveCodeLineEndings, "preserve-code-line-endings", preserveCodeLineEndings, "leave line endings inside fenced code blocks untouched")
+
+	// Delivery
+	fs.Var(flagFunc{"", func(s string) error {
+		if err := checkHTTPURL(s, false); err != nil {
+			return err
+		}
+		webhookURL = s
+		return nil
+	}}, "webhook-url", "webhook `url` for deliver: webhook")
+	fs.Var(flagFunc{webhookFormat, func(s string) error {
+		if s != "json" && s != "ntfy" {
+			return errors.New("expected json or ntfy")
+		}
+		webhookFormat = s
+		return nil
+	}}, "webhook-format", "webhook body `format`: json or ntfy")
+	fs.Var(flagFunc{"", func(s string) error {
+		if err := validateWebhookHeader(s); err != nil {
+			return err
+		}
+		webhookHeaders = append(webhookHeaders, s)
+		return nil
+	}}, "webhook-header", "extra webhook request `header` \"Name: value\" (repeatable)")
+	fs.StringVar(&webhookToken, "webhook-token", webhookToken, "send Authorization: Bearer `token` with webhook requests")
+	fs.BoolVar(&webhookAlways, "webhook-always", webhookAlways, "post every handoff to the webhook as well")
+	fs.Var(flagFunc{"", func(s string) error {
+		if err := checkHTTPURL(s, true); err != nil {
+			return err
+		}
+		slackWebhookURL = s
+		return nil
+	}}, "slack-webhook-url", "Slack incoming webhook `url` for deliver: slack")
+	fs.StringVar(&openaiAPIKey, "openai-api-key", "", "OpenAI API `key` for direct: true (also read from OPENAI_API_KEY)")
+	fs.Var(flagFunc{openaiBaseURL, func(s string) error {
+		if err := checkHTTPURL(s, false); err != nil {
+			return err
+		}
+		openaiBaseURL = s
+		return nil
+	}}, "openai-base-url", "OpenAI API base `url`")
+	fs.StringVar(&openaiModel, "openai-model", openaiModel, "`model` for direct handoffs")
+	fs.Var(durationFlag(&openaiTimeout, false, "a positive duration such as 90s"), "openai-timeout", "how long to wait for a direct answer (`duration`)")
+	fs.StringVar(&smtpHost, "smtp-host", smtpHost, "mail server `host` for deliver: email")
+	fs.Var(intFlag(&smtpPort, 1, 65535, "a port number"), "smtp-port", "mail server `port`")
+	fs.StringVar(&smtpUser, "smtp-user", smtpUser, "SMTP login `user`")
+	fs.StringVar(&smtpPass, "smtp-pass", smtpPass, "SMTP `password`")
+	fs.StringVar(&smtpFrom, "smtp-from", smtpFrom, "sender `address`")
+	fs.Var(flagFunc{"", func(s string) error {
+		for _, to := range strings.Split(s, ",") {
+			if to = strings.TrimSpace(to); to != "" {
+				smtpTo = append(smtpTo, to)
+			}
+		}
+		return nil
+	}}, "smtp-to", "comma-separated recipient `addresses` (repeatable)")
+	fs.BoolVar(&smtpInsecure, "smtp-insecure", smtpInsecure, "allow SMTP relays that don't offer STARTTLS")
+
+	// Prompt
+	fs.Var(textFlag(&promptPrefix, false), "prompt-prefix", "`text` or @file put before every prompt")
+	fs.Var(textFlag(&promptSuffix, false), "prompt-suffix", "`text` or @file put after every prompt")
+	fs.Var(flagFunc{defaultResponseLanguage, func(s string) error {
+		language, err := normalizeLanguage(s)
+		if err != nil {
+			return err
+		}
+		defaultResponseLanguage = language
+		return nil
+	}}, "default-response-language", "ask for every answer in this `language`, e.g. de or pt-BR")
+	fs.Var(intFlag(&maxPromptLength, 1, math.MaxInt, "a positive number of bytes"), "max-prompt-length", "largest final prompt a handoff accepts, in `bytes`")
+	fs.BoolVar(&redactSecrets, "redact-secrets", redactSecrets, "replace secrets in every prompt with [REDACTED:<type>]")
+	fs.Var(flagFunc{"", func(s string) error {
+		p, err := parseRedactionPattern(s)
+		if err != nil {
+			return err
+		}
+		customRedactions = append(customRedactions, p)
+		return nil
+	}}, "redact-pattern", "extra `name=regex` to redact (repeatable)")
+	fs.Var(intFlag(&tokenWarning, 0, math.MaxInt, "a number of tokens (0 disables the warning)"), "token-warning", "warn above this many estimated `tokens` (0 disables)")
+	fs.Var(intFlag(&chunkSize, MIN_CHUNK_SIZE, math.MaxInt, fmt.Sprintf("a number of characters, at least %d", MIN_CHUNK_SIZE)), "chunk-size", "`characters` per part of a chunked handoff")
+	fs.Var(intFlag(&autoChunkAbove, 0, math.MaxInt, "a number of characters (0 disables it)"), "auto-chunk-above", "chunk prompts longer than this many `characters` (0 disables)")
+	fs.Var(intFlag(&followupDepth, 1, math.MaxInt, "a positive number of handoffs"), "followup-depth", "how many earlier `handoffs` a follow-up includes")
+	fs.Var(intFlag(&followupResponseBudget, 0, math.MaxInt, "a number of characters"), "followup-response-budget", "`characters` of each earlier response a follow-up includes")
+
+	// Deeplinks
+	fs.Var(flagFunc{strconv.Itoa(maxDeeplinkLength), func(s string) error {
+		n, err := parseDeeplinkLength(s)
+		if err != nil {
+			return err
+		}
+		maxDeeplinkLength = n
+		return nil
+	}}, "max-deeplink-length", fmt.Sprintf("longest deeplink URL to open, in `characters` (0 disables, max %d)", MAX_DEEPLINK_LENGTH_LIMIT))
+	fs.Var(flagFunc{chatgptURL, func(s string) error {
+		if err := validateChatGPTURL(s); err != nil {
+			return err
+		}
+		chatgptURL = s
+		return nil
+	}}, "chatgpt-url", "base `url` for deeplinks")
+	fs.BoolVar(&preferDesktopApp, "prefer-desktop-app", preferDesktopApp, "open the ChatGPT desktop app when installed (macOS, Windows)")
+	fs.StringVar(&targetApp, "target-app", targetApp, "on macOS, open deeplinks with this `app`")
+	fs.StringVar(&browser, "browser", browser, "open deeplinks in this `browser`")
+	fs.StringVar(&browserProfile, "browser-profile", browserProfile, "open deeplinks in this browser `profile`")
+	fs.BoolVar(&forceDeeplink, "force-deeplink", forceDeeplink, "open deeplinks even when no local display is detected")
+	fs.BoolVar(&incognito, "incognito", incognito, "open deeplinks in a private window")
+	fs.BoolVar(&omitDeeplink, "omit-deeplink-from-result", omitDeeplink, "leave the deeplink out of tool results")
+	fs.StringVar(&defaultModel, "default-model", defaultModel, "open deeplinks with this ChatGPT model `slug`")
+	fs.Var(flagFunc{defaultGPT, func(s string) error {
+		if err := validateGPTID(s); err != nil {
+			return err
+		}
+		defaultGPT = s
+		return nil
+	}}, "default-gpt", "open ChatGPT deeplinks in this custom GPT `id`")
+	fs.BoolVar(&temporaryChat, "temporary-chat", temporaryChat, "open deeplinks as temporary chats")
+	fs.BoolVar(&autoWebSearch, "auto-web-search", autoWebSearch, "pre-select web search for research prompts")
+	fs.BoolVar(&autoSubmitPrompt, "auto-submit", autoSubmitPrompt, "macOS only: paste and submit the prompt in the ChatGPT app")
+	fs.Var(durationFlag(&deeplinkDelay, true, "a non-negative duration such as 300ms"), "deeplink-delay", "wait this long after copying before opening the deeplink (`duration`)")
+	fs.BoolVar(&deeplinkPreview, "deeplink-preview", deeplinkPreview, "open a preview when a prompt is too long to deeplink")
+	fs.BoolVar(&reuseTab, "reuse-tab", reuseTab, "focus an already open chat instead of opening a new one")
+	fs.Var(flagFunc{cdpEndpoint, func(s string) error {
+		if err := validateCDPEndpoint(s); err != nil {
+			return err
+		}
+		cdpEndpoint = s
+		return nil
+	}}, "cdp-endpoint", "Chrome DevTools `url` for filling in long prompts")
+	fs.BoolVar(&relayEnabled, "relay", relayEnabled, "serve long prompts on a one-time local page")
+	fs.BoolVar(&qrCode, "qr", qrCode, "also return the deeplink as a QR code")
+
+	// Priority, confirmation and feedback
+	fs.Var(flagFunc{defaultPriority, func(s string) error {
+		if err := validatePriority(s); err != nil {
+			return err
+		}
+		defaultPriority = s
+		return nil
+	}}, "default-priority", "`priority` of calls that don't pass one: low, normal or high")
+	fs.Var(flagFunc{"", func(s string) error {
+		level, b, err := parsePriorityBehavior(s)
+		if err != nil {
+			return err
+		}
+		priorityBehaviors[level] = b
+		return nil
+	}}, "priority-behavior", "what a priority level does, as `level=behavior,...` from open, preview, notify and sound (repeatable)")
+	fs.BoolVar(&confirmHandoffs, "confirm", confirmHandoffs, "ask in a local dialog before each handoff")
+	fs.Var(durationFlag(&confirmTimeout, false, "a positive duration such as 30s"), "confirm-timeout", "how long the --confirm dialog waits (`duration`)")
+	fs.BoolVar(&notifyEnabled, "notify", notifyEnabled, "show a desktop notification after each handoff")
+	fs.BoolVar(&soundEnabled, "sound", soundEnabled, "play a sound once the prompt is on the clipboard")
+	fs.BoolVar(&errorSoundEnabled, "error-sound", errorSoundEnabled, "play a sound when copying fails")
+	fs.Var(flagFunc{soundCommand, func(s string) error {
+		soundCommand, soundEnabled = s, true
+		return nil
+	}}, "sound-cmd", "`command` that plays the success sound; implies --sound")
+	fs.Var(flagFunc{errorSoundCommand, func(s string) error {
+		errorSoundCommand, errorSoundEnabled = s, true
+		return nil
+	}}, "error-sound-cmd", "`command` that plays the failure sound; implies --error-sound")
+
+	// History
+	fs.StringVar(&historyFile, "history-file", historyFile, "handoff history `path` (default history.jsonl in the data directory)")
+	fs.BoolVar(&noHistory, "no-history", noHistory, "don't record handoffs at all")
+	fs.Var(flagFunc{"", func(s string) error {
+		d, err := parseAge(s)
+		if err != nil || d == 0 {
+			return errors.New("expected a positive duration such as 720h or 30d")
+		}
+		historyRetention = d
+		return nil
+	}}, "retention", "at startup, delete handoffs older than this `duration`, e.g. 30d")
+	fs.Var(durationFlag(&duplicateWindow, true, "a duration such as 10m, or 0 to turn the check off"), "duplicate-window", "how long an identical prompt counts as a retry (`duration`, 0 disables)")
+	fs.Var(intFlag(&queueDepth, 1, math.MaxInt, "a positive number"), "queue-depth", "how many queued `handoffs` can wait")
+	fs.Var(flagFunc{"", func(s string) error {
+		if strings.TrimSpace(s) == "" {
+			return errors.New("expected a file path")
+		}
+		transcriptFile = s
+		return nil
+	}}, "transcript-file", "append every handoff and response to this Markdown `path`, or to transcript.md in the data directory with auto")
+	fs.Var(flagFunc{"", func(s string) error {
+		tags, err := normalizeTags(strings.Split(s, ","))
+		if err != nil {
+			return err
+		}
+		defaultTags = tags
+		return nil
+	}}, "default-tags", "comma-separated `tags` added to every handoff")
+	fs.Var(dirFlag(&exportRoots), "export-root", "`dir` export_handoffs may write into (repeatable; default the working directory)")
+
+	// Tools
+	fs.Var(flagFunc{"", func(s string) error {
+		handoffTools, copyToolEnabled = nil, false
+		for _, name := range strings.Split(s, ",") {
+			name = strings.TrimSpace(name)
+			if name == "copy_to_clipboard" {
+				copyToolEnabled = true
+				continue
+			}
+			if _, err := lookupTarget(name); err != nil {
+				return errors.New(strings.TrimPrefix(err.Error(), "invalid params: "))
+			}
+			if !slices.Contains(handoffTools, name) {
+				handoffTools = append(handoffTools, name)
+			}
+		}
+		return nil
+	}}, "tools", "expose one tool per `target`, comma-separated (and copy_to_clipboard)")
+	fs.Var(textFlag(&toolDescription, true), "tool-description", "`text` or @file replacing the handoff_to_chatgpt description")
+	fs.Var(textFlag(&toolDescriptionAppend, true), "tool-description-append", "`text` or @file added to the handoff_to_chatgpt description")
+	fs.Var(flagFunc{"", func(s string) error {
+		alias, tool, err := parseToolAlias(s)
+		if err != nil {
+			return err
+		}
+		toolAliases[alias] = tool
+		return nil
+	}}, "tool-alias", "also expose a tool as `alias=tool` (repeatable)")
+	fs.Var(flagFunc{toolAliasMode, func(s string) error {
+		if s != "add" && s != "replace" {
+			return errors.New("expected add or replace")
+		}
+		toolAliasMode = s
+		return nil
+	}}, "tool-alias-mode", "list aliases next to their tools or instead of them (`mode`: add or replace)")
+	fs.BoolVar(&toolVariants, "tool-variants", toolVariants, "also register research_with_chatgpt and debug_with_chatgpt")
+	fs.StringVar(&templatesDir, "templates-dir", templatesDir, "`dir` of prompt templates (default templates in the config directory, or in --data-dir)")
+
+	// Attachments and environment
+	fs.Var(dirFlag(&attachmentRoots), "attachment-root", "`dir` attachments may be read from (repeatable; default the working directory)")
+	fs.Var(intFlag(&maxAttachmentBytes, 1, math.MaxInt, "a positive number of bytes"), "max-attachment-bytes", "largest single attachment, in `bytes`")
+	fs.Var(intFlag(&maxAttachmentsTotal, 1, math.MaxInt, "a positive number of bytes"), "max-attachments-total", "largest total of a handoff's attachments, in `bytes`")
+	fs.Var(flagFunc{"", func(s string) error {
+		envProbeCommands = nil
+		for _, command := range strings.Split(s, ",") {
+			if command = strings.TrimSpace(command); command != "" {
+				envProbeCommands = append(envProbeCommands, command)
+			}
+		}
+		return nil
+	}}, "env-probe-commands", "comma-separated `commands` whose output includeSystemInfo adds")
+	fs.Var(flagFunc{"", func(s string) error {
+		disabledEnvProbes = nil
+		for _, name := range strings.Split(s, ",") {
+			disabledEnvProbes = append(disabledEnvProbes, strings.TrimSpace(name))
+		}
+		return nil
+	}}, "disable-env-probes", "comma-separated probe `names` to leave out of includeSystemInfo")
+}
+
+type subcommand struct {
+	name, args, summary string
+	register            func(fs *flag.FlagSet)
+}
+
+// subcommands are the CLI's commands, each with its own flags on top of
+// the global ones. serve runs when no command is given; install and
+// uninstall parse their own arguments (runInstall).
+var subcommands = []subcommand{
+	{"serve", "[flags]", "run the MCP server over stdio, or over HTTP with --http (the default)", registerServeFlags},
+	{"send", "[flags] PROMPT | - | -f FILE", "hand off one prompt from the shell", func(fs *flag.FlagSet) {
+		registerServerFlags(fs)
+		registerSendFlags(fs)
+		registerJSONFlag(fs)
+	}},
+	{"doctor", "[--json] [flags]", "check the clipboard, browser, history, config file and port", func(fs *flag.FlagSet) {
+		registerServerFlags(fs)
+		registerJSONFlag(fs)
+	}},
+	{"history", "[--json] [--limit N] [--since AGE] [--tag TAG] [flags] [ID]", "list recent handoffs, or show one", func(fs *flag.FlagSet) {
+		registerServerFlags(fs)
+		registerHistoryFlags(fs)
+		registerJSONFlag(fs)
+	}},
+	{"clipboard-backends", "[flags]", "list the clipboard backends and which one would be used", registerServerFlags},
+	{"stop", "[--timeout DURATION] [--data-dir DIR]", "stop a server started with serve --daemon", func(fs *flag.FlagSet) {
+		registerStopFlags(fs)
+		registerDataDirFlag(fs)
+	}},
+	{"status", "[--data-dir DIR]", "report whether a server started with serve --daemon is running", registerDataDirFlag},
+	{"install", "--client NAME [--print|--apply] [-- flags]", "add the server to an MCP client's config", nil},
+	{"uninstall", "--client NAME", "remove the server from an MCP client's config", nil},
+	{"version", "", "print the version and build information", func(*flag.FlagSet) {}},
+}
+
+// command is the subcommand being run.
+var command = "serve"
+
+// newFlagSet returns the flags of the named command.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("chatgpt-handoff "+name, flag.ExitOnError)
+	registerGlobalFlags(fs)
+	for _, c := range subcommands {
+		if c.name == name {
+			c.register(fs)
+		}
+	}
+	fs.Usage = func() { printUsage(fs, name) }
+	return fs
+}
+
+// settingNames are the keys the config file may set.
+func settingNames() []string {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	registerGlobalFlags(fs)
+	registerServerFlags(fs)
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) { names = append(names, f.Name) })
+	return names
+}
+
+// printUsage lists a command's flags with their defaults and environment
+// variables, GNU style. serve's usage also lists the commands.
+func printUsage(fs *flag.FlagSet, name string) {
+	w := fs.Output()
+	for _, c := range subcommands {
+		if c.name == name {
+			fmt.Fprintf(w, "Usage: %s\n\n%s.\n", strings.TrimSpace("chatgpt-handoff "+name+" "+c.args), strings.ToUpper(c.summary[:1])+c.summary[1:])
+		}
+	}
+	if name == "serve" {
+		fmt.Fprint(w, "\nCommands:\n")
+		for _, c := range subcommands {
+			fmt.Fprintf(w, "  %-20s%s\n", c.name, c.summary)
+		}
+		fmt.Fprint(w, "\nRun chatgpt-handoff COMMAND --help for a command's flags. Global flags (--config, --log-level) are accepted before or after the command.\n")
+	}
+	fmt.Fprint(w, "\nFlags:\n")
+	fs.VisitAll(func(f *flag.Flag) {
+		name, usage := flag.UnquoteUsage(f)
+		fmt.Fprintf(w, "  --%s", f.Name)
+		if name != "" {
+			fmt.Fprintf(w, " %s", name)
+		}
+		fmt.Fprintf(w, "\n    \t%s", usage)
+		if f.DefValue != "" && !isBoolFlag(f) {
+			if strings.Contains(f.DefValue, " ") {
+				fmt.Fprintf(w, " (default %q)", f.DefValue)
+			} else {
+				fmt.Fprintf(w, " (default %s)", f.DefValue)
+			}
+		}
+		if !commandLineOnly(f.Name) {
+			fmt.Fprintf(w, " [env %s]", envName(f.Name))
+		}
+		fmt.Fprintln(w)
+	})
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// parseFlags picks the command and resolves its settings from the command
+// line, environment and config file. Invalid or unknown flags print a
+// message and exit with status 2; -h and --help print the usage and exit.
+func parseFlags() {
+	args := os.Args[1:]
+	// Global flags may come before the command.
+	var global []string
+	for len(args) > 0 {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[0], "-"), "=")
+		if !strings.HasPrefix(args[0], "-") || (name != "config" && name != "log-level") {
+			break
+		}
+		n := 1
+		if !hasValue && len(args) > 1 {
+			n = 2
+		}
+		global, args = append(global, args[:n]...), args[n:]
+	}
+
+	explicit := false
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		if !slices.ContainsFunc(subcommands, func(c subcommand) bool { return c.name == args[0] }) {
+			fmt.Fprintf(os.Stderr, "unknown command %q; run chatgpt-handoff --help for the list\n", args[0])
+			os.Exit(2)
+		}
+		command, args, explicit = args[0], args[1:], true
+	}
+	if command == "install" || command == "uninstall" {
+		os.Exit(runInstall(command, append(global, args...)))
+	}
+
+	fs := newFlagSet(command)
+	args = append(global, args...)
+	if !explicit && slices.ContainsFunc(args, func(a string) bool {
+		name, _, _ := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		return strings.HasPrefix(a, "-") && (name == "http" || name == "port")
+	}) {
+		slog.Warn("--http and --port without a command are deprecated; use chatgpt-handoff serve --http --port N")
+	}
+	resolveSettings(fs, args)
+
+	switch {
+	case command == "send":
+		sendPromptArgs = fs.Args()
+	case command == "history" && fs.NArg() > 0:
+		id, err := strconv.Atoi(fs.Arg(0))
+		if err != nil || id <= 0 {
+			fmt.Fprintf(fs.Output(), "invalid handoff id %q\n", fs.Arg(0))
+			os.Exit(2)
+		}
+		historyShowID = id
+		// Flags may follow the ID too.
+		if fs.Parse(fs.Args()[1:]); fs.NArg() > 0 {
+			fmt.Fprintf(fs.Output(), "unexpected argument %q\n", fs.Arg(0))
+			os.Exit(2)
+		}
+	case fs.NArg() > 0:
+		fmt.Fprintf(fs.Output(), "unexpected argument %q\n", fs.Arg(0))
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+func parseDeeplinkLength(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("not an integer")
+	}
+	if err := validateDeeplinkLength(n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
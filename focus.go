@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// focusExistingChat brings an already open tab or window for the assistant
+// to the front instead of opening a new one (--reuse-tab). On macOS browser
+// tabs are matched by host; elsewhere only the active tab's window title is
+// visible, so windows are matched by label (e.g. "ChatGPT"). It reports
+// false whenever nothing was focused, for whatever reason. A search that
+// times out ends the attempts, so a slow window manager can't stall the
+// handoff.
+func focusExistingChat(host, label string) bool {
+	var attempts []*exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		attempts = []*exec.Cmd{
+			exec.Command("osascript", "-e", chromeFocusScript("Google Chrome", host)),
+			exec.Command("osascript", "-e", safariFocusScript(host)),
+		}
+	case "windows":
+		attempts = []*exec.Cmd{exec.Command("powershell", "-NoProfile", "-Command",
+			"$p = Get-Process | Where-Object { $_.MainWindowTitle -like "+powershellQuote("*"+label+"*")+" } | Select-Object -First 1; "+
+				"if ($p -and (New-Object -ComObject WScript.Shell).AppActivate($p.Id)) { 'found' }")}
+	default:
+		attempts = []*exec.Cmd{
+			exec.Command("wmctrl", "-a", label),
+			exec.Command("xdotool", "search", "--onlyvisible", "--name", label, "windowactivate"),
+		}
+	}
+
+	for _, cmd := range attempts {
+		if _, err := exec.LookPath(cmd.Args[0]); err != nil {
+			continue
+		}
+		out, err := commandOutput(context.Background(), EXEC_SCALE_LOOKUP, cmd)
+		// wmctrl and xdotool signal a match by exit status; the scripts
+		// print "found".
+		byStatus := cmd.Args[0] == "wmctrl" || cmd.Args[0] == "xdotool"
+		if err == nil && (byStatus || strings.TrimSpace(string(out)) == "found") {
+			slog.Debug("focused existing chat", "via", cmd.Args[0])
+			return true
+		}
+		if errors.Is(err, errExecTimeout) {
+			break
+		}
+	}
+	return false
+}
+
+// chromeFocusScript activates the first tab on host in a Chromium-based
+// browser, without launching the browser when it isn't running.
+func chromeFocusScript(app, host string) string {
+	return `if application "` + app + `" is running then
+	tell application "` + app + `"
+		repeat with w in windows
+			set i to 0
+			repeat with t in tabs of w
+				set i to i + 1
+				if URL of t contains "` + host + `" then
+					set active tab index of w to i
+					set index of w to 1
+					activate
+					return "found"
+				end if
+			end repeat
+		end repeat
+	end tell
+end if
+return ""`
+}
+
+func safariFocusScript(host string) string {
+	return `if application "Safari" is running then
+	tell application "Safari"
+		repeat with w in windows
+			repeat with t in tabs of w
+				if URL of t contains "` + host + `" then
+					set current tab of w to t
+					set index of w to 1
+					activate
+					return "found"
+				end if
+			end repeat
+		end repeat
+	end tell
+end if
+return ""`
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	// DEFAULT_FOLLOWUP_DEPTH is how many ancestors a follow-up includes
+	// (--followup-depth).
+	DEFAULT_FOLLOWUP_DEPTH = 3
+
+	// DEFAULT_FOLLOWUP_RESPONSE_BUDGET caps each included response, in
+	// characters (--followup-response-budget).
+	DEFAULT_FOLLOWUP_RESPONSE_BUDGET = 4000
+)
+
+var (
+	followupDepth          = DEFAULT_FOLLOWUP_DEPTH
+	followupResponseBudget = DEFAULT_FOLLOWUP_RESPONSE_BUDGET
+)
+
+// ownPrompt is what the user asked in r, without the earlier thread a
+// follow-up carries.
+func (r HandoffRecord) ownPrompt() string {
+	if r.FollowUp != "" {
+		return r.FollowUp
+	}
+	return r.Prompt
+}
+
+// threadContext renders the "Previously I asked" section for a follow-up
+// to parentID: the nearest --followup-depth ancestors, oldest first, each
+// with its latest recorded response trimmed to the budget.
+func threadContext(parentID int) (string, error) {
+	var chain []HandoffRecord
+	truncated := false
+	for id := parentID; id != 0; {
+		r, ok := history.get(id)
+		if !ok {
+			if len(chain) == 0 {
+				return "", fmt.Errorf("unknown parentId %d", parentID)
+			}
+			// Older ancestors may have left the history.
+			break
+		}
+		if len(chain) == followupDepth {
+			truncated = true
+			break
+		}
+		chain = append(chain, r)
+		id = r.ParentID
+	}
+	slices.Reverse(chain)
+
+	var b strings.Builder
+	if truncated {
+		b.WriteString("(Earlier parts of this conversation are left out.)\n\n")
+	}
+	for i, r := range chain {
+		if i == 0 {
+			b.WriteString("Previously I asked:\n\n")
+		} else {
+			b.WriteString("Then I asked:\n\n")
+		}
+		b.WriteString(strings.TrimSpace(r.ownPrompt()) + "\n\n")
+		if len(r.Responses) > 0 {
+			text := strings.TrimSpace(r.Responses[len(r.Responses)-1].Text)
+			if runes := []rune(text); len(runes) > followupResponseBudget {
+				text = string(runes[:followupResponseBudget]) + "…(trimmed)"
+			}
+			b.WriteString("You answered:\n\n" + text + "\n\n")
+		}
+	}
+	b.WriteString("My follow-up question:\n\n")
+	return b.String(), nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// MAX_EXPECTED_FORMAT_LENGTH bounds a free-form expectedFormat, in
+// characters.
+const MAX_EXPECTED_FORMAT_LENGTH = 2000
+
+// formatPresets are the expectedFormat values with a standard instruction;
+// anything else is passed on as a free-form description.
+var formatPresets = map[string]string{
+	"markdown":  "Respond in Markdown, using headings, lists and fenced code blocks where they help.",
+	"json":      "Respond only with a single JSON object, with no text before or after it and no code fence around it.",
+	"diff":      "Respond only with a unified diff, as produced by git diff, that applies to the code above. Don't add any explanation before or after it.",
+	"code-only": "Respond only with the code, in a single fenced code block. Don't add any explanation before or after it.",
+}
+
+// formatInstruction renders the block expectedFormat appends to the
+// prompt.
+func formatInstruction(format string) (string, error) {
+	format = strings.TrimSpace(format)
+	if format == "" {
+		return "", errors.New("expectedFormat is empty")
+	}
+	if preset, ok := formatPresets[format]; ok {
+		return "\n\nResponse format: " + preset, nil
+	}
+	if utf8.RuneCountInString(format) > MAX_EXPECTED_FORMAT_LENGTH {
+		return "", fmt.Errorf("expectedFormat is longer than %d characters", MAX_EXPECTED_FORMAT_LENGTH)
+	}
+	return "\n\nResponse format: format your response as follows: " + format, nil
+}
+
+// checkJSONResponse reports why a response to an expectedFormat json
+// handoff is not a single JSON object, or nil if it is. A surrounding code
+// fence is tolerated, since chat apps often add one anyway.
+func checkJSONResponse(text string) error {
+	text = strings.TrimSpace(text)
+	if marker := fenceMarker(text); marker != "" {
+		_, body, _ := strings.Cut(text, "\n")
+		if i := strings.LastIndex(body, marker); i >= 0 {
+			body = body[:i]
+		}
+		text = strings.TrimSpace(body)
+	}
+	var v any
+	if err := json.Unmarshal([]byte(text), &v); err != nil {
+		return err
+	}
+	if _, ok := v.(map[string]any); !ok {
+		return errors.New("it is valid JSON but not an object")
+	}
+	return nil
+}
+
+// defaultResponseLanguage is the responseLanguage of calls that don't
+// pass one (--default-response-language).
+var defaultResponseLanguage = ""
+
+// languagePattern loosely accepts a BCP 47 tag such as pt-BR or a language
+// name such as Brazilian Portuguese.
+var languagePattern = regexp.MustCompile(`^\p{L}[\p{L}\p{N} _-]{0,34}$`)
+
+// languageNames names the common primary language subtags, so the
+// instruction reads "Please respond in German." rather than "in de.".
+var languageNames = map[string]string{
+	"ar": "Arabic", "cs": "Czech", "da": "Danish", "de": "German", "el": "Greek",
+	"en": "English", "es": "Spanish", "fi": "Finnish", "fr": "French", "he": "Hebrew",
+	"hi": "Hindi", "hu": "Hungarian", "id": "Indonesian", "it": "Italian", "ja": "Japanese",
+	"ko": "Korean", "nb": "Norwegian", "nl": "Dutch", "no": "Norwegian", "pl": "Polish",
+	"pt": "Portuguese", "ro": "Romanian", "ru": "Russian", "sv": "Swedish", "th": "Thai",
+	"tr": "Turkish", "uk": "Ukrainian", "vi": "Vietnamese", "zh": "Chinese",
+}
+
+// normalizeLanguage checks a responseLanguage and tidies its spacing.
+func normalizeLanguage(language string) (string, error) {
+	language = strings.Join(strings.Fields(language), " ")
+	if !languagePattern.MatchString(language) {
+		return "", fmt.Errorf("responseLanguage %q should be a language tag such as de or pt-BR, or a language name", language)
+	}
+	return language, nil
+}
+
+// languageInstruction renders the line responseLanguage appends as the
+// very last line of the prompt. A known tag is spelled out, keeping the
+// tag itself when it has a region or script.
+func languageInstruction(language string) string {
+	primary, rest, _ := strings.Cut(strings.ReplaceAll(language, "_", "-"), "-")
+	if name, ok := languageNames[strings.ToLower(primary)]; ok {
+		if rest != "" {
+			name += " (" + language + ")"
+		}
+		language = name
+	}
+	return "\n\nPlease respond in " + language + "."
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFormatInstruction(t *testing.T) {
+	type formatTest struct {
+		format  string
+		want    string
+		wantErr string
+	}
+	tests := []formatTest{
+		{"markdown", "\n\nResponse format: Respond in Markdown, using headings, lists and fenced code blocks where they help.", ""},
+		{"json", "\n\nResponse format: Respond only with a single JSON object, with no text before or after it and no code fence around it.", ""},
+		{"diff", "\n\nResponse format: Respond only with a unified diff, as produced by git diff, that applies to the code above. Don't add any explanation before or after it.", ""},
+		{"code-only", "\n\nResponse format: Respond only with the code, in a single fenced code block. Don't add any explanation before or after it.", ""},
+		{"  json\n", "\n\nResponse format: Respond only with a single JSON object, with no text before or after it and no code fence around it.", ""},
+		// Presets match exactly; anything else is free-form.
+		{"JSON", "\n\nResponse format: format your response as follows: JSON", ""},
+		{"a table with one row per library", "\n\nResponse format: format your response as follows: a table with one row per library", ""},
+		{strings.Repeat("é", MAX_EXPECTED_FORMAT_LENGTH), "\n\nResponse format: format your response as follows: " + strings.Repeat("é", MAX_EXPECTED_FORMAT_LENGTH), ""},
+		{strings.Repeat("é", MAX_EXPECTED_FORMAT_LENGTH+1), "", "longer than 2000 characters"},
+		{" \n", "", "expectedFormat is empty"},
+	}
+	for _, tt := range tests {
+		got, err := formatInstruction(tt.format)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("formatInstruction(%.20q): err = %v, want %q", tt.format, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("formatInstruction(%.20q) = %q, %v; want %q", tt.format, got, err, tt.want)
+		}
+	}
+	for preset := range formatPresets {
+		if !slices.ContainsFunc(tests, func(tt formatTest) bool { return tt.format == preset }) {
+			t.Errorf("the %s preset isn't tested", preset)
+		}
+	}
+}
+
+func TestCheckJSONResponse(t *testing.T) {
+	for _, ok := range []string{`{"a": 1}`, "  {}\n", "```json\n{\"a\": [1]}\n```", "```\n{}\n```\n"} {
+		if err := checkJSONResponse(ok); err != nil {
+			t.Errorf("checkJSONResponse(%q) = %v", ok, err)
+		}
+	}
+	for _, bad := range []string{"", "[1]", `"x"`, "Here: {}", "{} trailing", "```json\n[1]\n```"} {
+		if err := checkJSONResponse(bad); err == nil {
+			t.Errorf("checkJSONResponse(%q) accepted it", bad)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+const (
+	// GIT_DIFF_MAX_BYTES caps the diff added to a prompt; larger diffs keep
+	// their head and tail.
+	GIT_DIFF_MAX_BYTES = 64 << 10
+
+	MAX_GIT_DIFF_CONTEXT_LINES = 100
+)
+
+// GitDiffOptions is the includeGitDiff argument: true for the working tree
+// diff with defaults, or an object choosing what to diff.
+type GitDiffOptions struct {
+	Enabled      bool     `json:"-"`
+	Staged       bool     `json:"staged,omitempty" jsonschema:"diff the staged changes instead of the unstaged ones"`
+	Paths        []string `json:"paths,omitempty" jsonschema:"only diff these paths, relative to the repository directory"`
+	ContextLines *int     `json:"contextLines,omitempty" jsonschema:"lines of context around each change (default 3)"`
+}
+
+func (o *GitDiffOptions) UnmarshalJSON(data []byte) error {
+	var enabled bool
+	if err := json.Unmarshal(data, &enabled); err == nil {
+		*o = GitDiffOptions{Enabled: enabled}
+		return nil
+	}
+	type options GitDiffOptions
+	var opts options
+	if err := json.Unmarshal(data, &opts); err != nil {
+		return err
+	}
+	*o = GitDiffOptions(opts)
+	o.Enabled = true
+	return nil
+}
+
+// allowBooleanGitDiff lets includeGitDiff be given as a plain boolean, which
+// the schema inferred from GitDiffOptions does not allow.
+func allowBooleanGitDiff(schema *jsonschema.Schema) {
+	obj := schema.Properties["includeGitDiff"]
+	if obj == nil {
+		return
+	}
+	desc := obj.Description
+	obj.Description = ""
+	obj.Types = nil
+	obj.Type = "object"
+	schema.Properties["includeGitDiff"] = &jsonschema.Schema{
+		Description: desc,
+		AnyOf:       []*jsonschema.Schema{{Type: "boolean"}, obj},
+	}
+}
+
+// gitDiffDir picks the directory to run git diff in: cwd when given, else
+// the client's first root, else the first attachment root. It must lie
+// within a client root or an attachment root.
+func gitDiffDir(ctx context.Context, ss *mcp.ServerSession, cwd string) (string, error) {
+	client := clientRoots(ctx, ss)
+	dir := cwd
+	if dir == "" || !filepath.IsAbs(dir) {
+		base := attachmentRoots[0]
+		if len(client) > 0 {
+			base = client[0]
+		}
+		dir = filepath.Join(base, dir)
+	}
+	real, err := filepath.EvalSymlinks(filepath.Clean(dir))
+	if err != nil {
+		return "", err
+	}
+	if roots := allowedRoots(client); !dirWithinRoots(real, roots) {
+		return "", fmt.Errorf("%s is outside the client and attachment roots (%s)", real, strings.Join(roots, ", "))
+	}
+	return real, nil
+}
+
+// dirWithinRoots is like withinRoots but also accepts a root itself.
+func dirWithinRoots(dir string, roots []string) bool {
+	for _, root := range roots {
+		if real, err := filepath.EvalSymlinks(root); err == nil && real == dir {
+			return true
+		}
+	}
+	return withinRoots(dir, roots) != ""
+}
+
+// renderGitDiff runs git diff in dir and formats it as a fenced diff block.
+func renderGitDiff(ctx context.Context, dir string, opts GitDiffOptions) (string, error) {
+	contextLines := 3
+	if opts.ContextLines != nil {
+		contextLines = *opts.ContextLines
+		if contextLines < 0 || contextLines > MAX_GIT_DIFF_CONTEXT_LINES {
+			return "", fmt.Errorf("contextLines must be between 0 and %d", MAX_GIT_DIFF_CONTEXT_LINES)
+		}
+	}
+	args := []string{"-C", dir, "diff", "--no-color", "--no-ext-diff", "--no-textconv", "-U" + strconv.Itoa(contextLines)}
+	if opts.Staged {
+		args = append(args, "--staged")
+	}
+	args = append(args, "--")
+	for _, p := range opts.Paths {
+		full := filepath.Clean(filepath.Join(dir, p))
+		if filepath.IsAbs(p) || (full != dir && withinRoots(full, []string{dir}) == "") {
+			return "", fmt.Errorf("path %s is outside %s", p, dir)
+		}
+		args = append(args, p)
+	}
+
+	out, err := commandOutput(ctx, EXEC_SCALE_GIT, exec.Command("git", args...))
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", errors.New("git is not installed")
+	} else if err != nil {
+		if msg := commandStderr(err); msg != "" {
+			return "", errors.New(strings.SplitN(msg, "\n", 2)[0])
+		}
+		return "", err
+	}
+
+	what := "unstaged changes"
+	if opts.Staged {
+		what = "staged changes"
+	}
+	diff := strings.TrimRight(string(out), "\n")
+	if diff == "" {
+		return fmt.Sprintf("\n\nGit diff of %s in %s: no changes.", what, dir), nil
+	}
+	diff = truncateDiff(diff, GIT_DIFF_MAX_BYTES)
+	fence := markdownFence(diff)
+	return fmt.Sprintf("\n\nGit diff of %s in %s:\n%sdiff\n%s\n%s", what, dir, fence, diff, fence), nil
+}
+
+// truncateDiff keeps the first and last lines of a diff that is over limit
+// bytes, noting how many lines and hunks were left out in between.
+func truncateDiff(diff string, limit int) string {
+	if len(diff) <= limit {
+		return diff
+	}
+	lines := strings.Split(diff, "\n")
+	head, size := 0, 0
+	for head < len(lines) && size+len(lines[head])+1 <= limit/2 {
+		size += len(lines[head]) + 1
+		head++
+	}
+	tail, size := len(lines), 0
+	for tail > head && size+len(lines[tail-1])+1 <= limit/2 {
+		size += len(lines[tail-1]) + 1
+		tail--
+	}
+	hunks := 0
+	for _, l := range lines[head:tail] {
+		if strings.HasPrefix(l, "@@ ") {
+			hunks++
+		}
+	}
+	note := fmt.Sprintf("... %d lines omitted (%d hunks start in them); the diff was over %d bytes ...", tail-head, hunks, limit)
+	return strings.Join(lines[:head], "\n") + "\n" + note + "\n" + strings.Join(lines[tail:], "\n")
+}
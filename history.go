@@ -0,0 +1,991 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+const (
+	// HISTORY_LIMIT is how many handoffs are kept in memory.
+	HISTORY_LIMIT = 100
+
+	HISTORY_PREVIEW_LENGTH = 80
+
+	MAX_TAGS       = 10
+	MAX_TAG_LENGTH = 32
+
+	// HISTORY_MAX_FILE_SIZE is the size at which the history file is
+	// rotated to <file>.1, replacing any previous rotation.
+	HISTORY_MAX_FILE_SIZE = 10 << 20
+)
+
+// HandoffRecord is one entry of the handoff history.
+type HandoffRecord struct {
+	ID           int       `json:"id"`
+	Time         time.Time `json:"time"`
+	Prompt       string    `json:"prompt"`
+	PromptLength int       `json:"promptLength"`
+	// ParentID is the handoff this one follows up on (parentId), and
+	// FollowUp the new question without the earlier thread.
+	ParentID int    `json:"parentId,omitempty"`
+	FollowUp string `json:"followUp,omitempty"`
+	// ExpectedFormat is the expectedFormat the response was asked for.
+	ExpectedFormat string `json:"expectedFormat,omitempty"`
+	// ResponseLanguage is the language the response was asked to be in.
+	ResponseLanguage string `json:"responseLanguage,omitempty"`
+	// PromptFile is the file the prompt was read from (promptFile).
+	PromptFile string   `json:"promptFile,omitempty"`
+	Title      string   `json:"title,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	// Tool is the tool that made the handoff, e.g. handoff_to_claude, and
+	// Kind the kind of prompt a --tool-variants tool is for, or
+	// "clipboard" for a copy_to_clipboard entry.
+	Tool             string `json:"tool,omitempty"`
+	Kind             string `json:"kind,omitempty"`
+	Target           string `json:"target"`
+	ClipboardBackend string `json:"clipboardBackend"`
+	DeeplinkStatus   string `json:"deeplinkStatus"`
+	// Confirmation is how the user answered the --confirm dialog:
+	// "confirmed", "declined" or "timed-out". A handoff that wasn't
+	// confirmed has the same value as its DeeplinkStatus and went nowhere.
+	Confirmation string `json:"confirmation,omitempty"`
+	// ChunkCount is how many parts a chunked handoff was split into, with
+	// ChunksCopied of them copied so far; ChunkSize is the --chunk-size
+	// they were cut with, so next_handoff_chunk can cut them again.
+	ChunkCount   int `json:"chunkCount,omitempty"`
+	ChunksCopied int `json:"chunksCopied,omitempty"`
+	ChunkSize    int `json:"chunkSize,omitempty"`
+	// DryRun marks a dryRun call: nothing was copied or opened, and the
+	// status is the one that would have resulted.
+	DryRun bool `json:"dryRun,omitempty"`
+	// Responses are the replies recorded with record_response; later ones
+	// are revisions of earlier ones.
+	Responses []HandoffResponse `json:"responses,omitempty"`
+}
+
+// HandoffResponse is an assistant reply pasted back by the user.
+type HandoffResponse struct {
+	Time time.Time `json:"time"`
+	Text string    `json:"text"`
+}
+
+// handoffHistory is a bounded, concurrency-safe list of recent handoffs,
+// oldest first. IDs increase monotonically and are never reused. When a
+// history file is open every record is also appended to it.
+type handoffHistory struct {
+	mu      sync.Mutex
+	records []HandoffRecord
+	nextID  int
+
+	path string
+	file *os.File
+}
+
+var history = &handoffHistory{nextID: 1}
+
+// add assigns the next id and time to r and stores it.
+func (h *handoffHistory) add(r HandoffRecord) HandoffRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r.ID = h.nextID
+	h.nextID++
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	h.records = append(h.records, r)
+	if len(h.records) > HISTORY_LIMIT {
+		h.records = h.records[len(h.records)-HISTORY_LIMIT:]
+	}
+	if h.file != nil {
+		if err := h.persist(r); err != nil {
+			slog.Warn("failed to write history file", "path", h.path, "error", err)
+		}
+		if err := writeFileAtomic(h.counterPath(), []byte(strconv.Itoa(h.nextID)+"\n")); err != nil {
+			slog.Warn("failed to write history id counter", "error", err)
+		}
+	}
+	return r
+}
+
+// counterPath holds the next id, so ids stay unique even after the file
+// holding the newest records has been rotated away.
+func (h *handoffHistory) counterPath() string {
+	return h.path + ".next-id"
+}
+
+// get returns the record with the given id. Records that have left memory
+// are looked up in the history files, which search_handoffs and
+// record_response also reach.
+func (h *handoffHistory) get(id int) (HandoffRecord, bool) {
+	h.mu.Lock()
+	for _, r := range h.records {
+		if r.ID == id {
+			h.mu.Unlock()
+			return r, true
+		}
+	}
+	h.mu.Unlock()
+
+	var found HandoffRecord
+	ok := false
+	h.scan(func(r HandoffRecord) bool {
+		if r.ID == id {
+			found, ok = r, true
+		}
+		return !ok
+	})
+	return found, ok
+}
+
+// latestID returns the id of the most recent handoff, or 0 when none.
+// copy_to_clipboard entries are not handoffs and are skipped.
+func (h *handoffHistory) latestID() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := len(h.records) - 1; i >= 0; i-- {
+		if h.records[i].Kind != CLIPBOARD_KIND {
+			return h.records[i].ID
+		}
+	}
+	return 0
+}
+
+// idRange returns the lowest and highest id in the history files (or in
+// memory without them), or ok=false when empty.
+func (h *handoffHistory) idRange() (lo, hi int, ok bool) {
+	h.scan(func(r HandoffRecord) bool {
+		if !ok || r.ID < lo {
+			lo = r.ID
+		}
+		hi, ok = max(hi, r.ID), true
+		return true
+	})
+	return lo, hi, ok
+}
+
+// writeFileAtomic replaces path with data via a temp file and rename.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// open seeds the history from the last HISTORY_LIMIT records of path and
+// appends new records to it. Unparseable lines, typically a record cut
+// short by a crash, are skipped with a warning.
+func (h *handoffHistory) open(path string) error {
+	if err := makeParentDir(path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	var records []HandoffRecord
+	err = readHistoryLines(f, path, func(n int, line []byte) bool {
+		var r HandoffRecord
+		if err := json.Unmarshal(line, &r); err != nil || r.ID == 0 {
+			slog.Warn("skipping corrupt history line", "path", path, "line", n)
+			return true
+		}
+		records = append(records, r)
+		return true
+	})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	// Terminate a partial last line so the next record starts cleanly.
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			f.Write([]byte{'\n'})
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, r := range records {
+		h.nextID = max(h.nextID, r.ID+1)
+	}
+	h.path, h.file = path, f
+	if data, err := os.ReadFile(h.counterPath()); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+			h.nextID = max(h.nextID, n)
+		}
+	}
+	if len(records) > HISTORY_LIMIT {
+		records = records[len(records)-HISTORY_LIMIT:]
+	}
+	h.records = append(records, h.records...)
+	return nil
+}
+
+// persist appends r to the history file and syncs it, rotating the file
+// first when it would grow past HISTORY_MAX_FILE_SIZE. Callers hold h.mu,
+// which serializes concurrent writers.
+func (h *handoffHistory) persist(r HandoffRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if info, err := h.file.Stat(); err == nil && info.Size()+int64(len(line)) > HISTORY_MAX_FILE_SIZE {
+		h.file.Close()
+		if err := os.Rename(h.path, h.path+".1"); err != nil {
+			return errors.Join(err, h.reopen())
+		}
+		if err := h.reopen(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := h.file.Write(line); err != nil {
+		return err
+	}
+	return h.file.Sync()
+}
+
+// filePath is the history file, or "" when none is open.
+func (h *handoffHistory) filePath() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.path
+}
+
+func (h *handoffHistory) reopen() error {
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		h.file = nil
+		return err
+	}
+	h.file = f
+	return nil
+}
+
+// list returns up to limit records at or after since carrying all of tags,
+// newest first. A limit of 0 means no limit.
+func (h *handoffHistory) list(limit int, since time.Time, tags []string) []HandoffRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []HandoffRecord
+	for i := len(h.records) - 1; i >= 0; i-- {
+		r := h.records[i]
+		if r.Time.Before(since) {
+			break
+		}
+		if !r.hasTags(tags) {
+			continue
+		}
+		out = append(out, r)
+		if limit > 0 && len(out) == limit {
+			break
+		}
+	}
+	return out
+}
+
+// title returns the record's title, deriving one for records saved before
+// titles existed.
+func (r HandoffRecord) title() string {
+	if r.Title != "" {
+		return r.Title
+	}
+	return deriveTitle(r.Prompt)
+}
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// normalizeTags validates tags and returns them sorted without duplicates.
+// Tags are lowercase words such as "research" or "project-x".
+func normalizeTags(tags []string) ([]string, error) {
+	var out []string
+	for _, t := range tags {
+		if len(t) > MAX_TAG_LENGTH {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", t, MAX_TAG_LENGTH)
+		}
+		if !tagPattern.MatchString(t) {
+			return nil, fmt.Errorf("tag %q must be lowercase letters, digits, '.', '_' or '-'", t)
+		}
+		if !slices.Contains(out, t) {
+			out = append(out, t)
+		}
+	}
+	if len(out) > MAX_TAGS {
+		return nil, fmt.Errorf("at most %d tags are allowed", MAX_TAGS)
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// hasTags reports whether r carries every one of tags.
+func (r HandoffRecord) hasTags(tags []string) bool {
+	for _, t := range tags {
+		if !slices.Contains(r.Tags, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// promptPreview collapses whitespace and shortens prompt for tables.
+func promptPreview(prompt string) string {
+	s := strings.Join(strings.Fields(prompt), " ")
+	if r := []rune(s); len(r) > HISTORY_PREVIEW_LENGTH {
+		s = string(r[:HISTORY_PREVIEW_LENGTH-1]) + "…"
+	}
+	return s
+}
+
+type ListHandoffsArgs struct {
+	Limit int      `json:"limit,omitempty" jsonschema:"maximum number of handoffs to return, newest first (default all, up to 100)"`
+	Since string   `json:"since,omitempty" jsonschema:"only handoffs at or after this time: an RFC 3339 timestamp or a duration such as 30m or 2h"`
+	Tags  []string `json:"tags,omitempty" jsonschema:"only handoffs carrying all of these tags"`
+}
+
+// HandoffSummary is a list_handoffs row.
+type HandoffSummary struct {
+	ID             int       `json:"id"`
+	Time           time.Time `json:"time"`
+	Target         string    `json:"target"`
+	Title          string    `json:"title"`
+	Tags           []string  `json:"tags,omitempty"`
+	PromptPreview  string    `json:"promptPreview"`
+	PromptLength   int       `json:"promptLength"`
+	DeeplinkStatus string    `json:"deeplinkStatus"`
+	DryRun         bool      `json:"dryRun,omitempty"`
+}
+
+// ListHandoffsResult is the structured content returned by list_handoffs.
+type ListHandoffsResult struct {
+	Handoffs []HandoffSummary `json:"handoffs"`
+}
+
+// parseSince accepts an RFC 3339 timestamp or a duration back from now.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("since %q is neither an RFC 3339 timestamp nor a duration like 30m", s)
+}
+
+func handleListHandoffs(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[ListHandoffsArgs]) (*mcp.CallToolResultFor[any], error) {
+	if params.Arguments.Limit < 0 {
+		return toolError("invalid params: limit must not be negative"), nil
+	}
+	since, err := parseSince(params.Arguments.Since, time.Now())
+	if err != nil {
+		return toolError("invalid params: " + err.Error()), nil
+	}
+	tags, err := normalizeTags(params.Arguments.Tags)
+	if err != nil {
+		return toolError("invalid params: " + err.Error()), nil
+	}
+
+	result := &ListHandoffsResult{Handoffs: []HandoffSummary{}}
+	for _, r := range history.list(params.Arguments.Limit, since, tags) {
+		result.Handoffs = append(result.Handoffs, HandoffSummary{
+			ID:             r.ID,
+			Time:           r.Time,
+			Target:         r.Target,
+			Title:          r.title(),
+			Tags:           r.Tags,
+			PromptPreview:  promptPreview(r.Prompt),
+			PromptLength:   r.PromptLength,
+			DeeplinkStatus: r.DeeplinkStatus,
+			DryRun:         r.DryRun,
+		})
+	}
+
+	text := "No handoffs yet."
+	if noHistory {
+		text = "Handoff history is disabled (--no-history)."
+	} else if len(result.Handoffs) > 0 {
+		var b strings.Builder
+		tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTIME\tTARGET\tOUTCOME\tTAGS\tTITLE")
+		for _, h := range result.Handoffs {
+			outcome := h.DeeplinkStatus
+			if h.DryRun {
+				outcome += " (dry run)"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", h.ID, h.Time.Format(time.DateTime), h.Target, outcome, strings.Join(h.Tags, ","), promptPreview(h.Title))
+		}
+		tw.Flush()
+		text = b.String()
+	}
+
+	return &mcp.CallToolResultFor[any]{
+		Content:           []mcp.Content{&mcp.TextContent{Text: text}},
+		StructuredContent: result,
+	}, nil
+}
+
+type GetHandoffArgs struct {
+	ID int `json:"id" jsonschema:"handoff id as shown by list_handoffs"`
+}
+
+func handleGetHandoff(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[GetHandoffArgs]) (*mcp.CallToolResultFor[any], error) {
+	id := params.Arguments.ID
+	r, ok := history.get(id)
+	if !ok {
+		lo, hi, ok := history.idRange()
+		if !ok {
+			return toolError(fmt.Sprintf("unknown handoff id %d: no handoffs recorded", id)), nil
+		}
+		return toolError(fmt.Sprintf("unknown handoff id %d (available ids: %d-%d)", id, lo, hi)), nil
+	}
+
+	outcome := r.DeeplinkStatus
+	if r.DryRun {
+		outcome = "dry run, would be " + outcome
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Handoff %d %q to %s at %s (%s, %d characters)\n\n", r.ID, r.title(), r.Target, r.Time.Format(time.DateTime), outcome, r.PromptLength)
+	if r.ParentID != 0 {
+		fmt.Fprintf(&b, "Follow-up to handoff %d.\n\n", r.ParentID)
+	}
+	if r.ChunkCount > 0 {
+		fmt.Fprintf(&b, "Split into %d parts, %d copied so far.\n\n", r.ChunkCount, r.ChunksCopied)
+	}
+	if r.PromptFile != "" {
+		fmt.Fprintf(&b, "Read from %s:\n\n", r.PromptFile)
+	}
+	b.WriteString(r.Prompt)
+	for i, resp := range r.Responses {
+		title := "Response"
+		if i > 0 {
+			title = fmt.Sprintf("Response (revision %d)", i+1)
+		}
+		fmt.Fprintf(&b, "\n\n%s, recorded %s:\n\n%s", title, resp.Time.Format(time.DateTime), resp.Text)
+	}
+	return &mcp.CallToolResultFor[any]{
+		Content:           []mcp.Content{&mcp.TextContent{Text: b.String()}},
+		StructuredContent: &r,
+	}, nil
+}
+
+// HISTORY_SNIPPET_CONTEXT is how many characters around a search match are
+// shown in its snippet.
+const HISTORY_SNIPPET_CONTEXT = 40
+
+// scan calls fn for every record on disk, oldest first, including the
+// rotated file, without loading them all into memory. Without a history
+// file it walks the in-memory records instead. Scanning stops when fn
+// returns false.
+func (h *handoffHistory) scan(fn func(HandoffRecord) bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.path == "" {
+		for _, r := range h.records {
+			if !fn(r) {
+				break
+			}
+		}
+		return nil
+	}
+	for _, path := range []string{h.path + ".1", h.path} {
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		stopped := false
+		err = readHistoryLines(f, path, func(_ int, line []byte) bool {
+			var r HandoffRecord
+			if json.Unmarshal(line, &r) != nil || r.ID == 0 {
+				return true
+			}
+			stopped = !fn(r)
+			return !stopped
+		})
+		f.Close()
+		if err != nil || stopped {
+			return err
+		}
+	}
+	return nil
+}
+
+type SearchHandoffsArgs struct {
+	Query  string   `json:"query" jsonschema:"text to look for in prompts, case-insensitive"`
+	Regex  bool     `json:"regex,omitempty" jsonschema:"treat query as a regular expression (RE2 syntax)"`
+	After  string   `json:"after,omitempty" jsonschema:"only handoffs at or after this time: an RFC 3339 timestamp or a duration such as 2h"`
+	Before string   `json:"before,omitempty" jsonschema:"only handoffs before this time: an RFC 3339 timestamp or a duration such as 2h"`
+	Target string   `json:"target,omitempty" jsonschema:"only handoffs to this target, e.g. chatgpt"`
+	Tags   []string `json:"tags,omitempty" jsonschema:"only handoffs carrying all of these tags"`
+	Limit  int      `json:"limit,omitempty" jsonschema:"maximum number of matches to return, newest first (default 20)"`
+}
+
+// HandoffMatch is a search_handoffs result: a handoff and the text around
+// the first match, with the match itself marked «like this».
+type HandoffMatch struct {
+	ID             int       `json:"id"`
+	Time           time.Time `json:"time"`
+	Target         string    `json:"target"`
+	Title          string    `json:"title"`
+	Tags           []string  `json:"tags,omitempty"`
+	Snippet        string    `json:"snippet"`
+	PromptLength   int       `json:"promptLength"`
+	DeeplinkStatus string    `json:"deeplinkStatus"`
+}
+
+// SearchHandoffsResult is the structured content returned by
+// search_handoffs. MatchedCount counts every match, including those beyond
+// the limit.
+type SearchHandoffsResult struct {
+	Handoffs     []HandoffMatch `json:"handoffs"`
+	MatchedCount int            `json:"matchedCount"`
+}
+
+const DEFAULT_SEARCH_LIMIT = 20
+
+// MAX_SEARCH_PATTERN keeps regular expressions to a sane size; RE2 runs in
+// linear time, but compiling a huge pattern is still expensive.
+const MAX_SEARCH_PATTERN = 1000
+
+// searchPattern compiles query into a case-insensitive matcher.
+func searchPattern(query string, isRegex bool) (*regexp.Regexp, error) {
+	if query == "" {
+		return nil, errors.New("query must not be empty")
+	}
+	if len(query) > MAX_SEARCH_PATTERN {
+		return nil, fmt.Errorf("query is longer than %d characters", MAX_SEARCH_PATTERN)
+	}
+	if !isRegex {
+		query = regexp.QuoteMeta(query)
+	}
+	re, err := regexp.Compile("(?i)" + query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex: %w", err)
+	}
+	return re, nil
+}
+
+// matchSnippet returns the text around loc in s, up to
+// HISTORY_SNIPPET_CONTEXT characters on each side, with the match marked
+// and whitespace collapsed.
+func matchSnippet(s string, loc []int) string {
+	before, after := []rune(s[:loc[0]]), []rune(s[loc[1]:])
+	prefix, suffix := "", ""
+	if len(before) > HISTORY_SNIPPET_CONTEXT {
+		before, prefix = before[len(before)-HISTORY_SNIPPET_CONTEXT:], "…"
+	}
+	if len(after) > HISTORY_SNIPPET_CONTEXT {
+		after, suffix = after[:HISTORY_SNIPPET_CONTEXT], "…"
+	}
+	snippet := string(before) + "«" + s[loc[0]:loc[1]] + "»" + string(after)
+	return prefix + strings.Join(strings.Fields(snippet), " ") + suffix
+}
+
+func handleSearchHandoffs(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[SearchHandoffsArgs]) (*mcp.CallToolResultFor[any], error) {
+	args := params.Arguments
+	re, err := searchPattern(args.Query, args.Regex)
+	if err != nil {
+		return toolError("invalid params: " + err.Error()), nil
+	}
+	now := time.Now()
+	after, err := parseSince(args.After, now)
+	if err != nil {
+		return toolError("invalid params: after: " + err.Error()), nil
+	}
+	before, err := parseSince(args.Before, now)
+	if err != nil {
+		return toolError("invalid params: before: " + err.Error()), nil
+	}
+	if args.Limit < 0 {
+		return toolError("invalid params: limit must not be negative"), nil
+	}
+	tags, err := normalizeTags(args.Tags)
+	if err != nil {
+		return toolError("invalid params: " + err.Error()), nil
+	}
+	limit := args.Limit
+	if limit == 0 {
+		limit = DEFAULT_SEARCH_LIMIT
+	}
+
+	// Records arrive oldest first; keep the newest limit matches.
+	result := &SearchHandoffsResult{Handoffs: []HandoffMatch{}}
+	err = history.scan(func(r HandoffRecord) bool {
+		if r.Time.Before(after) || (!before.IsZero() && !r.Time.Before(before)) {
+			return true
+		}
+		if args.Target != "" && !strings.EqualFold(r.Target, args.Target) || !r.hasTags(tags) {
+			return true
+		}
+		loc := re.FindStringIndex(r.Prompt)
+		if loc == nil {
+			return true
+		}
+		result.MatchedCount++
+		result.Handoffs = append(result.Handoffs, HandoffMatch{
+			ID:             r.ID,
+			Time:           r.Time,
+			Target:         r.Target,
+			Title:          r.title(),
+			Tags:           r.Tags,
+			Snippet:        matchSnippet(r.Prompt, loc),
+			PromptLength:   r.PromptLength,
+			DeeplinkStatus: r.DeeplinkStatus,
+		})
+		if len(result.Handoffs) > limit {
+			result.Handoffs = result.Handoffs[1:]
+		}
+		return ctx.Err() == nil
+	})
+	if err != nil {
+		return toolError("reading history: " + err.Error()), nil
+	}
+	slices.Reverse(result.Handoffs)
+
+	var text string
+	switch {
+	case noHistory:
+		text = "Handoff history is disabled (--no-history)."
+	case result.MatchedCount == 0:
+		text = "No handoffs match."
+	default:
+		var b strings.Builder
+		if result.MatchedCount > len(result.Handoffs) {
+			fmt.Fprintf(&b, "%d handoffs match; showing the newest %d. Narrow the query or time range to see others.\n\n", result.MatchedCount, len(result.Handoffs))
+		}
+		tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTIME\tTARGET\tTITLE\tMATCH")
+		for _, m := range result.Handoffs {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", m.ID, m.Time.Format(time.DateTime), m.Target, promptPreview(m.Title), m.Snippet)
+		}
+		tw.Flush()
+		text = b.String()
+	}
+
+	return &mcp.CallToolResultFor[any]{
+		Content:           []mcp.Content{&mcp.TextContent{Text: text}},
+		StructuredContent: result,
+	}, nil
+}
+
+// historyEdit changes r in place or reports that it should be dropped
+// (keep false); changed marks the records an edit applied to.
+type historyEdit func(r *HandoffRecord) (changed, keep bool)
+
+// rewrite applies edit to every record in memory and in the history files,
+// and reports how many records it changed. Files are compacted by writing
+// a temporary copy and renaming it over the original, so a crash leaves
+// either the old or the new file; unparseable lines are dropped along the
+// way.
+func (h *handoffHistory) rewrite(edit historyEdit) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	changed := 0
+	kept := h.records[:0:0]
+	for _, r := range h.records {
+		c, keep := edit(&r)
+		if c {
+			changed++
+		}
+		if keep {
+			kept = append(kept, r)
+		}
+	}
+	h.records = kept
+	if h.path == "" {
+		return changed, nil
+	}
+
+	// Windows can't rename over a file that is open, so the append handle
+	// is closed for the rewrite and reopened afterwards, on the old file if
+	// the rename failed.
+	if h.file != nil {
+		h.file.Close()
+		h.file = nil
+	}
+	// The files also hold records that have left memory, so they decide the
+	// count.
+	changed = 0
+	var err error
+	for _, path := range []string{h.path + ".1", h.path} {
+		n, rerr := rewriteHistoryFile(path, edit)
+		changed += n
+		if rerr != nil {
+			err = rerr
+			break
+		}
+	}
+	return changed, errors.Join(err, h.reopen())
+}
+
+// remove deletes every record matching drop and reports how many went.
+func (h *handoffHistory) remove(drop func(HandoffRecord) bool) (int, error) {
+	return h.rewrite(func(r *HandoffRecord) (bool, bool) {
+		d := drop(*r)
+		return d, !d
+	})
+}
+
+func rewriteHistoryFile(path string, edit historyEdit) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	changed := 0
+	w := bufio.NewWriter(tmp)
+	var marshalErr error
+	readErr := readHistoryLines(f, path, func(_ int, line []byte) bool {
+		var r HandoffRecord
+		if json.Unmarshal(line, &r) != nil || r.ID == 0 {
+			return true
+		}
+		c, keep := edit(&r)
+		if c {
+			changed++
+		}
+		if !keep {
+			return true
+		}
+		if c {
+			if line, marshalErr = json.Marshal(r); marshalErr != nil {
+				return false
+			}
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+		return true
+	})
+	// Both files must be closed before the rename for Windows' sake.
+	err = errors.Join(marshalErr, readErr, w.Flush(), tmp.Sync(), tmp.Close(), f.Close())
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return 0, err
+	}
+	return changed, nil
+}
+
+// historyMaxLine caps a single history record. Longer lines are skipped
+// with a warning rather than failing the whole read.
+var historyMaxLine = HISTORY_MAX_FILE_SIZE
+
+// readHistoryLines calls fn with each line of r, numbered from 1 and
+// without its newline, until fn returns false. The slice is only valid
+// during the call.
+func readHistoryLines(r io.Reader, path string, fn func(n int, line []byte) bool) error {
+	br := bufio.NewReader(r)
+	var buf []byte
+	oversized := false
+	for n := 1; ; {
+		chunk, err := br.ReadSlice('\n')
+		if !oversized {
+			if len(buf)+len(chunk) > historyMaxLine+1 {
+				oversized, buf = true, buf[:0]
+			} else {
+				buf = append(buf, chunk...)
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if oversized {
+			slog.Warn("skipping oversized history line", "path", path, "line", n, "limit", historyMaxLine)
+		} else if line := bytes.TrimSuffix(buf, []byte{'\n'}); len(line) > 0 || err == nil {
+			if !fn(n, line) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		buf, oversized = buf[:0], false
+		n++
+	}
+}
+
+// parseAge parses a duration such as 90m or 720h; a d suffix counts days,
+// so retention can be written as 30d.
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%q is not a non-negative duration such as 12h or 30d", s)
+	}
+	return d, nil
+}
+
+type DeleteHandoffArgs struct {
+	ID  int   `json:"id,omitempty" jsonschema:"id of the handoff to delete"`
+	IDs []int `json:"ids,omitempty" jsonschema:"ids of several handoffs to delete"`
+}
+
+type ClearHandoffsArgs struct {
+	OlderThan string `json:"olderThan,omitempty" jsonschema:"only delete handoffs older than this, e.g. 12h or 30d (default: delete all)"`
+}
+
+// RemovedHandoffsResult is the structured content returned by
+// delete_handoff and clear_handoffs.
+type RemovedHandoffsResult struct {
+	Removed int `json:"removed"`
+}
+
+func removedResult(n int, text string) *mcp.CallToolResultFor[any] {
+	return &mcp.CallToolResultFor[any]{
+		Content:           []mcp.Content{&mcp.TextContent{Text: text}},
+		StructuredContent: &RemovedHandoffsResult{Removed: n},
+	}
+}
+
+func handleDeleteHandoff(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[DeleteHandoffArgs]) (*mcp.CallToolResultFor[any], error) {
+	ids := params.Arguments.IDs
+	if params.Arguments.ID != 0 {
+		ids = append(ids, params.Arguments.ID)
+	}
+	if len(ids) == 0 {
+		return toolError("invalid params: id or ids is required"), nil
+	}
+	n, err := history.remove(func(r HandoffRecord) bool { return slices.Contains(ids, r.ID) })
+	if err != nil {
+		return toolError(fmt.Sprintf("deleted %d handoffs, then failed to rewrite the history file: %v", n, err)), nil
+	}
+	if n == 0 {
+		return toolError(fmt.Sprintf("no handoff with id %v", strings.Trim(fmt.Sprint(ids), "[]"))), nil
+	}
+	return removedResult(n, fmt.Sprintf("Deleted %d handoff(s).", n)), nil
+}
+
+func handleClearHandoffs(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[ClearHandoffsArgs]) (*mcp.CallToolResultFor[any], error) {
+	cutoff := time.Now()
+	if params.Arguments.OlderThan != "" {
+		age, err := parseAge(params.Arguments.OlderThan)
+		if err != nil {
+			return toolError("invalid params: olderThan: " + err.Error()), nil
+		}
+		cutoff = cutoff.Add(-age)
+	}
+	queued := queue.clear(cutoff)
+	n, err := history.remove(func(r HandoffRecord) bool { return !r.Time.After(cutoff) })
+	if err != nil {
+		return toolError(fmt.Sprintf("cleared %d handoffs, then failed to rewrite the history file: %v", n, err)), nil
+	}
+	text := fmt.Sprintf("Cleared %d handoff(s).", n)
+	if queued > 0 {
+		text += fmt.Sprintf(" Also dropped %d queued handoff(s) that were never delivered.", queued)
+	}
+	return removedResult(n, text), nil
+}
+
+type RecordResponseArgs struct {
+	HandoffID int    `json:"handoffId,omitempty" jsonschema:"id of the handoff the response answers (default: the most recent handoff)"`
+	Response  string `json:"response" jsonschema:"the assistant's reply, exactly as the user shared it"`
+}
+
+// RecordResponseResult is the structured content returned by
+// record_response. Revision is 1 for the first response to a handoff.
+type RecordResponseResult struct {
+	HandoffID      int `json:"handoffId"`
+	Revision       int `json:"revision"`
+	PromptLength   int `json:"promptLength"`
+	ResponseLength int `json:"responseLength"`
+	// FormatWarning says why the response doesn't match the handoff's
+	// expectedFormat, when that can be checked (json).
+	FormatWarning string `json:"formatWarning,omitempty"`
+}
+
+func handleRecordResponse(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[RecordResponseArgs]) (*mcp.CallToolResultFor[any], error) {
+	if noHistory {
+		return toolError("handoff history is disabled (--no-history)"), nil
+	}
+	text := strings.TrimSpace(params.Arguments.Response)
+	if text == "" {
+		return toolError("invalid params: response must not be empty"), nil
+	}
+	id := params.Arguments.HandoffID
+	if id == 0 {
+		if id = history.latestID(); id == 0 {
+			return toolError("no handoffs recorded yet"), nil
+		}
+	}
+
+	resp := HandoffResponse{Time: time.Now(), Text: text}
+	result := &RecordResponseResult{HandoffID: id, ResponseLength: len(text)}
+	title := ""
+	n, err := history.rewrite(func(r *HandoffRecord) (bool, bool) {
+		if r.ID != id {
+			return false, true
+		}
+		r.Responses = append(r.Responses, resp)
+		result.Revision, result.PromptLength = len(r.Responses), r.PromptLength
+		title = r.Title
+		if r.ExpectedFormat == "json" {
+			if err := checkJSONResponse(text); err != nil {
+				result.FormatWarning = "the response isn't the single JSON object the handoff asked for: " + err.Error()
+			}
+		}
+		return true, true
+	})
+	if err != nil {
+		return toolError("failed to save the response: " + err.Error()), nil
+	}
+	if n == 0 {
+		return toolError(fmt.Sprintf("unknown handoff id %d", id)), nil
+	}
+
+	msg := fmt.Sprintf("Recorded a %d-character response to handoff %d (prompt: %d characters).", result.ResponseLength, id, result.PromptLength)
+	if result.Revision > 1 {
+		msg = fmt.Sprintf("Recorded revision %d of the response to handoff %d: %d characters (prompt: %d characters).", result.Revision, id, result.ResponseLength, result.PromptLength)
+	}
+	content := []mcp.Content{&mcp.TextContent{Text: msg}}
+	if result.FormatWarning != "" {
+		content = append(content, &mcp.TextContent{Text: "Note: " + result.FormatWarning})
+	}
+	if w := transcribe(ctx, ss, transcriptResponse(id, title, result.Revision, resp)); w != "" {
+		content = append(content, &mcp.TextContent{Text: "Note: " + w})
+	}
+	return &mcp.CallToolResultFor[any]{
+		Content:           content,
+		StructuredContent: result,
+	}, nil
+}
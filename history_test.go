@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestReadHistoryLines(t *testing.T) {
+	old := historyMaxLine
+	t.Cleanup(func() { historyMaxLine = old })
+	historyMaxLine = 8
+
+	logged := captureLog(t)
+	in := "short\n" + strings.Repeat("x", 5000) + "\nexactly8\n\nlast"
+	var got []string
+	var nums []int
+	err := readHistoryLines(strings.NewReader(in), "h.jsonl", func(n int, line []byte) bool {
+		got = append(got, string(line))
+		nums = append(nums, n)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"short", "exactly8", "", "last"}; !slices.Equal(got, want) {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+	if want := []int{1, 3, 4, 5}; !slices.Equal(nums, want) {
+		t.Errorf("line numbers = %v, want %v", nums, want)
+	}
+	if !strings.Contains(logged.String(), "skipping oversized history line") || !strings.Contains(logged.String(), "line=2") {
+		t.Errorf("no warning for line 2:\n%s", logged)
+	}
+}
+
+func TestHistorySkipsOversizedRecord(t *testing.T) {
+	old := historyMaxLine
+	t.Cleanup(func() { historyMaxLine = old })
+	historyMaxLine = 200
+	captureLog(t)
+
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	big := `{"id":2,"prompt":"` + strings.Repeat("x", 10000) + `"}`
+	data := `{"id":1,"prompt":"a"}` + "\n" + big + "\n" + `{"id":3,"prompt":"c"}` + "\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &handoffHistory{nextID: 1}
+	if err := h.open(path); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { h.file.Close() })
+	if _, ok := h.get(1); !ok {
+		t.Error("record 1 not loaded")
+	}
+	if _, ok := h.get(3); !ok {
+		t.Error("record 3 not loaded")
+	}
+
+	var ids []int
+	if err := h.scan(func(r HandoffRecord) bool { ids = append(ids, r.ID); return true }); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if !slices.Equal(ids, []int{1, 3}) {
+		t.Errorf("scanned ids = %v, want [1 3]", ids)
+	}
+
+	n, err := rewriteHistoryFile(path, func(r *HandoffRecord) (bool, bool) { return r.ID == 1, r.ID != 1 })
+	if err != nil || n != 1 {
+		t.Fatalf("rewrite = %d, %v", n, err)
+	}
+	rest, _ := os.ReadFile(path)
+	if string(rest) != `{"id":3,"prompt":"c"}`+"\n" {
+		t.Errorf("file after rewrite = %q", rest)
+	}
+}
+
+func TestHistoryGetBeyondMemory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	var data []byte
+	for id := 1; id <= HISTORY_LIMIT+50; id++ {
+		r := HandoffRecord{ID: id, Prompt: fmt.Sprintf("prompt %d", id), Target: "chatgpt"}
+		if id > 1 {
+			r.ParentID = id - 1
+		}
+		line, _ := json.Marshal(r)
+		data = append(append(data, line...), '\n')
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := history
+	t.Cleanup(func() { history = old })
+	history = &handoffHistory{nextID: 1}
+	if err := history.open(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { history.file.Close() })
+
+	if r, ok := history.get(3); !ok || r.Prompt != "prompt 3" {
+		t.Errorf("get(3) = %+v, %v; want the record from the file", r, ok)
+	}
+	if _, ok := history.get(HISTORY_LIMIT + 51); ok {
+		t.Error("found an id that was never recorded")
+	}
+	if lo, hi, ok := history.idRange(); !ok || lo != 1 || hi != HISTORY_LIMIT+50 {
+		t.Errorf("idRange = %d-%d, %v; want 1-%d", lo, hi, ok, HISTORY_LIMIT+50)
+	}
+
+	res, err := handleGetHandoff(context.Background(), nil, &mcp.CallToolParamsFor[GetHandoffArgs]{Arguments: GetHandoffArgs{ID: 3}})
+	if err != nil || res.IsError {
+		t.Errorf("get_handoff 3 = %v, %v", res.Content, err)
+	}
+	res, _ = handleGetHandoff(context.Background(), nil, &mcp.CallToolParamsFor[GetHandoffArgs]{Arguments: GetHandoffArgs{ID: 999}})
+	if text := res.Content[0].(*mcp.TextContent).Text; !strings.Contains(text, fmt.Sprintf("available ids: 1-%d", HISTORY_LIMIT+50)) {
+		t.Errorf("unknown id error = %q", text)
+	}
+
+	thread, err := threadContext(3)
+	if err != nil || !strings.Contains(thread, "prompt 3") {
+		t.Errorf("threadContext(3) = %q, %v", thread, err)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// historyArgs are the history subcommand's filters and historyShowID the
+// handoff it shows, if any.
+var (
+	historyArgs   ListHandoffsArgs
+	historyShowID = 0
+)
+
+func registerHistoryFlags(fs *flag.FlagSet) {
+	fs.IntVar(&historyArgs.Limit, "limit", 20, "show at most `n` handoffs, newest first")
+	fs.StringVar(&historyArgs.Since, "since", "", "only handoffs at or after this `time`: RFC 3339 or an age such as 2h")
+	fs.Func("tag", "only handoffs carrying this `tag` (repeatable)", func(s string) error {
+		historyArgs.Tags = append(historyArgs.Tags, s)
+		return nil
+	})
+}
+
+// showHistory runs list_handoffs, or get_handoff for an ID, and prints the
+// result. It returns the exit status.
+func showHistory() int {
+	if noHistory {
+		fmt.Fprintln(os.Stderr, "history: disabled by --no-history")
+		return 1
+	}
+	var res *mcp.CallToolResultFor[any]
+	var err error
+	if historyShowID > 0 {
+		res, err = handleGetHandoff(context.Background(), nil, &mcp.CallToolParamsFor[GetHandoffArgs]{Arguments: GetHandoffArgs{ID: historyShowID}})
+	} else {
+		res, err = handleListHandoffs(context.Background(), nil, &mcp.CallToolParamsFor[ListHandoffsArgs]{Arguments: historyArgs})
+	}
+	if err != nil {
+		res = toolError(err.Error())
+	}
+	return printToolResult(res, nil)
+}
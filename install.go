@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SERVER_NAME is the entry install adds to a client's MCP configuration.
+const SERVER_NAME = "chatgpt-handoff"
+
+// mcpClient is a client install knows how to configure: where its config
+// file is on this OS and how a server entry is stored in it.
+type mcpClient struct {
+	Name string
+	// Path returns the config file for the current user.
+	Path func(home, configDir string) string
+	// Key is the JSON object holding the servers; empty for Codex's TOML.
+	Key string
+	// Type is added as the entry's "type" when the client wants one.
+	Type string
+}
+
+var mcpClients = []mcpClient{
+	{
+		Name: "claude-desktop",
+		Path: func(home, configDir string) string {
+			return filepath.Join(configDir, "Claude", "claude_desktop_config.json")
+		},
+		Key: "mcpServers",
+	},
+	{
+		Name: "cursor",
+		Path: func(home, configDir string) string { return filepath.Join(home, ".cursor", "mcp.json") },
+		Key:  "mcpServers",
+	},
+	{
+		Name: "vscode",
+		Path: func(home, configDir string) string { return filepath.Join(configDir, "Code", "User", "mcp.json") },
+		Key:  "servers",
+		Type: "stdio",
+	},
+	{
+		Name: "codex",
+		Path: func(home, configDir string) string { return filepath.Join(home, ".codex", "config.toml") },
+	},
+}
+
+func lookupMCPClient(name string) (mcpClient, error) {
+	var names []string
+	for _, c := range mcpClients {
+		if c.Name == name {
+			return c, nil
+		}
+		names = append(names, c.Name)
+	}
+	return mcpClient{}, fmt.Errorf("unknown client %q (known: %s)", name, strings.Join(names, ", "))
+}
+
+// configPath is the client's config file. The config directory is
+// ~/Library/Application Support on macOS, %AppData% on Windows and
+// $XDG_CONFIG_HOME or ~/.config on Linux.
+func (c mcpClient) configPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return c.Path(home, configDir), nil
+}
+
+// serverEntry is the MCP server configuration install writes.
+type serverEntry struct {
+	Type    string            `json:"type,omitempty"`
+	Command string            `json:"command"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+}
+
+// snippet is the entry as a complete config file for c.
+func (c mcpClient) snippet(e serverEntry) []byte {
+	if c.Key == "" {
+		return []byte(codexSection(e))
+	}
+	data, _ := json.MarshalIndent(map[string]any{c.Key: map[string]any{SERVER_NAME: e}}, "", "  ")
+	return append(data, '\n')
+}
+
+// runInstall implements the install and uninstall subcommands and returns
+// the exit status.
+func runInstall(command string, args []string) int {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	var (
+		client                  string
+		apply, printOnly, force bool
+		env                     []string
+	)
+	registerGlobalFlags(fs)
+	fs.StringVar(&client, "client", "", "`client` to configure: claude-desktop, cursor, vscode or codex")
+	if command == "install" {
+		fs.BoolVar(&apply, "apply", false, "change the client's config file instead of printing the snippet")
+		fs.BoolVar(&printOnly, "print", false, "print the config snippet (the default)")
+		fs.BoolVar(&force, "force", false, "replace an existing "+SERVER_NAME+" entry")
+		fs.Func("env", "`NAME=value` to set in the server's environment (repeatable)", func(s string) error {
+			if name, _, ok := strings.Cut(s, "="); !ok || name == "" {
+				return errors.New("expected NAME=value")
+			}
+			env = append(env, s)
+			return nil
+		})
+	}
+	fs.Usage = func() {
+		w := fs.Output()
+		if command == "install" {
+			fmt.Fprint(w, "Usage: chatgpt-handoff install --client NAME [--print|--apply] [--force] [--env NAME=value] [-- server flags]\n\n")
+		} else {
+			fmt.Fprint(w, "Usage: chatgpt-handoff uninstall --client NAME\n\n")
+		}
+		fs.PrintDefaults()
+		fmt.Fprint(w, "\nClients and their config files:\n")
+		printInstallClients(w)
+	}
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+	c, err := lookupMCPClient(client)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", command, err)
+		return 2
+	}
+	if apply && printOnly {
+		fmt.Fprintf(os.Stderr, "%s: use either --print or --apply\n", command)
+		return 2
+	}
+
+	switch {
+	case command == "uninstall":
+		if fs.NArg() > 0 {
+			fmt.Fprintf(os.Stderr, "uninstall: unexpected argument %q\n", fs.Arg(0))
+			return 2
+		}
+		err = uninstallServer(c)
+	case apply:
+		err = installServer(c, fs.Args(), env, force)
+	default:
+		var e serverEntry
+		if e, err = newServerEntry(c, fs.Args(), env); err == nil {
+			os.Stdout.Write(c.snippet(e))
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", command, err)
+		return 1
+	}
+	return 0
+}
+
+// newServerEntry runs this binary by its absolute path with serverArgs.
+func newServerEntry(c mcpClient, serverArgs, env []string) (serverEntry, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return serverEntry{}, fmt.Errorf("can't find this binary: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	e := serverEntry{Type: c.Type, Command: exe, Args: serverArgs}
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		if e.Env == nil {
+			e.Env = map[string]string{}
+		}
+		e.Env[name] = value
+	}
+	return e, nil
+}
+
+func installServer(c mcpClient, serverArgs, env []string, force bool) error {
+	e, err := newServerEntry(c, serverArgs, env)
+	if err != nil {
+		return err
+	}
+	path, err := c.configPath()
+	if err != nil {
+		return err
+	}
+	data, mode, err := readClientConfig(path)
+	if err != nil {
+		return err
+	}
+	var updated []byte
+	if c.Key == "" {
+		updated, err = mergeCodexConfig(data, e, force)
+	} else {
+		updated, err = mergeJSONConfig(data, c.Key, e, force)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := writeClientConfig(path, data, updated, mode); err != nil {
+		return err
+	}
+	fmt.Printf("Added %s to %s. Restart %s to load it.\n", SERVER_NAME, path, c.Name)
+	return nil
+}
+
+func uninstallServer(c mcpClient) error {
+	path, err := c.configPath()
+	if err != nil {
+		return err
+	}
+	data, mode, err := readClientConfig(path)
+	if err != nil {
+		return err
+	}
+	var updated []byte
+	var found bool
+	if c.Key == "" {
+		updated, found = removeCodexServer(data)
+	} else {
+		updated, found, err = removeJSONServer(data, c.Key)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if !found {
+		fmt.Printf("%s has no %s entry.\n", path, SERVER_NAME)
+		return nil
+	}
+	if err := writeClientConfig(path, data, updated, mode); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %s from %s.\n", SERVER_NAME, path)
+	return nil
+}
+
+// readClientConfig returns the config file and its mode; a missing file
+// reads as empty.
+func readClientConfig(path string) ([]byte, os.FileMode, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0o600, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	data, err := os.ReadFile(path)
+	return data, info.Mode().Perm(), err
+}
+
+// writeClientConfig backs up the original, if there is one, next to it as
+// <name>.<timestamp>.bak before replacing it.
+func writeClientConfig(path string, original, updated []byte, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if original != nil {
+		backup := path + "." + time.Now().Format("20060102-150405") + ".bak"
+		if err := os.WriteFile(backup, original, mode); err != nil {
+			return fmt.Errorf("backing up %s: %w", path, err)
+		}
+		fmt.Printf("Backed up %s to %s.\n", path, backup)
+	}
+	if err := writeFileAtomic(path, updated); err != nil {
+		return err
+	}
+	return os.Chmod(path, mode)
+}
+
+// mergeJSONConfig adds e under key. Other settings are kept, though the
+// file is rewritten with sorted keys.
+func mergeJSONConfig(data []byte, key string, e serverEntry, force bool) ([]byte, error) {
+	config, err := parseClientJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	servers, _ := config[key].(map[string]any)
+	if servers == nil {
+		if _, ok := config[key]; ok {
+			return nil, fmt.Errorf("%q is not an object", key)
+		}
+		servers = map[string]any{}
+	}
+	if _, ok := servers[SERVER_NAME]; ok && !force {
+		return nil, fmt.Errorf("there is already a %s entry; pass --force to replace it", SERVER_NAME)
+	}
+	servers[SERVER_NAME] = e
+	config[key] = servers
+	return marshalClientJSON(config)
+}
+
+func removeJSONServer(data []byte, key string) ([]byte, bool, error) {
+	config, err := parseClientJSON(data)
+	if err != nil {
+		return nil, false, err
+	}
+	servers, _ := config[key].(map[string]any)
+	if _, ok := servers[SERVER_NAME]; !ok {
+		return nil, false, nil
+	}
+	delete(servers, SERVER_NAME)
+	updated, err := marshalClientJSON(config)
+	return updated, true, err
+}
+
+func parseClientJSON(data []byte) (map[string]any, error) {
+	config := map[string]any{}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return config, nil
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("can't parse the config file (comments aren't supported; add the snippet from --print by hand): %w", err)
+	}
+	return config, nil
+}
+
+func marshalClientJSON(config map[string]any) ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(config); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// codexHeader starts the server's table in Codex's config.toml.
+const codexHeader = "[mcp_servers." + SERVER_NAME + "]"
+
+func codexSection(e serverEntry) string {
+	var b strings.Builder
+	b.WriteString(codexHeader + "\n")
+	fmt.Fprintf(&b, "command = %s\n", tomlString(e.Command))
+	if len(e.Args) > 0 {
+		quoted := make([]string, len(e.Args))
+		for i, a := range e.Args {
+			quoted[i] = tomlString(a)
+		}
+		fmt.Fprintf(&b, "args = [%s]\n", strings.Join(quoted, ", "))
+	}
+	if len(e.Env) > 0 {
+		names := make([]string, 0, len(e.Env))
+		for name := range e.Env {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		pairs := make([]string, len(names))
+		for i, name := range names {
+			pairs[i] = tomlString(name) + " = " + tomlString(e.Env[name])
+		}
+		fmt.Fprintf(&b, "env = { %s }\n", strings.Join(pairs, ", "))
+	}
+	return b.String()
+}
+
+// tomlString quotes s as a TOML basic string.
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, "\\u%04X", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// codexSectionRange finds the server's table and any subtables of it,
+// from the header to the next unrelated table.
+func codexSectionRange(lines []string) (int, int, bool) {
+	start := -1
+	for i, line := range lines {
+		header := strings.TrimSpace(line)
+		if start < 0 {
+			if header == codexHeader {
+				start = i
+			}
+			continue
+		}
+		if strings.HasPrefix(header, "[") && !strings.HasPrefix(header, "[mcp_servers."+SERVER_NAME+".") {
+			return start, i, true
+		}
+	}
+	return start, len(lines), start >= 0
+}
+
+// mergeCodexConfig appends the server's table to config.toml, replacing
+// an existing one with force. The rest of the file is left as it is.
+func mergeCodexConfig(data []byte, e serverEntry, force bool) ([]byte, error) {
+	lines := strings.Split(string(data), "\n")
+	if start, end, ok := codexSectionRange(lines); ok {
+		if !force {
+			return nil, fmt.Errorf("there is already a %s entry; pass --force to replace it", SERVER_NAME)
+		}
+		lines = slices.Delete(lines, start, end)
+	}
+	text := strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	if text != "" {
+		text += "\n\n"
+	}
+	return []byte(text + codexSection(e)), nil
+}
+
+func removeCodexServer(data []byte) ([]byte, bool) {
+	lines := strings.Split(string(data), "\n")
+	start, end, ok := codexSectionRange(lines)
+	if !ok {
+		return nil, false
+	}
+	lines = slices.Delete(lines, start, end)
+	text := strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	if text != "" {
+		text += "\n"
+	}
+	return []byte(text), true
+}
+
+// printInstallClients lists the supported clients and their config files,
+// for install's usage.
+func printInstallClients(w io.Writer) {
+	for _, c := range mcpClients {
+		path, err := c.configPath()
+		if err != nil {
+			path = err.Error()
+		}
+		fmt.Fprintf(w, "  %-15s %s\n", c.Name, path)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClientConfigPaths(t *testing.T) {
+	// The config directory os.UserConfigDir gives on each OS.
+	systems := []struct {
+		goos, home, configDir string
+	}{
+		{"linux", "/home/u", "/home/u/.config"},
+		{"darwin", "/Users/u", "/Users/u/Library/Application Support"},
+		{"windows", `C:\Users\u`, `C:\Users\u\AppData\Roaming`},
+	}
+	// Each client's file, under the home or the config directory.
+	want := map[string]struct {
+		inConfigDir bool
+		rel         string
+	}{
+		"claude-desktop": {true, "Claude/claude_desktop_config.json"},
+		"cursor":         {false, ".cursor/mcp.json"},
+		"vscode":         {true, "Code/User/mcp.json"},
+		"codex":          {false, ".codex/config.toml"},
+	}
+	if len(want) != len(mcpClients) {
+		t.Fatalf("the table covers %d clients, there are %d", len(want), len(mcpClients))
+	}
+	for _, c := range mcpClients {
+		w, ok := want[c.Name]
+		if !ok {
+			t.Errorf("no expected path for %s", c.Name)
+			continue
+		}
+		for _, s := range systems {
+			base := s.home
+			if w.inConfigDir {
+				base = s.configDir
+			}
+			if got, want := c.Path(s.home, s.configDir), filepath.Join(base, filepath.FromSlash(w.rel)); got != want {
+				t.Errorf("%s on %s: %s, want %s", c.Name, s.goos, got, want)
+			}
+		}
+	}
+
+	home := t.TempDir()
+	setHome(t, home)
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range mcpClients {
+		got, err := c.configPath()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := c.Path(home, configDir); got != want {
+			t.Errorf("%s configPath() = %s, want %s", c.Name, got, want)
+		}
+	}
+}
+
+// setHome points the home and config directories into a test's own.
+func setHome(t *testing.T, home string) {
+	t.Helper()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("AppData", filepath.Join(home, "AppData", "Roaming"))
+}
+
+func TestMergeJSONConfig(t *testing.T) {
+	existing := []byte(`{
+  "theme": "dark",
+  "mcpServers": {
+    "other": {"command": "other-server", "args": ["--flag"]}
+  }
+}`)
+	e := serverEntry{Command: "/bin/chatgpt-handoff", Args: []string{"--http"}}
+
+	updated, err := mergeJSONConfig(existing, "mcpServers", e, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		Theme      string                     `json:"theme"`
+		MCPServers map[string]json.RawMessage `json:"mcpServers"`
+	}
+	if err := json.Unmarshal(updated, &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Theme != "dark" {
+		t.Errorf("lost the other settings: %s", updated)
+	}
+	if _, ok := config.MCPServers["other"]; !ok {
+		t.Errorf("lost the other server: %s", updated)
+	}
+	var got serverEntry
+	if err := json.Unmarshal(config.MCPServers[SERVER_NAME], &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Command != e.Command || strings.Join(got.Args, " ") != "--http" {
+		t.Errorf("entry = %+v, want %+v", got, e)
+	}
+
+	if _, err := mergeJSONConfig(updated, "mcpServers", e, false); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("replacing the entry without --force: err = %v", err)
+	}
+	e.Args = []string{"--port", "0"}
+	replaced, err := mergeJSONConfig(updated, "mcpServers", e, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(replaced), `"0"`) {
+		t.Errorf("--force didn't replace the entry: %s", replaced)
+	}
+
+	if _, err := mergeJSONConfig([]byte(`{"mcpServers": []}`), "mcpServers", e, false); err == nil {
+		t.Error("merged into a servers key that isn't an object")
+	}
+	if _, err := mergeJSONConfig([]byte("// comment\n{}"), "mcpServers", e, false); err == nil {
+		t.Error("merged into a file that isn't JSON")
+	}
+}
+
+func TestMergeCodexConfig(t *testing.T) {
+	existing := "model = \"o3\"\n\n[mcp_servers.other]\ncommand = \"other-server\"\n"
+	e := serverEntry{Command: `C:\bin\chatgpt-handoff.exe`, Env: map[string]string{"A": "1"}}
+
+	updated, err := mergeCodexConfig([]byte(existing), e, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := existing + "\n" + codexHeader + "\n" +
+		`command = "C:\\bin\\chatgpt-handoff.exe"` + "\n" +
+		`env = { "A" = "1" }` + "\n"
+	if string(updated) != want {
+		t.Errorf("merged:\n%s\nwant:\n%s", updated, want)
+	}
+	if _, err := mergeCodexConfig(updated, e, false); err == nil {
+		t.Error("replaced the entry without --force")
+	}
+
+	removed, found := removeCodexServer(updated)
+	if !found || string(removed) != existing {
+		t.Errorf("removed (%v):\n%s\nwant:\n%s", found, removed, existing)
+	}
+	if _, found := removeCodexServer([]byte(existing)); found {
+		t.Error("found an entry that isn't there")
+	}
+}
+
+func TestInstallUninstall(t *testing.T) {
+	home := t.TempDir()
+	setHome(t, home)
+	c, err := lookupMCPClient("cursor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, _ := c.configPath()
+	original := `{"mcpServers": {"other": {"command": "other-server"}}}`
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(original), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := installServer(c, []string{"--http"}, []string{"OPENAI_API_KEY=sk-test"}, false); err != nil {
+		t.Fatal(err)
+	}
+	backups, _ := filepath.Glob(path + ".*.bak")
+	if len(backups) != 1 {
+		t.Fatalf("backups = %q, want one", backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != original {
+		t.Errorf("backup holds %s, want the original", data)
+	}
+	if err := installServer(c, nil, nil, false); err == nil {
+		t.Error("install replaced the entry without --force")
+	}
+
+	if err := uninstallServer(c); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config map[string]map[string]any
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := config["mcpServers"][SERVER_NAME]; ok {
+		t.Errorf("uninstall left the entry: %s", data)
+	}
+	if _, ok := config["mcpServers"]["other"]; !ok {
+		t.Errorf("uninstall removed the other server: %s", data)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+const (
+	DEFAULT_LOG_MAX_SIZE  = 10 // megabytes
+	DEFAULT_LOG_MAX_FILES = 3
+)
+
+// logFile is --log-file: empty leaves logging on stderr only, "auto" uses
+// defaultLogFile. logFilePath is the file actually written, once
+// setupLogging has opened it, and logFileErr why it couldn't be.
+var (
+	logFile     = ""
+	logMaxSize  = DEFAULT_LOG_MAX_SIZE
+	logMaxFiles = DEFAULT_LOG_MAX_FILES
+	logFormat   = "text"
+	logPrompts  = false
+	logLevel    slog.LevelVar
+
+	logFilePath = ""
+	logFileErr  error
+)
+
+// setupLogging tees the log to --log-file and, with --log-format json,
+// replaces the default handler with a JSON one for both. A file that
+// can't be opened leaves logging on stderr with one warning.
+func setupLogging() {
+	var out io.Writer = os.Stderr
+	if logFile != "" {
+		path, err := autoPath(logFile, defaultLogFile)
+		var w *rotatingFile
+		if err == nil {
+			w, err = openRotatingFile(path, int64(logMaxSize)<<20, logMaxFiles)
+		}
+		if err != nil {
+			logFileErr = err
+		} else if daemonChild {
+			// stderr already goes to the log file (startDaemon).
+			logFilePath, out = path, w
+		} else {
+			logFilePath = path
+			out = io.MultiWriter(os.Stderr, w)
+		}
+	}
+	if logFormat == "json" {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: &logLevel})))
+	} else {
+		// slog's default handler writes through the log package.
+		log.SetOutput(out)
+	}
+	if logFileErr != nil {
+		slog.Warn("can't open the log file; logging to stderr only", "error", logFileErr)
+	}
+}
+
+// Log attributes with the same name everywhere, so JSON logs can be
+// queried across messages. Use these rather than spelling the keys out.
+
+func logMethod(method string) slog.Attr { return slog.String("method", method) }
+
+func logRequestID(id int64) slog.Attr { return slog.Int64("requestId", id) }
+
+func logSessionID(id string) slog.Attr { return slog.String("sessionId", id) }
+
+func logDuration(d time.Duration) slog.Attr { return slog.Int64("durationMs", d.Milliseconds()) }
+
+func logOutcome(outcome string) slog.Attr { return slog.String("outcome", outcome) }
+
+func logBackend(backend string) slog.Attr { return slog.String("backend", backend) }
+
+// logPrompt is the only way prompt text reaches the log, and only with
+// --log-prompts; otherwise it is an empty attribute, which slog drops.
+func logPrompt(prompt string) slog.Attr {
+	if !logPrompts {
+		return slog.Attr{}
+	}
+	return slog.String("prompt", prompt)
+}
+
+// requestSeq numbers the requests logRequests logs; the SDK doesn't pass
+// the JSON-RPC id to middleware.
+var requestSeq atomic.Int64
+
+// sessionSeq and sessionIDs number the sessions whose transport has no id
+// of its own, which is every stdio and SSE session, until they end.
+var (
+	sessionSeq atomic.Int64
+	sessionIDs sync.Map // *mcp.ServerSession to string
+)
+
+// sessionID is the id ss is logged under.
+func sessionID(ss *mcp.ServerSession) string {
+	if id := ss.ID(); id != "" {
+		return id
+	}
+	if id, ok := sessionIDs.Load(ss); ok {
+		return id.(string)
+	}
+	id, loaded := sessionIDs.LoadOrStore(ss, fmt.Sprintf("s%d", sessionSeq.Add(1)))
+	if !loaded {
+		go func() {
+			ss.Wait()
+			sessionIDs.Delete(ss)
+		}()
+	}
+	return id.(string)
+}
+
+// resultBackend is the clipboard backend a tool call used, or "".
+func resultBackend(result mcp.Result) string {
+	r, ok := result.(*mcp.CallToolResultFor[any])
+	if !ok || r == nil {
+		return ""
+	}
+	switch c := r.StructuredContent.(type) {
+	case *HandoffResult:
+		return c.ClipboardBackend
+	case *CopyResult:
+		return c.ClipboardBackend
+	case *NextChunkResult:
+		return c.ClipboardBackend
+	case *ReadClipboardResult:
+		return c.Backend
+	case *ClipboardChangeResult:
+		return c.Backend
+	}
+	return ""
+}
+
+// logRequests logs every request the server receives: tools/call at info
+// and everything else at debug.
+func logRequests(next mcp.MethodHandler[*mcp.ServerSession]) mcp.MethodHandler[*mcp.ServerSession] {
+	return func(ctx context.Context, ss *mcp.ServerSession, method string, params mcp.Params) (mcp.Result, error) {
+		start := time.Now()
+		result, err := next(ctx, ss, method, params)
+		outcome := "ok"
+		if r, ok := result.(*mcp.CallToolResultFor[any]); ok && r != nil && r.IsError {
+			outcome = "tool-error"
+		}
+		if err != nil {
+			outcome = "error"
+		}
+		level, attrs := slog.LevelDebug, []slog.Attr{logMethod(method), logRequestID(requestSeq.Add(1)), logDuration(time.Since(start)), logOutcome(outcome)}
+		if ss != nil {
+			attrs = append(attrs, logSessionID(sessionID(ss)))
+		}
+		if p, ok := params.(*mcp.CallToolParamsFor[json.RawMessage]); ok {
+			level = slog.LevelInfo
+			attrs = append(attrs, slog.String("tool", p.Name))
+			if backend := resultBackend(result); backend != "" {
+				attrs = append(attrs, logBackend(backend))
+			}
+		}
+		if err != nil {
+			attrs = append(attrs, slog.Any("error", err))
+		}
+		slog.LogAttrs(ctx, level, "handled request", attrs...)
+		return result, err
+	}
+}
+
+// rotatingFile appends to path and, when a write would take it past
+// maxSize, shifts it to path.1, path.1 to path.2 and so on, keeping keep
+// old files.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	f       *os.File
+	size    int64
+	failed  bool
+}
+
+func openRotatingFile(path string, maxSize int64, keep int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, keep: keep}
+	if err := makeParentDir(path); err != nil {
+		return nil, err
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.keep))
+	for i := r.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if r.keep > 0 {
+		os.Rename(r.path, r.path+".1")
+	} else {
+		os.Remove(r.path)
+	}
+	return r.open()
+}
+
+// Write never fails, so a broken log file can't take stderr logging down
+// with it through the MultiWriter; after the first error the file is
+// dropped with a warning on stderr.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failed {
+		return len(p), nil
+	}
+	var err error
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		err = r.rotate()
+	}
+	if err == nil {
+		var n int
+		n, err = r.f.Write(p)
+		r.size += int64(n)
+	}
+	if err != nil {
+		r.failed = true
+		fmt.Fprintf(os.Stderr, "chatgpt-handoff: logging to stderr only: %v\n", err)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// callLogged makes a tools/call to a new server through an in-memory
+// session and returns the JSON log lines it wrote.
+func callLogged(t *testing.T, name string, args map[string]any) []map[string]any {
+	t.Helper()
+	var buf bytes.Buffer
+	old := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(old) })
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	ctx := context.Background()
+	clientTransport, serverTransport := mcp.NewInMemoryTransports()
+	ss, err := buildServer().Connect(ctx, serverTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	cs, err := mcp.NewClient(&mcp.Implementation{Name: "test"}, nil).Connect(ctx, clientTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	res, err := cs.CallTool(ctx, &mcp.CallToolParams{Name: name, Arguments: args})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.IsError {
+		t.Fatalf("%s failed: %v", name, res.Content)
+	}
+
+	var lines []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var m map[string]any
+		if err := json.Unmarshal(line, &m); err != nil {
+			t.Fatalf("log line isn't JSON: %s", line)
+		}
+		lines = append(lines, m)
+	}
+	return lines
+}
+
+// withFileClipboard sends copies to the file backend and keeps the handoff
+// out of the history.
+func withFileClipboard(t *testing.T) {
+	t.Helper()
+	oldFallback, oldNoHistory, oldLogPrompts := fallbackFile, noHistory, logPrompts
+	t.Cleanup(func() { fallbackFile, noHistory, logPrompts = oldFallback, oldNoHistory, oldLogPrompts })
+	fallbackFile = filepath.Join(t.TempDir(), "prompt.md")
+	noHistory = true
+	live := liveSettings.Load()
+	t.Cleanup(func() { liveSettings.Store(live) })
+	liveSettings.Store(captureSettings())
+}
+
+const loggedPrompt = "a secret prompt"
+
+func handoffLogArgs() map[string]any {
+	return map[string]any{"prompt": loggedPrompt, "openChat": false, "clipboardBackend": "file"}
+}
+
+func TestToolCallLogFields(t *testing.T) {
+	withFileClipboard(t)
+	logPrompts = false
+	lines := callLogged(t, "handoff_to_chatgpt", handoffLogArgs())
+
+	var call map[string]any
+	for _, line := range lines {
+		if line["msg"] == "handled request" && line["method"] == "tools/call" {
+			call = line
+		}
+		for key, value := range line {
+			if key == "prompt" || strings.Contains(fmtValue(value), loggedPrompt) {
+				t.Errorf("the prompt is logged without --log-prompts: %v", line)
+			}
+		}
+	}
+	if call == nil {
+		t.Fatalf("no tools/call line in the log: %v", lines)
+	}
+	for _, key := range []string{"method", "requestId", "sessionId", "durationMs", "outcome", "backend"} {
+		if _, ok := call[key]; !ok {
+			t.Errorf("the tools/call line has no %s: %v", key, call)
+		}
+	}
+	if call["outcome"] != "ok" || call["backend"] != "file" || call["tool"] != "handoff_to_chatgpt" {
+		t.Errorf("tools/call line = %v", call)
+	}
+}
+
+func TestToolCallLogPrompts(t *testing.T) {
+	withFileClipboard(t)
+	logPrompts = true
+	found := false
+	for _, line := range callLogged(t, "handoff_to_chatgpt", handoffLogArgs()) {
+		if line["prompt"] == loggedPrompt {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("no prompt field with --log-prompts")
+	}
+}
+
+func fmtValue(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -1,38 +1,357 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
 	"errors"
-	"io"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
-	"runtime"
-	"strconv"
+	"reflect"
+	"slices"
 	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 )
 
 type HandoffArgs struct {
-	Prompt string `json:"prompt"`
+	Prompt     string           `json:"prompt,omitempty" jsonschema:"the prompt to hand off; give exactly one of prompt, messages, promptFile or template"`
+	Messages   []HandoffMessage `json:"messages,omitempty" jsonschema:"a condensed conversation to hand off instead of prompt, formatted into one prompt with a section per entry"`
+	PromptFile string           `json:"promptFile,omitempty" jsonschema:"read the prompt from this UTF-8 text file instead; relative paths resolve against the client's root"`
+	Wrap       *bool            `json:"wrap,omitempty" jsonschema:"surround the copied text with start/end markers; defaults to the server's --clipboard-wrapper setting"`
+
+	Template  string            `json:"template,omitempty" jsonschema:"name of a prompt template to render instead of passing prompt"`
+	Variables map[string]string `json:"variables,omitempty" jsonschema:"values for the template's {{variable}} placeholders"`
+
+	Context string     `json:"context,omitempty" jsonschema:"background to put before the prompt, e.g. what the project is and what was tried"`
+	Code    CodeBlocks `json:"code,omitempty" jsonschema:"code to put after the prompt, fenced by the server: a string, or a list of {filename, language, content} blocks. Pass code here rather than fencing it inside prompt"`
+
+	Attachments []Attachment `json:"attachments,omitempty" jsonschema:"files to append to the prompt as code blocks, optionally limited to a line range"`
+
+	IncludeGitDiff *GitDiffOptions `json:"includeGitDiff,omitempty" jsonschema:"append the output of git diff to the prompt: true for unstaged changes, or an object with staged, paths and contextLines"`
+	Cwd            string          `json:"cwd,omitempty" jsonschema:"directory to run git diff in (default: the client's root)"`
+
+	ResponseLanguage *string `json:"responseLanguage,omitempty" jsonschema:"language the answer should be in, as a tag such as de or pt-BR or a name; adds a final line asking for it. Defaults to the server's --default-response-language; pass an empty string to leave it out"`
+
+	ExpectedFormat string `json:"expectedFormat,omitempty" jsonschema:"ask for the answer in a given shape: markdown, json, diff or code-only, or a free-form description; appended to the end of the prompt"`
+
+	IncludeSystemInfo bool `json:"includeSystemInfo,omitempty" jsonschema:"append the OS, architecture, locale, shell and tool versions, for environment-specific problems"`
+
+	ParentID int `json:"parentId,omitempty" jsonschema:"id of an earlier handoff this follows up on; its prompt and recorded response are included before the new prompt"`
+
+	Chunked *bool `json:"chunked,omitempty" jsonschema:"split a very long prompt into numbered parts: the first is copied now and next_handoff_chunk copies each following one; defaults to the server's --auto-chunk-above setting"`
+
+	Redact *bool `json:"redact,omitempty" jsonschema:"replace API keys, tokens and private keys with [REDACTED:<type>] before copying; defaults to the server's --redact-secrets setting"`
+
+	SkipPreamble bool `json:"skipPreamble,omitempty" jsonschema:"leave out the server's configured prompt prefix and suffix for this handoff"`
+
+	ClipboardBackend string `json:"clipboardBackend,omitempty" jsonschema:"force a specific clipboard backend for this call (for testing)"`
+
+	MaxDeeplinkLength *int `json:"maxDeeplinkLength,omitempty" jsonschema:"override the maximum deeplink URL length for this call (0 disables the deeplink)"`
+
+	Model           string `json:"model,omitempty" jsonschema:"ChatGPT model slug to open the chat with, e.g. gpt-4o or o3"`
+	TemporaryChat   *bool  `json:"temporaryChat,omitempty" jsonschema:"open the chat in temporary mode so it is not saved to ChatGPT history"`
+	WebSearch       *bool  `json:"webSearch,omitempty" jsonschema:"pre-select ChatGPT web search (hints=search); useful for research prompts"`
+	DesktopApp      *bool  `json:"desktopApp,omitempty" jsonschema:"set false to force the browser (fresh web session), true to try the ChatGPT desktop app first"`
+	Incognito       *bool  `json:"incognito,omitempty" jsonschema:"open the deeplink in a private/incognito browser window"`
+	OpenChat        *bool  `json:"openChat,omitempty" jsonschema:"set false to only copy the prompt without opening a new chat (default true)"`
+	AutoSubmit      *bool  `json:"autoSubmit,omitempty" jsonschema:"macOS only: paste the prompt into the ChatGPT desktop app and press Return (needs Accessibility permission)"`
+	DeeplinkPreview *bool  `json:"deeplinkPreview,omitempty" jsonschema:"when the prompt is too long to deeplink, open the chat with a truncated preview instead"`
+	QR              *bool  `json:"qr,omitempty" jsonschema:"also return the deeplink as a QR code so it can be opened on a phone"`
+	Notify          *bool  `json:"notify,omitempty" jsonschema:"show a desktop notification once the handoff is done; defaults to the server's --notify setting"`
+
+	Target string `json:"target,omitempty" jsonschema:"assistant to hand off to: chatgpt (default), claude, gemini, perplexity, or grok"`
+	GPTID  string `json:"gptId,omitempty" jsonschema:"custom GPT to open, e.g. g-abc123-code-review (chatgpt target only)"`
+
+	Priority string `json:"priority,omitempty" jsonschema:"low only copies the prompt, without opening a chat or notifying; normal (default) is the usual handoff; high also opens a truncated preview when the prompt is too long to deeplink, and notifies"`
+
+	Deliver string `json:"deliver,omitempty" jsonschema:"open (default) copies the prompt and opens the chat; copy-link copies the deeplink URL instead, for sharing; both copies the prompt and returns the link without opening it; webhook only posts it to the server's --webhook-url; slack only posts it to the server's --slack-webhook-url; email only emails it through the server's --smtp-host"`
+
+	RichText *bool `json:"richText,omitempty" jsonschema:"with deliver email, also include an HTML version of the prompt"`
+
+	Title string `json:"title,omitempty" jsonschema:"short label for this handoff in the history, at most 120 characters; derived from the prompt's first line when omitted"`
+
+	Tags []string `json:"tags,omitempty" jsonschema:"lowercase labels for filtering the history, e.g. research or debugging (at most 10, 32 characters each)"`
+
+	Direct bool `json:"direct,omitempty" jsonschema:"send the prompt to the OpenAI API and return ChatGPT's answer instead of handing it off by hand; needs the server's OPENAI_API_KEY, and falls back to the normal handoff if the request fails"`
+
+	Force bool `json:"force,omitempty" jsonschema:"open the chat even if the identical prompt was handed off a few minutes ago"`
+
+	Queue bool `json:"queue,omitempty" jsonschema:"hold the handoff in a queue instead of delivering it now, e.g. when there are several independent questions; next_handoff delivers the oldest queued one"`
+
+	DryRun bool `json:"dryRun,omitempty" jsonschema:"validate and build everything but don't touch the clipboard or open anything; returns what would have been copied and opened"`
+}
+
+// HandoffResult is the structured content returned by handoff_to_chatgpt.
+type HandoffResult struct {
+	ClipboardBackend string `json:"clipboardBackend"`
+	ClipboardDetail  string `json:"clipboardDetail,omitempty"`
+	// ClipboardContent is what was copied: "prompt" or "deeplink".
+	ClipboardContent string `json:"clipboardContent"`
+	// ClipboardVerified tells whether reading the clipboard back gave the
+	// copied text; it is absent when the backend can't read back.
+	ClipboardVerified *bool `json:"clipboardVerified,omitempty"`
+	// Webhook is "posted" or "failed" when the handoff went to
+	// --webhook-url.
+	Webhook string `json:"webhook,omitempty"`
+	// Slack is "posted" or "truncated" when the handoff went to
+	// --slack-webhook-url.
+	Slack string `json:"slack,omitempty"`
+	// EmailedTo lists the recipients when the handoff was emailed.
+	EmailedTo []string `json:"emailedTo,omitempty"`
+	// FallbackFile is where the text went when no clipboard was available
+	// (--fallback-file).
+	FallbackFile string `json:"fallbackFile,omitempty"`
+
+	// DeeplinkStatus is "opened", "failed", "skipped-too-long",
+	// "skipped-disabled", "skipped-headless", "skipped-by-request",
+	// "skipped-unverified" (the clipboard read-back didn't match),
+	// "skipped-cancelled" (cancelled during --deeplink-delay),
+	// "skipped-auto-submit", "skipped-duplicate", "focused-existing"
+	// (--reuse-tab), "filled-cdp" when a prompt too long to deeplink was
+	// filled into Chrome via --cdp-endpoint, or "preview" when a truncated
+	// preview deeplink was opened.
+	DeeplinkStatus    string   `json:"deeplinkStatus"`
+	DeeplinkError     string   `json:"deeplinkError,omitempty"`
+	Deeplink          string   `json:"deeplink,omitempty"`
+	DeeplinkLength    int      `json:"deeplinkLength"`
+	MaxDeeplinkLength int      `json:"maxDeeplinkLength"`
+	DroppedParams     []string `json:"droppedParams,omitempty"`
+	// DeeplinkExceeded is set when the prompt was too long to deeplink
+	// within MaxDeeplinkLength.
+	DeeplinkExceeded bool `json:"deeplinkExceeded,omitempty"`
+	// DeeplinkReason says why no deeplink was opened, for the skipped-*
+	// statuses.
+	DeeplinkReason string `json:"deeplinkReason,omitempty"`
+	// DuplicateOf is the earlier handoff of the identical prompt, for
+	// skipped-duplicate; it is 0 with --no-history.
+	DuplicateOf int `json:"duplicateOf,omitempty"`
+
+	Model         string `json:"model,omitempty"`
+	TemporaryChat bool   `json:"temporaryChat"`
+	WebSearch     bool   `json:"webSearch"`
+
+	// OpenedTarget is "desktop-app" or "browser" when the deeplink was
+	// opened, "relay" when the relay page was opened instead, "cdp" when
+	// the prompt was filled into a Chrome tab via --cdp-endpoint, or
+	// "existing-tab" when --reuse-tab focused an open chat.
+	OpenedTarget string `json:"openedTarget,omitempty"`
+	Incognito    bool   `json:"incognito"`
+
+	Target string   `json:"target"`
+	Host   string   `json:"host"`
+	GPTID  string   `json:"gptId,omitempty"`
+	Title  string   `json:"title,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+
+	// Confirmation is "confirmed" when --confirm asked the user first.
+	Confirmation string `json:"confirmation,omitempty"`
+
+	// Priority is the handoff's priority level and PriorityBehavior what
+	// that level did; the openChat, deeplinkPreview and notify arguments
+	// override it.
+	Priority         string           `json:"priority"`
+	PriorityBehavior PriorityBehavior `json:"priorityBehavior"`
+
+	// Submission is "submitted" when --auto-submit pasted and sent the
+	// prompt, or "manual-paste" when it was requested but fell back.
+	Submission string `json:"submission,omitempty"`
+
+	// RelayURL is the one-time localhost page holding a prompt too long
+	// to deeplink (--relay), valid until RelayExpires.
+	RelayURL     string     `json:"relayUrl,omitempty"`
+	RelayExpires *time.Time `json:"relayExpires,omitempty"`
+
+	// QRURL is where the QR code PNG can be fetched in HTTP mode.
+	QRURL string `json:"qrUrl,omitempty"`
+
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Redactions counts the secrets replaced in the prompt, by type.
+	Redactions map[string]int `json:"redactions,omitempty"`
+
+	// PromptLength is the length of the prompt as handed off, including
+	// any attachments.
+	PromptLength int `json:"promptLength"`
+	// CharCount is the prompt's length in characters and EstimatedTokens
+	// a rough guess at its size in tokens.
+	CharCount       int `json:"charCount"`
+	EstimatedTokens int `json:"estimatedTokens"`
+
+	// Chunks is how many parts a chunked handoff was split into; only the
+	// first was copied.
+	Chunks int `json:"chunks,omitempty"`
+
+	// DryRun results carry the text that would have been copied and the
+	// delivery steps that would have been taken.
+	DryRun    bool     `json:"dryRun,omitempty"`
+	Copied    string   `json:"copied,omitempty"`
+	Decisions []string `json:"decisions,omitempty"`
 }
 
 const (
-	MAX_DEEPLINK_LENGTH = 1800
+	DEFAULT_MAX_DEEPLINK_LENGTH = 1800
+	// Upper bound accepted for --max-deeplink-length; 0 disables deeplinks.
+	MAX_DEEPLINK_LENGTH_LIMIT = 32000
+
+	// Windows caps command lines at 32767 UTF-16 units; prompts above this
+	// many bytes go through a temp file instead of the inline here-string.
+	MAX_WINDOWS_INLINE_CLIPBOARD = 8192
 )
 
 var (
-	httpMode = false
-	httpPort = 8080
+	httpMode         = false
+	httpPort         = 8080
+	clipboardCommand = ""
+	fallbackFile     = ""
+
+	clipboardBackendName  = ""
+	listClipboardBackends = false
+	showVersion           = false
+	jsonOutput            = false
+	allowClipboardRead    = false
+	clipboardReadLimit    = DEFAULT_CLIPBOARD_READ_LIMIT
+
+	promptPrefix    = ""
+	promptSuffix    = ""
+	maxPromptLength = DEFAULT_MAX_PROMPT_LENGTH
+
+	clipboardWrapper      = false
+	clipboardWrapperStart = DEFAULT_WRAPPER_START
+	clipboardWrapperEnd   = DEFAULT_WRAPPER_END
+
+	preserveCodeLineEndings = false
+
+	maxDeeplinkLength = DEFAULT_MAX_DEEPLINK_LENGTH
+	chatgptURL        = DEFAULT_CHATGPT_URL
+	preferDesktopApp  = false
+	omitDeeplink      = false
+	browser           = ""
+	browserProfile    = ""
+	incognito         = false
+	forceDeeplink     = false
+	targetApp         = ""
+	defaultModel      = ""
+	defaultGPT        = ""
+	temporaryChat     = false
+	autoWebSearch     = false
+	qrCode            = false
+	relayEnabled      = false
+	autoSubmitPrompt  = false
+	cdpEndpoint       = ""
+	reuseTab          = false
+	deeplinkDelay     time.Duration
+	deeplinkPreview   = false
+	historyFile       = ""
+	noHistory         = false
+	defaultTags       []string
+	handoffTools      []string
+	historyRetention  time.Duration
+
+	// toolDescription replaces the built-in handoff_to_chatgpt description
+	// and toolDescriptionAppend is added to it (--tool-description,
+	// --tool-description-append).
+	toolDescription       = ""
+	toolDescriptionAppend = ""
 )
 
 func main() {
 	parseFlags()
+	setupLogging()
+
+	switch {
+	case command == "version" || showVersion:
+		printVersion(os.Stdout)
+		return
+	case command == "doctor":
+		r := doctor()
+		printDoctor(os.Stdout, r)
+		if !r.OK {
+			os.Exit(1)
+		}
+		return
+	case command == "stop":
+		os.Exit(stopDaemon())
+	case command == "status":
+		os.Exit(daemonStatus())
+	case daemonMode && !daemonChild:
+		os.Exit(startDaemon())
+	case command == "clipboard-backends" || listClipboardBackends:
+		printClipboardBackends(os.Stdout)
+		return
+	}
+	if clipboardBackendName != "" {
+		if _, err := lookupClipboardBackend(clipboardBackendName); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if browser != "" {
+		if err := validateBrowser(browser); err != nil {
+			log.Fatalf("invalid --browser: %v", err)
+		}
+		if _, err := browserProfileArgs(browser); err != nil {
+			log.Fatalf("invalid --browser-profile: %v", err)
+		}
+	}
+	if smtpHost != "" {
+		if err := validateSMTPConfig(); err != nil {
+			log.Fatalf("invalid SMTP settings: %v", err)
+		}
+		if smtpInsecure {
+			slog.Warn("--smtp-insecure: emails to relays without STARTTLS are sent in the clear, so the prompt and the SMTP password can be read on the network", "host", smtpHost)
+		}
+	}
+
+	if wd, err := os.Getwd(); err == nil {
+		if len(exportRoots) == 0 {
+			exportRoots = []string{wd}
+		}
+		if len(attachmentRoots) == 0 {
+			attachmentRoots, attachmentRootsDefaulted = []string{wd}, true
+		}
+	}
+
+	templatesPath := loadPromptTemplates()
+
+	if !noHistory {
+		openHistory()
+		if historyRetention > 0 {
+			applyRetention()
+		}
+		if path := history.filePath(); path != "" {
+			if err := queue.load(path); err != nil {
+				slog.Warn("failed to load the handoff queue", "error", err)
+			}
+		}
+	}
+
+	switch command {
+	case "send":
+		os.Exit(send(sendPromptArgs))
+	case "history":
+		os.Exit(showHistory())
+	}
+
+	b := buildInfo()
+	slog.Info("starting chatgpt-handoff", "version", b.Version, "commit", b.Commit, "go", b.GoVersion, "logFile", cmp.Or(logFilePath, "none"))
 
 	srv := buildServer()
+	if len(toolAliases) > 0 {
+		if err := applyToolAliases(srv); err != nil {
+			log.Fatalf("invalid --tool-alias: %v", err)
+		}
+	}
+	watchSIGHUP(srv, templatesPath, templatesDir != "")
+	if templatesPath != "" {
+		watchTemplates(srv, templatesPath, templatesDir != "")
+	}
 	ctx := context.Background()
 
 	if httpMode {
@@ -47,129 +366,1133 @@ func main() {
 	}
 }
 
-func parseFlags() {
-	for i := 1; i < len(os.Args); i++ {
-		arg := os.Args[i]
-		switch {
-		case arg == "--http":
-			httpMode = true
-		case arg == "--port" && i+1 < len(os.Args):
-			if p, err := strconv.Atoi(os.Args[i+1]); err == nil {
-				httpPort = p
-			}
-			i++
+// openHistory attaches the history file. An explicit --history-file must
+// work; problems with the default location only cost persistence.
+func openHistory() {
+	if historyFile != "" {
+		if err := history.open(historyFile); err != nil {
+			log.Fatalf("invalid --history-file: %v", err)
+		}
+		return
+	}
+	path, err := defaultHistoryFile()
+	if err == nil {
+		err = history.open(path)
+	}
+	if err != nil {
+		slog.Warn("handoff history will not be persisted", "error", err)
+	}
+}
+
+// loadPromptTemplates loads the templates directory and returns it, or ""
+// when there is none to watch. An explicit --templates-dir must load;
+// problems with the default location leave only the built-in templates.
+func loadPromptTemplates() string {
+	if templatesDir != "" {
+		if err := loadTemplates(templatesDir, true); err != nil {
+			log.Fatalf("invalid --templates-dir: %v", err)
+		}
+		return templatesDir
+	}
+	dir, err := defaultTemplatesDir()
+	if err != nil {
+		slog.Warn("only the built-in prompt templates are available", "error", err)
+		loadTemplates("", false)
+		return ""
+	}
+	if err := loadTemplates(dir, false); err != nil {
+		slog.Warn("only the built-in prompt templates are available", "error", err)
+	}
+	return dir
+}
+
+// applyRetention drops handoffs older than --retention.
+func applyRetention() {
+	cutoff := time.Now().Add(-historyRetention)
+	n, err := history.remove(func(r HandoffRecord) bool { return r.Time.Before(cutoff) })
+	if err != nil {
+		slog.Warn("failed to apply history retention", "error", err)
+	}
+	if n > 0 {
+		slog.Debug("removed expired handoffs", "count", n, "retention", historyRetention)
+	}
+}
+
+func validateDeeplinkLength(n int) error {
+	if n < 0 || n > MAX_DEEPLINK_LENGTH_LIMIT {
+		return fmt.Errorf("deeplink length %d out of range (0 disables, max %d)", n, MAX_DEEPLINK_LENGTH_LIMIT)
+	}
+	return nil
+}
+
+// registeredHandoffTools are the handoff tools currently on the server.
+var registeredHandoffTools []string
+
+// registerHandoffTools (re)registers the handoff tools: the single
+// handoff_to_chatgpt tool with a target argument by default, or one
+// handoff_to_<target> tool per --tools entry with the target pre-bound,
+// plus the research and debugging variants with --tool-variants. The SDK
+// notifies clients of the changed tool list.
+func registerHandoffTools(srv *mcp.Server) {
+	srv.RemoveTools(registeredHandoffTools...)
+	registeredHandoffTools = nil
+
+	tools := settingsFor(context.Background()).HandoffTools
+	if len(tools) == 0 {
+		mcp.AddTool(srv, &mcp.Tool{
+			Name:        "handoff_to_chatgpt",
+			InputSchema: handoffInputSchema(),
+			Description: chatgptToolDescription("Hand off a research or debugging prompt to ChatGPT, powered by the very powerful GPT-5 thinking model with advanced tools like browsing. Write detailed, specific prompts that include all necessary context. After sending your prompt, you should stop and wait for the user to relay ChatGPT's response back to you.\n\nExample uses:\n1. Research: \"Research the latest developments in WebAssembly performance optimizations, focusing on 2024-2025 improvements and real-world benchmarks\"\n2. Debugging: \"Debug this Go memory leak issue: [include relevant code snippets, error messages, and context about when the issue occurs]\"\n\nPass a short title to label the handoff in the history. If the user wants the prompt added to their current ChatGPT chat rather than a new one, pass openChat: false.\n\nTo hand off to a different assistant, set target to one of: " + strings.Join(targetNames(), ", ") + " (default chatgpt).\n\n" + templateHint()),
+		}, handleHandoff)
+		registeredHandoffTools = append(registeredHandoffTools, "handoff_to_chatgpt")
+	}
+
+	for _, name := range tools {
+		t, _ := lookupTarget(name)
+		schema := handoffInputSchema()
+		delete(schema.Properties, "target")
+		tool := "handoff_to_" + t.Name
+		description := t.toolDescription() + " " + templateHint()
+		if t.Name == "chatgpt" {
+			description = chatgptToolDescription(description)
+		}
+		mcp.AddTool(srv, &mcp.Tool{
+			Name:        tool,
+			Description: description,
+			InputSchema: schema,
+		}, func(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[HandoffArgs]) (*mcp.CallToolResultFor[any], error) {
+			params.Arguments.Target = t.Name
+			return handleHandoff(ctx, ss, params)
+		})
+		registeredHandoffTools = append(registeredHandoffTools, tool)
+	}
+
+	if toolVariants {
+		for _, v := range handoffVariants {
+			schema := handoffInputSchema()
+			delete(schema.Properties, "target")
+			mcp.AddTool(srv, &mcp.Tool{
+				Name:        v.Tool,
+				Description: v.Description + "\n\n" + templateHint(),
+				InputSchema: schema,
+			}, func(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[HandoffArgs]) (*mcp.CallToolResultFor[any], error) {
+				params.Arguments.Target = "chatgpt"
+				return handleHandoff(ctx, ss, params)
+			})
+			registeredHandoffTools = append(registeredHandoffTools, v.Tool)
 		}
 	}
 }
 
+// chatgptToolDescription applies --tool-description and
+// --tool-description-append to the built-in handoff_to_chatgpt description.
+func chatgptToolDescription(builtin string) string {
+	s := settingsFor(context.Background())
+	description := cmp.Or(s.ToolDescription, builtin)
+	if s.ToolDescriptionAppend != "" {
+		description += "\n\n" + s.ToolDescriptionAppend
+	}
+	return description
+}
+
+// handoffInputSchema is the schema inferred from HandoffArgs, adjusted
+// where a field accepts more than its Go type suggests.
+func handoffInputSchema() *jsonschema.Schema {
+	schema, err := jsonschema.For[HandoffArgs]()
+	if err != nil {
+		panic(err)
+	}
+	allowBooleanGitDiff(schema)
+	allowStringCode(schema)
+	limit := settingsFor(context.Background()).MaxPromptLength
+	schema.Properties["prompt"].MaxLength = &limit
+	for _, name := range targetNames() {
+		schema.Properties["target"].Enum = append(schema.Properties["target"].Enum, name)
+	}
+	return schema
+}
+
 func buildServer() *mcp.Server {
 	impl := &mcp.Implementation{
 		Name:    "chatgpt-handoff",
-		Version: "0.1.0",
+		Version: buildInfo().Version,
 	}
 
 	srv := mcp.NewServer(impl, nil)
+	srv.AddReceivingMiddleware(logRequests, pinSettings, checkTarget)
+
+	registerHandoffTools(srv)
+	registerTemplatePrompts(srv)
 
-	tool := &mcp.Tool{
-		Name:        "handoff_to_chatgpt",
-		Description: "Hand off a research or debugging prompt to ChatGPT, powered by the very powerful GPT-5 thinking model with advanced tools like browsing. Write detailed, specific prompts that include all necessary context. After sending your prompt, you should stop and wait for the user to relay ChatGPT's response back to you.\n\nExample uses:\n1. Research: \"Research the latest developments in WebAssembly performance optimizations, focusing on 2024-2025 improvements and real-world benchmarks\"\n2. Debugging: \"Debug this Go memory leak issue: [include relevant code snippets, error messages, and context about when the issue occurs]\"",
+	// Opening a blank chat changes nothing but the user's screen.
+	notDestructive := false
+	openChatSchema, err := jsonschema.For[OpenChatArgs]()
+	if err != nil {
+		panic(err)
 	}
+	delete(openChatSchema.Properties, "target")
+	mcp.AddTool(srv, &mcp.Tool{
+		Name:        "open_chatgpt",
+		Description: "Open a new, empty ChatGPT chat without handing anything off, for when the user wants to type the prompt themselves. Reports whether the chat was opened.",
+		InputSchema: openChatSchema,
+		Annotations: &mcp.ToolAnnotations{Title: "Open ChatGPT", DestructiveHint: &notDestructive},
+	}, func(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[OpenChatArgs]) (*mcp.CallToolResultFor[any], error) {
+		params.Arguments.Target = "chatgpt"
+		return handleOpenChat(ctx, ss, params)
+	})
+	mcp.AddTool(srv, &mcp.Tool{
+		Name:        "open_assistant",
+		Description: "Open a new, empty chat with an assistant (" + strings.Join(targetNames(), ", ") + ") without handing anything off. Reports whether the chat was opened.",
+		Annotations: &mcp.ToolAnnotations{Title: "Open an assistant", DestructiveHint: &notDestructive},
+	}, handleOpenChat)
 
-	mcp.AddTool(srv, tool, handleHandoff)
+	mcp.AddTool(srv, &mcp.Tool{
+		Name:        "list_handoffs",
+		Description: "List prompts already handed off in this session (newest first), with their id, time, target and outcome. Use it to check what was sent before handing off again.",
+	}, handleListHandoffs)
+	mcp.AddTool(srv, &mcp.Tool{
+		Name:        "get_handoff",
+		Description: "Get the full prompt and details of a past handoff by id (see list_handoffs), e.g. to re-send it with extra context.",
+	}, handleGetHandoff)
+	mcp.AddTool(srv, &mcp.Tool{
+		Name:        "search_handoffs",
+		Description: "Search past handoff prompts by text or regular expression, optionally filtered by time range and target. Returns matching handoffs newest first with the match highlighted; use get_handoff for the full prompt.",
+	}, handleSearchHandoffs)
+	mcp.AddTool(srv, &mcp.Tool{
+		Name:        "record_response",
+		Description: "Save the reply the user pasted back from ChatGPT with the handoff it answers (default: the most recent one), so get_handoff shows the full question and answer. Recording again adds a revision.",
+	}, handleRecordResponse)
+	mcp.AddTool(srv, &mcp.Tool{
+		Name:        "next_handoff_chunk",
+		Description: "Copy the next part of a chunked handoff (default: the most recent one) to the clipboard and report how many remain. Call it after the user has pasted and sent the previous part.",
+	}, handleNextChunk)
+	mcp.AddTool(srv, &mcp.Tool{
+		Name:        "next_handoff",
+		Description: "Deliver the oldest handoff queued with queue: true, copying it and opening the chat as a normal handoff would. Call it once the user has dealt with the previous one.",
+	}, handleNextHandoff)
+	mcp.AddTool(srv, &mcp.Tool{
+		Name:        "list_handoff_queue",
+		Description: "List the handoffs waiting in the queue, next first.",
+		Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+	}, handleListQueue)
+	if copyToolEnabled {
+		mcp.AddTool(srv, &mcp.Tool{
+			Name:        "copy_to_clipboard",
+			Description: "Put any text on the user's clipboard, e.g. a generated commit message, a command or a URL, without opening anything. Use handoff_to_chatgpt for prompts meant for an assistant.",
+		}, handleCopyToClipboard)
+	}
+	mcp.AddTool(srv, &mcp.Tool{
+		Name:        "check_environment",
+		Description: "Check what handoffs can do on this machine before trying one: the clipboard backend that would be used, whether a browser can be opened, whether the history is saved, and which other deliveries (webhook, slack, email, direct API) are configured. Call it at the start of a session to warn the user about missing tools, e.g. wl-clipboard.",
+		Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+	}, handleCheckEnvironment)
+	mcp.AddTool(srv, &mcp.Tool{
+		Name:        "handoff_stats",
+		Description: "Summarize handoff activity: counts for today, this week and all time, clipboard and deeplink success rates, prompt lengths, targets and clipboard backends.",
+		Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+	}, handleHandoffStats)
+	mcp.AddTool(srv, &mcp.Tool{
+		Name:        "export_handoffs",
+		Description: "Write past handoffs, with any recorded responses, to a Markdown transcript or JSON file, e.g. at the end of a session. Optionally limited to handoffs since a time or to specific ids.",
+	}, handleExportHandoffs)
+	if allowClipboardRead {
+		mcp.AddTool(srv, &mcp.Tool{
+			Name:        "read_clipboard",
+			Description: "Read the text on the user's clipboard. Only call this after the user says they have copied ChatGPT's response; it returns whatever the clipboard holds.",
+			Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+		}, handleReadClipboard)
+		mcp.AddTool(srv, &mcp.Tool{
+			Name:        "wait_for_clipboard_change",
+			Description: "Wait until the user copies new text (ChatGPT's response) and return it. Tell the user to copy the answer when ChatGPT is done, then call this; it polls the clipboard every second and ignores the handed-off prompt and short stray copies.",
+			Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true},
+		}, handleWaitForClipboard)
+	}
+	destructive := true
+	mcp.AddTool(srv, &mcp.Tool{
+		Name:        "delete_handoff",
+		Description: "Permanently delete one or more past handoffs (prompt included) from the history by id.",
+		Annotations: &mcp.ToolAnnotations{DestructiveHint: &destructive, IdempotentHint: true},
+	}, handleDeleteHandoff)
+	mcp.AddTool(srv, &mcp.Tool{
+		Name:        "clear_handoffs",
+		Description: "Permanently delete all past handoffs from the history, and any still waiting in the queue, or only those older than olderThan.",
+		Annotations: &mcp.ToolAnnotations{DestructiveHint: &destructive, IdempotentHint: true},
+	}, handleClearHandoffs)
 
 	return srv
 }
 
-func handleHandoff(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[HandoffArgs]) (*mcp.CallToolResultFor[any], error) {
-	prompt := strings.TrimSpace(params.Arguments.Prompt)
+// assembledPrompt is a handoff's final prompt with what was derived
+// while building it. Queued handoffs keep it instead of their arguments,
+// so the queue file holds the same redacted text the history would.
+type assembledPrompt struct {
+	Prompt     string         `json:"prompt"`
+	PromptFile string         `json:"promptFile,omitempty"`
+	Title      string         `json:"title"`
+	Tags       []string       `json:"tags,omitempty"`
+	FollowUp   string         `json:"followUp,omitempty"`
+	Language   string         `json:"language,omitempty"`
+	Redactions map[string]int `json:"redactions,omitempty"`
+	Warnings   []string       `json:"warnings,omitempty"`
+}
+
+// assembleHandoffPrompt builds the prompt from the handoff's sources:
+// the prompt, messages, file or template, then context, code,
+// attachments, the git diff and the other additions, redacted and
+// checked against the length limit. Errors describe invalid arguments.
+func assembleHandoffPrompt(ctx context.Context, ss *mcp.ServerSession, args HandoffArgs) (*assembledPrompt, error) {
+	sources := 0
+	for _, s := range []string{args.Prompt, args.PromptFile, args.Template} {
+		if strings.TrimSpace(s) != "" {
+			sources++
+		}
+	}
+	if args.Messages != nil {
+		sources++
+	}
+	if sources != 1 {
+		return nil, errors.New("pass exactly one of prompt, messages, promptFile or template")
+	}
+	// Refuse an oversized prompt before doing any work on it; the final
+	// prompt is checked again once everything is added.
+	if err := checkPromptLength(ctx, len(args.Prompt)); err != nil {
+		return nil, err
+	}
+	titleSource := ""
+	if args.Messages != nil {
+		text, err := formatMessages(args.Messages)
+		if err != nil {
+			return nil, err
+		}
+		args.Prompt = text
+		titleSource = messagesTitleSource(args.Messages)
+	}
+	var promptFile string
+	if args.PromptFile != "" {
+		text, path, err := readPromptFile(ctx, ss, args.PromptFile)
+		if err != nil {
+			return nil, err
+		}
+		args.Prompt, promptFile = text, path
+	}
+	if name := args.Template; name != "" {
+		t, err := lookupTemplate(name)
+		if err != nil {
+			return nil, err
+		}
+		rendered, err := t.render(args.Variables)
+		if err != nil {
+			return nil, err
+		}
+		args.Prompt = rendered
+	} else if len(args.Variables) > 0 {
+		return nil, errors.New("variables are only used with template")
+	}
+	prompt := strings.TrimSpace(args.Prompt)
 	if prompt == "" {
-		return &mcp.CallToolResultFor[any]{
-			IsError: true,
-			Content: []mcp.Content{
-				&mcp.TextContent{Text: "prompt is required"},
-			},
-		}, nil
+		return nil, errors.New("the prompt is empty")
+	}
+	if args.Context != "" || args.Code != nil {
+		// The title should come from the question, not the context.
+		titleSource = cmp.Or(titleSource, prompt)
+		assembled, err := assemblePrompt(args.Context, prompt, args.Code)
+		if err != nil {
+			return nil, err
+		}
+		prompt = assembled
+	}
+	if len(args.Attachments) > 0 {
+		attached, err := renderAttachments(ctx, args.Attachments)
+		if err != nil {
+			return nil, err
+		}
+		prompt += attached
+	}
+	var warnings []string
+	if opts := args.IncludeGitDiff; opts != nil && opts.Enabled {
+		// A missing diff shouldn't stop the handoff; the prompt says why
+		// it is absent.
+		dir, err := gitDiffDir(ctx, ss, args.Cwd)
+		diff := ""
+		if err == nil {
+			diff, err = renderGitDiff(ctx, dir, *opts)
+		}
+		if err != nil {
+			warnings = append(warnings, "couldn't include the git diff: "+err.Error())
+			diff = "\n\n(The git diff couldn't be included: " + err.Error() + ")"
+		}
+		prompt += diff
+	} else if args.Cwd != "" {
+		return nil, errors.New("cwd is only used with includeGitDiff")
+	}
+	if args.IncludeSystemInfo {
+		prompt += systemInfo(ctx)
+	}
+	if args.ExpectedFormat != "" {
+		instruction, err := formatInstruction(args.ExpectedFormat)
+		if err != nil {
+			return nil, err
+		}
+		prompt += instruction
 	}
 
-	// Always copy to clipboard as reliable fallback
-	if err := copyToClipboard(prompt); err != nil {
-		return &mcp.CallToolResultFor[any]{
-			IsError: true,
-			Content: []mcp.Content{
-				&mcp.TextContent{Text: "failed to copy prompt to clipboard: " + err.Error()},
-			},
-		}, nil
+	title := strings.Join(strings.Fields(args.Title), " ")
+	if utf8.RuneCountInString(title) > MAX_TITLE_LENGTH {
+		return nil, fmt.Errorf("title is longer than %d characters", MAX_TITLE_LENGTH)
+	}
+	if title == "" {
+		title = deriveTitle(cmp.Or(titleSource, prompt))
+	}
+	var followUp string
+	if parentID := args.ParentID; parentID != 0 {
+		if parentID < 0 {
+			return nil, errors.New("parentId must be positive")
+		}
+		thread, err := threadContext(parentID)
+		if err != nil {
+			return nil, err
+		}
+		followUp = prompt
+		prompt = thread + prompt
+	}
+	if !args.SkipPreamble {
+		prompt = applyPreamble(prompt)
+	}
+	// The language comes last, after any suffix, so it is the final thing
+	// the assistant reads.
+	language := defaultResponseLanguage
+	if args.ResponseLanguage != nil {
+		language = strings.TrimSpace(*args.ResponseLanguage)
+	}
+	if language != "" {
+		normalized, err := normalizeLanguage(language)
+		if err != nil {
+			return nil, err
+		}
+		language = normalized
+		prompt += languageInstruction(language)
+	}
+	wantRedact := redactSecrets
+	if args.Redact != nil {
+		wantRedact = *args.Redact
+	}
+	var redactions map[string]int
+	if wantRedact {
+		redactions = map[string]int{}
+		prompt = redact(prompt, redactions)
+		// The title and follow-up come from the same text and are only
+		// redacted so the history holds no secrets.
+		title = redact(title, map[string]int{})
+		followUp = redact(followUp, map[string]int{})
+		if len(redactions) > 0 {
+			warnings = append(warnings, "redacted secrets ("+describeRedactions(redactions)+"); check the prompt before sending it")
+		} else {
+			redactions = nil
+		}
+	}
+	if err := checkPromptLength(ctx, len(prompt)); err != nil {
+		return nil, err
+	}
+	tags, err := normalizeTags(append(slices.Clone(defaultTags), args.Tags...))
+	if err != nil {
+		return nil, err
+	}
+	return &assembledPrompt{
+		Prompt: prompt, PromptFile: promptFile, Title: title, Tags: tags, FollowUp: followUp,
+		Language: language, Redactions: redactions, Warnings: warnings,
+	}, nil
+
+}
+
+func handleHandoff(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[HandoffArgs]) (*mcp.CallToolResultFor[any], error) {
+	a, err := assembleHandoffPrompt(ctx, ss, params.Arguments)
+	if err != nil {
+		return toolError("invalid params: " + err.Error()), nil
+	}
+	return deliverHandoff(ctx, ss, params, a)
+}
+
+// deliverHandoff checks the delivery arguments and hands off the
+// assembled prompt, or queues it when the call asks for that.
+func deliverHandoff(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[HandoffArgs], a *assembledPrompt) (*mcp.CallToolResultFor[any], error) {
+	prompt, promptFile, title, tags, followUp, language, redactions := a.Prompt, a.PromptFile, a.Title, a.Tags, a.FollowUp, a.Language, a.Redactions
+	warnings := slices.Clone(a.Warnings)
+
+	targetName := params.Arguments.Target
+	if targetName == "" {
+		targetName = DEFAULT_TARGET
+	}
+	target, err := lookupTarget(targetName)
+	if err != nil {
+		return toolError(err.Error()), nil
+	}
+
+	gptID := params.Arguments.GPTID
+	if gptID != "" {
+		if target.Name != "chatgpt" {
+			return toolError("gptId is only supported for the chatgpt target"), nil
+		}
+		if err := validateGPTID(gptID); err != nil {
+			return toolError("invalid params: " + err.Error()), nil
+		}
+	} else if target.Name == "chatgpt" {
+		gptID = defaultGPT
+	}
+
+	priority := cmp.Or(params.Arguments.Priority, defaultPriority)
+	if err := validatePriority(priority); err != nil {
+		return toolError("invalid params: " + err.Error()), nil
+	}
+	behavior := behaviorFor(priority)
+	if params.Arguments.OpenChat != nil {
+		behavior.Open = *params.Arguments.OpenChat
+	}
+	if params.Arguments.DeeplinkPreview != nil {
+		behavior.Preview = *params.Arguments.DeeplinkPreview
+	}
+	if params.Arguments.Notify != nil {
+		behavior.Notify = *params.Arguments.Notify
+	}
+
+	deliver := params.Arguments.Deliver
+	switch deliver {
+	case "":
+		deliver = "open"
+	case "open", "copy-link", "both":
+	case "webhook":
+		if webhookURL == "" {
+			return toolError("invalid params: deliver webhook needs the server's --webhook-url"), nil
+		}
+	case "slack":
+		if slackWebhookURL == "" {
+			return toolError("invalid params: deliver slack needs the server's --slack-webhook-url"), nil
+		}
+	case "email":
+		if smtpHost == "" {
+			return toolError("invalid params: deliver email needs the server's --smtp-host"), nil
+		}
+	default:
+		return toolError(fmt.Sprintf("invalid params: unknown deliver %q (valid: open, copy-link, both, webhook, slack, email)", deliver)), nil
+	}
+	if params.Arguments.Direct {
+		switch {
+		case openaiAPIKey == "":
+			return toolError("invalid params: direct needs OPENAI_API_KEY or the server's --openai-api-key"), nil
+		case target.Name != "chatgpt":
+			return toolError(fmt.Sprintf("invalid params: direct only works with the chatgpt target, not %s", target.Name)), nil
+		}
+	}
+
+	limit := target.maxLength(ctx)
+	if params.Arguments.MaxDeeplinkLength != nil {
+		limit = *params.Arguments.MaxDeeplinkLength
+		if err := validateDeeplinkLength(limit); err != nil {
+			return toolError("invalid maxDeeplinkLength: " + err.Error()), nil
+		}
+	}
+
+	wantChunks := autoChunkAbove > 0 && utf8.RuneCountInString(prompt) > autoChunkAbove
+	if params.Arguments.Chunked != nil {
+		wantChunks = *params.Arguments.Chunked
+	}
+	var chunks []string
+	if wantChunks {
+		if noHistory {
+			return toolError("invalid params: chunked handoffs need the handoff history, which is disabled (--no-history)"), nil
+		}
+		if deliver != "open" {
+			return toolError("invalid params: chunked handoffs only support deliver open"), nil
+		}
+		if chunks = splitPrompt(prompt, chunkSize); len(chunks) < 2 {
+			chunks = nil
+		}
+	}
+
+	clipText := prompt
+	wrap := clipboardWrapper
+	if params.Arguments.Wrap != nil {
+		wrap = *params.Arguments.Wrap
+	}
+	if chunks != nil {
+		// Each part carries its own header, so markers would only add noise.
+		clipText = chunks[0]
+	} else if wrap {
+		clipText = wrapClipboardText(prompt, title, time.Now())
+	}
+	clipText = normalizeLineEndings(clipText, clipboardLineEnding(), preserveCodeLineEndings)
+
+	model := defaultModel
+	if params.Arguments.Model != "" {
+		model = params.Arguments.Model
+	}
+	temporary := temporaryChat
+	if params.Arguments.TemporaryChat != nil {
+		temporary = *params.Arguments.TemporaryChat
+	}
+
+	webSearch := autoWebSearch && looksLikeResearch(prompt)
+	if params.Arguments.WebSearch != nil {
+		webSearch = *params.Arguments.WebSearch
+	}
+
+	var dlParams []deeplinkParam
+	if model != "" {
+		dlParams = append(dlParams, deeplinkParam{Key: "model", Value: model})
+	}
+	if temporary {
+		dlParams = append(dlParams, deeplinkParam{Key: "temporary-chat", Value: "true"})
+	}
+	if webSearch {
+		dlParams = append(dlParams, deeplinkParam{Key: "hints", Value: "search"})
 	}
 
 	// Additionally, try deeplink if prompt is short enough
-	deeplink := buildChatGPTDeeplink(prompt)
-	if len(deeplink) <= MAX_DEEPLINK_LENGTH {
-		_ = openURL(deeplink) // Best effort, ignore errors
+	base := target.BaseURL()
+	if gptID != "" {
+		base = customGPTURL(base, gptID)
+	}
+	dlParams = target.filterParams(dlParams)
+	if !slices.Contains(target.Params, "model") {
+		model = ""
+	}
+	dlPrompt := normalizeLineEndings(prompt, "\n", false)
+	if chunks != nil {
+		dlPrompt = normalizeLineEndings(chunks[0], "\n", false)
+	}
+	dl := fitDeeplink(base, dlPrompt, dlParams, limit)
+	deeplinkExceeded := limit > 0 && !dl.Fits
+
+	// A preview only helps when nothing better handles oversized prompts.
+	preview := false
+	if behavior.Preview && deliver == "open" && !dl.Fits && limit > 0 && !(cdpEndpoint != "" && target.Name == "chatgpt") {
+		for n := PREVIEW_CHARS; n >= MIN_PREVIEW_CHARS; n = n * 3 / 4 {
+			if p := fitDeeplink(base, previewPrompt(dlPrompt, n), dlParams, limit); p.Fits {
+				dl, preview = p, true
+				break
+			}
+		}
+	}
+
+	clipContent := "prompt"
+	if chunks != nil {
+		clipContent = "chunk"
+	}
+	if deliver == "copy-link" {
+		if !dl.Fits {
+			return toolError(fmt.Sprintf("deliver copy-link: a link can't carry the full prompt (the deeplink is %d characters, the limit is %d); use deliver open or both instead", len(dl.URL), limit)), nil
+		}
+		clipText, clipContent = dl.URL, "deeplink"
+	}
+
+	backend := clipboardBackendName
+	if params.Arguments.ClipboardBackend != "" {
+		backend = params.Arguments.ClipboardBackend
+	}
+
+	// Everything is checked by now, so a queued handoff only fails at
+	// delivery for reasons outside the call.
+	if params.Arguments.Queue {
+		return enqueueHandoff(params, a, target), nil
+	}
+
+	// A retrying agent shouldn't open a chat per attempt; the clipboard
+	// is still refreshed.
+	hash := promptHash(target.Name, prompt)
+	var duplicate recentPrompt
+	isDuplicate := false
+	if duplicateWindow > 0 && deliver == "open" && behavior.Open && !params.Arguments.Force {
+		duplicate, isDuplicate = recent.find(hash)
+	}
+
+	if params.Arguments.DryRun {
+		return dryRunResult(ctx, params.Arguments, dryRun{
+			prompt: prompt, promptFile: promptFile, parentID: params.Arguments.ParentID, followUp: followUp, target: target, base: base, gptID: gptID, deliver: deliver, model: model, title: title, tags: tags, tool: params.Name, language: language,
+			limit: limit, dl: dl, preview: preview, clipText: clipText, clipContent: clipContent, chunks: len(chunks),
+			backend: backend, dlParams: dlParams, tooLong: deeplinkExceeded, redactions: redactions,
+			priority: priority, behavior: behavior, duplicate: duplicate, isDuplicate: isDuplicate,
+		}), nil
+	}
+
+	confirmation := ""
+	newRecord := func(backend, status string) HandoffRecord {
+		return HandoffRecord{
+			Confirmation:     confirmation,
+			Prompt:           prompt,
+			PromptLength:     len(prompt),
+			PromptFile:       promptFile,
+			ParentID:         params.Arguments.ParentID,
+			FollowUp:         followUp,
+			ExpectedFormat:   strings.TrimSpace(params.Arguments.ExpectedFormat),
+			ResponseLanguage: language,
+			Title:            title,
+			Tags:             tags,
+			Tool:             params.Name,
+			Kind:             handoffKind(params.Name),
+			Target:           target.Name,
+			ClipboardBackend: backend,
+			DeeplinkStatus:   status,
+		}
+	}
+
+	if confirmHandoffs {
+		confirmation, err = confirmHandoff(ctx, target.Label, confirmText(title, target.Label, prompt))
+		if err != nil {
+			return toolError("couldn't ask the user to confirm the handoff (--confirm): " + err.Error()), nil
+		}
+		if confirmation != CONFIRM_ACCEPTED {
+			if !noHistory {
+				history.add(newRecord("", confirmation))
+			}
+			if confirmation == CONFIRM_TIMED_OUT {
+				return toolError(fmt.Sprintf("the user didn't confirm the handoff within %s, so nothing was copied or opened", confirmTimeout)), nil
+			}
+			return toolError("the user declined the handoff, so nothing was copied or opened; ask them what to change before trying again"), nil
+		}
+	}
+
+	if params.Arguments.Direct {
+		answer, err := askOpenAI(ctx, cmp.Or(model, openaiModel), prompt)
+		if err == nil {
+			counters.remoteHandoff("openai-api", "direct")
+			rec := newRecord("", "direct")
+			rec.Time = time.Now()
+			rec.Responses = []HandoffResponse{{Time: time.Now(), Text: answer.Response}}
+			if !noHistory {
+				rec = history.add(rec)
+				answer.HandoffID = rec.ID
+			}
+			if w := transcribe(ctx, ss, transcriptHandoff(rec, target.Label), transcriptResponse(rec.ID, rec.Title, 1, rec.Responses[0])); w != "" {
+				warnings = append(warnings, w)
+			}
+			return directResult(answer, warnings), nil
+		}
+		slog.Warn("direct OpenAI request failed", "error", err)
+		warnings = append(warnings, "the OpenAI API request failed, so the prompt was handed off manually instead: "+err.Error())
+	}
+
+	// Always copy to clipboard as reliable fallback
+	var cb ClipboardCopy
+	var verified *bool
+	if !remoteDelivery(deliver) {
+		cb, err = copyToClipboard(clipText, backend)
+		if err != nil {
+			counters.clipboardFailure()
+			playSound(true)
+			return toolError("failed to copy " + clipContent + " to clipboard: " + err.Error()), nil
+		}
+		slog.Debug("copied to clipboard", "content", clipContent, logBackend(cb.Backend), "detail", cb.Detail, logPrompt(prompt))
+		rememberCopied(clipText)
+		if behavior.Sound {
+			playSound(false)
+		}
+		verified = verifyCopy(cb, clipText)
+	}
+
+	webhook := ""
+	if deliver == "webhook" || (webhookAlways && webhookURL != "") {
+		payload := WebhookPayload{Prompt: prompt, Title: title, Target: target.Name, Tags: tags, Priority: priority, Timestamp: time.Now()}
+		if dl.Fits && !preview {
+			payload.Deeplink = dl.URL
+		}
+		webhook = "posted"
+		if err := postWebhook(ctx, payload); err != nil {
+			if deliver == "webhook" {
+				return toolError("webhook delivery failed: " + err.Error()), nil
+			}
+			webhook = "failed"
+			warnings = append(warnings, "webhook delivery failed: "+err.Error())
+		}
+	}
+	slack := ""
+	if deliver == "slack" {
+		deeplink := ""
+		if dl.Fits && !preview {
+			deeplink = dl.URL
+		}
+		truncated, err := postSlack(ctx, title, prompt, deeplink, target.Label)
+		if err != nil {
+			return toolError("slack delivery failed: " + err.Error()), nil
+		}
+		slack = "posted"
+		if truncated {
+			slack = "truncated"
+			warnings = append(warnings, "the prompt is over Slack's message size limit, so the Slack message was truncated")
+		}
+	}
+	var emailedTo []string
+	if deliver == "email" {
+		deeplink := ""
+		if dl.Fits && !preview {
+			deeplink = dl.URL
+		}
+		richText := params.Arguments.RichText != nil && *params.Arguments.RichText
+		msg, err := buildEmail(title, prompt, deeplink, target.Label, richText)
+		if err == nil {
+			err = sendEmail(ctx, msg)
+		}
+		if err != nil {
+			return toolError("email delivery failed: " + err.Error()), nil
+		}
+		emailedTo = smtpTo
+	}
+	fallback := ""
+	if cb.Backend == fileBackend.Name {
+		fallback = cb.Detail
+	}
+
+	applied := func(key string) bool {
+		return slices.ContainsFunc(dlParams, func(p deeplinkParam) bool { return p.Key == key }) &&
+			!slices.Contains(dl.Dropped, key)
+	}
+	openReq := openRequest{Desktop: params.Arguments.DesktopApp, Incognito: incognito}
+	if !target.DesktopApp {
+		openReq.Desktop = new(bool)
+	}
+	if params.Arguments.Incognito != nil {
+		openReq.Incognito = *params.Arguments.Incognito
+	}
+	var opened openResult
+	var relay Relay
+	var deeplinkErr string
+	var status, skipReason string
+	headless := ""
+	if !forceDeeplink {
+		headless = headlessReason()
+	}
+	openChat := behavior.Open
+
+	var submission string
+	wantSubmit := autoSubmitPrompt
+	if params.Arguments.AutoSubmit != nil {
+		wantSubmit = *params.Arguments.AutoSubmit
+	}
+	// Only deliver open puts the prompt itself on the clipboard for a chat
+	// being opened: copy-link copies the URL, both leaves the chat closed,
+	// and the remote deliveries copy nothing.
+	if wantSubmit && deliver == "open" && !isDuplicate {
+		submission = "manual-paste"
+		switch {
+		case target.Name != "chatgpt":
+			warnings = append(warnings, "auto-submit only supports the ChatGPT desktop app; paste the prompt manually")
+		case !openChat:
+			warnings = append(warnings, "auto-submit skipped because openChat is false; paste the prompt manually")
+		case verified != nil && !*verified:
+			// Pasting now would submit whatever the clipboard holds.
+			warnings = append(warnings, "auto-submit skipped because reading the clipboard back gave different text; check it before pasting")
+		default:
+			if err := autoSubmit(); err != nil {
+				warnings = append(warnings, "auto-submit failed, paste the prompt manually: "+err.Error())
+			} else {
+				submission = "submitted"
+			}
+		}
+	}
+
+	switch {
+	case isDuplicate:
+		status = "skipped-duplicate"
+		skipReason = fmt.Sprintf("an identical prompt was already handed off %d seconds ago", int(recent.age(duplicate).Seconds()))
+		if duplicate.id != 0 {
+			skipReason += fmt.Sprintf(" (id %d)", duplicate.id)
+		}
+		slog.Debug("skipping deeplink", "reason", "duplicate", "id", duplicate.id)
+	case submission == "submitted":
+		status = "skipped-auto-submit"
+	case !openChat, deliver != "open":
+		status = "skipped-by-request"
+		skipReason = "deliver " + deliver
+		switch {
+		case params.Arguments.OpenChat != nil && !openChat:
+			skipReason = "openChat is false"
+		case !openChat:
+			skipReason = "priority " + priority + " only copies the prompt"
+		}
+	case limit == 0:
+		status = "skipped-disabled"
+		skipReason = "deeplinks are disabled (max deeplink length 0)"
+	case !dl.Fits:
+		status = "skipped-too-long"
+		skipReason = fmt.Sprintf("the deeplink would be %d characters, over the %d limit", len(dl.URL), limit)
+		slog.Debug("skipping deeplink", "reason", "too long", "length", len(dl.URL), "limit", limit)
+		if cdpEndpoint != "" && target.Name == "chatgpt" {
+			err := fillViaCDP(ctx, cdpEndpoint, base, dlPrompt)
+			if err == nil {
+				status, skipReason = "filled-cdp", ""
+				opened = openResult{Target: "cdp"}
+				break
+			}
+			slog.Debug("filling prompt via DevTools failed", "error", err)
+			warnings = append(warnings, "could not fill the prompt into Chrome via DevTools ("+err.Error()+"); paste it from the clipboard")
+		}
+		if !relayEnabled {
+			break
+		}
+		relay, err = newRelay(dlPrompt, target.Label, base)
+		if err != nil {
+			warnings = append(warnings, "relay page unavailable: "+err.Error())
+			break
+		}
+		if headless != "" {
+			break
+		}
+		relayOpened, err := openDeeplink(relay.URL, openRequest{Desktop: new(bool), Incognito: openReq.Incognito})
+		if err != nil {
+			warnings = append(warnings, "failed to open relay page: "+err.Error())
+			break
+		}
+		opened = openResult{Target: "relay", Incognito: relayOpened.Incognito, Note: relayOpened.Note}
+		if opened.Note != "" {
+			warnings = append(warnings, opened.Note)
+		}
+	case headless != "":
+		status = "skipped-headless"
+		skipReason = headless
+		slog.Debug("skipping deeplink", "reason", headless)
+	case verified != nil && !*verified:
+		// A chat opened now would be pasted into with whatever the
+		// clipboard holds instead.
+		status = "skipped-unverified"
+		skipReason = "reading the clipboard back gave different text"
+		slog.Debug("skipping deeplink", "reason", "clipboard not verified", logBackend(cb.Backend))
+	case reuseTab && focusExistingChat(urlHost(base), target.Label):
+		status = "focused-existing"
+		opened = openResult{Target: "existing-tab"}
+	default:
+		// Give slow clipboard tools time to own the selection before the
+		// browser takes focus.
+		if deeplinkDelay > 0 {
+			slog.Debug("waiting before opening deeplink", "delay", deeplinkDelay)
+			if err := sleepContext(ctx, deeplinkDelay); err != nil {
+				// The prompt is on the clipboard already, so the handoff
+				// still counts.
+				status = "skipped-cancelled"
+				skipReason = "the request was cancelled before the chat was opened"
+				slog.Debug("skipping deeplink", "reason", "cancelled", "error", err)
+				break
+			}
+		}
+		status = "opened"
+		opened, err = openDeeplink(dl.URL, openReq)
+		if err != nil {
+			// The prompt is already on the clipboard, so this is not fatal.
+			status = "failed"
+			deeplinkErr = err.Error()
+			slog.Warn("failed to open deeplink", "error", err)
+		} else if preview {
+			status = "preview"
+		}
+		if opened.Note != "" {
+			warnings = append(warnings, opened.Note)
+		}
+	}
+
+	text := deliveryText(deliveryOutcome{
+		label: target.Label, host: urlHost(base), deliver: deliver, clipContent: clipContent,
+		backend: cb.Backend, fallbackFile: fallback, verified: verified,
+		status: status, deeplinkErr: deeplinkErr, skipReason: skipReason,
+		openedBy: opened.Target, relayURL: relay.URL, chunks: len(chunks), webhook: webhook,
+		slack: slack, emailedTo: strings.Join(emailedTo, ", "),
+	})
+	tokens := estimateTokens(prompt)
+	if w := promptSizeWarning(ctx, tokens); w != "" {
+		text = w + "\n\n" + text
+	}
+	content := []mcp.Content{&mcp.TextContent{Text: text}}
+	link := dl.URL
+	if relay.URL != "" && opened.Target == "" {
+		content = append(content, &mcp.TextContent{Text: "Open this page to copy the prompt: " + relay.URL})
+	}
+	if omitDeeplink {
+		link = ""
+		if deliver == "both" {
+			warnings = append(warnings, "the deeplink is not shown because of --omit-deeplink-from-result")
+		}
+	} else if deliver == "both" {
+		content = append(content, &mcp.TextContent{Text: "Deeplink: " + link})
+		if !dl.Fits {
+			warnings = append(warnings, fmt.Sprintf("the deeplink is %d characters, over the %d limit; some clients may reject it", len(dl.URL), limit))
+		}
+	} else if deliver == "open" && opened.Target == "" && openChat && relay.URL == "" && submission != "submitted" && !isDuplicate {
+		content = append(content, &mcp.TextContent{Text: "Open this link manually: " + link})
+	}
+
+	wantQR := qrCode
+	if params.Arguments.QR != nil {
+		wantQR = *params.Arguments.QR
+	}
+	var qrURL string
+	if wantQR {
+		var qrItems []mcp.Content
+		qrLink := dl.URL
+		if len(qrLink) > MAX_QR_PAYLOAD && relay.URL != "" && opened.Target == "" {
+			qrLink = relay.URL
+		}
+		qrItems, qrURL, err = qrContent(qrLink)
+		if err != nil {
+			warnings = append(warnings, "QR code unavailable: "+err.Error())
+		}
+		content = append(content, qrItems...)
+	}
+
+	if behavior.Notify {
+		if err := sendNotification(ctx, "Handed off to "+target.Label, notificationBody(title, target.Label, status)); err != nil {
+			slog.Debug("desktop notification failed", "error", err)
+			warnings = append(warnings, "desktop notification failed: "+err.Error())
+		}
+	}
+
+	if remoteDelivery(deliver) {
+		counters.remoteHandoff(deliver, status)
+	} else {
+		counters.handoff(cb.Backend, status)
+	}
+	rec := newRecord(cb.Backend, status)
+	rec.Time = time.Now()
+	if !noHistory {
+		if chunks != nil {
+			rec.ChunkCount, rec.ChunksCopied, rec.ChunkSize = len(chunks), 1, chunkSize
+		}
+		rec = history.add(rec)
+	}
+	if deliver == "open" && openChat && !isDuplicate {
+		recent.remember(hash, rec.ID)
+	}
+	if w := transcribe(ctx, ss, transcriptHandoff(rec, target.Label)); w != "" {
+		warnings = append(warnings, w)
+	}
+
+	for _, w := range warnings {
+		content = append(content, &mcp.TextContent{Text: "Note: " + w})
 	}
 
 	return &mcp.CallToolResultFor[any]{
-		Content: []mcp.Content{
-			&mcp.TextContent{Text: "Request sent. Now you should stop and wait for the user to share ChatGPT's response."},
+		Content: content,
+		StructuredContent: &HandoffResult{
+			ClipboardBackend:  cb.Backend,
+			ClipboardDetail:   cb.Detail,
+			ClipboardContent:  clipContent,
+			ClipboardVerified: verified,
+			FallbackFile:      fallback,
+			Webhook:           webhook,
+			Slack:             slack,
+			EmailedTo:         emailedTo,
+			DeeplinkStatus:    status,
+			DeeplinkError:     deeplinkErr,
+			DeeplinkReason:    skipReason,
+			DuplicateOf:       duplicate.id,
+			Deeplink:          link,
+			DeeplinkLength:    len(dl.URL),
+			MaxDeeplinkLength: limit,
+			DroppedParams:     dl.Dropped,
+			DeeplinkExceeded:  deeplinkExceeded,
+			Model:             model,
+			TemporaryChat:     applied("temporary-chat"),
+			WebSearch:         applied("hints"),
+			OpenedTarget:      opened.Target,
+			Incognito:         opened.Incognito,
+			Target:            target.Name,
+			Host:              urlHost(base),
+			GPTID:             gptID,
+			Title:             title,
+			Tags:              tags,
+			Confirmation:      confirmation,
+			Priority:          priority,
+			PriorityBehavior:  behavior,
+			Submission:        submission,
+			RelayURL:          relay.URL,
+			RelayExpires:      relayExpires(relay),
+			QRURL:             qrURL,
+			Warnings:          warnings,
+			Redactions:        redactions,
+			PromptLength:      len(prompt),
+			CharCount:         utf8.RuneCountInString(prompt),
+			EstimatedTokens:   tokens,
+			Chunks:            len(chunks),
 		},
 	}, nil
 }
 
-func copyToClipboard(s string) error {
-	switch runtime.GOOS {
-	case "darwin":
-		cmd := exec.Command("pbcopy")
-		in, _ := cmd.StdinPipe()
-		if err := cmd.Start(); err != nil {
-			return err
-		}
-		_, _ = io.WriteString(in, s)
-		_ = in.Close()
-		return cmd.Wait()
-	case "windows":
-		cmd := exec.Command("powershell", "-NoProfile", "-Command", "Set-Clipboard -Value @'\n"+s+"\n'@")
-		return cmd.Run()
-	default:
-		// Linux: try xclip first, then xsel
-		if err := exec.Command("bash", "-c", "command -v xclip >/dev/null").Run(); err == nil {
-			c := exec.Command("xclip", "-selection", "clipboard")
-			in, _ := c.StdinPipe()
-			_ = c.Start()
-			_, _ = io.WriteString(in, s)
-			_ = in.Close()
-			return c.Wait()
-		}
-		if err := exec.Command("bash", "-c", "command -v xsel >/dev/null").Run(); err == nil {
-			c := exec.Command("xsel", "--clipboard", "--input")
-			in, _ := c.StdinPipe()
-			_ = c.Start()
-			_, _ = io.WriteString(in, s)
-			_ = in.Close()
-			return c.Wait()
-		}
-		return errors.New("no clipboard utility found (install xclip or xsel)")
-	}
-}
-
-func buildChatGPTDeeplink(prompt string) string {
-	encoded := url.QueryEscape(prompt)
-	return "https://chatgpt.com/?q=" + encoded
-}
-
-func openURL(urlStr string) error {
-	switch runtime.GOOS {
-	case "darwin":
-		return exec.Command("open", urlStr).Run()
-	case "windows":
-		return exec.Command("rundll32", "url.dll,FileProtocolHandler", urlStr).Run()
-	default:
-		// Linux - try common browsers
-		browsers := []string{"xdg-open", "sensible-browser", "x-www-browser", "firefox", "chromium", "google-chrome"}
-		for _, browser := range browsers {
-			if err := exec.Command("which", browser).Run(); err == nil {
-				return exec.Command(browser, urlStr).Run()
+// qrContent renders link as a QR code image plus a text fallback. In HTTP
+// mode the PNG is also published under /qr/ and its URL returned.
+func qrContent(link string) ([]mcp.Content, string, error) {
+	if len(link) > MAX_QR_PAYLOAD {
+		return nil, "", fmt.Errorf("the deeplink is %d bytes but a QR code holds at most %d; paste the copied prompt on your phone instead", len(link), MAX_QR_PAYLOAD)
+	}
+	q, err := encodeQR([]byte(link))
+	if err != nil {
+		return nil, "", err
+	}
+	img, err := q.PNG()
+	if err != nil {
+		return nil, "", err
+	}
+	content := []mcp.Content{
+		&mcp.ImageContent{Data: img, MIMEType: "image/png"},
+		&mcp.TextContent{Text: "Scan to open on your phone:\n" + q.ASCII()},
+	}
+	var qrURL string
+	if httpMode {
+		qrURL = fmt.Sprintf("http://localhost:%d/qr/%s.png", httpPort, storeQRImage(img))
+		content = append(content, &mcp.TextContent{Text: "QR code image: " + qrURL})
+	}
+	return content, qrURL, nil
+}
+
+func relayExpires(r Relay) *time.Time {
+	if r.URL == "" {
+		return nil
+	}
+	return &r.Expires
+}
+
+func urlHost(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// CODE_INVALID_PARAMS is the JSON-RPC error code for invalid params.
+const CODE_INVALID_PARAMS = -32602
+
+// invalidParams returns a JSON-RPC invalid params error. The SDK keeps its
+// error type internal, so this takes the one ResourceNotFoundError builds
+// and replaces its code and message.
+func invalidParams(msg string) error {
+	err := mcp.ResourceNotFoundError("")
+	v := reflect.ValueOf(err).Elem()
+	v.FieldByName("Code").SetInt(CODE_INVALID_PARAMS)
+	v.FieldByName("Message").SetString(msg)
+	v.FieldByName("Data").SetBytes(nil)
+	return err
+}
+
+// checkTarget rejects a handoff_to_chatgpt call naming an unknown target
+// as invalid params, ahead of the schema validation, whose error would
+// quote the whole schema.
+func checkTarget(next mcp.MethodHandler[*mcp.ServerSession]) mcp.MethodHandler[*mcp.ServerSession] {
+	return func(ctx context.Context, ss *mcp.ServerSession, method string, params mcp.Params) (mcp.Result, error) {
+		if p, ok := params.(*mcp.CallToolParamsFor[json.RawMessage]); ok && p.Name == "handoff_to_chatgpt" {
+			var args struct {
+				Target string `json:"target"`
+			}
+			if json.Unmarshal(p.Arguments, &args) == nil && args.Target != "" {
+				if _, err := lookupTarget(args.Target); err != nil {
+					return nil, invalidParams(err.Error())
+				}
 			}
 		}
-		return errors.New("no suitable browser found")
+		return next(ctx, ss, method, params)
+	}
+}
+
+func toolError(text string) *mcp.CallToolResultFor[any] {
+	return &mcp.CallToolResultFor[any]{
+		IsError: true,
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: text},
+		},
 	}
 }
 
@@ -180,10 +1503,37 @@ func startHTTPServer(srv *mcp.Server) {
 	mux.Handle("/mcp/", handler)
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
+		w.Write([]byte("OK\nversion " + buildInfo().Version))
+		for _, p := range checkEnvironment().problems() {
+			w.Write([]byte("\n" + p))
+		}
+	})
+
+	registerRelayHandler(mux)
+	registerMetricsHandler(mux)
+	mux.HandleFunc("/qr/", func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/qr/"), ".png")
+		img, found := loadQRImage(token)
+		if !ok || !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(img)
 	})
 
-	addr := ":" + strconv.Itoa(httpPort)
-	log.Printf("Starting MCP server on %s", addr)
-	log.Fatal(http.ListenAndServe(addr, mux))
+	requested := httpPort
+	l, err := listenHTTP()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if httpPort != requested && requested != 0 {
+		slog.Warn("port in use, using the next free one", "requested", requested, "port", httpPort)
+	}
+	log.Printf("Starting MCP server on :%d", httpPort)
+	if daemonChild {
+		announceDaemon()
+	}
+	log.Fatal(http.Serve(l, mux))
 }
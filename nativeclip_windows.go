@@ -0,0 +1,86 @@
+//go:build nativeclip && windows
+
+package main
+
+import (
+	"errors"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	cfUnicodeText = 13
+	gmemMoveable  = 0x0002
+)
+
+var (
+	user32   = syscall.NewLazyDLL("user32.dll")
+	kernel32 = syscall.NewLazyDLL("kernel32.dll")
+
+	procOpenClipboard    = user32.NewProc("OpenClipboard")
+	procCloseClipboard   = user32.NewProc("CloseClipboard")
+	procEmptyClipboard   = user32.NewProc("EmptyClipboard")
+	procSetClipboardData = user32.NewProc("SetClipboardData")
+	procGlobalAlloc      = kernel32.NewProc("GlobalAlloc")
+	procGlobalFree       = kernel32.NewProc("GlobalFree")
+	procGlobalLock       = kernel32.NewProc("GlobalLock")
+	procGlobalUnlock     = kernel32.NewProc("GlobalUnlock")
+	procRtlMoveMemory    = kernel32.NewProc("RtlMoveMemory")
+)
+
+func init() {
+	nativeBackends = append(nativeBackends, clipboardBackend{
+		Name:      "win32",
+		Available: func() bool { return user32.Load() == nil },
+		Copy: func(s string) (string, error) {
+			return "CF_UNICODETEXT (native)", copyWin32(s)
+		},
+	})
+}
+
+func copyWin32(s string) error {
+	text, err := syscall.UTF16FromString(s)
+	if err != nil {
+		return err
+	}
+
+	// Another process may hold the clipboard open briefly; retry for a
+	// short while before giving up.
+	var opened bool
+	for range 10 {
+		if r, _, _ := procOpenClipboard.Call(0); r != 0 {
+			opened = true
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !opened {
+		return errors.New("clipboard is locked by another application")
+	}
+	defer procCloseClipboard.Call()
+
+	if r, _, err := procEmptyClipboard.Call(); r == 0 {
+		return err
+	}
+
+	size := uintptr(len(text)) * unsafe.Sizeof(text[0])
+	mem, _, err := procGlobalAlloc.Call(gmemMoveable, size)
+	if mem == 0 {
+		return err
+	}
+	ptr, _, err := procGlobalLock.Call(mem)
+	if ptr == 0 {
+		procGlobalFree.Call(mem)
+		return err
+	}
+	procRtlMoveMemory.Call(ptr, uintptr(unsafe.Pointer(&text[0])), size)
+	procGlobalUnlock.Call(mem)
+
+	// On success the system owns mem; only free it if the handoff failed.
+	if r, _, err := procSetClipboardData.Call(cfUnicodeText, mem); r == 0 {
+		procGlobalFree.Call(mem)
+		return err
+	}
+	return nil
+}
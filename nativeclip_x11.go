@@ -0,0 +1,401 @@
+//go:build nativeclip && !windows && !darwin
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// This file implements just enough of the X11 wire protocol to own the
+// CLIPBOARD selection and serve UTF-8 text to other clients, without cgo or
+// external tools. The connection stays open in a goroutine until another
+// client takes ownership.
+
+func init() {
+	nativeBackends = append(nativeBackends, clipboardBackend{
+		Name:      "x11",
+		Available: func() bool { return os.Getenv("DISPLAY") != "" },
+		Copy: func(s string) (string, error) {
+			return "selection CLIPBOARD (native)", copyX11(s)
+		},
+	})
+}
+
+const (
+	x11OpCreateWindow      = 1
+	x11OpInternAtom        = 16
+	x11OpChangeProperty    = 18
+	x11OpSetSelectionOwner = 22
+	x11OpGetSelectionOwner = 23
+	x11OpSendEvent         = 25
+
+	x11EventSelectionClear   = 29
+	x11EventSelectionRequest = 30
+	x11EventSelectionNotify  = 31
+
+	x11AtomAtom   = 4
+	x11AtomString = 31
+)
+
+// The setup request asks for little-endian, so all traffic uses it.
+var x11Order = binary.LittleEndian
+
+type x11Conn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	root   uint32
+	ridMin uint32
+	maxReq int // maximum request length in bytes
+}
+
+type x11Atoms struct {
+	clipboard, targets, utf8String, text uint32
+}
+
+func copyX11(s string) error {
+	c, err := dialX11(os.Getenv("DISPLAY"))
+	if err != nil {
+		return err
+	}
+	// ChangeProperty carries a 24-byte header before the data.
+	if len(s)+24 > c.maxReq {
+		c.conn.Close()
+		return fmt.Errorf("prompt exceeds the X server's %d-byte request limit", c.maxReq)
+	}
+
+	atoms, err := c.internAtoms()
+	if err != nil {
+		c.conn.Close()
+		return err
+	}
+
+	win := c.ridMin
+	if err := c.createWindow(win); err != nil {
+		c.conn.Close()
+		return err
+	}
+	if err := c.setSelectionOwner(win, atoms.clipboard); err != nil {
+		c.conn.Close()
+		return err
+	}
+	owner, err := c.getSelectionOwner(atoms.clipboard)
+	if err != nil {
+		c.conn.Close()
+		return err
+	}
+	if owner != win {
+		c.conn.Close()
+		return errors.New("X server did not grant CLIPBOARD ownership")
+	}
+
+	go c.serve(atoms, []byte(s))
+	return nil
+}
+
+// serve answers SelectionRequest events until ownership is lost.
+func (c *x11Conn) serve(atoms x11Atoms, data []byte) {
+	defer c.conn.Close()
+	for {
+		ev, err := c.readPacket()
+		if err != nil {
+			slog.Debug("x11 clipboard connection closed", "error", err)
+			return
+		}
+		switch ev[0] & 0x7f {
+		case x11EventSelectionClear:
+			return
+		case x11EventSelectionRequest:
+			requestor := x11Order.Uint32(ev[12:])
+			selection := x11Order.Uint32(ev[16:])
+			target := x11Order.Uint32(ev[20:])
+			property := x11Order.Uint32(ev[24:])
+			if property == 0 {
+				property = target
+			}
+
+			switch target {
+			case atoms.targets:
+				var list []byte
+				for _, a := range []uint32{atoms.targets, atoms.utf8String, x11AtomString, atoms.text} {
+					list = x11Order.AppendUint32(list, a)
+				}
+				err = c.changeProperty(requestor, property, x11AtomAtom, 32, list)
+			case atoms.utf8String, atoms.text, x11AtomString:
+				err = c.changeProperty(requestor, property, target, 8, data)
+			default:
+				property = 0
+			}
+			if err == nil {
+				err = c.sendSelectionNotify(requestor, x11Order.Uint32(ev[4:]), selection, target, property)
+			}
+			if err != nil {
+				slog.Debug("x11 clipboard request failed", "error", err)
+				return
+			}
+		}
+	}
+}
+
+func dialX11(display string) (*x11Conn, error) {
+	if display == "" {
+		return nil, errors.New("DISPLAY is not set")
+	}
+	host, rest, ok := strings.Cut(display, ":")
+	if !ok {
+		return nil, fmt.Errorf("malformed DISPLAY %q", display)
+	}
+	number, _, _ := strings.Cut(rest, ".")
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return nil, fmt.Errorf("malformed DISPLAY %q", display)
+	}
+
+	var conn net.Conn
+	if host == "" || host == "unix" {
+		conn, err = net.Dial("unix", "/tmp/.X11-unix/X"+number)
+	} else {
+		conn, err = net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(6000+n)))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connecting to X server: %w", err)
+	}
+
+	c := &x11Conn{conn: conn, r: bufio.NewReader(conn)}
+	if err := c.handshake(host, number); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *x11Conn) handshake(host, number string) error {
+	authName, authData := readXauthority(host, number)
+
+	req := []byte{'l', 0}
+	req = x11Order.AppendUint16(req, 11)
+	req = x11Order.AppendUint16(req, 0)
+	req = x11Order.AppendUint16(req, uint16(len(authName)))
+	req = x11Order.AppendUint16(req, uint16(len(authData)))
+	req = append(req, 0, 0)
+	req = append(req, x11Pad([]byte(authName))...)
+	req = append(req, x11Pad(authData)...)
+	if _, err := c.conn.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(c.r, head); err != nil {
+		return fmt.Errorf("reading X setup reply: %w", err)
+	}
+	body := make([]byte, int(x11Order.Uint16(head[6:]))*4)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return fmt.Errorf("reading X setup reply: %w", err)
+	}
+	if head[0] != 1 {
+		reason := body
+		if int(head[1]) <= len(reason) {
+			reason = reason[:head[1]]
+		}
+		return fmt.Errorf("X server refused connection: %s", strings.TrimSpace(string(reason)))
+	}
+	if len(body) < 40 {
+		return errors.New("short X setup reply")
+	}
+
+	c.ridMin = x11Order.Uint32(body[4:])
+	c.maxReq = int(x11Order.Uint16(body[18:])) * 4
+	vendorLen := int(x11Order.Uint16(body[16:]))
+	numFormats := int(body[21])
+	screens := 32 + (vendorLen+3)&^3 + numFormats*8
+	if len(body) < screens+4 {
+		return errors.New("short X setup reply")
+	}
+	c.root = x11Order.Uint32(body[screens:])
+	return nil
+}
+
+// readXauthority returns the MIT-MAGIC-COOKIE-1 entry for the display, or
+// empty values when no cookie is found (servers without access control).
+func readXauthority(host, number string) (string, []byte) {
+	path := os.Getenv("XAUTHORITY")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", nil
+		}
+		path = filepath.Join(home, ".Xauthority")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil
+	}
+	if host == "" || host == "unix" {
+		host, _ = os.Hostname()
+	}
+
+	be := binary.BigEndian
+	field := func() ([]byte, bool) {
+		if len(data) < 2 {
+			return nil, false
+		}
+		n := int(be.Uint16(data))
+		if len(data) < 2+n {
+			return nil, false
+		}
+		f := data[2 : 2+n]
+		data = data[2+n:]
+		return f, true
+	}
+	for len(data) >= 2 {
+		family := be.Uint16(data)
+		data = data[2:]
+		addr, ok1 := field()
+		num, ok2 := field()
+		name, ok3 := field()
+		cookie, ok4 := field()
+		if !ok1 || !ok2 || !ok3 || !ok4 {
+			break
+		}
+		// 256 is FamilyLocal, 65535 matches any address.
+		if family != 65535 && (family != 256 || string(addr) != host) {
+			continue
+		}
+		if len(num) > 0 && string(num) != number {
+			continue
+		}
+		if string(name) == "MIT-MAGIC-COOKIE-1" {
+			return string(name), cookie
+		}
+	}
+	return "", nil
+}
+
+func (c *x11Conn) internAtoms() (x11Atoms, error) {
+	var atoms x11Atoms
+	for _, a := range []struct {
+		name string
+		dst  *uint32
+	}{
+		{"CLIPBOARD", &atoms.clipboard},
+		{"TARGETS", &atoms.targets},
+		{"UTF8_STRING", &atoms.utf8String},
+		{"TEXT", &atoms.text},
+	} {
+		req := x11Order.AppendUint16(nil, uint16(len(a.name)))
+		req = append(req, 0, 0)
+		req = append(req, x11Pad([]byte(a.name))...)
+		reply, err := c.roundTrip(x11OpInternAtom, 0, req)
+		if err != nil {
+			return atoms, fmt.Errorf("interning %s: %w", a.name, err)
+		}
+		*a.dst = x11Order.Uint32(reply[8:])
+	}
+	return atoms, nil
+}
+
+func (c *x11Conn) createWindow(win uint32) error {
+	req := x11Order.AppendUint32(nil, win)
+	req = x11Order.AppendUint32(req, c.root)
+	req = append(req, make([]byte, 4)...)  // x, y
+	req = x11Order.AppendUint16(req, 1)    // width
+	req = x11Order.AppendUint16(req, 1)    // height
+	req = append(req, make([]byte, 12)...) // border, class, visual, value-mask
+	return c.send(x11OpCreateWindow, 0, req)
+}
+
+func (c *x11Conn) setSelectionOwner(win, selection uint32) error {
+	req := x11Order.AppendUint32(nil, win)
+	req = x11Order.AppendUint32(req, selection)
+	req = x11Order.AppendUint32(req, 0) // CurrentTime
+	return c.send(x11OpSetSelectionOwner, 0, req)
+}
+
+func (c *x11Conn) getSelectionOwner(selection uint32) (uint32, error) {
+	reply, err := c.roundTrip(x11OpGetSelectionOwner, 0, x11Order.AppendUint32(nil, selection))
+	if err != nil {
+		return 0, err
+	}
+	return x11Order.Uint32(reply[8:]), nil
+}
+
+func (c *x11Conn) changeProperty(win, property, typ uint32, format byte, data []byte) error {
+	req := x11Order.AppendUint32(nil, win)
+	req = x11Order.AppendUint32(req, property)
+	req = x11Order.AppendUint32(req, typ)
+	req = append(req, format, 0, 0, 0)
+	req = x11Order.AppendUint32(req, uint32(len(data)/int(format/8)))
+	req = append(req, x11Pad(data)...)
+	return c.send(x11OpChangeProperty, 0, req)
+}
+
+func (c *x11Conn) sendSelectionNotify(requestor, time, selection, target, property uint32) error {
+	ev := []byte{x11EventSelectionNotify, 0, 0, 0}
+	ev = x11Order.AppendUint32(ev, time)
+	ev = x11Order.AppendUint32(ev, requestor)
+	ev = x11Order.AppendUint32(ev, selection)
+	ev = x11Order.AppendUint32(ev, target)
+	ev = x11Order.AppendUint32(ev, property)
+	ev = append(ev, make([]byte, 32-len(ev))...)
+
+	req := x11Order.AppendUint32(nil, requestor)
+	req = x11Order.AppendUint32(req, 0) // event mask
+	req = append(req, ev...)
+	return c.send(x11OpSendEvent, 0, req)
+}
+
+func (c *x11Conn) send(opcode, detail byte, body []byte) error {
+	req := []byte{opcode, detail}
+	req = x11Order.AppendUint16(req, uint16((4+len(body))/4))
+	_, err := c.conn.Write(append(req, body...))
+	return err
+}
+
+// roundTrip sends a request and waits for its reply. It is only used during
+// setup, before we own the selection, so no events are expected.
+func (c *x11Conn) roundTrip(opcode, detail byte, body []byte) ([]byte, error) {
+	if err := c.send(opcode, detail, body); err != nil {
+		return nil, err
+	}
+	for {
+		p, err := c.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		switch p[0] {
+		case 0:
+			return nil, fmt.Errorf("X error code %d", p[1])
+		case 1:
+			return p, nil
+		}
+	}
+}
+
+// readPacket reads one reply, error, or event from the server.
+func (c *x11Conn) readPacket() ([]byte, error) {
+	p := make([]byte, 32)
+	if _, err := io.ReadFull(c.r, p); err != nil {
+		return nil, err
+	}
+	if p[0] == 1 {
+		extra := make([]byte, int(x11Order.Uint32(p[4:]))*4)
+		if _, err := io.ReadFull(c.r, extra); err != nil {
+			return nil, err
+		}
+		p = append(p, extra...)
+	}
+	return p, nil
+}
+
+func x11Pad(b []byte) []byte {
+	return append(b, make([]byte, (4-len(b)%4)%4)...)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// NOTIFY_BODY_LENGTH caps the notification body, in characters.
+const NOTIFY_BODY_LENGTH = 120
+
+// notifyEnabled fires a desktop notification after each handoff
+// (--notify); the notify argument overrides it per call.
+var notifyEnabled = false
+
+// notificationBody summarizes a handoff for the notification: its title
+// and whether a chat was opened. Secrets are redacted even when
+// --redact-secrets is off, since notifications linger on screen and in
+// the notification center.
+func notificationBody(title, label, status string) string {
+	outcome := "copied, paste it into " + label
+	switch status {
+	case "opened", "preview":
+		outcome = label + " opened"
+	case "focused-existing":
+		outcome = label + " tab focused"
+	case "filled-cdp":
+		outcome = "filled into " + label + " in Chrome"
+	case "skipped-auto-submit":
+		outcome = "submitted to " + label
+	}
+	body := redact(title, map[string]int{}) + " — " + outcome
+	return clipLine(body, NOTIFY_BODY_LENGTH)
+}
+
+// sendNotification shows a desktop notification: osascript on macOS,
+// notify-send on Linux, and a PowerShell toast on Windows. The command
+// gets a short time limit so it never holds up the handoff reply.
+func sendNotification(ctx context.Context, title, body string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		// Passing the text as arguments avoids quoting it into the script.
+		cmd = exec.Command("osascript",
+			"-e", "on run argv",
+			"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+			"-e", "end run",
+			title, body)
+	case "windows":
+		cmd = exec.Command("powershell", "-NoProfile", "-Command",
+			"[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null; "+
+				"$t = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02); "+
+				"$x = $t.GetElementsByTagName('text'); "+
+				"$x.Item(0).AppendChild($t.CreateTextNode("+powershellQuote(title)+")) > $null; "+
+				"$x.Item(1).AppendChild($t.CreateTextNode("+powershellQuote(body)+")) > $null; "+
+				"[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('chatgpt-handoff').Show([Windows.UI.Notifications.ToastNotification]::new($t))")
+	default:
+		if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			return errors.New("no DISPLAY or WAYLAND_DISPLAY set")
+		}
+		if _, err := exec.LookPath("notify-send"); err != nil {
+			return errors.New("notify-send is not installed (install libnotify)")
+		}
+		cmd = exec.Command("notify-send", "--app-name=chatgpt-handoff", "--", title, body)
+	}
+
+	return runCommand(ctx, EXEC_SCALE_LAUNCH, cmd)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+const (
+	DEFAULT_OPENAI_BASE_URL = "https://api.openai.com/v1"
+	DEFAULT_OPENAI_MODEL    = "gpt-4o"
+	DEFAULT_OPENAI_TIMEOUT  = 2 * time.Minute
+
+	// OPENAI_ATTEMPTS is how often a rate limited (429) or failed (5xx)
+	// request is tried; the wait between attempts follows Retry-After,
+	// capped at OPENAI_MAX_RETRY_DELAY.
+	OPENAI_ATTEMPTS        = 3
+	OPENAI_MAX_RETRY_DELAY = 20 * time.Second
+)
+
+var (
+	// openaiAPIKey enables direct: true; it defaults to $OPENAI_API_KEY
+	// (--openai-api-key).
+	openaiAPIKey  = ""
+	openaiBaseURL = DEFAULT_OPENAI_BASE_URL
+	openaiModel   = DEFAULT_OPENAI_MODEL
+	openaiTimeout = DEFAULT_OPENAI_TIMEOUT
+)
+
+// OpenAIUsage is the token usage the API reports for a direct handoff.
+type OpenAIUsage struct {
+	PromptTokens     int `json:"promptTokens"`
+	CompletionTokens int `json:"completionTokens"`
+	TotalTokens      int `json:"totalTokens"`
+}
+
+// DirectResult is the structured content of a direct handoff.
+type DirectResult struct {
+	// HandoffID is the history entry holding the prompt and the answer;
+	// it is 0 with --no-history.
+	HandoffID int         `json:"handoffId,omitempty"`
+	Model     string      `json:"model"`
+	Response  string      `json:"response"`
+	Usage     OpenAIUsage `json:"usage"`
+	Elapsed   string      `json:"elapsed"`
+}
+
+type chatCompletionRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatCompletionResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message      chatMessage `json:"message"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+		TotalTokens      int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// askOpenAI sends prompt to the Chat Completions API and waits for the
+// whole answer, within --openai-timeout.
+func askOpenAI(ctx context.Context, model, prompt string) (DirectResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, openaiTimeout)
+	defer cancel()
+	start := time.Now()
+
+	body, err := json.Marshal(chatCompletionRequest{
+		Model:    model,
+		Messages: []chatMessage{{Role: "user", Content: prompt}},
+	})
+	if err != nil {
+		return DirectResult{}, err
+	}
+	data, err := postOpenAI(ctx, strings.TrimRight(openaiBaseURL, "/")+"/chat/completions", body)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return DirectResult{}, fmt.Errorf("no answer within %s (--openai-timeout)", openaiTimeout)
+		}
+		return DirectResult{}, err
+	}
+
+	var resp chatCompletionResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return DirectResult{}, fmt.Errorf("unreadable API response: %v", err)
+	}
+	if len(resp.Choices) == 0 || strings.TrimSpace(resp.Choices[0].Message.Content) == "" {
+		return DirectResult{}, errors.New("the API returned an empty answer")
+	}
+	return DirectResult{
+		Model:    cmp.Or(resp.Model, model),
+		Response: resp.Choices[0].Message.Content,
+		Usage: OpenAIUsage{
+			PromptTokens:     resp.Usage.PromptTokens,
+			CompletionTokens: resp.Usage.CompletionTokens,
+			TotalTokens:      resp.Usage.TotalTokens,
+		},
+		Elapsed: time.Since(start).Round(time.Millisecond).String(),
+	}, nil
+}
+
+// postOpenAI POSTs body, retrying 429 and 5xx responses.
+func postOpenAI(ctx context.Context, rawURL string, body []byte) ([]byte, error) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Authorization", "Bearer "+openaiAPIKey)
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var data []byte
+		var wait time.Duration
+		data, wait, err = postOpenAIOnce(ctx, rawURL, header, body)
+		if err == nil || wait == 0 || attempt == OPENAI_ATTEMPTS {
+			return data, err
+		}
+		slog.Debug("retrying OpenAI request", "attempt", attempt, "wait", wait, "error", err)
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// postOpenAIOnce makes one request. A non-zero wait means the request may
+// be retried after that long.
+func postOpenAIOnce(ctx context.Context, rawURL string, header http.Header, body []byte) ([]byte, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header = header.Clone()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+		return nil, time.Second, err
+	}
+	defer resp.Body.Close()
+	var data []byte
+	if data, err = io.ReadAll(io.LimitReader(resp.Body, 16<<20)); err != nil {
+		return nil, time.Second, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return data, 0, nil
+	}
+
+	// The API explains errors as {"error": {"message": ...}}.
+	var apiErr struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	msg := strings.TrimSpace(string(data))
+	if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+		msg = apiErr.Error.Message
+	}
+	err = fmt.Errorf("OpenAI API responded %s: %s", resp.Status, clipLine(msg, WEBHOOK_ERROR_BODY))
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return nil, 0, err
+	}
+	wait := time.Second
+	if s, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil && s > 0 {
+		wait = min(time.Duration(s)*time.Second, OPENAI_MAX_RETRY_DELAY)
+	}
+	return nil, wait, err
+}
+
+// directResult returns the API's answer as the tool result.
+func directResult(answer DirectResult, warnings []string) *mcp.CallToolResultFor[any] {
+	summary := fmt.Sprintf("Answered by %s through the OpenAI API in %s (%d tokens).", answer.Model, answer.Elapsed, answer.Usage.TotalTokens)
+	if answer.HandoffID != 0 {
+		summary += fmt.Sprintf(" The answer is recorded as the response to handoff %d.", answer.HandoffID)
+	}
+	content := []mcp.Content{
+		&mcp.TextContent{Text: answer.Response},
+		&mcp.TextContent{Text: summary},
+	}
+	for _, w := range warnings {
+		content = append(content, &mcp.TextContent{Text: "Note: " + w})
+	}
+	return &mcp.CallToolResultFor[any]{Content: content, StructuredContent: &answer}
+}
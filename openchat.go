@@ -0,0 +1,98 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type OpenChatArgs struct {
+	Target        string `json:"target,omitempty" jsonschema:"assistant to open: chatgpt (default), claude, gemini, perplexity, or grok"`
+	Model         string `json:"model,omitempty" jsonschema:"ChatGPT model slug to open the chat with, e.g. gpt-4o or o3"`
+	TemporaryChat *bool  `json:"temporaryChat,omitempty" jsonschema:"open the chat in temporary mode so it is not saved to ChatGPT history"`
+}
+
+// OpenChatResult is the structured content returned by open_chatgpt and
+// open_assistant.
+type OpenChatResult struct {
+	Target string `json:"target"`
+	URL    string `json:"url"`
+	Opened bool   `json:"opened"`
+	// OpenedBy is "desktop-app" or "browser" when the chat was opened.
+	OpenedBy string `json:"openedBy,omitempty"`
+	// Reason says why the chat wasn't opened.
+	Reason string `json:"reason,omitempty"`
+}
+
+// blankChatURL is the URL of a new, empty chat on base with params.
+func blankChatURL(base string, params []deeplinkParam) string {
+	var b strings.Builder
+	b.WriteString(base)
+	for i, p := range params {
+		if i == 0 && !strings.Contains(base, "?") {
+			b.WriteString("?")
+		} else {
+			b.WriteString("&")
+		}
+		b.WriteString(escapeQueryComponent(p.Key))
+		b.WriteString("=")
+		b.WriteString(escapeQueryComponent(p.Value))
+	}
+	return b.String()
+}
+
+// handleOpenChat opens a blank chat with no prompt, for when the user
+// wants to type it themselves.
+func handleOpenChat(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[OpenChatArgs]) (*mcp.CallToolResultFor[any], error) {
+	target, err := lookupTarget(cmp.Or(params.Arguments.Target, DEFAULT_TARGET))
+	if err != nil {
+		return toolError(err.Error()), nil
+	}
+
+	model := defaultModel
+	if params.Arguments.Model != "" {
+		model = params.Arguments.Model
+	}
+	temporary := temporaryChat
+	if params.Arguments.TemporaryChat != nil {
+		temporary = *params.Arguments.TemporaryChat
+	}
+	var dlParams []deeplinkParam
+	if model != "" {
+		dlParams = append(dlParams, deeplinkParam{Key: "model", Value: model})
+	}
+	if temporary {
+		dlParams = append(dlParams, deeplinkParam{Key: "temporary-chat", Value: "true"})
+	}
+
+	result := &OpenChatResult{Target: target.Name, URL: blankChatURL(target.BaseURL(), target.filterParams(dlParams))}
+	if !forceDeeplink {
+		result.Reason = headlessReason()
+	}
+	if result.Reason == "" {
+		req := openRequest{Incognito: incognito}
+		if !target.DesktopApp {
+			req.Desktop = new(bool)
+		}
+		opened, err := openDeeplink(result.URL, req)
+		if err != nil {
+			result.Reason = err.Error()
+		} else {
+			result.Opened, result.OpenedBy = true, opened.Target
+		}
+	}
+
+	text := "Opened a new " + target.Label + " chat in the browser."
+	switch {
+	case result.OpenedBy == "desktop-app":
+		text = "Opened a new " + target.Label + " chat in the desktop app."
+	case !result.Opened:
+		text = "Couldn't open " + target.Label + ": " + result.Reason + ". The user can open " + result.URL + " themselves."
+	}
+	return &mcp.CallToolResultFor[any]{
+		Content:           []mcp.Content{&mcp.TextContent{Text: text}},
+		StructuredContent: result,
+	}, nil
+}
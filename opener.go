@@ -0,0 +1,584 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_MAC_APP = "ChatGPT"
+
+	// MAC_APP_LAUNCH_TIMEOUT bounds how long a cold start of the macOS app
+	// may take before the URL is sent anyway.
+	MAC_APP_LAUNCH_TIMEOUT = 5 * time.Second
+
+	// OPENER_FAILURE_WINDOW is how long a Linux opener is watched after it
+	// starts; exiting non-zero within it counts as a failure, while one
+	// still running (a browser in the foreground) counts as success.
+	OPENER_FAILURE_WINDOW = 1500 * time.Millisecond
+)
+
+// headlessReason explains why there is no local display to open a browser
+// on, or returns "" when a graphical session is available.
+func headlessReason() string {
+	ssh := os.Getenv("SSH_CONNECTION") != "" || os.Getenv("SSH_TTY") != ""
+	switch runtime.GOOS {
+	case "windows":
+		return ""
+	case "darwin":
+		if ssh {
+			return "SSH session without a local display"
+		}
+		return ""
+	default:
+		if os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != "" {
+			return ""
+		}
+		if ssh {
+			return "SSH session without X11 forwarding"
+		}
+		return "no DISPLAY or WAYLAND_DISPLAY set"
+	}
+}
+
+// launchDetached starts a long-lived process (a browser) detached from the
+// server and reaps it in the background. A successful start counts as
+// success; the browser's eventual exit status is irrelevant.
+func launchDetached(cmd *exec.Cmd) error {
+	detach(cmd)
+	slog.Debug("starting command", "command", cmd.Args)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// runDetached runs a short-lived launcher (open, rundll32, ...) detached
+// from the server and waits for it, because its exit status tells us
+// whether the hand-off to the real application worked.
+func runDetached(cmd *exec.Cmd) error {
+	detach(cmd)
+	return runCommand(context.Background(), EXEC_SCALE_LAUNCH, cmd)
+}
+
+// sleepContext waits for d, returning early with ctx's error when the
+// request is cancelled.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// openRequest carries the per-call preferences for opening a deeplink.
+type openRequest struct {
+	Desktop   *bool
+	Incognito bool
+}
+
+// openResult reports how a deeplink was opened.
+type openResult struct {
+	Target    string // "desktop-app" or "browser"
+	Incognito bool
+	Note      string
+}
+
+// openDeeplink opens the web deeplink and reports which target handled it.
+// When the desktop app is wanted (--prefer-desktop-app, --target-app, or the
+// per-call desktop override) it is tried first, falling back to the browser.
+// Private windows always use a browser.
+func openDeeplink(webURL string, req openRequest) (openResult, error) {
+	if req.Incognito {
+		res, err := openPrivateWindow(webURL)
+		if err == nil {
+			return res, nil
+		}
+		slog.Debug("private window unavailable, opening normally", "error", err)
+		if err := openURL(webURL); err != nil {
+			return openResult{}, err
+		}
+		return openResult{Target: "browser", Note: err.Error() + "; opened in a normal window instead"}, nil
+	}
+
+	useApp := preferDesktopApp || targetApp != ""
+	if req.Desktop != nil {
+		useApp = *req.Desktop
+	}
+	if useApp {
+		err := openInDesktopApp(webURL)
+		if err == nil {
+			return openResult{Target: "desktop-app"}, nil
+		}
+		slog.Debug("desktop app unavailable, using browser", "error", err)
+	}
+	if err := openURL(webURL); err != nil {
+		return openResult{}, err
+	}
+	return openResult{Target: "browser"}, nil
+}
+
+// knownBrowsers lists browsers probed, in order of preference, when a
+// feature needs a concrete browser (private windows, profiles) and
+// --browser is not set.
+func knownBrowsers() []string {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"Google Chrome", "Firefox", "Microsoft Edge", "Brave Browser", "Chromium"}
+	case "windows":
+		return []string{"chrome", "msedge", "firefox", "brave"}
+	default:
+		return []string{"google-chrome", "chromium", "chromium-browser", "firefox", "microsoft-edge", "brave-browser"}
+	}
+}
+
+// privateWindowFlag returns the command-line switch that opens a private
+// window in the named browser, or "" if it is not a browser we know.
+func privateWindowFlag(name string) string {
+	base := strings.ToLower(filepath.Base(name))
+	switch {
+	case strings.Contains(base, "firefox"):
+		return "-private-window"
+	case strings.Contains(base, "edge"):
+		return "--inprivate"
+	case strings.Contains(base, "chrome"), strings.Contains(base, "chromium"), strings.Contains(base, "brave"):
+		return "--incognito"
+	default:
+		return ""
+	}
+}
+
+// concreteBrowser returns --browser, or the first installed known browser.
+func concreteBrowser() string {
+	if browser != "" {
+		return browser
+	}
+	for _, candidate := range knownBrowsers() {
+		if validateBrowser(candidate) == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// browserProfileArgs returns the switches selecting --browser-profile in
+// the named browser, or nil when no profile is configured. Chromium-based
+// browsers take a profile directory ("Profile 2") or an account email.
+func browserProfileArgs(name string) ([]string, error) {
+	if browserProfile == "" {
+		return nil, nil
+	}
+	base := strings.ToLower(filepath.Base(name))
+	switch {
+	case strings.Contains(base, "firefox"):
+		return []string{"-P", browserProfile}, nil
+	case strings.Contains(base, "chrome"), strings.Contains(base, "chromium"),
+		strings.Contains(base, "edge"), strings.Contains(base, "brave"):
+		if strings.Contains(browserProfile, "@") {
+			return []string{"--profile-email=" + browserProfile}, nil
+		}
+		return []string{"--profile-directory=" + browserProfile}, nil
+	default:
+		return nil, fmt.Errorf("don't know how to select a profile in %q (supported: Chromium-based browsers and Firefox)", name)
+	}
+}
+
+// launchBrowser starts the browser binary itself with args. Unlike the
+// generic openers this lets us pass switches, at the cost of bypassing the
+// user's default-browser choice.
+func launchBrowser(name string, args ...string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return runDetached(exec.Command("open", append([]string{"-na", name, "--args"}, args...)...))
+	case "windows":
+		return startProcessWindows(name, args...)
+	default:
+		return launchDetached(exec.Command(name, args...))
+	}
+}
+
+// openPrivateWindow launches a concrete browser (--browser, or the first
+// detected one) with its private-window switch. Generic openers such as
+// xdg-open cannot do this, so an error means the caller should fall back.
+func openPrivateWindow(webURL string) (openResult, error) {
+	name := concreteBrowser()
+	if name == "" {
+		return openResult{}, errors.New("no browser with private-window support found")
+	}
+	flag := privateWindowFlag(name)
+	if flag == "" {
+		return openResult{}, fmt.Errorf("don't know how to open a private window in %q", name)
+	}
+	if err := validateBrowser(name); err != nil {
+		return openResult{}, err
+	}
+	args, err := browserProfileArgs(name)
+	if err != nil {
+		return openResult{}, err
+	}
+
+	if err := launchBrowser(name, append(args, flag, webURL)...); err != nil {
+		return openResult{}, err
+	}
+	return openResult{Target: "browser", Incognito: true}, nil
+}
+
+// openWithProfile opens urlStr in --browser-profile. Profiles can only be
+// chosen when launching the browser binary directly, so there is no
+// fallback to the generic openers.
+func openWithProfile(urlStr string) error {
+	name := concreteBrowser()
+	if name == "" {
+		return errors.New("--browser-profile needs --browser or an installed Chrome, Edge, Brave, Chromium or Firefox; generic openers can't select a profile")
+	}
+	args, err := browserProfileArgs(name)
+	if err != nil {
+		return err
+	}
+	return launchBrowser(name, append(args, urlStr)...)
+}
+
+// openInDesktopApp prefers an installed macOS app bundle via open -a, then
+// the chatgpt:// scheme on macOS and Windows.
+func openInDesktopApp(webURL string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		app := targetApp
+		if app == "" {
+			app = DEFAULT_MAC_APP
+		}
+		if macAppInstalled(app) {
+			return openWithMacApp(app, webURL)
+		}
+		return openDesktopApp(desktopAppURL(webURL))
+	case "windows":
+		return openDesktopApp(desktopAppURL(webURL))
+	default:
+		return errors.New("desktop app is not supported on " + runtime.GOOS)
+	}
+}
+
+func macAppInstalled(app string) bool {
+	dirs := []string{"/Applications"}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, "Applications"))
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, app+".app")); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// openWithMacApp hands the URL to a specific app with open -a. If the app is
+// not running yet, a URL sent during launch can be dropped, so the app is
+// started first and the URL is sent once its process is up.
+func openWithMacApp(app, webURL string) error {
+	running := func() bool {
+		return runCommand(context.Background(), EXEC_SCALE_LOOKUP, exec.Command("pgrep", "-x", app)) == nil
+	}
+	if !running() {
+		if err := runDetached(exec.Command("open", "-a", app)); err != nil {
+			return err
+		}
+		deadline := time.Now().Add(MAC_APP_LAUNCH_TIMEOUT)
+		for !running() && time.Now().Before(deadline) {
+			time.Sleep(200 * time.Millisecond)
+		}
+		// Give the app a moment to register its URL handlers.
+		time.Sleep(500 * time.Millisecond)
+	}
+	return runDetached(exec.Command("open", "-a", app, webURL))
+}
+
+// desktopAppURL carries the web deeplink's query over to the chatgpt://
+// scheme understood by the desktop app.
+func desktopAppURL(webURL string) string {
+	u, err := url.Parse(webURL)
+	if err != nil {
+		return "chatgpt://"
+	}
+	return "chatgpt://?" + u.RawQuery
+}
+
+func openDesktopApp(appURL string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		// open exits non-zero when no application handles the scheme.
+		return runDetached(exec.Command("open", "-g", appURL))
+	case "windows":
+		// url.dll succeeds even for unknown schemes, so check the
+		// registration first.
+		if err := runCommand(context.Background(), EXEC_SCALE_LOOKUP, exec.Command("reg", "query", `HKCR\chatgpt`)); err != nil {
+			return errors.New("chatgpt:// scheme is not registered")
+		}
+		return runDetached(exec.Command("rundll32", "url.dll,FileProtocolHandler", appURL))
+	default:
+		return errors.New("desktop app is not supported on " + runtime.GOOS)
+	}
+}
+
+// validateBrowser checks that a --browser value refers to something we can
+// launch: an app bundle on macOS, an executable (or registered App Path on
+// Windows) elsewhere.
+func validateBrowser(name string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		if macAppInstalled(name) {
+			return nil
+		}
+		if _, err := os.Stat(name); err == nil {
+			return nil
+		}
+		return fmt.Errorf("no application named %q in /Applications or ~/Applications", name)
+	case "windows":
+		if _, err := exec.LookPath(name); err == nil {
+			return nil
+		}
+		exe := strings.TrimSuffix(name, ".exe") + ".exe"
+		key := `HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\App Paths\` + exe
+		if runCommand(context.Background(), EXEC_SCALE_LOOKUP, exec.Command("reg", "query", key)) == nil {
+			return nil
+		}
+		return fmt.Errorf("browser %q not found on PATH or in App Paths", name)
+	default:
+		if _, err := exec.LookPath(name); err != nil {
+			return fmt.Errorf("browser %q not found: %w", name, err)
+		}
+		return nil
+	}
+}
+
+// openInBrowser launches the configured --browser with the URL.
+func openInBrowser(name, urlStr string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return runDetached(exec.Command("open", "-a", name, urlStr))
+	case "windows":
+		return startProcessWindows(name, urlStr)
+	default:
+		return launchDetached(exec.Command(name, urlStr))
+	}
+}
+
+// startProcessWindows runs PowerShell's Start-Process, which resolves App
+// Paths like cmd's start but without cmd's parsing of & and ^ in URLs.
+func startProcessWindows(name string, args ...string) error {
+	var quoted []string
+	for _, a := range args {
+		quoted = append(quoted, powershellQuote(a))
+	}
+	return runDetached(exec.Command("powershell", "-NoProfile", "-Command",
+		"Start-Process -FilePath "+powershellQuote(name)+" -ArgumentList "+strings.Join(quoted, ",")))
+}
+
+func openURL(urlStr string) error {
+	if browserProfile != "" {
+		return openWithProfile(urlStr)
+	}
+	if browser != "" {
+		err := validateBrowser(browser)
+		if err == nil {
+			return openInBrowser(browser, urlStr)
+		}
+		slog.Warn("configured browser unavailable, using the default opener", "browser", browser, "error", err)
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return runDetached(exec.Command("open", urlStr))
+	case "windows":
+		return openURLWindows(urlStr)
+	default:
+		return openURLLinux(urlStr)
+	}
+}
+
+// inSandbox reports whether we run inside Flatpak or another container,
+// where browsers on PATH may be unusable but the desktop portal works.
+func inSandbox() bool {
+	if os.Getenv("FLATPAK_ID") != "" || os.Getenv("container") != "" {
+		return true
+	}
+	_, err := os.Stat("/.flatpak-info")
+	return err == nil
+}
+
+// linuxOpener is one entry of the Linux opener chain. Args is the full
+// command line, with "{url}" standing for the URL, since some openers take
+// a subcommand or options around it.
+type linuxOpener struct {
+	Args []string
+	// Sandboxed entries are only tried inside Flatpak or a container.
+	Sandboxed bool
+}
+
+// linuxOpeners is tried in order: the XDG desktop portal (and the host's
+// xdg-open) when sandboxed, then the desktop-specific openers, which honour
+// the user's default browser best, then xdg-open, and only then browser
+// binaries directly, since snap-confined browsers often refuse URLs from a
+// non-graphical parent that an opener would have handled.
+var linuxOpeners = []linuxOpener{
+	{Args: []string{"gdbus", "call", "--session",
+		"--dest", "org.freedesktop.portal.Desktop",
+		"--object-path", "/org/freedesktop/portal/desktop",
+		"--method", "org.freedesktop.portal.OpenURI.OpenURI",
+		"", "{url}", "{}"}, Sandboxed: true},
+	{Args: []string{"flatpak-spawn", "--host", "xdg-open", "{url}"}, Sandboxed: true},
+	{Args: []string{"gio", "open", "{url}"}},
+	{Args: []string{"kde-open", "{url}"}},
+	{Args: []string{"kde-open5", "{url}"}},
+	{Args: []string{"kioclient", "exec", "{url}"}},
+	{Args: []string{"kioclient5", "exec", "{url}"}},
+	{Args: []string{"exo-open", "--launch", "WebBrowser", "{url}"}},
+	{Args: []string{"handlr", "open", "{url}"}},
+	{Args: []string{"xdg-open", "{url}"}},
+	{Args: []string{"sensible-browser", "{url}"}},
+	{Args: []string{"x-www-browser", "{url}"}},
+	{Args: []string{"firefox", "{url}"}},
+	{Args: []string{"chromium", "{url}"}},
+	{Args: []string{"google-chrome", "{url}"}},
+}
+
+// errOpenerMissing tells runOpenerChain to skip an opener silently.
+var errOpenerMissing = errors.New("not installed")
+
+// openerRunner runs one opener command line.
+type openerRunner func(args []string) error
+
+// runLinuxOpener is the real openerRunner.
+func runLinuxOpener(args []string) error {
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return errOpenerMissing
+	}
+	return launchWatched(exec.Command(args[0], args[1:]...))
+}
+
+func openURLLinux(urlStr string) error {
+	return runOpenerChain(linuxOpeners, urlStr, inSandbox(), runLinuxOpener)
+}
+
+// runOpenerChain tries each applicable opener until one succeeds, and
+// reports every failure otherwise.
+func runOpenerChain(chain []linuxOpener, urlStr string, sandboxed bool, run openerRunner) error {
+	var tried, failures []string
+	for _, o := range chain {
+		if o.Sandboxed && !sandboxed {
+			continue
+		}
+		args := make([]string, len(o.Args))
+		for i, a := range o.Args {
+			if a == "{url}" {
+				a = urlStr
+			}
+			args[i] = a
+		}
+		err := run(args)
+		if errors.Is(err, errOpenerMissing) {
+			continue
+		}
+		tried = append(tried, args[0])
+		if err != nil {
+			failures = append(failures, args[0]+": "+err.Error())
+			continue
+		}
+		slog.Debug("opened URL", "opener", args[0], "tried", tried)
+		return nil
+	}
+	slog.Debug("no opener succeeded", "tried", tried, "failures", failures)
+	if len(failures) == 0 {
+		return errors.New("no suitable browser found")
+	}
+	return errors.New(strings.Join(failures, "; "))
+}
+
+// launchWatched starts cmd detached and waits up to OPENER_FAILURE_WINDOW
+// for it to fail, folding its stderr into the error so failures are
+// diagnosable. A process still running after that is left to run.
+func launchWatched(cmd *exec.Cmd) error {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	detach(cmd)
+	slog.Debug("starting command", "command", cmd.Args)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil && stderr.Len() > 0 {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return err
+	case <-time.After(OPENER_FAILURE_WINDOW):
+		return nil
+	}
+}
+
+// openURLWindows tries rundll32 first, then cmd's start, then PowerShell,
+// since hardened images often block one or two of them via AppLocker.
+func openURLWindows(urlStr string) error {
+	attempts := []struct {
+		name string
+		cmd  *exec.Cmd
+	}{
+		{"rundll32", exec.Command("rundll32", "url.dll,FileProtocolHandler", urlStr)},
+		{"cmd start", cmdStartCommand(urlStr)},
+		{"powershell", exec.Command("powershell", "-NoProfile", "-Command", "Start-Process "+powershellQuote(urlStr))},
+	}
+	var failures []string
+	for _, a := range attempts {
+		err := runDetached(a.cmd)
+		if err == nil {
+			return nil
+		}
+		failures = append(failures, a.name+": "+err.Error())
+	}
+	return errors.New(strings.Join(failures, "; "))
+}
+
+// cmdSpecialChars are the characters cmdStartLine escapes with ^: cmd's
+// operators and delimiters, quotes, and the % and ! of variable expansion.
+const cmdSpecialChars = "^&|<>()%!\" \t,;="
+
+// cmdStartLine is the verbatim command line for cmd's start builtin. The
+// empty "" is the window title; without it start would treat the URL as
+// the title. The URL isn't quoted: quotes would stop cmd splitting on &,
+// but it expands %NAME% pairs inside them as well, and dynamic variables
+// such as %CD%, %DATE%, %TIME% and %RANDOM% are always defined, so a
+// percent-encoded URL containing %CD% would be mangled. Instead every
+// special character gets a ^. Each % then starts a name ending in ^, which
+// is never defined and so left alone, and cmd drops the carets before
+// start sees the URL.
+func cmdStartLine(urlStr string) string {
+	var b strings.Builder
+	b.WriteString(`cmd /c start "" `)
+	for _, r := range urlStr {
+		if strings.ContainsRune(cmdSpecialChars, r) {
+			b.WriteByte('^')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// powershellQuote wraps s in a single-quoted PowerShell string literal.
+func powershellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
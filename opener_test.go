@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// cmdParse mimics how cmd /c reads a command line: %NAME% pairs naming a
+// variable in vars are expanded (undefined names are left as they are),
+// then carets outside quotes escape the character after them.
+func cmdParse(line string, vars map[string]string) string {
+	var expanded strings.Builder
+	for {
+		i := strings.IndexByte(line, '%')
+		if i < 0 {
+			expanded.WriteString(line)
+			break
+		}
+		expanded.WriteString(line[:i])
+		line = line[i+1:]
+		if j := strings.IndexByte(line, '%'); j >= 0 {
+			if v, ok := vars[strings.ToUpper(line[:j])]; ok {
+				expanded.WriteString(v)
+				line = line[j+1:]
+				continue
+			}
+		}
+		expanded.WriteByte('%')
+	}
+
+	var b strings.Builder
+	quoted, escaped := false, false
+	for _, r := range expanded.String() {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '"':
+			quoted = !quoted
+		case r == '^' && !quoted:
+			escaped = true
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func TestCmdStartLine(t *testing.T) {
+	// The variables cmd defines dynamically, whatever the environment.
+	vars := map[string]string{"CD": `C:\Users\u`, "DATE": "01/05/2024", "TIME": "12:00:00.00", "RANDOM": "4242", "ERRORLEVEL": "0"}
+	const start = `cmd /c start "" `
+
+	urls := []string{
+		"https://chatgpt.com/",
+		"https://chatgpt.com/?q=a%20b&model=gpt-5&hints=search",
+		// Í is %C3%8D and U+0340 is %CD%80, so the encoded prompt holds %CD%.
+		"https://chatgpt.com/?q=" + escapeQueryComponent("Í\u0340 100% of %DATE% & %RANDOM%|<x>"),
+		"https://chatgpt.com/?q=" + escapeQueryComponent("naïve 日本語 🎉 (a^b) !x!"),
+		"https://example.com/ünïcode/path?a=(1)&b=2;c,d",
+	}
+	for _, u := range urls {
+		line := cmdStartLine(u)
+		rest, ok := strings.CutPrefix(cmdParse(line, vars), start)
+		if !ok {
+			t.Fatalf("%s doesn't start with %s", line, start)
+		}
+		if rest != u {
+			t.Errorf("cmd reads %s\nas  %s\nnot %s", line, rest, u)
+		}
+	}
+
+	if got := cmdStartLine("https://x/?q=%CD%&a=1"); got != `cmd /c start "" https://x/?q^=^%CD^%^&a^=1` {
+		t.Errorf("cmdStartLine = %s", got)
+	}
+}
+
+// fakeOpeners is an openerRunner over the real chain in which only the
+// named commands are installed, and those in failing fail.
+type fakeOpeners struct {
+	installed []string
+	failing   []string
+	ran       [][]string
+}
+
+func (f *fakeOpeners) run(args []string) error {
+	if !slices.Contains(f.installed, args[0]) {
+		return errOpenerMissing
+	}
+	f.ran = append(f.ran, args)
+	if slices.Contains(f.failing, args[0]) {
+		return errors.New("exit status 1")
+	}
+	return nil
+}
+
+func TestRunOpenerChain(t *testing.T) {
+	const url = "https://chatgpt.com/?q=a%20b&model=gpt-5"
+	tests := []struct {
+		name      string
+		installed []string
+		failing   []string
+		sandboxed bool
+		ran       []string
+		err       string
+	}{
+		{
+			name:      "first installed wins",
+			installed: []string{"xdg-open", "gio", "firefox"},
+			ran:       []string{"gio"},
+		},
+		{
+			name:      "falls through failures",
+			installed: []string{"gio", "kde-open", "xdg-open", "firefox"},
+			failing:   []string{"gio", "kde-open"},
+			ran:       []string{"gio", "kde-open", "xdg-open"},
+		},
+		{
+			name:      "portal skipped outside a sandbox",
+			installed: []string{"gdbus", "flatpak-spawn", "xdg-open"},
+			ran:       []string{"xdg-open"},
+		},
+		{
+			name:      "portal first in a sandbox",
+			installed: []string{"gdbus", "flatpak-spawn", "xdg-open"},
+			sandboxed: true,
+			ran:       []string{"gdbus"},
+		},
+		{
+			name: "nothing installed",
+			err:  "no suitable browser found",
+		},
+		{
+			name:      "every opener fails",
+			installed: []string{"xdg-open", "firefox"},
+			failing:   []string{"xdg-open", "firefox"},
+			ran:       []string{"xdg-open", "firefox"},
+			err:       "xdg-open: exit status 1; firefox: exit status 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeOpeners{installed: tt.installed, failing: tt.failing}
+			err := runOpenerChain(linuxOpeners, url, tt.sandboxed, f.run)
+			if tt.err == "" && err != nil {
+				t.Errorf("err = %v", err)
+			} else if tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Errorf("err = %v, want %s", err, tt.err)
+			}
+			var ran []string
+			for _, args := range f.ran {
+				ran = append(ran, args[0])
+				if !slices.Contains(args, url) {
+					t.Errorf("%s wasn't given the URL: %q", args[0], args)
+				}
+				if slices.Contains(args, "{url}") {
+					t.Errorf("%s was given the placeholder: %q", args[0], args)
+				}
+			}
+			if !slices.Equal(ran, tt.ran) {
+				t.Errorf("ran %q, want %q", ran, tt.ran)
+			}
+		})
+	}
+}
+
+func TestRunOpenerChainArgs(t *testing.T) {
+	f := &fakeOpeners{installed: []string{"exo-open", "gdbus"}}
+	if err := runOpenerChain(linuxOpeners, "https://x/", true, f.run); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"gdbus", "call", "--session",
+		"--dest", "org.freedesktop.portal.Desktop",
+		"--object-path", "/org/freedesktop/portal/desktop",
+		"--method", "org.freedesktop.portal.OpenURI.OpenURI",
+		"", "https://x/", "{}"}
+	if len(f.ran) != 1 || !slices.Equal(f.ran[0], want) {
+		t.Errorf("ran %q, want %q", f.ran, want)
+	}
+
+	f = &fakeOpeners{installed: []string{"exo-open"}}
+	runOpenerChain(linuxOpeners, "https://x/", false, f.run)
+	if want := []string{"exo-open", "--launch", "WebBrowser", "https://x/"}; len(f.ran) != 1 || !slices.Equal(f.ran[0], want) {
+		t.Errorf("ran %q, want %q", f.ran, want)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// cmdStartCommand is only meaningful on Windows; elsewhere it builds the
+// same arguments without a verbatim command line.
+func cmdStartCommand(urlStr string) *exec.Cmd {
+	return exec.Command("cmd", "/c", "start", "", urlStr)
+}
+
+// detach puts the child in its own session so it is not tied to our
+// process group (and is not killed along with the server).
+func detach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+}
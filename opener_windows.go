@@ -0,0 +1,28 @@
+//go:build windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+const detachedProcess = 0x00000008
+
+// cmdStartCommand builds `cmd /c start "" <url>` with the URL escaped for
+// cmd (see cmdStartLine). The command line is set verbatim because Go's
+// argument escaping uses backslash-quotes, which cmd does not understand.
+func cmdStartCommand(urlStr string) *exec.Cmd {
+	cmd := exec.Command("cmd")
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: cmdStartLine(urlStr)}
+	return cmd
+}
+
+// detach starts the child without our console and in its own process
+// group, so console signals aimed at the server do not reach it.
+func detach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP | detachedProcess
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// APP_DIR is the directory the server keeps its files in under each XDG
+// base directory.
+const APP_DIR = "chatgpt-handoff"
+
+// pathKind is the XDG base directory a file belongs in when --data-dir
+// isn't set.
+type pathKind int
+
+const (
+	DATA_PATH   pathKind = iota // $XDG_DATA_HOME: history, transcript, fallback prompt
+	STATE_PATH                  // $XDG_STATE_HOME: log and PID files
+	CONFIG_PATH                 // the user config directory: config file, templates
+)
+
+// dataDir is --data-dir, which holds every file the server writes, and
+// the templates, in place of the XDG directories.
+var dataDir = ""
+
+// baseDir is the XDG base directory for kind, with the usual fallbacks.
+// The config directory is os.UserConfigDir, so it follows each OS's
+// convention rather than XDG's outside Linux.
+func baseDir(kind pathKind) (string, error) {
+	switch kind {
+	case CONFIG_PATH:
+		return os.UserConfigDir()
+	case STATE_PATH:
+		if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+			return dir, nil
+		}
+	default:
+		if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+			return dir, nil
+		}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	if kind == STATE_PATH {
+		return filepath.Join(home, ".local", "state"), nil
+	}
+	return filepath.Join(home, ".local", "share"), nil
+}
+
+// appPath is where the server keeps name: directly in --data-dir when it
+// is set, else in the chatgpt-handoff directory of kind's base directory.
+// Every default path is built here.
+func appPath(kind pathKind, name string) (string, error) {
+	if dataDir != "" {
+		return filepath.Join(dataDir, name), nil
+	}
+	dir, err := baseDir(kind)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, APP_DIR, name), nil
+}
+
+// makeParentDir creates the directories above path that don't exist yet,
+// readable only by the user.
+func makeParentDir(path string) error {
+	return os.MkdirAll(filepath.Dir(path), 0o700)
+}
+
+// The default locations of the server's files. The config file stays in
+// the config directory even with --data-dir, which it can set itself.
+
+func defaultConfigFile() (string, error) {
+	dir, err := baseDir(CONFIG_PATH)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, APP_DIR, "config.json"), nil
+}
+
+func defaultHistoryFile() (string, error)    { return appPath(DATA_PATH, "history.jsonl") }
+func defaultTranscriptFile() (string, error) { return appPath(DATA_PATH, "transcript.md") }
+func defaultFallbackFile() (string, error)   { return appPath(DATA_PATH, "fallback-prompt.md") }
+func defaultTemplatesDir() (string, error)   { return appPath(CONFIG_PATH, "templates") }
+func defaultLogFile() (string, error)        { return appPath(STATE_PATH, "chatgpt-handoff.log") }
+func defaultPIDFile() (string, error)        { return appPath(STATE_PATH, "chatgpt-handoff.pid") }
+
+// autoPath resolves the value of a path flag that takes auto, which means
+// def's path.
+func autoPath(value string, def func() (string, error)) (string, error) {
+	if value != "auto" {
+		return value, nil
+	}
+	return def()
+}
+
+// ResolvedPath is one of the files doctor lists. Note says which flag
+// chose it, or why it isn't used.
+type ResolvedPath struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Note string `json:"note,omitempty"`
+}
+
+// resolvedPaths lists every file and directory the server reads or
+// writes with the current settings.
+func resolvedPaths() []ResolvedPath {
+	var paths []ResolvedPath
+	if dataDir != "" {
+		paths = append(paths, ResolvedPath{Name: "data dir", Path: dataDir, Note: "--data-dir"})
+	}
+	// add reports a path flag's value, or the default path when it's
+	// unset or auto; off explains an unset flag that turns the file off.
+	add := func(name, flagName, value string, def func() (string, error), off string) {
+		p := ResolvedPath{Name: name, Path: value}
+		if value != "" && value != "auto" {
+			p.Note = "--" + flagName
+		} else if path, err := def(); err != nil {
+			p.Note = err.Error()
+		} else {
+			p.Path = path
+			if value == "" {
+				p.Note = off
+			}
+		}
+		paths = append(paths, p)
+	}
+	add("config file", "config", configFile, defaultConfigFile, "")
+	add("history", "history-file", historyFile, defaultHistoryFile, "")
+	if noHistory {
+		paths[len(paths)-1].Note = "off with --no-history"
+	}
+	add("templates", "templates-dir", templatesDir, defaultTemplatesDir, "")
+	add("transcript", "transcript-file", transcriptFile, defaultTranscriptFile, "off; --transcript-file auto writes here")
+	add("fallback file", "fallback-file", fallbackFile, defaultFallbackFile, "off; --fallback-file auto writes here")
+	add("log file", "log-file", logFile, defaultLogFile, "off; --log-file auto writes here")
+	add("PID file", "", "", defaultPIDFile, "")
+	return paths
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestAppPath(t *testing.T) {
+	root := t.TempDir()
+	home := filepath.Join(root, "home")
+
+	tests := []struct {
+		name    string
+		dataDir string
+		xdg     bool
+		want    map[pathKind]string
+	}{
+		{
+			name:    "data dir",
+			dataDir: filepath.Join(root, "data"),
+			xdg:     true,
+			want: map[pathKind]string{
+				DATA_PATH:   filepath.Join(root, "data", "f"),
+				STATE_PATH:  filepath.Join(root, "data", "f"),
+				CONFIG_PATH: filepath.Join(root, "data", "f"),
+			},
+		},
+		{
+			name: "XDG directories",
+			xdg:  true,
+			want: map[pathKind]string{
+				DATA_PATH:  filepath.Join(root, "xdg-data", APP_DIR, "f"),
+				STATE_PATH: filepath.Join(root, "xdg-state", APP_DIR, "f"),
+			},
+		},
+		{
+			name: "home only",
+			want: map[pathKind]string{
+				DATA_PATH:  filepath.Join(home, ".local", "share", APP_DIR, "f"),
+				STATE_PATH: filepath.Join(home, ".local", "state", APP_DIR, "f"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", home)
+			t.Setenv("USERPROFILE", home)
+			for _, v := range []string{"XDG_DATA_HOME", "XDG_STATE_HOME", "XDG_CONFIG_HOME"} {
+				t.Setenv(v, "")
+			}
+			if tt.xdg {
+				t.Setenv("XDG_DATA_HOME", filepath.Join(root, "xdg-data"))
+				t.Setenv("XDG_STATE_HOME", filepath.Join(root, "xdg-state"))
+				t.Setenv("XDG_CONFIG_HOME", filepath.Join(root, "xdg-config"))
+			}
+			old := dataDir
+			t.Cleanup(func() { dataDir = old })
+			dataDir = tt.dataDir
+
+			want := tt.want
+			if _, ok := want[CONFIG_PATH]; !ok {
+				// Only Linux and the BSDs use XDG_CONFIG_HOME, or else
+				// ~/.config; elsewhere the config directory is the OS's.
+				switch {
+				case runtime.GOOS == "darwin" || runtime.GOOS == "windows":
+					dir, err := os.UserConfigDir()
+					if err != nil {
+						t.Fatal(err)
+					}
+					want[CONFIG_PATH] = filepath.Join(dir, APP_DIR, "f")
+				case tt.xdg:
+					want[CONFIG_PATH] = filepath.Join(root, "xdg-config", APP_DIR, "f")
+				default:
+					want[CONFIG_PATH] = filepath.Join(home, ".config", APP_DIR, "f")
+				}
+			}
+			for kind, path := range want {
+				got, err := appPath(kind, "f")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != path {
+					t.Errorf("appPath(%d) = %s, want %s", kind, got, path)
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultConfigFileIgnoresDataDir(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	old := dataDir
+	t.Cleanup(func() { dataDir = old })
+	dataDir = t.TempDir()
+
+	got, err := defaultConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := os.UserConfigDir()
+	if want := filepath.Join(dir, APP_DIR, "config.json"); got != want {
+		t.Errorf("defaultConfigFile() = %s, want %s", got, want)
+	}
+}
+
+func TestAutoPath(t *testing.T) {
+	old := dataDir
+	t.Cleanup(func() { dataDir = old })
+	dataDir = t.TempDir()
+
+	if got, _ := autoPath("/some/file", defaultLogFile); got != "/some/file" {
+		t.Errorf("autoPath kept %s, want the given path", got)
+	}
+	if got, _ := autoPath("auto", defaultLogFile); got != filepath.Join(dataDir, "chatgpt-handoff.log") {
+		t.Errorf("autoPath(auto) = %s", got)
+	}
+}
+
+func TestMakeParentDirMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows has no permission bits")
+	}
+	root := t.TempDir()
+	if err := makeParentDir(filepath.Join(root, "a", "b", "file")); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"a", filepath.Join("a", "b")} {
+		info, err := os.Stat(filepath.Join(root, dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != 0o700 {
+			t.Errorf("%s has mode %o, want 700", dir, mode)
+		}
+	}
+}
+
+func TestHistoryInDataDirMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows has no permission bits")
+	}
+	old := dataDir
+	t.Cleanup(func() { dataDir = old })
+	dataDir = filepath.Join(t.TempDir(), "state")
+
+	path, err := defaultHistoryFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &handoffHistory{nextID: 1}
+	if err := h.open(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.file.Close() })
+	info, err := os.Stat(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o700 {
+		t.Errorf("data dir has mode %o, want 700", mode)
+	}
+}
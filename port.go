@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// portRetry is --port-retry: how many following ports to try when
+// --port is taken.
+var portRetry = 0
+
+// listenHTTP binds --port, or with --port-retry the first free one of the
+// ports after it, and sets httpPort to the port bound, which is how
+// --port 0 learns the one the system picked.
+func listenHTTP() (net.Listener, error) {
+	var first error
+	for i := 0; i <= portRetry; i++ {
+		port := httpPort + i
+		if port > 65535 || (httpPort == 0 && i > 0) {
+			break
+		}
+		l, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+		if err == nil {
+			httpPort = l.Addr().(*net.TCPAddr).Port
+			return l, nil
+		}
+		if first == nil {
+			first = err
+		}
+		if !isAddrInUse(err) {
+			break
+		}
+	}
+	return nil, portError(httpPort, first)
+}
+
+// portError explains a failed bind in terms of what to do about it.
+func portError(port int, err error) error {
+	if !isAddrInUse(err) {
+		return fmt.Errorf("can't listen on port %d: %v", port, err)
+	}
+	msg := fmt.Sprintf("port %d is already in use", port)
+	if portRetry > 0 {
+		msg = fmt.Sprintf("ports %d to %d are already in use", port, min(port+portRetry, 65535))
+	}
+	if holder := portHolder(port); holder != "" {
+		msg += " by " + holder
+	}
+	return errors.New(msg + "; stop that program, pick another port with --port, use --port 0 for any free port, or --port-retry N to try the next N ports")
+}
+
+// portHolder names the process listening on port, best effort: lsof on
+// Unix, netstat on Windows. It returns "" when it can't tell.
+func portHolder(port int) string {
+	if runtime.GOOS == "windows" {
+		out, err := commandOutput(context.Background(), EXEC_SCALE_LOOKUP, exec.Command("netstat", "-ano", "-p", "TCP"))
+		if err != nil {
+			return ""
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			f := strings.Fields(line)
+			if len(f) == 5 && f[3] == "LISTENING" && strings.HasSuffix(f[1], ":"+strconv.Itoa(port)) {
+				return "process " + f[4]
+			}
+		}
+		return ""
+	}
+	out, err := commandOutput(context.Background(), EXEC_SCALE_LOOKUP, exec.Command("lsof", "-nP", "-iTCP:"+strconv.Itoa(port), "-sTCP:LISTEN", "-Fpc"))
+	if err != nil {
+		return ""
+	}
+	var pid, name string
+	for _, line := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(line, "p") && pid == "":
+			pid = line[1:]
+		case strings.HasPrefix(line, "c") && name == "":
+			name = line[1:]
+		}
+	}
+	switch {
+	case name != "" && pid != "":
+		return fmt.Sprintf("%s (pid %s)", name, pid)
+	case pid != "":
+		return "process " + pid
+	}
+	return ""
+}
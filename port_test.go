@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// holdPort keeps a loopback port busy for the rest of the test.
+func holdPort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func setPort(t *testing.T, port, retry int) {
+	t.Helper()
+	oldPort, oldRetry := httpPort, portRetry
+	t.Cleanup(func() { httpPort, portRetry = oldPort, oldRetry })
+	httpPort, portRetry = port, retry
+}
+
+func TestListenHTTPBusyPort(t *testing.T) {
+	busy := holdPort(t)
+	setPort(t, busy, 0)
+
+	l, err := listenHTTP()
+	if err == nil {
+		// BSD sockets let a wildcard bind share a port with a loopback one.
+		l.Close()
+		t.Skip("this OS allows binding a port already bound on 127.0.0.1")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, fmt.Sprintf("port %d is already in use", busy)) {
+		t.Errorf("error doesn't name the busy port: %s", msg)
+	}
+	if !strings.Contains(msg, "--port 0") {
+		t.Errorf("error doesn't suggest --port 0: %s", msg)
+	}
+}
+
+func TestListenHTTPRetry(t *testing.T) {
+	busy := holdPort(t)
+	const retry = 10
+	setPort(t, busy, retry)
+
+	l, err := listenHTTP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	got := l.Addr().(*net.TCPAddr).Port
+	if got == busy {
+		t.Skip("this OS allows binding a port already bound on 127.0.0.1")
+	}
+	if got <= busy || got > busy+retry {
+		t.Errorf("bound port %d, want one of %d to %d", got, busy+1, busy+retry)
+	}
+	if httpPort != got {
+		t.Errorf("httpPort = %d, want the bound port %d", httpPort, got)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// WSAEADDRINUSE is what Winsock returns for a port in use; Go's
+// syscall.EADDRINUSE on Windows is an invented value that never matches.
+const WSAEADDRINUSE syscall.Errno = 10048
+
+func isAddrInUse(err error) bool {
+	return errors.Is(err, WSAEADDRINUSE) || errors.Is(err, syscall.EADDRINUSE)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// PRIORITY_LEVELS are the values of the priority argument, lowest first.
+var PRIORITY_LEVELS = []string{"low", "normal", "high"}
+
+// PRIORITY_BEHAVIORS are what a --priority-behavior level can turn on.
+var PRIORITY_BEHAVIORS = []string{"open", "preview", "notify", "sound"}
+
+var (
+	// defaultPriority applies to calls without a priority argument
+	// (--default-priority).
+	defaultPriority = "normal"
+	// priorityBehaviors holds the --priority-behavior overrides; levels
+	// without one use defaultPriorityBehavior.
+	priorityBehaviors = map[string]PriorityBehavior{}
+)
+
+// PriorityBehavior is what a priority level does besides copying the
+// prompt. The openChat, deeplinkPreview and notify arguments still win
+// over it.
+type PriorityBehavior struct {
+	// Open opens the chat.
+	Open bool `json:"open"`
+	// Preview opens a truncated preview when the prompt is too long to
+	// deeplink.
+	Preview bool `json:"preview"`
+	// Notify shows the desktop notification.
+	Notify bool `json:"notify"`
+	// Sound plays the --sound cue; without --sound there is none.
+	Sound bool `json:"sound"`
+}
+
+// defaultPriorityBehavior is the built-in behavior of a level: low only
+// copies, normal follows --deeplink-preview and --notify, and high opens
+// a preview when the prompt is too long and always notifies.
+func defaultPriorityBehavior(level string) PriorityBehavior {
+	switch level {
+	case "low":
+		return PriorityBehavior{}
+	case "high":
+		return PriorityBehavior{Open: true, Preview: true, Notify: true, Sound: true}
+	}
+	return PriorityBehavior{Open: true, Preview: deeplinkPreview, Notify: notifyEnabled, Sound: true}
+}
+
+// behaviorFor returns the behavior of a valid priority level.
+func behaviorFor(level string) PriorityBehavior {
+	if b, ok := priorityBehaviors[level]; ok {
+		return b
+	}
+	return defaultPriorityBehavior(level)
+}
+
+func validatePriority(level string) error {
+	if !slices.Contains(PRIORITY_LEVELS, level) {
+		return fmt.Errorf("priority must be one of %s, got %q", strings.Join(PRIORITY_LEVELS, ", "), level)
+	}
+	return nil
+}
+
+// parsePriorityBehavior parses a --priority-behavior LEVEL=BEHAVIOR,...
+// value; an empty list means the level only copies the prompt.
+func parsePriorityBehavior(value string) (string, PriorityBehavior, error) {
+	level, list, ok := strings.Cut(value, "=")
+	level = strings.TrimSpace(level)
+	if !ok {
+		return "", PriorityBehavior{}, fmt.Errorf("expected LEVEL=BEHAVIOR,... with behaviors from %s", strings.Join(PRIORITY_BEHAVIORS, ", "))
+	}
+	if err := validatePriority(level); err != nil {
+		return "", PriorityBehavior{}, err
+	}
+	var b PriorityBehavior
+	for _, name := range strings.Split(list, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "open":
+			b.Open = true
+		case "preview":
+			b.Preview = true
+		case "notify":
+			b.Notify = true
+		case "sound":
+			b.Sound = true
+		default:
+			return "", PriorityBehavior{}, fmt.Errorf("unknown behavior %q, expected one of %s", name, strings.Join(PRIORITY_BEHAVIORS, ", "))
+		}
+	}
+	return level, b, nil
+}
+
+// ntfyPriority maps a priority level to ntfy's Priority header.
+func ntfyPriority(level string) string {
+	switch level {
+	case "low":
+		return "low"
+	case "high":
+		return "high"
+	}
+	return "default"
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_WRAPPER_START = "----- HANDOFF START {timestamp} -----"
+	DEFAULT_WRAPPER_END   = "----- HANDOFF END -----"
+
+	MAX_DERIVED_TITLE_LENGTH = 80
+	// MAX_TITLE_LENGTH bounds an explicit title argument, in characters.
+	MAX_TITLE_LENGTH = 120
+
+	// Deeplink previews start at PREVIEW_CHARS characters and shrink (down
+	// to MIN_PREVIEW_CHARS) until the encoded URL fits.
+	PREVIEW_CHARS     = 1200
+	MIN_PREVIEW_CHARS = 200
+	PREVIEW_NOTE      = "…(truncated) — the full prompt is on my clipboard, ask me to paste it."
+
+	// MAX_MESSAGES_BYTES bounds the prompt formatted from a messages
+	// argument.
+	MAX_MESSAGES_BYTES = 1 << 20
+
+	// DEFAULT_MAX_PROMPT_LENGTH is the default --max-prompt-length, in
+	// bytes of the final prompt.
+	DEFAULT_MAX_PROMPT_LENGTH = 2 << 20
+)
+
+// checkPromptLength rejects a prompt over --max-prompt-length before it
+// reaches the clipboard, which some clipboard tools can't cope with.
+func checkPromptLength(ctx context.Context, n int) error {
+	limit := settingsFor(ctx).MaxPromptLength
+	if n <= limit {
+		return nil
+	}
+	return fmt.Errorf("the prompt is %d bytes, over the %d byte limit (--max-prompt-length); attach only the relevant files or line ranges, or split the work across several handoffs", n, limit)
+}
+
+// HandoffMessage is one entry of the messages argument.
+type HandoffMessage struct {
+	Role    string `json:"role" jsonschema:"context, user, assistant, or code"`
+	Content string `json:"content"`
+}
+
+// messageSections are the headings formatMessages gives each role.
+var messageSections = map[string]string{
+	"context":   "Context",
+	"user":      "User",
+	"assistant": "Assistant",
+	"code":      "Code",
+}
+
+// wrapClipboardText surrounds the prompt with the configured start/end
+// markers. It is applied to the clipboard copy only; deeplinks always carry
+// the bare prompt.
+func wrapClipboardText(prompt, title string, now time.Time) string {
+	r := strings.NewReplacer(
+		"{timestamp}", now.Format(time.RFC3339),
+		"{title}", title,
+	)
+	return r.Replace(clipboardWrapperStart) + "\n" + prompt + "\n" + r.Replace(clipboardWrapperEnd)
+}
+
+// applyPreamble puts --prompt-prefix before the prompt and --prompt-suffix
+// after it. Each part is trimmed and the parts are separated by exactly one
+// blank line; empty parts are left out.
+func applyPreamble(prompt string) string {
+	var parts []string
+	for _, part := range []string{promptPrefix, prompt, promptSuffix} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// readTextFlag returns value, or the contents of the file when value is
+// @path.
+func readTextFlag(value string) (string, error) {
+	path, ok := strings.CutPrefix(value, "@")
+	if !ok {
+		return value, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(string(data), "\r\n", "\n"), nil
+}
+
+// formatMessages flattens messages into one prompt, in order: each entry
+// becomes a "## Role" section, and code entries are fenced.
+func formatMessages(messages []HandoffMessage) (string, error) {
+	if len(messages) == 0 {
+		return "", errors.New("messages is empty")
+	}
+	var b strings.Builder
+	for i, m := range messages {
+		heading, ok := messageSections[m.Role]
+		if !ok {
+			return "", fmt.Errorf("messages[%d]: unknown role %q (valid: context, user, assistant, code)", i, m.Role)
+		}
+		content := strings.TrimSpace(strings.ReplaceAll(m.Content, "\r\n", "\n"))
+		if content == "" {
+			return "", fmt.Errorf("messages[%d]: content is empty", i)
+		}
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		b.WriteString("## " + heading + "\n\n")
+		if m.Role == "code" {
+			fence := markdownFence(content)
+			b.WriteString(fence + "\n" + content + "\n" + fence)
+		} else {
+			b.WriteString(content)
+		}
+		if b.Len() > MAX_MESSAGES_BYTES {
+			return "", fmt.Errorf("messages add up to more than %d bytes", MAX_MESSAGES_BYTES)
+		}
+	}
+	return b.String(), nil
+}
+
+// messagesTitleSource is the text a title is derived from for a messages
+// prompt: the first user entry, or else the first entry.
+func messagesTitleSource(messages []HandoffMessage) string {
+	for _, m := range messages {
+		if m.Role == "user" {
+			return m.Content
+		}
+	}
+	return messages[0].Content
+}
+
+// deriveTitle returns the first non-empty line of the prompt, shortened at
+// a word boundary when it is too long to be a useful label.
+func deriveTitle(prompt string) string {
+	for _, line := range strings.Split(prompt, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if len(line) <= MAX_DERIVED_TITLE_LENGTH {
+			return line
+		}
+		cut := line[:MAX_DERIVED_TITLE_LENGTH]
+		if i := strings.LastIndex(cut, " "); i > 0 {
+			cut = cut[:i]
+		}
+		return strings.TrimRight(cut, " ,.;:") + "…"
+	}
+	return ""
+}
+
+// clipboardLineEnding is the newline convention pasted text should use on
+// this platform.
+func clipboardLineEnding() string {
+	if runtime.GOOS == "windows" {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// normalizeLineEndings rewrites every CRLF, LF, or lone CR line ending to
+// eol. With preserveCode set, lines inside fenced code blocks (``` or ~~~)
+// keep their original endings; the lines opening and closing a block are
+// normalized.
+func normalizeLineEndings(s, eol string, preserveCode bool) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	var fence string
+	for len(s) > 0 {
+		line, ending := s, ""
+		if i := strings.IndexAny(s, "\r\n"); i >= 0 {
+			line, ending = s[:i], s[i:i+1]
+			if strings.HasPrefix(s[i:], "\r\n") {
+				ending = "\r\n"
+			}
+		}
+		s = s[len(line)+len(ending):]
+
+		// Fences nested in a block, which don't close it, are part of it.
+		inside := fence != ""
+		if preserveCode {
+			if marker := fenceMarker(line); marker != "" {
+				if fence == "" {
+					fence = marker
+				} else if closesFence(line, marker, fence) {
+					fence, inside = "", false
+				}
+			}
+		}
+
+		b.WriteString(line)
+		switch {
+		case ending == "":
+		case preserveCode && inside:
+			b.WriteString(ending)
+		default:
+			b.WriteString(eol)
+		}
+	}
+	return b.String()
+}
+
+// closesFence reports whether line, whose fence marker is marker, closes a
+// block opened with fence: same character, at least as long, no info string.
+func closesFence(line, marker, fence string) bool {
+	return marker[0] == fence[0] && len(marker) >= len(fence) &&
+		strings.TrimSpace(strings.TrimLeft(line, " ")[len(marker):]) == ""
+}
+
+// previewPrompt returns about the first maxRunes characters of prompt, cut
+// at a word boundary and never inside a fenced code block, followed by
+// PREVIEW_NOTE. It is used for deeplinks when the full prompt won't fit.
+func previewPrompt(prompt string, maxRunes int) string {
+	runes := []rune(prompt)
+	if len(runes) <= maxRunes {
+		return prompt
+	}
+	cut := string(runes[:maxRunes])
+	if i := strings.LastIndexAny(cut, " \t\n"); i > 0 {
+		cut = cut[:i]
+	}
+
+	// If a fence is still open at the cut, drop the whole block.
+	var fence string
+	fenceStart, offset := 0, 0
+	for _, line := range strings.SplitAfter(cut, "\n") {
+		trimmed := strings.TrimRight(line, "\n")
+		if marker := fenceMarker(trimmed); marker != "" {
+			if fence == "" {
+				fence, fenceStart = marker, offset
+			} else if closesFence(trimmed, marker, fence) {
+				fence = ""
+			}
+		}
+		offset += len(line)
+	}
+	if fence != "" {
+		cut = cut[:fenceStart]
+	}
+	return strings.TrimRight(cut, " \t\n") + "\n\n" + PREVIEW_NOTE
+}
+
+// fenceMarker returns the run of backticks or tildes opening a Markdown
+// code fence on line, or "" if line is not a fence.
+func fenceMarker(line string) string {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 || len(trimmed) < 3 {
+		return ""
+	}
+	c := trimmed[0]
+	if c != '`' && c != '~' {
+		return ""
+	}
+	n := 0
+	for n < len(trimmed) && trimmed[n] == c {
+		n++
+	}
+	if n < 3 {
+		return ""
+	}
+	return trimmed[:n]
+}
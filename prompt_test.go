@@ -0,0 +1,181 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeLineEndings(t *testing.T) {
+	tests := []struct {
+		name         string
+		in           string
+		eol          string
+		preserveCode bool
+		want         string
+	}{
+		{"empty", "", "\r\n", false, ""},
+		{"no endings", "abc", "\r\n", false, "abc"},
+		{"mixed to LF", "a\r\nb\nc\rd\r\n", "\n", false, "a\nb\nc\nd\n"},
+		{"mixed to CRLF", "a\r\nb\nc\rd\n", "\r\n", false, "a\r\nb\r\nc\r\nd\r\n"},
+		{"blank lines", "a\n\n\r\n\r\rb", "\r\n", false, "a\r\n\r\n\r\n\r\n\r\nb"},
+		{"CR before CRLF", "a\r\r\nb", "\n", false, "a\n\nb"},
+		{
+			name: "code normalized without preserve",
+			in:   "x\n```go\na\r\nb\n```\ny",
+			eol:  "\n",
+			want: "x\n```go\na\nb\n```\ny",
+		},
+		{
+			name:         "code kept with preserve",
+			in:           "x\r\n```go\r\na\r\nb\nc\r\n```\r\ny\r\n",
+			eol:          "\n",
+			preserveCode: true,
+			want:         "x\n```go\na\r\nb\nc\r\n```\ny\n",
+		},
+		{
+			name:         "tilde fence",
+			in:           "~~~\na\r\n~~~\nb\r\n",
+			eol:          "\n",
+			preserveCode: true,
+			want:         "~~~\na\r\n~~~\nb\n",
+		},
+		{
+			// A shorter or different fence inside doesn't close the block.
+			name:         "nested fences",
+			in:           "````\n```\r\na\r\n```\r\n````\nb\r\n",
+			eol:          "\n",
+			preserveCode: true,
+			want:         "````\n```\r\na\r\n```\r\n````\nb\n",
+		},
+		{
+			name:         "fence with info string doesn't close",
+			in:           "```\na\r\n```go\r\nb\r\n```\nc\r\n",
+			eol:          "\n",
+			preserveCode: true,
+			want:         "```\na\r\n```go\r\nb\r\n```\nc\n",
+		},
+		{
+			name:         "unclosed fence",
+			in:           "a\r\n```\nb\r\nc\r\n",
+			eol:          "\n",
+			preserveCode: true,
+			want:         "a\n```\nb\r\nc\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeLineEndings(tt.in, tt.eol, tt.preserveCode); got != tt.want {
+				t.Errorf("normalizeLineEndings(%q, %q, %v) = %q, want %q", tt.in, tt.eol, tt.preserveCode, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyPreamble(t *testing.T) {
+	oldPrefix, oldSuffix := promptPrefix, promptSuffix
+	t.Cleanup(func() { promptPrefix, promptSuffix = oldPrefix, oldSuffix })
+
+	tests := []struct {
+		name, prefix, prompt, suffix, want string
+	}{
+		{"neither", "", "Why?", "", "Why?"},
+		{"prefix", "Be brief.", "Why?", "", "Be brief.\n\nWhy?"},
+		{"suffix", "", "Why?", "Cite sources.", "Why?\n\nCite sources."},
+		{"both", "Be brief.", "Why?", "Cite sources.", "Be brief.\n\nWhy?\n\nCite sources."},
+		{"trimmed", "\n Be brief. \n\n", "\n\nWhy?\n\n", "  Cite sources.\n", "Be brief.\n\nWhy?\n\nCite sources."},
+		{"inner blank lines kept", "a\n\n\nb", "c\n\nd", "", "a\n\n\nb\n\nc\n\nd"},
+		{"blank parts dropped", " \n\t", "Why?", "\n", "Why?"},
+		{"empty prompt", "Be brief.", "  ", "Cite sources.", "Be brief.\n\nCite sources."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			promptPrefix, promptSuffix = tt.prefix, tt.suffix
+			if got := applyPreamble(tt.prompt); got != tt.want {
+				t.Errorf("applyPreamble(%q) = %q, want %q", tt.prompt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []HandoffMessage
+		want     string
+		wantErr  string
+	}{
+		{
+			name:     "single",
+			messages: []HandoffMessage{{"user", "Why?"}},
+			want:     "## User\n\nWhy?",
+		},
+		{
+			name: "every role in order",
+			messages: []HandoffMessage{
+				{"context", "A Go service."},
+				{"user", "It leaks."},
+				{"assistant", "Check the pool."},
+				{"code", "func main() {}"},
+				{"user", "Still leaks."},
+			},
+			want: "## Context\n\nA Go service.\n\n## User\n\nIt leaks.\n\n## Assistant\n\nCheck the pool.\n\n## Code\n\n```\nfunc main() {}\n```\n\n## User\n\nStill leaks.",
+		},
+		{
+			name:     "trimmed and CRLF normalized",
+			messages: []HandoffMessage{{"user", "\r\n  a\r\nb  \r\n"}},
+			want:     "## User\n\na\nb",
+		},
+		{
+			name:     "code with a fence inside",
+			messages: []HandoffMessage{{"code", "```go\nx\n```"}},
+			want:     "## Code\n\n````\n```go\nx\n```\n````",
+		},
+		{
+			name:     "fences only for code",
+			messages: []HandoffMessage{{"assistant", "```\nx\n```"}},
+			want:     "## Assistant\n\n```\nx\n```",
+		},
+		{name: "none", wantErr: "messages is empty"},
+		{
+			name:     "unknown role",
+			messages: []HandoffMessage{{"user", "a"}, {"system", "b"}},
+			wantErr:  `messages[1]: unknown role "system"`,
+		},
+		{
+			name:     "empty content",
+			messages: []HandoffMessage{{"user", " \r\n "}},
+			wantErr:  "messages[0]: content is empty",
+		},
+		{
+			name:     "too large",
+			messages: []HandoffMessage{{"user", strings.Repeat("a", MAX_MESSAGES_BYTES/2)}, {"user", strings.Repeat("b", MAX_MESSAGES_BYTES/2)}},
+			wantErr:  "more than",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := formatMessages(tt.messages)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%q\nwant:\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessagesTitleSource(t *testing.T) {
+	if got := messagesTitleSource([]HandoffMessage{{"context", "ctx"}, {"user", "question"}}); got != "question" {
+		t.Errorf("title source = %q, want the first user entry", got)
+	}
+	if got := messagesTitleSource([]HandoffMessage{{"context", "ctx"}, {"code", "x"}}); got != "ctx" {
+		t.Errorf("title source = %q, want the first entry", got)
+	}
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal QR code encoder: byte mode, error correction level L, versions
+// 1-40. Level L keeps the largest capacity, which matters more for long
+// deeplinks than damage tolerance on a screen.
+
+const (
+	// Largest payload (bytes) a version 40-L symbol can hold in byte mode.
+	MAX_QR_PAYLOAD = 2953
+
+	QR_MODULE_PIXELS = 6
+	QR_QUIET_ZONE    = 4
+	QR_IMAGE_TTL     = 5 * time.Minute
+)
+
+// Error correction codewords per block and number of blocks for level L,
+// indexed by version (index 0 unused).
+var (
+	qrECCPerBlock = [41]int{0,
+		7, 10, 15, 20, 26, 18, 20, 24, 30, 18,
+		20, 24, 26, 30, 22, 24, 28, 30, 28, 28,
+		28, 28, 30, 30, 26, 28, 30, 30, 30, 30,
+		30, 30, 30, 30, 30, 30, 30, 30, 30, 30}
+	qrECCBlocks = [41]int{0,
+		1, 1, 1, 1, 1, 2, 2, 2, 2, 4,
+		4, 4, 4, 4, 6, 6, 6, 6, 7, 8,
+		8, 9, 9, 10, 12, 12, 12, 13, 14, 15,
+		16, 17, 18, 19, 19, 20, 21, 22, 24, 25}
+)
+
+// QRCode is an encoded symbol; Modules[y][x] is true for dark modules.
+type QRCode struct {
+	Version int
+	Size    int
+	Modules [][]bool
+
+	function [][]bool
+}
+
+// encodeQR encodes data as a QR code using the smallest version that fits.
+func encodeQR(data []byte) (*QRCode, error) {
+	version := 0
+	for v := 1; v <= 40; v++ {
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+len(data)*8 <= qrDataCodewords(v)*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("%d bytes is too long for a QR code (max %d)", len(data), MAX_QR_PAYLOAD)
+	}
+
+	var bits qrBitBuffer
+	bits.append(0x4, 4) // byte mode
+	if version >= 10 {
+		bits.append(len(data), 16)
+	} else {
+		bits.append(len(data), 8)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	capacity := qrDataCodewords(version) * 8
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i/8] |= 1 << (7 - i%8)
+		}
+	}
+
+	q := newQRCode(version)
+	q.drawCodewords(qrAddECC(codewords, version))
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		q.applyMask(mask) // XOR undoes the mask
+	}
+	q.applyMask(best)
+	q.drawFormatBits(best)
+	return q, nil
+}
+
+type qrBitBuffer []bool
+
+func (b *qrBitBuffer) append(val, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, (val>>i)&1 != 0)
+	}
+}
+
+// qrRawModules is the number of modules available for data and error
+// correction in a symbol of the given version.
+func qrRawModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+func qrDataCodewords(version int) int {
+	return qrRawModules(version)/8 - qrECCPerBlock[version]*qrECCBlocks[version]
+}
+
+// qrAddECC splits data into blocks, appends Reed-Solomon error correction
+// to each, and interleaves the result.
+func qrAddECC(data []byte, version int) []byte {
+	numBlocks := qrECCBlocks[version]
+	eccLen := qrECCPerBlock[version]
+	raw := qrRawModules(version) / 8
+	numShort := numBlocks - raw%numBlocks
+	shortLen := raw / numBlocks
+
+	divisor := qrRSDivisor(eccLen)
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := range blocks {
+		n := shortLen - eccLen
+		if i >= numShort {
+			n++
+		}
+		block := append([]byte(nil), data[k:k+n]...)
+		k += n
+		ecc := qrRSRemainder(block, divisor)
+		if i < numShort {
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, ecc...)
+	}
+
+	var out []byte
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			// Skip the padding byte of short blocks.
+			if i != shortLen-eccLen || j >= numShort {
+				out = append(out, block[i])
+			}
+		}
+	}
+	return out
+}
+
+func qrRSDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 0x02)
+	}
+	return result
+}
+
+func qrRSRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMul(d, factor)
+		}
+	}
+	return result
+}
+
+// gfMul multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+func newQRCode(version int) *QRCode {
+	size := version*4 + 17
+	q := &QRCode{Version: version, Size: size}
+	q.Modules = make([][]bool, size)
+	q.function = make([][]bool, size)
+	for i := range q.Modules {
+		q.Modules[i] = make([]bool, size)
+		q.function[i] = make([]bool, size)
+	}
+
+	for i := 0; i < size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+	q.drawFinder(3, 3)
+	q.drawFinder(size-4, 3)
+	q.drawFinder(3, size-4)
+
+	pos := qrAlignmentPositions(version)
+	for i, y := range pos {
+		for j, x := range pos {
+			corner := (i == 0 && j == 0) || (i == 0 && j == len(pos)-1) || (i == len(pos)-1 && j == 0)
+			if !corner {
+				q.drawAlignment(x, y)
+			}
+		}
+	}
+
+	q.drawFormatBits(0) // reserve the area; redrawn once the mask is chosen
+	q.drawVersion()
+	return q
+}
+
+func (q *QRCode) setFunction(x, y int, dark bool) {
+	q.Modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+func (q *QRCode) drawFinder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || x >= q.Size || y < 0 || y >= q.Size {
+				continue
+			}
+			d := max(abs(dx), abs(dy))
+			q.setFunction(x, y, d != 2 && d != 4)
+		}
+	}
+}
+
+func (q *QRCode) drawAlignment(cx, cy int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			q.setFunction(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	n := version/7 + 2
+	step := (version*8 + n*3 + 5) / (n*4 - 4) * 2
+	pos := make([]int, n)
+	pos[0] = 6
+	for i, p := n-1, version*4+17-7; i >= 1; i, p = i-1, p-step {
+		pos[i] = p
+	}
+	return pos
+}
+
+func (q *QRCode) drawFormatBits(mask int) {
+	data := 1<<3 | mask // level L is 01
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		q.setFunction(8, i, bit(i))
+	}
+	q.setFunction(8, 7, bit(6))
+	q.setFunction(8, 8, bit(7))
+	q.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.setFunction(q.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, q.Size-15+i, bit(i))
+	}
+	q.setFunction(8, q.Size-8, true) // always-dark module
+}
+
+func (q *QRCode) drawVersion() {
+	if q.Version < 7 {
+		return
+	}
+	rem := q.Version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := q.Version<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := (bits>>i)&1 != 0
+		a, b := q.Size-11+i%3, i/3
+		q.setFunction(a, b, dark)
+		q.setFunction(b, a, dark)
+	}
+}
+
+// drawCodewords places data in the zigzag column-pair order, skipping
+// function modules.
+func (q *QRCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.Size - 1 - vert
+				}
+				if !q.function[y][x] && i < len(data)*8 {
+					q.Modules[y][x] = (data[i/8]>>(7-i%8))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (q *QRCode) applyMask(mask int) {
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !q.function[y][x] {
+				q.Modules[y][x] = !q.Modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores the symbol with the four rules from ISO/IEC 18004; the
+// mask with the lowest score is used.
+func (q *QRCode) penalty() int {
+	n := q.Size
+	at := func(x, y int, vertical bool) bool {
+		if vertical {
+			return q.Modules[x][y]
+		}
+		return q.Modules[y][x]
+	}
+	finderA := []bool{true, false, true, true, true, false, true, false, false, false, false}
+	finderB := []bool{false, false, false, false, true, false, true, true, true, false, true}
+
+	score := 0
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < n; y++ {
+			run := 1
+			for x := 1; x <= n; x++ {
+				if x < n && at(x, y, vertical) == at(x-1, y, vertical) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += 3 + run - 5
+				}
+				run = 1
+			}
+			for x := 0; x+11 <= n; x++ {
+				matchA, matchB := true, true
+				for k := 0; k < 11; k++ {
+					m := at(x+k, y, vertical)
+					matchA = matchA && m == finderA[k]
+					matchB = matchB && m == finderB[k]
+				}
+				if matchA {
+					score += 40
+				}
+				if matchB {
+					score += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			if q.Modules[y][x] {
+				dark++
+			}
+			if x+1 < n && y+1 < n {
+				c := q.Modules[y][x]
+				if c == q.Modules[y][x+1] && c == q.Modules[y+1][x] && c == q.Modules[y+1][x+1] {
+					score += 3
+				}
+			}
+		}
+	}
+	total := n * n
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return score + k*10
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// PNG renders the symbol with a quiet zone, QR_MODULE_PIXELS per module.
+func (q *QRCode) PNG() ([]byte, error) {
+	dim := (q.Size + 2*QR_QUIET_ZONE) * QR_MODULE_PIXELS
+	img := image.NewPaletted(image.Rect(0, 0, dim, dim), color.Palette{color.White, color.Black})
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			if !q.Modules[y][x] {
+				continue
+			}
+			px := (x + QR_QUIET_ZONE) * QR_MODULE_PIXELS
+			py := (y + QR_QUIET_ZONE) * QR_MODULE_PIXELS
+			for dy := 0; dy < QR_MODULE_PIXELS; dy++ {
+				for dx := 0; dx < QR_MODULE_PIXELS; dx++ {
+					img.SetColorIndex(px+dx, py+dy, 1)
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ASCII renders the symbol with half-block characters, two module rows per
+// line. Like qrencode's UTF8 output it assumes light text on a dark
+// terminal, so blocks are drawn for light modules.
+func (q *QRCode) ASCII() string {
+	const quiet = 2
+	light := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= q.Size || y >= q.Size {
+			return true
+		}
+		return !q.Modules[y][x]
+	}
+	var sb strings.Builder
+	for y := -quiet; y < q.Size+quiet; y += 2 {
+		for x := -quiet; x < q.Size+quiet; x++ {
+			top, bottom := light(x, y), light(x, y+1)
+			switch {
+			case top && bottom:
+				sb.WriteString("█")
+			case top:
+				sb.WriteString("▀")
+			case bottom:
+				sb.WriteString("▄")
+			default:
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// qrImages holds rendered QR codes served at /qr/<token>.png in HTTP mode.
+var qrImages = struct {
+	sync.Mutex
+	m map[string]qrImage
+}{m: map[string]qrImage{}}
+
+type qrImage struct {
+	png     []byte
+	expires time.Time
+}
+
+// storeQRImage keeps png for QR_IMAGE_TTL and returns its token.
+func storeQRImage(png []byte) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+
+	now := time.Now()
+	qrImages.Lock()
+	defer qrImages.Unlock()
+	for t, img := range qrImages.m {
+		if now.After(img.expires) {
+			delete(qrImages.m, t)
+		}
+	}
+	qrImages.m[token] = qrImage{png: png, expires: now.Add(QR_IMAGE_TTL)}
+	return token
+}
+
+func loadQRImage(token string) ([]byte, bool) {
+	qrImages.Lock()
+	defer qrImages.Unlock()
+	img, ok := qrImages.m[token]
+	if !ok || time.Now().After(img.expires) {
+		return nil, false
+	}
+	return img.png, true
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestEncodeQRCapacity(t *testing.T) {
+	// Byte-mode capacities at level L from ISO/IEC 18004, table 7.
+	capacities := map[int]int{1: 17, 2: 32, 3: 53, 4: 78, 5: 106, 6: 134, 7: 154, 8: 192, 9: 230, 10: 271, 20: 858, 27: 1465, 40: 2953}
+	for version, capacity := range capacities {
+		q, err := encodeQR(bytes.Repeat([]byte("a"), capacity))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", capacity, err)
+		}
+		if q.Version != version || q.Size != 17+4*version || len(q.Modules) != q.Size {
+			t.Errorf("%d bytes: version %d, size %d; want version %d", capacity, q.Version, q.Size, version)
+		}
+		if version == 40 {
+			continue
+		}
+		if q, _ := encodeQR(bytes.Repeat([]byte("a"), capacity+1)); q.Version != version+1 {
+			t.Errorf("%d bytes: version %d, want %d", capacity+1, q.Version, version+1)
+		}
+	}
+	if MAX_QR_PAYLOAD != capacities[40] {
+		t.Errorf("MAX_QR_PAYLOAD is %d, want %d", MAX_QR_PAYLOAD, capacities[40])
+	}
+	if _, err := encodeQR(bytes.Repeat([]byte("a"), MAX_QR_PAYLOAD+1)); err == nil {
+		t.Error("encoded one byte over MAX_QR_PAYLOAD")
+	}
+}
+
+func TestEncodeQRFinders(t *testing.T) {
+	q, err := encodeQR([]byte("https://chatgpt.com/?q=hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A finder is a dark 7x7 ring around a light ring around a dark 3x3.
+	finder := func(x0, y0 int) bool {
+		for y := 0; y < 7; y++ {
+			for x := 0; x < 7; x++ {
+				ring := max(abs(x-3), abs(y-3))
+				if q.Modules[y0+y][x0+x] != (ring != 2) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	for _, c := range [][2]int{{0, 0}, {q.Size - 7, 0}, {0, q.Size - 7}} {
+		if !finder(c[0], c[1]) {
+			t.Errorf("no finder pattern at %v", c)
+		}
+	}
+	// The timing patterns alternate between the finders.
+	for i := 8; i < q.Size-8; i++ {
+		if q.Modules[6][i] != (i%2 == 0) || q.Modules[i][6] != (i%2 == 0) {
+			t.Fatalf("broken timing pattern at %d", i)
+		}
+	}
+}
+
+func TestQRRender(t *testing.T) {
+	q, err := encodeQR([]byte("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := q.PNG()
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dim := (q.Size + 2*QR_QUIET_ZONE) * QR_MODULE_PIXELS; img.Bounds().Dx() != dim || img.Bounds().Dy() != dim {
+		t.Errorf("the PNG is %v, want %dx%d", img.Bounds(), dim, dim)
+	}
+	lines := strings.Split(strings.TrimSuffix(q.ASCII(), "\n"), "\n")
+	if want := (q.Size + 4 + 1) / 2; len(lines) != want {
+		t.Errorf("the text rendering has %d lines, want %d", len(lines), want)
+	}
+}
+
+func TestQRContentLimit(t *testing.T) {
+	link := "https://chatgpt.com/?q=" + strings.Repeat("a", MAX_QR_PAYLOAD-len("https://chatgpt.com/?q="))
+	if _, _, err := qrContent(link); err != nil {
+		t.Errorf("a %d-byte link: %v", len(link), err)
+	}
+	if _, _, err := qrContent(link + "a"); err == nil || !strings.Contains(err.Error(), "at most 2953") {
+		t.Errorf("a link one byte over: err = %v", err)
+	}
+}
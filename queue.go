@@ -0,0 +1,259 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+const DEFAULT_QUEUE_DEPTH = 20
+
+// queueDepth is how many handoffs can wait in the queue (--queue-depth).
+var queueDepth = DEFAULT_QUEUE_DEPTH
+
+// queuedHandoff is a handoff call held back by queue: true and delivered
+// by next_handoff. Prompt is assembled and redacted when the call is
+// queued, and Args keeps only the delivery options; entries saved before
+// that have no Prompt and are assembled from Args at delivery.
+type queuedHandoff struct {
+	Time   time.Time        `json:"time"`
+	Tool   string           `json:"tool"`
+	Args   HandoffArgs      `json:"args"`
+	Prompt *assembledPrompt `json:"prompt,omitempty"`
+}
+
+// title labels a queued handoff the way the history will.
+func (q queuedHandoff) title() string {
+	switch {
+	case q.Prompt != nil:
+		return q.Prompt.Title
+	case q.Args.Title != "":
+		return q.Args.Title
+	case q.Args.Template != "":
+		return "template " + q.Args.Template
+	case q.Args.PromptFile != "":
+		return "file " + q.Args.PromptFile
+	case q.Args.Messages != nil:
+		return messagesTitleSource(q.Args.Messages)
+	}
+	return deriveTitle(q.Args.Prompt)
+}
+
+// handoffQueue is a FIFO of queued handoffs. When the history has a file,
+// the queue is kept next to it in <history-file>.queue.json, so it
+// survives restarts.
+type handoffQueue struct {
+	mu      sync.Mutex
+	entries []queuedHandoff
+	path    string
+}
+
+var queue = &handoffQueue{}
+
+// load attaches the queue to the history file and reads any entries left
+// from an earlier run.
+func (q *handoffQueue) load(historyPath string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	path := historyPath + ".queue.json"
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		// A queue that can't be read is left alone rather than
+		// overwritten, and the queue is kept in memory only.
+		if err := json.Unmarshal(data, &q.entries); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	q.path = path
+	return nil
+}
+
+// save writes the queue; the caller holds q.mu.
+func (q *handoffQueue) save() {
+	if q.path == "" {
+		return
+	}
+	data, err := json.Marshal(q.entries)
+	if err == nil {
+		err = writeFileAtomic(q.path, data)
+	}
+	if err != nil {
+		slog.Warn("failed to save handoff queue", "path", q.path, "error", err)
+	}
+}
+
+// push appends h and returns its 1-based position.
+func (q *handoffQueue) push(h queuedHandoff) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) >= queueDepth {
+		return 0, fmt.Errorf("the handoff queue is full (%d prompts, --queue-depth); call next_handoff to deliver the oldest first", queueDepth)
+	}
+	q.entries = append(q.entries, h)
+	q.save()
+	return len(q.entries), nil
+}
+
+// peek returns the oldest entry and how many wait behind it.
+func (q *handoffQueue) peek() (queuedHandoff, int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) == 0 {
+		return queuedHandoff{}, 0, false
+	}
+	return q.entries[0], len(q.entries) - 1, true
+}
+
+// drop removes h once it has been delivered, unless the queue was
+// cleared meanwhile.
+func (q *handoffQueue) drop(h queuedHandoff) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) > 0 && q.entries[0].Time.Equal(h.Time) && q.entries[0].Tool == h.Tool {
+		q.entries = q.entries[1:]
+		q.save()
+	}
+}
+
+// clear removes the entries queued at or before cutoff and returns how
+// many there were.
+func (q *handoffQueue) clear(cutoff time.Time) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := len(q.entries)
+	q.entries = slices.DeleteFunc(q.entries, func(h queuedHandoff) bool { return !h.Time.After(cutoff) })
+	if n -= len(q.entries); n > 0 {
+		q.save()
+	}
+	return n
+}
+
+func (q *handoffQueue) list() []queuedHandoff {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]queuedHandoff(nil), q.entries...)
+}
+
+// QueueResult is the structured content of a queued handoff.
+type QueueResult struct {
+	Queued   bool   `json:"queued"`
+	Position int    `json:"position"`
+	MaxDepth int    `json:"maxDepth"`
+	Title    string `json:"title"`
+	Target   string `json:"target"`
+}
+
+// enqueueHandoff holds a checked handoff call for next_handoff. Only the
+// assembled prompt is kept, not what it was built from, so secrets that
+// redaction removed never reach the queue file.
+func enqueueHandoff(params *mcp.CallToolParamsFor[HandoffArgs], a *assembledPrompt, target handoffTarget) *mcp.CallToolResultFor[any] {
+	args := params.Arguments
+	if args.DryRun {
+		return toolError("invalid params: queue and dryRun can't be combined")
+	}
+	args.Queue = false
+	args.Prompt, args.Messages, args.PromptFile, args.Template, args.Variables = "", nil, "", "", nil
+	args.Context, args.Code, args.Attachments, args.Title, args.Tags = "", nil, nil, "", nil
+	args.IncludeGitDiff, args.Cwd, args.IncludeSystemInfo = nil, "", false
+	h := queuedHandoff{Time: time.Now(), Tool: params.Name, Args: args, Prompt: a}
+	pos, err := queue.push(h)
+	if err != nil {
+		return toolError(err.Error())
+	}
+	text := fmt.Sprintf("Queued %q for %s at position %d. Nothing was copied or opened yet; call next_handoff when the user is ready for it.", h.title(), target.Label, pos)
+	for _, w := range a.Warnings {
+		text += "\nNote: " + w
+	}
+	return &mcp.CallToolResultFor[any]{
+		Content:           []mcp.Content{&mcp.TextContent{Text: text}},
+		StructuredContent: &QueueResult{Queued: true, Position: pos, MaxDepth: queueDepth, Title: h.title(), Target: target.Name},
+	}
+}
+
+// nextHandoffMu keeps two next_handoff calls from delivering the same
+// entry.
+var nextHandoffMu sync.Mutex
+
+// handleNextHandoff delivers the oldest queued handoff as if it had just
+// been made, and removes it only once that succeeds. An empty queue is
+// reported, not an error.
+func handleNextHandoff(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[struct{}]) (*mcp.CallToolResultFor[any], error) {
+	nextHandoffMu.Lock()
+	defer nextHandoffMu.Unlock()
+	h, remaining, ok := queue.peek()
+	if !ok {
+		return &mcp.CallToolResultFor[any]{
+			Content: []mcp.Content{&mcp.TextContent{Text: "The handoff queue is empty; there is nothing to deliver."}},
+		}, nil
+	}
+	call := &mcp.CallToolParamsFor[HandoffArgs]{Name: h.Tool, Arguments: h.Args}
+	var result *mcp.CallToolResultFor[any]
+	var err error
+	if h.Prompt != nil {
+		result, err = deliverHandoff(ctx, ss, call, h.Prompt)
+	} else {
+		result, err = handleHandoff(ctx, ss, call)
+	}
+	if err != nil || result == nil {
+		return result, err
+	}
+	note := fmt.Sprintf("Queued handoff %q failed and stays first in the queue; call next_handoff to retry it, or clear_handoffs to drop it. %d more behind it.", h.title(), remaining)
+	if !result.IsError {
+		queue.drop(h)
+		note = fmt.Sprintf("Delivered queued handoff %q (queued %s ago); %d more in the queue.", h.title(), time.Since(h.Time).Round(time.Second), remaining)
+	}
+	result.Content = append(result.Content, &mcp.TextContent{Text: note})
+	return result, nil
+}
+
+// QueuedHandoffSummary is one entry of list_handoff_queue.
+type QueuedHandoffSummary struct {
+	Position int       `json:"position"`
+	Time     time.Time `json:"time"`
+	Title    string    `json:"title"`
+	Target   string    `json:"target"`
+	Tool     string    `json:"tool"`
+}
+
+// ListQueueResult is the structured content of list_handoff_queue.
+type ListQueueResult struct {
+	Queue    []QueuedHandoffSummary `json:"queue"`
+	MaxDepth int                    `json:"maxDepth"`
+}
+
+func handleListQueue(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[struct{}]) (*mcp.CallToolResultFor[any], error) {
+	entries := queue.list()
+	result := &ListQueueResult{Queue: []QueuedHandoffSummary{}, MaxDepth: queueDepth}
+	var b strings.Builder
+	if len(entries) == 0 {
+		b.WriteString("The handoff queue is empty.")
+	} else {
+		fmt.Fprintf(&b, "%d of %d queued handoffs, next first:\n", len(entries), queueDepth)
+		tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "POS\tQUEUED\tTARGET\tTITLE")
+		for i, h := range entries {
+			s := QueuedHandoffSummary{Position: i + 1, Time: h.Time, Title: h.title(), Target: cmp.Or(h.Args.Target, DEFAULT_TARGET), Tool: h.Tool}
+			result.Queue = append(result.Queue, s)
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", s.Position, s.Time.Format(time.DateTime), s.Target, s.Title)
+		}
+		tw.Flush()
+	}
+	return &mcp.CallToolResultFor[any]{
+		Content:           []mcp.Content{&mcp.TextContent{Text: b.String()}},
+		StructuredContent: result,
+	}, nil
+}
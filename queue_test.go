@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// withQueue gives the test an empty queue saved under a temporary
+// history path, and returns the queue file.
+func withQueue(t *testing.T) string {
+	t.Helper()
+	old := queue
+	t.Cleanup(func() { queue = old })
+	queue = &handoffQueue{}
+	if err := queue.load(filepath.Join(t.TempDir(), "history.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+	return queue.path
+}
+
+func queueHandoff(t *testing.T, ctx context.Context, args HandoffArgs) *mcp.CallToolResultFor[any] {
+	t.Helper()
+	args.Queue = true
+	res, err := handleHandoff(ctx, nil, &mcp.CallToolParamsFor[HandoffArgs]{Name: "handoff_to_chatgpt", Arguments: args})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func nextHandoff(t *testing.T) *mcp.CallToolResultFor[any] {
+	t.Helper()
+	res, err := handleNextHandoff(context.Background(), nil, &mcp.CallToolParamsFor[struct{}]{Name: "next_handoff"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestQueueRedactsBeforeSaving(t *testing.T) {
+	withFileClipboard(t)
+	path := withQueue(t)
+	secret := "sk-" + strings.Repeat("a1B2", 8)
+	redact, openChat := true, false
+	res := queueHandoff(t, context.Background(), HandoffArgs{
+		Prompt: "why does this key fail: " + secret, Redact: &redact, OpenChat: &openChat, ClipboardBackend: "file",
+	})
+	if res.IsError {
+		t.Fatalf("queueing failed: %v", res.Content)
+	}
+	if text := res.Content[0].(*mcp.TextContent).Text; !strings.Contains(text, "redacted secrets") {
+		t.Errorf("the queue result doesn't mention the redaction: %q", text)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), secret) {
+		t.Errorf("the queue file holds the secret: %s", data)
+	}
+	if !strings.Contains(string(data), "[REDACTED:openai-key]") {
+		t.Errorf("the queue file doesn't hold the redacted prompt: %s", data)
+	}
+
+	if res := nextHandoff(t); res.IsError {
+		t.Fatalf("next_handoff failed: %v", res.Content)
+	}
+	copied, err := os.ReadFile(fallbackFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(copied), secret) || !strings.Contains(string(copied), "[REDACTED:openai-key]") {
+		t.Errorf("delivered %q, want the redacted prompt", copied)
+	}
+	if n := len(queue.list()); n != 0 {
+		t.Errorf("%d entries left after delivery, want 0", n)
+	}
+}
+
+func TestQueueValidatesBeforeSaving(t *testing.T) {
+	withFileClipboard(t)
+	withQueue(t)
+	ctx := context.WithValue(context.Background(), settingsKey{}, &reloadableSettings{MaxPromptLength: 100})
+	for name, args := range map[string]HandoffArgs{
+		"too long":       {Prompt: strings.Repeat("long ", 50)},
+		"empty":          {Prompt: " "},
+		"long format":    {Prompt: "hi", ExpectedFormat: strings.Repeat("x", MAX_EXPECTED_FORMAT_LENGTH+1)},
+		"unknown target": {Prompt: "hi", Target: "nowhere"},
+		"bad deliver":    {Prompt: "hi", Deliver: "pigeon"},
+	} {
+		if res := queueHandoff(t, ctx, args); !res.IsError {
+			t.Errorf("%s: queued, want an error", name)
+		}
+	}
+	if n := len(queue.list()); n != 0 {
+		t.Errorf("%d invalid handoffs were queued", n)
+	}
+}
+
+func TestQueueKeepsFailedHandoff(t *testing.T) {
+	withFileClipboard(t)
+	withQueue(t)
+	old := webhookURL
+	t.Cleanup(func() { webhookURL = old })
+	webhookURL = "http://127.0.0.1:1/hook"
+	if res := queueHandoff(t, context.Background(), HandoffArgs{Prompt: "hi", Deliver: "webhook"}); res.IsError {
+		t.Fatalf("queueing failed: %v", res.Content)
+	}
+
+	// The server was restarted without the webhook, so delivery fails.
+	webhookURL = ""
+	res := nextHandoff(t)
+	if !res.IsError {
+		t.Fatalf("next_handoff succeeded: %v", res.Content)
+	}
+	if n := len(queue.list()); n != 1 {
+		t.Errorf("%d entries after a failed delivery, want the handoff kept", n)
+	}
+}
+
+func TestClearHandoffsClearsQueue(t *testing.T) {
+	withFileClipboard(t)
+	path := withQueue(t)
+	old := history
+	t.Cleanup(func() { history = old })
+	history = &handoffHistory{nextID: 1}
+	for _, prompt := range []string{"first", "second"} {
+		if res := queueHandoff(t, context.Background(), HandoffArgs{Prompt: prompt}); res.IsError {
+			t.Fatalf("queueing failed: %v", res.Content)
+		}
+	}
+	res, err := handleClearHandoffs(context.Background(), nil, &mcp.CallToolParamsFor[ClearHandoffsArgs]{Name: "clear_handoffs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := res.Content[0].(*mcp.TextContent).Text; !strings.Contains(text, "2 queued handoff(s)") {
+		t.Errorf("clear_handoffs said %q", text)
+	}
+	if n := len(queue.list()); n != 0 {
+		t.Errorf("%d entries left after clear_handoffs", n)
+	}
+	if data, err := os.ReadFile(path); err != nil || strings.TrimSpace(string(data)) != "[]" {
+		t.Errorf("queue file = %q, %v; want an empty list", data, err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// redactionPattern finds one kind of secret. When the expression has a
+// group named "secret", only that group is replaced, so e.g. the header
+// name of an Authorization header stays readable.
+type redactionPattern struct {
+	Name string
+	Re   *regexp.Regexp
+}
+
+// builtinRedactions are checked in order, so PEM blocks are replaced
+// before the keys that may appear inside them.
+var builtinRedactions = []redactionPattern{
+	{"private-key", regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z0-9 ]*PRIVATE KEY-----`)},
+	{"aws-access-key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"github-token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{"openai-key", regexp.MustCompile(`\bsk-(?:proj-|svcacct-|admin-)?[A-Za-z0-9_-]{20,}`)},
+	{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{5,}\.eyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{10,}`)},
+	{"authorization", regexp.MustCompile(`(?i)\bauthorization:[ \t]*(?:(?:bearer|basic|token)[ \t]+)?(?P<secret>[^\s\["'][^\s"']*)`)},
+}
+
+var (
+	// redactSecrets turns redaction on for every handoff (--redact-secrets);
+	// the redact argument overrides it per call.
+	redactSecrets = false
+	// customRedactions are the --redact-pattern NAME=REGEX expressions,
+	// checked after the built-in ones.
+	customRedactions []redactionPattern
+)
+
+// parseRedactionPattern parses a --redact-pattern value.
+func parseRedactionPattern(value string) (redactionPattern, error) {
+	name, expr, ok := strings.Cut(value, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || expr == "" {
+		return redactionPattern{}, errors.New("expected NAME=REGEX")
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return redactionPattern{}, err
+	}
+	return redactionPattern{Name: name, Re: re}, nil
+}
+
+// redact replaces the secrets in text with [REDACTED:<type>] and counts
+// them by type.
+func redact(text string, counts map[string]int) string {
+	for _, p := range slices.Concat(builtinRedactions, customRedactions) {
+		group := p.Re.SubexpIndex("secret")
+		var b strings.Builder
+		last := 0
+		for _, loc := range p.Re.FindAllStringSubmatchIndex(text, -1) {
+			start, end := loc[0], loc[1]
+			if group >= 0 && loc[2*group] >= 0 {
+				start, end = loc[2*group], loc[2*group+1]
+			}
+			b.WriteString(text[last:start])
+			b.WriteString("[REDACTED:" + p.Name + "]")
+			last = end
+			counts[p.Name]++
+		}
+		b.WriteString(text[last:])
+		text = b.String()
+	}
+	return text
+}
+
+// describeRedactions renders counts as "2 aws-access-key, 1 jwt".
+func describeRedactions(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%d %s", counts[name], name)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The relay is a one-shot localhost page that shows a prompt too long to
+// deeplink, with a Copy button and a link to the assistant. It lets the
+// browser open automatically even when the deeplink can't.
+
+const RELAY_TTL = 5 * time.Minute
+
+// Relay is a stored prompt waiting to be picked up by the browser.
+type Relay struct {
+	URL     string
+	Expires time.Time
+}
+
+type relayEntry struct {
+	Prompt  string
+	Label   string
+	ChatURL string
+	expires time.Time
+}
+
+var relays = struct {
+	sync.Mutex
+	m map[string]relayEntry
+}{m: map[string]relayEntry{}}
+
+var (
+	relayListenOnce sync.Once
+	relayListenAddr string
+	relayListenErr  error
+)
+
+// relayBaseURL returns the origin relay pages are served from: the HTTP
+// server in --http mode, otherwise a localhost listener started on first
+// use.
+func relayBaseURL() (string, error) {
+	if httpMode {
+		return fmt.Sprintf("http://127.0.0.1:%d", httpPort), nil
+	}
+	relayListenOnce.Do(func() {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			relayListenErr = err
+			return
+		}
+		relayListenAddr = ln.Addr().String()
+		mux := http.NewServeMux()
+		registerRelayHandler(mux)
+		go func() {
+			if err := http.Serve(ln, mux); err != nil {
+				slog.Warn("relay listener stopped", "error", err)
+			}
+		}()
+		slog.Debug("started relay listener", "addr", relayListenAddr)
+	})
+	if relayListenErr != nil {
+		return "", fmt.Errorf("starting relay listener: %w", relayListenErr)
+	}
+	return "http://" + relayListenAddr, nil
+}
+
+// newRelay stores prompt under a single-use token and returns the page URL.
+func newRelay(prompt, label, chatURL string) (Relay, error) {
+	origin, err := relayBaseURL()
+	if err != nil {
+		return Relay{}, err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return Relay{}, err
+	}
+	token := hex.EncodeToString(b)
+
+	now := time.Now()
+	expires := now.Add(RELAY_TTL)
+	relays.Lock()
+	for t, e := range relays.m {
+		if now.After(e.expires) {
+			delete(relays.m, t)
+		}
+	}
+	relays.m[token] = relayEntry{Prompt: prompt, Label: label, ChatURL: chatURL, expires: expires}
+	relays.Unlock()
+
+	return Relay{URL: origin + "/relay/" + token, Expires: expires}, nil
+}
+
+// takeRelay returns and forgets the entry for token, so each page can be
+// loaded only once.
+func takeRelay(token string) (relayEntry, bool) {
+	relays.Lock()
+	defer relays.Unlock()
+	e, ok := relays.m[token]
+	delete(relays.m, token)
+	if !ok || time.Now().After(e.expires) {
+		return relayEntry{}, false
+	}
+	return e, true
+}
+
+func registerRelayHandler(mux *http.ServeMux) {
+	mux.HandleFunc("/relay/", func(w http.ResponseWriter, r *http.Request) {
+		e, ok := takeRelay(strings.TrimPrefix(r.URL.Path, "/relay/"))
+		if !ok {
+			http.Error(w, "This handoff link has expired or was already used.", http.StatusNotFound)
+			return
+		}
+		// Inline script and style only; the page must not reach the network.
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := relayPage.Execute(w, e); err != nil {
+			slog.Warn("rendering relay page", "error", err)
+		}
+	})
+}
+
+var relayPage = template.Must(template.New("relay").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Handoff to {{.Label}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 50em; margin: 2em auto; padding: 0 1em; }
+pre { white-space: pre-wrap; word-wrap: break-word; background: #f4f4f4; padding: 1em; border-radius: 6px; max-height: 60vh; overflow: auto; }
+button, a.button { font-size: 1em; padding: .5em 1em; margin-right: .5em; }
+</style>
+</head>
+<body>
+<h1>Handoff to {{.Label}}</h1>
+<p>This prompt is too long to prefill. Copy it, then paste it into a new chat.</p>
+<p>
+<button id="copy">Copy</button>
+<a class="button" href="{{.ChatURL}}" rel="noreferrer">Open {{.Label}}</a>
+<span id="status"></span>
+</p>
+<pre id="prompt">{{.Prompt}}</pre>
+<script>
+document.getElementById("copy").addEventListener("click", async () => {
+  const status = document.getElementById("status");
+  try {
+    await navigator.clipboard.writeText(document.getElementById("prompt").textContent);
+    status.textContent = "Copied.";
+  } catch (e) {
+    status.textContent = "Copy failed: " + e;
+  }
+});
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// reloadableSettings are the settings SIGHUP can change while the server
+// runs. Handlers read them through settingsFor, never the globals the
+// flags write, so a request sees one snapshot from start to finish.
+type reloadableSettings struct {
+	MaxPromptLength       int
+	MaxDeeplinkLength     int
+	TokenWarning          int
+	MaxAttachmentBytes    int
+	MaxAttachmentsTotal   int
+	ToolDescription       string
+	ToolDescriptionAppend string
+	HandoffTools          []string
+	LogLevel              slog.Level
+}
+
+// reloadableFlags are the flags behind reloadableSettings.
+var reloadableFlags = []string{
+	"max-prompt-length", "max-deeplink-length", "token-warning",
+	"max-attachment-bytes", "max-attachments-total",
+	"tool-description", "tool-description-append", "tools", "log-level",
+}
+
+// restartFlags name the settings a reload can't change because the
+// server is already listening with them.
+var restartFlags = []string{"http", "port"}
+
+var (
+	liveSettings atomic.Pointer[reloadableSettings]
+
+	// baseSettings are the defaults with the command line and environment
+	// applied, which a reload starts from before applying the config file.
+	// settingsFlags and settingsSet are the flag set resolveSettings used
+	// and the flags the command line or environment set.
+	baseSettings  *reloadableSettings
+	settingsFlags *flag.FlagSet
+	settingsSet   map[string]bool
+
+	// reloadMu serializes config reloads and the re-registration of tools
+	// and prompts that follows a reload.
+	reloadMu sync.Mutex
+)
+
+func captureSettings() *reloadableSettings {
+	return &reloadableSettings{
+		MaxPromptLength:       maxPromptLength,
+		MaxDeeplinkLength:     maxDeeplinkLength,
+		TokenWarning:          tokenWarning,
+		MaxAttachmentBytes:    maxAttachmentBytes,
+		MaxAttachmentsTotal:   maxAttachmentsTotal,
+		ToolDescription:       toolDescription,
+		ToolDescriptionAppend: toolDescriptionAppend,
+		HandoffTools:          slices.Clone(handoffTools),
+		LogLevel:              logLevel.Level(),
+	}
+}
+
+func (s *reloadableSettings) apply() {
+	maxPromptLength = s.MaxPromptLength
+	maxDeeplinkLength = s.MaxDeeplinkLength
+	tokenWarning = s.TokenWarning
+	maxAttachmentBytes = s.MaxAttachmentBytes
+	maxAttachmentsTotal = s.MaxAttachmentsTotal
+	toolDescription = s.ToolDescription
+	toolDescriptionAppend = s.ToolDescriptionAppend
+	handoffTools = slices.Clone(s.HandoffTools)
+	logLevel.Set(s.LogLevel)
+	slog.SetLogLoggerLevel(s.LogLevel)
+}
+
+type settingsKey struct{}
+
+// settingsFor returns the settings pinned to ctx by pinSettings, or the
+// live ones outside a request.
+func settingsFor(ctx context.Context) *reloadableSettings {
+	if s, ok := ctx.Value(settingsKey{}).(*reloadableSettings); ok {
+		return s
+	}
+	if s := liveSettings.Load(); s != nil {
+		return s
+	}
+	return captureSettings()
+}
+
+// pinSettings gives each request the settings live when it arrived.
+func pinSettings(next mcp.MethodHandler[*mcp.ServerSession]) mcp.MethodHandler[*mcp.ServerSession] {
+	return func(ctx context.Context, ss *mcp.ServerSession, method string, params mcp.Params) (mcp.Result, error) {
+		return next(context.WithValue(ctx, settingsKey{}, liveSettings.Load()), ss, method, params)
+	}
+}
+
+// reloadConfig re-reads the config file and publishes the reloadable
+// settings in it. Settings from the command line or environment keep
+// their values; other changed settings are logged as needing a restart.
+// On any error the current settings stay in place.
+func reloadConfig() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	path := configFile
+	if path == "" {
+		var err error
+		if path, err = defaultConfigFile(); err != nil {
+			return err
+		}
+	}
+	values, err := loadConfig(path)
+	if errors.Is(err, os.ErrNotExist) && configFile == "" {
+		values, err = map[string][]string{}, nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	old := liveSettings.Load()
+	baseSettings.apply()
+	copyTool := copyToolEnabled
+	for _, key := range reloadableFlags {
+		list, ok := values[key]
+		if !ok || settingsSet[key] {
+			continue
+		}
+		if err := settingsFlags.Set(key, strings.Join(list, ",")); err != nil {
+			old.apply()
+			copyToolEnabled = copyTool
+			return fmt.Errorf("invalid %s in config file %s: %v", key, path, err)
+		}
+	}
+	if copyToolEnabled != copyTool {
+		slog.Warn("restart to add or remove copy_to_clipboard", "key", "tools")
+		copyToolEnabled = copyTool
+	}
+
+	for _, key := range settingNames() {
+		if key == "config" || slices.Contains(reloadableFlags, key) || settingsSet[key] || slices.Equal(values[key], loadedConfig.values[key]) {
+			continue
+		}
+		if slices.Contains(restartFlags, key) {
+			slog.Warn("not changing the listen address until restart", "key", key)
+		} else {
+			slog.Warn("config setting only changes on restart", "key", key)
+		}
+	}
+
+	liveSettings.Store(captureSettings())
+	loadedConfig.path, loadedConfig.values = path, values
+	return nil
+}
+
+// watchSIGHUP reloads the config file and the prompt templates on SIGHUP
+// and re-registers the tools and prompts when what clients see changed,
+// which notifies them through list_changed.
+func watchSIGHUP(srv *mcp.Server, templatesPath string, explicit bool) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			slog.Info("reloading the config file and prompt templates on SIGHUP")
+			before := visibleSurface()
+			if err := reloadConfig(); err != nil {
+				slog.Warn("keeping the current settings", "error", err)
+			}
+			if templatesPath != "" {
+				if err := loadTemplates(templatesPath, explicit); err != nil {
+					slog.Warn("failed to reload prompt templates", "dir", templatesPath, "error", err)
+				}
+			}
+			if visibleSurface() != before {
+				reregister(srv)
+			}
+		}
+	}()
+}
+
+// reregister rebuilds the template prompts and the handoff tools. The
+// template watcher and the SIGHUP handler run it from their own
+// goroutines, so it holds reloadMu while it swaps the registrations.
+func reregister(srv *mcp.Server) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	registerTemplatePrompts(srv)
+	registerHandoffTools(srv)
+}
+
+// visibleSurface summarizes what the tool and prompt lists are built
+// from, so a reload only notifies clients when one of them changed.
+func visibleSurface() string {
+	s := liveSettings.Load()
+	templatesMu.RLock()
+	defer templatesMu.RUnlock()
+	return fmt.Sprint(s.MaxPromptLength, s.ToolDescription, s.ToolDescriptionAppend, s.HandoffTools, templates)
+}
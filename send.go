@@ -0,0 +1,182 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// The send subcommand is one handoff from the shell through the same
+// handleHandoff pipeline MCP clients use. sendArgs collects its flags,
+// sendFile is -f and sendPromptArgs are the words of the prompt.
+var (
+	sendArgs       HandoffArgs
+	sendFile       = ""
+	sendPromptArgs []string
+)
+
+// sendOnlyFlags are registered for send only and never read from the
+// environment or config file.
+var sendOnlyFlags = []string{"f", "target", "model", "title", "tag", "template", "var", "deliver", "dry-run", "no-deeplink"}
+
+// registerSendFlags adds send's own flags.
+func registerSendFlags(fs *flag.FlagSet) {
+	fs.StringVar(&sendFile, "f", "", "read the prompt from this `file`")
+	fs.StringVar(&sendArgs.Target, "target", "", "assistant to hand off to: chatgpt (default), claude, gemini, perplexity or grok")
+	fs.StringVar(&sendArgs.Model, "model", "", "ChatGPT model `slug` to open the chat with")
+	fs.StringVar(&sendArgs.Title, "title", "", "`title` for the history")
+	fs.Func("tag", "`tag` for the history (repeatable)", func(s string) error {
+		sendArgs.Tags = append(sendArgs.Tags, s)
+		return nil
+	})
+	fs.StringVar(&sendArgs.Template, "template", "", "render this prompt template `name` instead of a prompt")
+	fs.Func("var", "template variable as `name=value` (repeatable)", func(s string) error {
+		name, value, ok := strings.Cut(s, "=")
+		if !ok || name == "" {
+			return errors.New("expected name=value")
+		}
+		if sendArgs.Variables == nil {
+			sendArgs.Variables = map[string]string{}
+		}
+		sendArgs.Variables[name] = value
+		return nil
+	})
+	fs.StringVar(&sendArgs.Deliver, "deliver", "", "`mode`: open (default), copy-link, webhook, slack or email")
+	fs.BoolVar(&sendArgs.DryRun, "dry-run", false, "show what would be copied and opened without doing it")
+	fs.Var(boolFunc(func() { sendArgs.OpenChat = new(bool) }), "no-deeplink", "only copy the prompt, without opening a chat")
+}
+
+// boolFunc is a switch flag that calls the function when turned on.
+type boolFunc func()
+
+func (f boolFunc) String() string   { return "false" }
+func (f boolFunc) IsBoolFlag() bool { return true }
+func (f boolFunc) Set(s string) error {
+	if s == "true" {
+		f()
+	}
+	return nil
+}
+
+// sendPrompt fills in the prompt from the arguments, -f or stdin ("-").
+func sendPrompt(args []string, stdin io.Reader) error {
+	switch {
+	case sendFile != "" && len(args) > 0:
+		return errors.New("pass either a prompt or -f, not both")
+	case sendFile != "":
+		text, err := readTextFile(sendFile, settingsFor(context.Background()).MaxAttachmentBytes)
+		if err != nil {
+			return err
+		}
+		sendArgs.Prompt = text
+	case len(args) == 1 && args[0] == "-":
+		data, err := io.ReadAll(io.LimitReader(stdin, int64(settingsFor(context.Background()).MaxPromptLength)+1))
+		if err != nil {
+			return err
+		}
+		sendArgs.Prompt = strings.ReplaceAll(string(data), "\r\n", "\n")
+	case len(args) > 0:
+		sendArgs.Prompt = strings.Join(args, " ")
+	case sendArgs.Template == "":
+		return errors.New("nothing to send: pass a prompt, - to read it from stdin, -f FILE, or --template")
+	}
+	return nil
+}
+
+// send runs the handoff and prints the outcome, as text or with --json as
+// the tool's structured result. It returns the exit status.
+func send(args []string) int {
+	if err := sendPrompt(args, os.Stdin); err != nil {
+		fmt.Fprintf(os.Stderr, "send: %v\n", err)
+		return 2
+	}
+	res, err := handleHandoff(context.Background(), nil, &mcp.CallToolParamsFor[HandoffArgs]{Arguments: sendArgs})
+	if err != nil {
+		res = toolError(err.Error())
+	}
+	return printToolResult(res, func(sc any) string {
+		if r, ok := sc.(*HandoffResult); ok && !r.DryRun {
+			return sendSummary(r)
+		}
+		return ""
+	})
+}
+
+// printToolResult prints a tool result for the shell: with --json its
+// structured content, otherwise summary's text for it when that isn't
+// empty, or the tool's text. Errors go to stderr. It returns the exit
+// status.
+func printToolResult(res *mcp.CallToolResultFor[any], summary func(any) string) int {
+	var text []string
+	for _, c := range res.Content {
+		if t, ok := c.(*mcp.TextContent); ok {
+			text = append(text, t.Text)
+		}
+	}
+	out := strings.Join(text, "\n\n") + "\n"
+	switch {
+	case jsonOutput:
+		v := res.StructuredContent
+		if res.IsError || v == nil {
+			v = map[string]any{"error": res.IsError, "text": strings.Join(text, "\n\n")}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+	case res.IsError:
+		fmt.Fprint(os.Stderr, out)
+	default:
+		if summary != nil {
+			out = cmp.Or(summary(res.StructuredContent), out)
+		}
+		fmt.Print(out)
+	}
+	if res.IsError {
+		return 1
+	}
+	return 0
+}
+
+// sendSummary describes a handoff for the person at the shell; the tool's
+// own text is addressed to an agent.
+func sendSummary(r *HandoffResult) string {
+	var b strings.Builder
+	if r.Title != "" {
+		fmt.Fprintf(&b, "Handed off to %s: %s\n", r.Host, r.Title)
+	}
+	switch {
+	case r.ClipboardBackend != "":
+		fmt.Fprintf(&b, "Clipboard: copied the %s (%s)\n", r.ClipboardContent, r.ClipboardBackend)
+	case r.FallbackFile != "":
+		fmt.Fprintf(&b, "Clipboard: unavailable, wrote the prompt to %s\n", r.FallbackFile)
+	}
+	for _, d := range []struct{ name, status string }{{"Webhook", r.Webhook}, {"Slack", r.Slack}} {
+		if d.status != "" {
+			fmt.Fprintf(&b, "%s: %s\n", d.name, d.status)
+		}
+	}
+	if len(r.EmailedTo) > 0 {
+		fmt.Fprintf(&b, "Email: sent to %s\n", strings.Join(r.EmailedTo, ", "))
+	}
+	switch r.DeeplinkStatus {
+	case "opened", "preview", "focused-existing":
+		fmt.Fprintf(&b, "Chat: %s (%s)\n", r.DeeplinkStatus, cmp.Or(r.OpenedTarget, "browser"))
+	default:
+		fmt.Fprintf(&b, "Chat: not opened, %s\n", cmp.Or(r.DeeplinkReason, r.DeeplinkError, r.DeeplinkStatus))
+		if r.Deeplink != "" {
+			fmt.Fprintf(&b, "Link: %s\n", r.Deeplink)
+		}
+	}
+	for _, w := range r.Warnings {
+		fmt.Fprintf(&b, "Note: %s\n", w)
+	}
+	return b.String()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// SLACK_PAYLOAD_LIMIT stays under the 40KB Slack accepts for a message.
+	SLACK_PAYLOAD_LIMIT = 39000
+	// SLACK_SECTION_LIMIT is the longest text a section block may hold.
+	SLACK_SECTION_LIMIT = 3000
+	// SLACK_MAX_BLOCKS is Slack's limit on blocks per message.
+	SLACK_MAX_BLOCKS = 50
+	// SLACK_HEADER_LIMIT is the longest text a header block may hold.
+	SLACK_HEADER_LIMIT = 150
+)
+
+// slackWebhookURL is the incoming webhook for deliver: slack
+// (--slack-webhook-url).
+var slackWebhookURL = ""
+
+// remoteDelivery reports whether deliver posts the handoff elsewhere
+// instead of copying it here, which also rules out opening a chat.
+func remoteDelivery(deliver string) bool {
+	return deliver == "webhook" || deliver == "slack" || deliver == "email"
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackBlock struct {
+	Type     string         `json:"type"`
+	Text     *slackText     `json:"text,omitempty"`
+	Elements []slackElement `json:"elements,omitempty"`
+}
+
+// slackElement is a button in an actions block or text in a context
+// block.
+type slackElement struct {
+	Type string `json:"type"`
+	// Text is a slackText for a button and a plain string in a context
+	// block.
+	Text any    `json:"text"`
+	URL  string `json:"url,omitempty"`
+}
+
+type slackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+// slackCodeSections splits prompt into code block sections that each fit
+// a section block. The characters Slack's mrkdwn treats as markup are
+// escaped, and runs of backticks are broken up with zero-width spaces so
+// the prompt can't end its code block early.
+func slackCodeSections(prompt string) []string {
+	const fence = "```\n"
+	limit := SLACK_SECTION_LIMIT - len(fence) - len("\n```")
+	var sections []string
+	var cur strings.Builder
+	n := 0
+	var prev rune
+	for _, r := range prompt {
+		var esc string
+		switch r {
+		case '&':
+			esc = "&amp;"
+		case '<':
+			esc = "&lt;"
+		case '>':
+			esc = "&gt;"
+		case '`':
+			esc = "`"
+			if prev == '`' {
+				esc = "\u200b`"
+			}
+		default:
+			esc = string(r)
+		}
+		prev = r
+		if n+utf8.RuneCountInString(esc) > limit {
+			sections = append(sections, cur.String())
+			cur.Reset()
+			n = 0
+		}
+		cur.WriteString(esc)
+		n += utf8.RuneCountInString(esc)
+	}
+	if cur.Len() > 0 {
+		sections = append(sections, cur.String())
+	}
+	for i, s := range sections {
+		sections[i] = fence + s + "\n```"
+	}
+	return sections
+}
+
+// buildSlackMessage formats a handoff as a Slack message: the title, the
+// prompt in code blocks, and a button opening the deeplink. When the prompt
+// doesn't fit Slack's size limits it is cut off with a note, and truncated
+// is set.
+func buildSlackMessage(title, prompt, deeplink, label string) (payload []byte, truncated bool, err error) {
+	msg := slackMessage{Text: "Handoff: " + title}
+	msg.Blocks = append(msg.Blocks, slackBlock{Type: "header", Text: &slackText{Type: "plain_text", Text: clipLine(title, SLACK_HEADER_LIMIT)}})
+
+	var tail []slackBlock
+	if deeplink != "" && len(deeplink) <= SLACK_SECTION_LIMIT {
+		tail = append(tail, slackBlock{Type: "actions", Elements: []slackElement{{
+			Type: "button",
+			Text: slackText{Type: "plain_text", Text: "Open in " + label},
+			URL:  deeplink,
+		}}})
+	} else {
+		tail = append(tail, slackBlock{Type: "context", Elements: []slackElement{{
+			Type: "mrkdwn",
+			Text: "No link to open it with: copy the prompt into " + label + ".",
+		}}})
+	}
+
+	size := func(blocks []slackBlock) int {
+		data, _ := json.Marshal(slackMessage{Text: msg.Text, Blocks: blocks})
+		return len(data)
+	}
+	sections := slackCodeSections(prompt)
+	shown := 0
+	for _, s := range sections {
+		next := append(msg.Blocks, slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: s}})
+		// Leave room for the tail and a truncation note.
+		if len(next)+len(tail)+1 > SLACK_MAX_BLOCKS || size(append(next, tail...))+SLACK_SECTION_LIMIT/10 > SLACK_PAYLOAD_LIMIT {
+			truncated = true
+			break
+		}
+		msg.Blocks = next
+		shown++
+	}
+	if truncated {
+		msg.Blocks = append(msg.Blocks, slackBlock{Type: "context", Elements: []slackElement{{
+			Type: "mrkdwn",
+			Text: fmt.Sprintf("…truncated: %d of %d parts shown, the prompt is over Slack's message size limit.", shown, len(sections)),
+		}}})
+	}
+	msg.Blocks = append(msg.Blocks, tail...)
+	payload, err = json.Marshal(msg)
+	return payload, truncated, err
+}
+
+// postSlack delivers a handoff to --slack-webhook-url.
+func postSlack(ctx context.Context, title, prompt, deeplink, label string) (bool, error) {
+	payload, truncated, err := buildSlackMessage(title, prompt, deeplink, label)
+	if err != nil {
+		return false, err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	_, err = postWithRetry(ctx, slackWebhookURL, header, payload)
+	return truncated, err
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+var (
+	// soundEnabled plays a cue once the prompt is on the clipboard
+	// (--sound), and errorSoundEnabled a different one when the copy
+	// fails (--error-sound).
+	soundEnabled      = false
+	errorSoundEnabled = false
+	// soundCommand and errorSoundCommand replace the platform's default
+	// player (--sound-cmd, --error-sound-cmd); they run without a shell.
+	soundCommand      = ""
+	errorSoundCommand = ""
+)
+
+// defaultSoundCommands lists the players to try for a cue, in order.
+func defaultSoundCommands(failure bool) [][]string {
+	switch runtime.GOOS {
+	case "darwin":
+		sound := "Glass"
+		if failure {
+			sound = "Basso"
+		}
+		return [][]string{{"afplay", "/System/Library/Sounds/" + sound + ".aiff"}}
+	case "windows":
+		beep := "[console]::beep(880,150)"
+		if failure {
+			beep = "[console]::beep(220,400)"
+		}
+		return [][]string{{"powershell", "-NoProfile", "-Command", beep}}
+	default:
+		id := "complete"
+		if failure {
+			id = "dialog-error"
+		}
+		return [][]string{
+			{"canberra-gtk-play", "-i", id},
+			{"paplay", "/usr/share/sounds/freedesktop/stereo/" + id + ".oga"},
+		}
+	}
+}
+
+// playSound plays the success or failure cue in the background. Failures
+// are only logged.
+func playSound(failure bool) {
+	enabled, custom := soundEnabled, soundCommand
+	if failure {
+		enabled, custom = errorSoundEnabled, errorSoundCommand
+	}
+	if !enabled {
+		return
+	}
+	go func() {
+		if err := runSound(context.Background(), custom, failure); err != nil {
+			slog.Warn("failed to play sound", "error", err)
+		}
+	}()
+}
+
+func runSound(ctx context.Context, custom string, failure bool) error {
+	if fields := strings.Fields(custom); len(fields) > 0 {
+		return runCommand(ctx, 1, exec.Command(fields[0], fields[1:]...))
+	}
+	var errs []error
+	for _, command := range defaultSoundCommands(failure) {
+		if _, err := exec.LookPath(command[0]); err != nil {
+			continue
+		}
+		err := runCommand(ctx, 1, exec.Command(command[0], command[1:]...))
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	if runtime.GOOS == "linux" {
+		// No player worked: ring the terminal bell, if there is a terminal.
+		// Stdout carries the MCP protocol, so it can't be used.
+		tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+		if err != nil {
+			return errors.Join(append(errs, errors.New("no sound player found and no terminal for the bell"))...)
+		}
+		defer tty.Close()
+		_, err = tty.WriteString("\a")
+		return err
+	}
+	if len(errs) == 0 {
+		return errors.New("no sound player found")
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// handoffCounters counts handoff outcomes since the server started. They
+// cover what the history cannot: failed clipboard copies never produce a
+// record, and history may be disabled. handoff_stats and /metrics both
+// read from here.
+type handoffCounters struct {
+	mu               sync.Mutex
+	started          time.Time
+	handoffs         int
+	clipboardFailed  int
+	clipboardBackend map[string]int
+	remote           map[string]int
+	deeplinkStatus   map[string]int
+}
+
+var counters = &handoffCounters{
+	started:          time.Now(),
+	clipboardBackend: map[string]int{},
+	remote:           map[string]int{},
+	deeplinkStatus:   map[string]int{},
+}
+
+func (c *handoffCounters) clipboardFailure() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clipboardFailed++
+}
+
+// handoff counts a handoff whose prompt reached the clipboard.
+func (c *handoffCounters) handoff(backend, status string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handoffs++
+	c.clipboardBackend[backend]++
+	c.deeplinkStatus[status]++
+}
+
+// remoteHandoff counts a handoff delivered without the clipboard: through
+// the webhook, Slack, email or the OpenAI API.
+func (c *handoffCounters) remoteHandoff(channel, status string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handoffs++
+	c.remote[channel]++
+	c.deeplinkStatus[status]++
+}
+
+// CounterSnapshot is a copy of handoffCounters.
+type CounterSnapshot struct {
+	Started          time.Time      `json:"started"`
+	Handoffs         int            `json:"handoffs"`
+	ClipboardFailed  int            `json:"clipboardFailed"`
+	ClipboardBackend map[string]int `json:"clipboardBackends"`
+	Remote           map[string]int `json:"remoteDeliveries"`
+	DeeplinkStatus   map[string]int `json:"deeplinkStatuses"`
+}
+
+// clipboardCopies is how many handoffs reached the clipboard.
+func (c CounterSnapshot) clipboardCopies() int {
+	n := 0
+	for _, copies := range c.ClipboardBackend {
+		n += copies
+	}
+	return n
+}
+
+func (c *handoffCounters) snapshot() CounterSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CounterSnapshot{
+		Started:          c.started,
+		Handoffs:         c.handoffs,
+		ClipboardFailed:  c.clipboardFailed,
+		ClipboardBackend: maps.Clone(c.clipboardBackend),
+		Remote:           maps.Clone(c.remote),
+		DeeplinkStatus:   maps.Clone(c.deeplinkStatus),
+	}
+}
+
+// HandoffStats is the structured content returned by handoff_stats. The
+// totals, prompt lengths, targets and deeplink figures come from the
+// persisted history; clipboard figures come from the counters, since
+// failed copies are not recorded in the history.
+type HandoffStats struct {
+	Today    int `json:"today"`
+	ThisWeek int `json:"thisWeek"`
+	AllTime  int `json:"allTime"`
+
+	ClipboardSucceeded   int       `json:"clipboardSucceeded"`
+	ClipboardFailed      int       `json:"clipboardFailed"`
+	ClipboardSuccessRate float64   `json:"clipboardSuccessRate"`
+	CountersSince        time.Time `json:"countersSince"`
+	// RemoteDeliveries counts, by channel, the handoffs since CountersSince
+	// that never touched the clipboard.
+	RemoteDeliveries map[string]int `json:"remoteDeliveries,omitempty"`
+
+	DeeplinkOpened      int            `json:"deeplinkOpened"`
+	DeeplinkFailed      int            `json:"deeplinkFailed"`
+	DeeplinkSuccessRate float64        `json:"deeplinkSuccessRate"`
+	DeeplinkStatuses    map[string]int `json:"deeplinkStatuses"`
+
+	AveragePromptLength int `json:"averagePromptLength"`
+	P95PromptLength     int `json:"p95PromptLength"`
+
+	TopTarget         string         `json:"topTarget,omitempty"`
+	Targets           map[string]int `json:"targets"`
+	ClipboardBackends map[string]int `json:"clipboardBackends"`
+}
+
+// collectStats combines the counters with a scan of the persisted history.
+// Dry runs, copy_to_clipboard copies and handoffs the user declined under
+// --confirm are left out.
+func collectStats(now time.Time) (*HandoffStats, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	// Weeks start on Monday.
+	week := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+
+	s := &HandoffStats{
+		DeeplinkStatuses:  map[string]int{},
+		Targets:           map[string]int{},
+		ClipboardBackends: map[string]int{},
+	}
+	var lengths []int
+	err := history.scan(func(r HandoffRecord) bool {
+		if r.DryRun || r.Kind == CLIPBOARD_KIND || (r.Confirmation != "" && r.Confirmation != CONFIRM_ACCEPTED) {
+			return true
+		}
+		s.AllTime++
+		if !r.Time.Before(week) {
+			s.ThisWeek++
+		}
+		if !r.Time.Before(today) {
+			s.Today++
+		}
+		s.DeeplinkStatuses[r.DeeplinkStatus]++
+		s.Targets[r.Target]++
+		s.ClipboardBackends[r.ClipboardBackend]++
+		lengths = append(lengths, r.PromptLength)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Opening counts as a success in whatever form the link was used.
+	for status, n := range s.DeeplinkStatuses {
+		switch status {
+		case "opened", "focused-existing", "preview", "filled-cdp":
+			s.DeeplinkOpened += n
+		case "failed":
+			s.DeeplinkFailed += n
+		}
+	}
+	s.DeeplinkSuccessRate = rate(s.DeeplinkOpened, s.DeeplinkFailed)
+
+	if len(lengths) > 0 {
+		slices.Sort(lengths)
+		sum := 0
+		for _, n := range lengths {
+			sum += n
+		}
+		s.AveragePromptLength = sum / len(lengths)
+		s.P95PromptLength = lengths[int(math.Ceil(0.95*float64(len(lengths))))-1]
+	}
+	top := 0
+	for _, t := range slices.Sorted(maps.Keys(s.Targets)) {
+		if s.Targets[t] > top {
+			s.TopTarget, top = t, s.Targets[t]
+		}
+	}
+
+	c := counters.snapshot()
+	s.ClipboardSucceeded, s.ClipboardFailed = c.clipboardCopies(), c.ClipboardFailed
+	s.ClipboardSuccessRate = rate(s.ClipboardSucceeded, c.ClipboardFailed)
+	s.CountersSince = c.Started
+	if len(c.Remote) > 0 {
+		s.RemoteDeliveries = c.Remote
+	}
+	return s, nil
+}
+
+// rate is ok/(ok+failed), or 0 when nothing was attempted.
+func rate(ok, failed int) float64 {
+	if ok+failed == 0 {
+		return 0
+	}
+	return math.Round(float64(ok)/float64(ok+failed)*1000) / 1000
+}
+
+// formatCounts renders a map as "a 3, b 1", largest first.
+func formatCounts(m map[string]int) string {
+	keys := slices.SortedFunc(maps.Keys(m), func(a, b string) int {
+		return cmp.Or(cmp.Compare(m[b], m[a]), strings.Compare(a, b))
+	})
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s %d", k, m[k])
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func handleHandoffStats(ctx context.Context, ss *mcp.ServerSession, params *mcp.CallToolParamsFor[struct{}]) (*mcp.CallToolResultFor[any], error) {
+	s, err := collectStats(time.Now())
+	if err != nil {
+		return toolError("reading history: " + err.Error()), nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Handoffs: %d today, %d this week, %d all time\n", s.Today, s.ThisWeek, s.AllTime)
+	fmt.Fprintf(&b, "Clipboard: %d copied, %d failed since %s (%.1f%% success)\n",
+		s.ClipboardSucceeded, s.ClipboardFailed, s.CountersSince.Format(time.DateTime), s.ClipboardSuccessRate*100)
+	if len(s.RemoteDeliveries) > 0 {
+		fmt.Fprintf(&b, "Delivered without the clipboard: %s\n", formatCounts(s.RemoteDeliveries))
+	}
+	fmt.Fprintf(&b, "Deeplinks: %d opened, %d failed (%.1f%% success); outcomes: %s\n",
+		s.DeeplinkOpened, s.DeeplinkFailed, s.DeeplinkSuccessRate*100, formatCounts(s.DeeplinkStatuses))
+	fmt.Fprintf(&b, "Prompt length: %d average, %d p95\n", s.AveragePromptLength, s.P95PromptLength)
+	fmt.Fprintf(&b, "Targets: %s\n", formatCounts(s.Targets))
+	fmt.Fprintf(&b, "Clipboard backends: %s", formatCounts(s.ClipboardBackends))
+	if noHistory {
+		b.WriteString("\nHistory is disabled (--no-history), so only the clipboard figures are available.")
+	}
+
+	return &mcp.CallToolResultFor[any]{
+		Content:           []mcp.Content{&mcp.TextContent{Text: b.String()}},
+		StructuredContent: s,
+	}, nil
+}
+
+// writeMetrics serves the counters in the Prometheus text format.
+func writeMetrics(w io.Writer) {
+	c := counters.snapshot()
+	fmt.Fprintln(w, "# HELP chatgpt_handoff_handoffs_total Handoffs delivered, by deeplink outcome.")
+	fmt.Fprintln(w, "# TYPE chatgpt_handoff_handoffs_total counter")
+	for _, status := range slices.Sorted(maps.Keys(c.DeeplinkStatus)) {
+		fmt.Fprintf(w, "chatgpt_handoff_handoffs_total{deeplink_status=%q} %d\n", status, c.DeeplinkStatus[status])
+	}
+	fmt.Fprintln(w, "# HELP chatgpt_handoff_clipboard_copies_total Clipboard copies, by backend.")
+	fmt.Fprintln(w, "# TYPE chatgpt_handoff_clipboard_copies_total counter")
+	for _, backend := range slices.Sorted(maps.Keys(c.ClipboardBackend)) {
+		fmt.Fprintf(w, "chatgpt_handoff_clipboard_copies_total{backend=%q} %d\n", backend, c.ClipboardBackend[backend])
+	}
+	fmt.Fprintln(w, "# HELP chatgpt_handoff_remote_deliveries_total Handoffs delivered without the clipboard, by channel.")
+	fmt.Fprintln(w, "# TYPE chatgpt_handoff_remote_deliveries_total counter")
+	for _, channel := range slices.Sorted(maps.Keys(c.Remote)) {
+		fmt.Fprintf(w, "chatgpt_handoff_remote_deliveries_total{channel=%q} %d\n", channel, c.Remote[channel])
+	}
+	fmt.Fprintln(w, "# HELP chatgpt_handoff_clipboard_failures_total Handoffs that failed because no clipboard backend worked.")
+	fmt.Fprintln(w, "# TYPE chatgpt_handoff_clipboard_failures_total counter")
+	fmt.Fprintf(w, "chatgpt_handoff_clipboard_failures_total %d\n", c.ClipboardFailed)
+	fmt.Fprintln(w, "# HELP chatgpt_handoff_start_time_seconds Unix time the server started.")
+	fmt.Fprintln(w, "# TYPE chatgpt_handoff_start_time_seconds gauge")
+	fmt.Fprintf(w, "chatgpt_handoff_start_time_seconds %d\n", c.Started.Unix())
+}
+
+func registerMetricsHandler(mux *http.ServeMux) {
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w)
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// withCounters gives the test fresh handoff counters.
+func withCounters(t *testing.T) {
+	t.Helper()
+	old := counters
+	t.Cleanup(func() { counters = old })
+	counters = &handoffCounters{
+		started:          time.Now(),
+		clipboardBackend: map[string]int{},
+		remote:           map[string]int{},
+		deeplinkStatus:   map[string]int{},
+	}
+}
+
+func TestRemoteDeliveriesAreNotClipboardCopies(t *testing.T) {
+	withFileClipboard(t)
+	withCounters(t)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer hook.Close()
+	old := webhookURL
+	t.Cleanup(func() { webhookURL = old })
+	webhookURL = hook.URL
+
+	for _, args := range []HandoffArgs{
+		{Prompt: "post me", Deliver: "webhook"},
+		{Prompt: "copy me", OpenChat: new(bool)},
+	} {
+		res, err := handleHandoff(context.Background(), nil, &mcp.CallToolParamsFor[HandoffArgs]{Name: "handoff_to_chatgpt", Arguments: args})
+		if err != nil || res.IsError {
+			t.Fatalf("deliver %q: %v, %v", args.Deliver, res.Content, err)
+		}
+	}
+
+	c := counters.snapshot()
+	if c.Handoffs != 2 || c.clipboardCopies() != 1 || c.ClipboardBackend["file"] != 1 || c.Remote["webhook"] != 1 {
+		t.Errorf("counters = %+v; want 2 handoffs, 1 file copy and 1 webhook delivery", c)
+	}
+	if _, ok := c.ClipboardBackend["webhook"]; ok {
+		t.Error("the webhook delivery counted as a clipboard backend")
+	}
+
+	s, err := collectStats(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ClipboardSucceeded != 1 || s.RemoteDeliveries["webhook"] != 1 {
+		t.Errorf("clipboardSucceeded %d, remoteDeliveries %v; want 1 and webhook 1", s.ClipboardSucceeded, s.RemoteDeliveries)
+	}
+
+	var metrics strings.Builder
+	writeMetrics(&metrics)
+	for _, want := range []string{
+		`chatgpt_handoff_clipboard_copies_total{backend="file"} 1`,
+		`chatgpt_handoff_remote_deliveries_total{channel="webhook"} 1`,
+	} {
+		if !strings.Contains(metrics.String(), want) {
+			t.Errorf("metrics lack %s:\n%s", want, metrics.String())
+		}
+	}
+	if strings.Contains(metrics.String(), `backend="webhook"`) {
+		t.Errorf("metrics count the webhook as a clipboard backend:\n%s", metrics.String())
+	}
+}
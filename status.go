@@ -0,0 +1,127 @@
+package main
+
+import "fmt"
+
+// deliveryOutcome is what a handoff actually did, as reported back to the
+// agent: where the text went and whether a chat was opened.
+type deliveryOutcome struct {
+	label       string
+	host        string
+	deliver     string
+	clipContent string
+	backend     string
+	// fallbackFile is set when the text was written to --fallback-file
+	// because no clipboard was available.
+	fallbackFile string
+	verified     *bool
+	status       string
+	deeplinkErr  string
+	skipReason   string
+	// openedBy is the openResult target: desktop-app, browser, cdp,
+	// relay or existing-tab.
+	openedBy string
+	relayURL string
+	chunks   int
+	// webhook is "posted" or "failed" when the handoff went to
+	// --webhook-url.
+	webhook string
+	// slack is "posted" or "truncated" when the handoff went to
+	// --slack-webhook-url.
+	slack string
+	// emailedTo lists the recipients when the handoff was emailed.
+	emailedTo string
+}
+
+// deliveryText describes o in the tool's text reply. It only claims a chat
+// was opened when one was, so the agent doesn't tell the user to look for
+// a window that isn't there.
+func deliveryText(o deliveryOutcome) string {
+	what := "The prompt"
+	switch {
+	case o.chunks > 0:
+		what = fmt.Sprintf("Part 1 of the %d-part prompt", o.chunks)
+	case o.clipContent == "deeplink":
+		what = "The deeplink"
+	}
+
+	var copied string
+	paste := "paste it"
+	switch {
+	case o.deliver == "webhook":
+		copied = what + " was posted to the webhook."
+	case o.slack == "truncated":
+		copied = what + " was posted to Slack, cut off at Slack's message size limit."
+	case o.slack != "":
+		copied = what + " was posted to Slack."
+	case o.emailedTo != "":
+		copied = what + " was emailed to " + o.emailedTo + "."
+	case o.fallbackFile != "":
+		copied = what + " was written to " + o.fallbackFile + " because no clipboard was available."
+		paste = "paste it from that file"
+	case o.verified == nil:
+		copied = what + " is on the clipboard (" + o.backend + ")."
+	case *o.verified:
+		copied = what + " is on the clipboard (" + o.backend + ", checked by reading it back)."
+	default:
+		copied = what + " was copied with " + o.backend + ", but reading the clipboard back gave different text; the user should check it before pasting."
+	}
+
+	var chat string
+	switch o.status {
+	case "opened":
+		in := "the browser"
+		if o.openedBy == "desktop-app" {
+			in = "the desktop app"
+		}
+		chat = "Opened a new " + o.label + " chat in " + in + " with it prefilled."
+	case "preview":
+		chat = "Opened a new " + o.label + " chat with a truncated preview; the user needs to replace it with the full prompt: " + paste + "."
+	case "focused-existing":
+		chat = "Switched to the open " + o.label + " tab instead of opening a new chat; the user needs to " + paste + " there."
+	case "skipped-auto-submit":
+		chat = "Pasted and submitted it in the ChatGPT desktop app."
+	case "failed":
+		chat = "Couldn't open a chat: " + o.deeplinkErr + ". The user needs to open " + o.host + " and " + paste + "."
+	case "filled-cdp":
+		chat = "It is too long for a link, so it was filled into the open " + o.label + " tab in Chrome."
+	case "skipped-too-long":
+		switch o.openedBy {
+		case "relay":
+			chat = "It is too long for a link, so a relay page was opened for the user to copy it from."
+		default:
+			chat = "No chat was opened: " + o.skipReason + ". The user needs to open " + o.host + " and " + paste + "."
+			if o.relayURL != "" {
+				chat = "No chat was opened: " + o.skipReason + ". The user needs to open " + o.host + " and " + paste + ", or copy it from the relay page below."
+			}
+		}
+	case "skipped-duplicate":
+		chat = "No new chat was opened: " + o.skipReason + ", so don't hand it off again."
+	case "skipped-unverified":
+		chat = "No chat was opened, since the clipboard may not hold the prompt. Once the user has checked it, they need to open " + o.host + " and " + paste + "."
+	case "skipped-disabled", "skipped-headless", "skipped-cancelled":
+		chat = "No chat was opened: " + o.skipReason + ". The user needs to open " + o.host + " and " + paste + "."
+	case "skipped-by-request":
+		switch o.deliver {
+		case "copy-link":
+			chat = "Whoever opens it gets a new " + o.label + " chat with the prompt prefilled."
+		case "both":
+			chat = "No chat was opened; the deeplink below opens it prefilled in " + o.label + " and can be shared."
+		case "webhook", "slack", "email":
+			chat = "It wasn't copied and no chat was opened here; whoever receives it can run it in " + o.label + "."
+		default:
+			chat = "No chat was opened, as requested; the user can " + paste + " into the existing chat."
+		}
+	}
+
+	text := copied + " " + chat
+	if o.webhook == "posted" && o.deliver != "webhook" {
+		text += " It was also posted to the webhook."
+	}
+	switch {
+	case o.chunks > 0:
+		text += " Once the user has sent it, call next_handoff_chunk for each following part."
+	case o.deliver == "open":
+		text += " Now you should stop and wait for the user to share " + o.label + "'s response."
+	}
+	return text
+}
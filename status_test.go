@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestDeliveryText(t *testing.T) {
+	yes, no := true, false
+	const wait = " Now you should stop and wait for the user to share ChatGPT's response."
+	base := deliveryOutcome{label: "ChatGPT", host: "chatgpt.com", deliver: "open", clipContent: "prompt", backend: "wl-copy", status: "opened"}
+	tests := []struct {
+		name string
+		edit func(o *deliveryOutcome)
+		want string
+	}{
+		// Where the text went.
+		{"unverified backend", func(o *deliveryOutcome) {},
+			"The prompt is on the clipboard (wl-copy). Opened a new ChatGPT chat in the browser with it prefilled." + wait},
+		{"verified", func(o *deliveryOutcome) { o.verified = &yes },
+			"The prompt is on the clipboard (wl-copy, checked by reading it back). Opened a new ChatGPT chat in the browser with it prefilled." + wait},
+		{"read-back mismatch", func(o *deliveryOutcome) {
+			o.verified, o.status, o.skipReason = &no, "skipped-unverified", "reading the clipboard back gave different text"
+		}, "The prompt was copied with wl-copy, but reading the clipboard back gave different text; the user should check it before pasting. No chat was opened, since the clipboard may not hold the prompt. Once the user has checked it, they need to open chatgpt.com and paste it." + wait},
+		{"fallback file", func(o *deliveryOutcome) {
+			o.backend, o.fallbackFile, o.status, o.skipReason = "file", "/tmp/p.md", "skipped-headless", "no DISPLAY or WAYLAND_DISPLAY set"
+		}, "The prompt was written to /tmp/p.md because no clipboard was available. No chat was opened: no DISPLAY or WAYLAND_DISPLAY set. The user needs to open chatgpt.com and paste it from that file." + wait},
+		{"deeplink copied", func(o *deliveryOutcome) {
+			o.clipContent, o.deliver, o.status = "deeplink", "copy-link", "skipped-by-request"
+		}, "The deeplink is on the clipboard (wl-copy). Whoever opens it gets a new ChatGPT chat with the prompt prefilled."},
+		{"chunked", func(o *deliveryOutcome) { o.chunks = 3 },
+			"Part 1 of the 3-part prompt is on the clipboard (wl-copy). Opened a new ChatGPT chat in the browser with it prefilled. Once the user has sent it, call next_handoff_chunk for each following part."},
+		{"webhook", func(o *deliveryOutcome) { o.deliver, o.status, o.webhook = "webhook", "skipped-by-request", "posted" },
+			"The prompt was posted to the webhook. It wasn't copied and no chat was opened here; whoever receives it can run it in ChatGPT."},
+		{"also posted to the webhook", func(o *deliveryOutcome) { o.webhook = "posted" },
+			"The prompt is on the clipboard (wl-copy). Opened a new ChatGPT chat in the browser with it prefilled. It was also posted to the webhook." + wait},
+		{"slack", func(o *deliveryOutcome) { o.deliver, o.status, o.slack = "slack", "skipped-by-request", "posted" },
+			"The prompt was posted to Slack. It wasn't copied and no chat was opened here; whoever receives it can run it in ChatGPT."},
+		{"slack truncated", func(o *deliveryOutcome) { o.deliver, o.status, o.slack = "slack", "skipped-by-request", "truncated" },
+			"The prompt was posted to Slack, cut off at Slack's message size limit. It wasn't copied and no chat was opened here; whoever receives it can run it in ChatGPT."},
+		{"email", func(o *deliveryOutcome) {
+			o.deliver, o.status, o.emailedTo = "email", "skipped-by-request", "a@example.com, b@example.com"
+		}, "The prompt was emailed to a@example.com, b@example.com. It wasn't copied and no chat was opened here; whoever receives it can run it in ChatGPT."},
+
+		// Whether a chat was opened.
+		{"desktop app", func(o *deliveryOutcome) { o.openedBy = "desktop-app" },
+			"The prompt is on the clipboard (wl-copy). Opened a new ChatGPT chat in the desktop app with it prefilled." + wait},
+		{"preview", func(o *deliveryOutcome) { o.status = "preview" },
+			"The prompt is on the clipboard (wl-copy). Opened a new ChatGPT chat with a truncated preview; the user needs to replace it with the full prompt: paste it." + wait},
+		{"existing tab", func(o *deliveryOutcome) { o.status, o.openedBy = "focused-existing", "existing-tab" },
+			"The prompt is on the clipboard (wl-copy). Switched to the open ChatGPT tab instead of opening a new chat; the user needs to paste it there." + wait},
+		{"auto-submitted", func(o *deliveryOutcome) { o.status = "skipped-auto-submit" },
+			"The prompt is on the clipboard (wl-copy). Pasted and submitted it in the ChatGPT desktop app." + wait},
+		{"failed", func(o *deliveryOutcome) { o.status, o.deeplinkErr = "failed", "no suitable browser found" },
+			"The prompt is on the clipboard (wl-copy). Couldn't open a chat: no suitable browser found. The user needs to open chatgpt.com and paste it." + wait},
+		{"too long", func(o *deliveryOutcome) {
+			o.status, o.skipReason = "skipped-too-long", "the deeplink would be 9000 characters, over the 1800 limit"
+		}, "The prompt is on the clipboard (wl-copy). No chat was opened: the deeplink would be 9000 characters, over the 1800 limit. The user needs to open chatgpt.com and paste it." + wait},
+		{"too long with a relay page", func(o *deliveryOutcome) {
+			o.status, o.skipReason, o.relayURL = "skipped-too-long", "too long", "http://localhost:1/r/x"
+		}, "The prompt is on the clipboard (wl-copy). No chat was opened: too long. The user needs to open chatgpt.com and paste it, or copy it from the relay page below." + wait},
+		{"too long, relay opened", func(o *deliveryOutcome) { o.status, o.openedBy = "skipped-too-long", "relay" },
+			"The prompt is on the clipboard (wl-copy). It is too long for a link, so a relay page was opened for the user to copy it from." + wait},
+		{"too long, filled via DevTools", func(o *deliveryOutcome) { o.status, o.openedBy = "filled-cdp", "cdp" },
+			"The prompt is on the clipboard (wl-copy). It is too long for a link, so it was filled into the open ChatGPT tab in Chrome." + wait},
+		{"duplicate", func(o *deliveryOutcome) {
+			o.status, o.skipReason = "skipped-duplicate", "an identical prompt was already handed off 30 seconds ago (id 4)"
+		}, "The prompt is on the clipboard (wl-copy). No new chat was opened: an identical prompt was already handed off 30 seconds ago (id 4), so don't hand it off again." + wait},
+		{"disabled", func(o *deliveryOutcome) {
+			o.status, o.skipReason = "skipped-disabled", "deeplinks are disabled (max deeplink length 0)"
+		}, "The prompt is on the clipboard (wl-copy). No chat was opened: deeplinks are disabled (max deeplink length 0). The user needs to open chatgpt.com and paste it." + wait},
+		{"cancelled", func(o *deliveryOutcome) {
+			o.status, o.skipReason = "skipped-cancelled", "the request was cancelled before the chat was opened"
+		}, "The prompt is on the clipboard (wl-copy). No chat was opened: the request was cancelled before the chat was opened. The user needs to open chatgpt.com and paste it." + wait},
+		{"openChat false", func(o *deliveryOutcome) { o.status, o.skipReason = "skipped-by-request", "openChat is false" },
+			"The prompt is on the clipboard (wl-copy). No chat was opened, as requested; the user can paste it into the existing chat." + wait},
+		{"both", func(o *deliveryOutcome) { o.deliver, o.status = "both", "skipped-by-request" },
+			"The prompt is on the clipboard (wl-copy). No chat was opened; the deeplink below opens it prefilled in ChatGPT and can be shared."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := base
+			tt.edit(&o)
+			if got := deliveryText(o); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// withMismatchedClipboard adds a clipboard backend whose read-back never
+// matches what it copied.
+func withMismatchedClipboard(t *testing.T) string {
+	t.Helper()
+	const name = "test-mismatch"
+	old := nativeBackends
+	t.Cleanup(func() { nativeBackends = old })
+	nativeBackends = append(nativeBackends[:len(nativeBackends):len(nativeBackends)], clipboardBackend{
+		Name:      name,
+		Available: func() bool { return true },
+		Copy:      func(string) (string, error) { return "", nil },
+		Paste:     func() (string, error) { return "something else", nil },
+	})
+	return name
+}
+
+func TestHandoffSkipsOpenWhenUnverified(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the Windows openers are found without PATH")
+	}
+	withFileClipboard(t)
+	backend := withMismatchedClipboard(t)
+	old := recent
+	t.Cleanup(func() { recent = old })
+	recent = &recentPrompts{now: old.now}
+	// Nothing can be opened if the check is missed.
+	t.Setenv("PATH", "")
+	t.Setenv("DISPLAY", ":0")
+	submit := true
+
+	res, err := handleHandoff(context.Background(), nil, &mcp.CallToolParamsFor[HandoffArgs]{
+		Name:      "handoff_to_chatgpt",
+		Arguments: HandoffArgs{Prompt: "check me", ClipboardBackend: backend, AutoSubmit: &submit},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := res.StructuredContent.(*HandoffResult)
+	if !ok {
+		t.Fatalf("result is %T: %v", res.StructuredContent, res.Content)
+	}
+	if r.ClipboardVerified == nil || *r.ClipboardVerified {
+		t.Errorf("clipboardVerified = %v, want false", r.ClipboardVerified)
+	}
+	if r.DeeplinkStatus != "skipped-unverified" || r.OpenedTarget != "" {
+		t.Errorf("deeplinkStatus %q, openedTarget %q; want skipped-unverified and nothing opened", r.DeeplinkStatus, r.OpenedTarget)
+	}
+	if text := res.Content[0].(*mcp.TextContent).Text; !strings.Contains(text, "No chat was opened") {
+		t.Errorf("the reply doesn't say no chat was opened: %s", text)
+	}
+	if r.Submission == "submitted" || !slices.ContainsFunc(r.Warnings, func(w string) bool {
+		return strings.HasPrefix(w, "auto-submit skipped because reading the clipboard back")
+	}) {
+		t.Errorf("submission %q, warnings %q; want auto-submit skipped for the unverified clipboard", r.Submission, r.Warnings)
+	}
+}
+
+func TestHandoffCancelledDuringDelay(t *testing.T) {
+	withFileClipboard(t)
+	oldDelay, oldHistory, oldRecent := deeplinkDelay, history, recent
+	t.Cleanup(func() { deeplinkDelay, history, recent = oldDelay, oldHistory, oldRecent })
+	deeplinkDelay = time.Hour
+	history = &handoffHistory{nextID: 1}
+	recent = &recentPrompts{now: oldRecent.now}
+	noHistory = false
+	t.Setenv("DISPLAY", ":0")
+	t.Setenv("SSH_CONNECTION", "")
+	t.Setenv("SSH_TTY", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	res, err := handleHandoff(ctx, nil, &mcp.CallToolParamsFor[HandoffArgs]{
+		Name:      "handoff_to_chatgpt",
+		Arguments: HandoffArgs{Prompt: "wait for it"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.IsError {
+		t.Fatalf("cancelled handoff is an error: %v", res.Content)
+	}
+	r := res.StructuredContent.(*HandoffResult)
+	if r.DeeplinkStatus != "skipped-cancelled" || r.OpenedTarget != "" {
+		t.Errorf("deeplinkStatus %q, openedTarget %q; want skipped-cancelled and nothing opened", r.DeeplinkStatus, r.OpenedTarget)
+	}
+	if rec, ok := history.get(history.latestID()); !ok || rec.DeeplinkStatus != "skipped-cancelled" {
+		t.Errorf("latest history record = %+v, %v; want a skipped-cancelled handoff", rec, ok)
+	}
+}
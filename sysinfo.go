@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// SYSTEM_INFO_MAX_LINE and SYSTEM_INFO_MAX_BYTES cap a probe's value
+	// and the whole includeSystemInfo block.
+	SYSTEM_INFO_MAX_LINE  = 200
+	SYSTEM_INFO_MAX_BYTES = 2000
+)
+
+var (
+	// envProbeCommands are run for includeSystemInfo, e.g. "go version".
+	envProbeCommands []string
+	// disabledEnvProbes names probes to leave out: os, arch, runtime,
+	// locale, shell, or a probe command's program name.
+	disabledEnvProbes []string
+)
+
+// osVersion describes the operating system release, or "" if unknown.
+func osVersion(ctx context.Context) string {
+	switch runtime.GOOS {
+	case "linux":
+		f, err := os.Open("/etc/os-release")
+		if err != nil {
+			return ""
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			if v, ok := strings.CutPrefix(sc.Text(), "PRETTY_NAME="); ok {
+				return strings.Trim(v, `"'`)
+			}
+		}
+	case "darwin":
+		if out, err := probeCommand(ctx, "sw_vers -productVersion"); err == nil {
+			return "macOS " + out
+		}
+	case "windows":
+		if out, err := probeCommand(ctx, "cmd /c ver"); err == nil {
+			return out
+		}
+	}
+	return ""
+}
+
+// locale reports the locale from the usual environment variables.
+func locale() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// probeCommand runs command without a shell and returns the first line of
+// its output.
+func probeCommand(ctx context.Context, command string) (string, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return "", errors.New("empty command")
+	}
+	// Some tools print their version to stderr.
+	var out bytes.Buffer
+	cmd := exec.Command(fields[0], fields[1:]...)
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := runCommand(ctx, EXEC_SCALE_LOOKUP, cmd); err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(out.String()), "\n")
+	return strings.TrimSpace(line), nil
+}
+
+func clipLine(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+// systemInfo renders the includeSystemInfo block. Probes that fail show as
+// unavailable.
+func systemInfo(ctx context.Context) string {
+	enabled := func(name string) bool { return !slices.Contains(disabledEnvProbes, name) }
+
+	var lines []string
+	add := func(label, value string) {
+		if value == "" {
+			value = "unavailable"
+		}
+		lines = append(lines, "- "+label+": "+clipLine(value, SYSTEM_INFO_MAX_LINE))
+	}
+	if enabled("os") {
+		v := runtime.GOOS
+		if ver := osVersion(ctx); ver != "" {
+			v += " (" + ver + ")"
+		}
+		add("OS", v)
+	}
+	if enabled("arch") {
+		add("Architecture", runtime.GOARCH)
+	}
+	if enabled("runtime") {
+		add("Server Go runtime", runtime.Version())
+	}
+	if enabled("locale") {
+		add("Locale", locale())
+	}
+	if enabled("shell") {
+		shell := os.Getenv("SHELL")
+		if shell == "" && runtime.GOOS == "windows" {
+			shell = os.Getenv("ComSpec")
+		}
+		add("Shell", shell)
+	}
+	for _, command := range envProbeCommands {
+		if !enabled(strings.Fields(command)[0]) {
+			continue
+		}
+		out, _ := probeCommand(ctx, command)
+		add(command, out)
+	}
+
+	var b strings.Builder
+	b.WriteString("\n\nSystem info:")
+	for _, line := range lines {
+		if b.Len()+len(line)+1 > SYSTEM_INFO_MAX_BYTES {
+			b.WriteString("\n- …")
+			break
+		}
+		b.WriteString("\n" + line)
+	}
+	return b.String()
+}
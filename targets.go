@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const DEFAULT_TARGET = "chatgpt"
+
+// handoffTarget describes an assistant that accepts a prefilled prompt via
+// its URL.
+type handoffTarget struct {
+	Name    string
+	Label   string
+	BaseURL func() string
+	// MaxLength is the longest URL the target reliably accepts. Zero means
+	// the target follows --max-deeplink-length.
+	MaxLength int
+	// Params lists the optional deeplink parameters the target honors;
+	// others are silently left off its URLs.
+	Params []string
+	// DesktopApp is set for targets the ChatGPT desktop app can open.
+	DesktopApp bool
+	// Strengths tells agents what to hand this target when it has its own
+	// tool (--tools).
+	Strengths string
+}
+
+// handoffTargets is the registry of supported targets, in the order they
+// are presented to agents.
+var handoffTargets = []handoffTarget{
+	{
+		Name:       "chatgpt",
+		Label:      "ChatGPT",
+		BaseURL:    func() string { return chatgptURL },
+		Params:     []string{"model", "temporary-chat", "hints"},
+		DesktopApp: true,
+		Strengths:  "a GPT-5 thinking model with browsing; best for deep research and hard debugging",
+	},
+	{
+		Name:      "claude",
+		Label:     "Claude",
+		BaseURL:   func() string { return "https://claude.ai/new" },
+		MaxLength: 1800,
+		Strengths: "long documents, careful writing and code review",
+	},
+	{
+		Name:      "gemini",
+		Label:     "Google Gemini",
+		BaseURL:   func() string { return "https://gemini.google.com/app" },
+		MaxLength: 1800,
+		Strengths: "questions about Google products, multimodal input and very long context",
+	},
+	{
+		Name:      "perplexity",
+		Label:     "Perplexity",
+		BaseURL:   func() string { return "https://www.perplexity.ai/search" },
+		MaxLength: 1800,
+		Strengths: "quick web research with cited sources and current events",
+	},
+	{
+		Name:      "grok",
+		Label:     "Grok",
+		BaseURL:   func() string { return "https://grok.com/" },
+		MaxLength: 1800,
+		Strengths: "real-time news and discussion on X",
+	},
+}
+
+func targetNames() []string {
+	var names []string
+	for _, t := range handoffTargets {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+func lookupTarget(name string) (handoffTarget, error) {
+	for _, t := range handoffTargets {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return handoffTarget{}, fmt.Errorf("invalid params: unknown target %q (valid targets: %s)", name, strings.Join(targetNames(), ", "))
+}
+
+// toolDescription describes the handoff_to_<name> tool generated for t.
+func (t handoffTarget) toolDescription() string {
+	return "Hand off a prompt to " + t.Label + ": " + t.Strengths + ". Write a detailed, self-contained prompt with all the context it needs; it is copied to the user's clipboard and " + t.Label +
+		" opens with it prefilled when possible. After sending, stop and wait for the user to relay " + t.Label + "'s response back to you. Pass a short title to label the handoff in the history."
+}
+
+func (t handoffTarget) maxLength(ctx context.Context) int {
+	limit := settingsFor(ctx).MaxDeeplinkLength
+	if t.MaxLength == 0 {
+		return limit
+	}
+	return min(t.MaxLength, limit)
+}
+
+// filterParams drops deeplink parameters the target does not understand.
+func (t handoffTarget) filterParams(params []deeplinkParam) []deeplinkParam {
+	var kept []deeplinkParam
+	for _, p := range params {
+		if slices.Contains(t.Params, p.Key) {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
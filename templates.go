@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// TEMPLATES_POLL_INTERVAL is how often the templates directory is checked
+// for changed files.
+const TEMPLATES_POLL_INTERVAL = 2 * time.Second
+
+// promptTemplate is a prompt skeleton with {{variable}} placeholders. Every
+// placeholder without a default is required. When Variables is set the
+// template is strict and rejects any variable not listed there.
+type promptTemplate struct {
+	Name        string            `json:"-"`
+	Description string            `json:"description,omitempty"`
+	Prompt      string            `json:"prompt"`
+	Variables   []string          `json:"variables,omitempty"`
+	Defaults    map[string]string `json:"defaults,omitempty"`
+	// Descriptions document the variables for prompts/list.
+	Descriptions map[string]string `json:"descriptions,omitempty"`
+}
+
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// builtinTemplates ship with the server; a file of the same name in the
+// templates directory replaces them.
+var builtinTemplates = []promptTemplate{
+	{
+		Name:        "debug",
+		Description: "find the root cause of a bug",
+		Prompt: `I'm debugging a problem and would like help finding the root cause.
+
+Problem: {{problem}}
+
+Expected behavior: {{expected}}
+
+Relevant code, logs and error messages:
+
+{{context}}
+
+What I've tried so far: {{tried}}
+
+Explain the most likely causes, how to confirm each one, and the fix you recommend.`,
+		Variables: []string{"problem", "context", "expected", "tried"},
+		Defaults: map[string]string{
+			"expected": "not stated",
+			"tried":    "nothing yet",
+		},
+		Descriptions: map[string]string{
+			"problem":  "what goes wrong",
+			"context":  "relevant code, logs and error messages",
+			"expected": "what should happen instead",
+			"tried":    "what has already been ruled out",
+		},
+	},
+	{
+		Name:        "research",
+		Description: "research a topic with sources",
+		Prompt: `Research the following topic: {{topic}}
+
+Focus on: {{focus}}
+
+Time frame: {{timeframe}}
+
+Cite your sources, point out where they disagree, and finish with a short summary of the key findings.`,
+		Variables: []string{"topic", "focus", "timeframe"},
+		Defaults: map[string]string{
+			"focus":     "the most important and practical aspects",
+			"timeframe": "the most recent information available",
+		},
+		Descriptions: map[string]string{
+			"topic":     "what to research",
+			"focus":     "aspects to concentrate on",
+			"timeframe": "period the research should cover, e.g. 2024-2025",
+		},
+	},
+}
+
+var (
+	// templatesDir is --templates-dir; empty means the default location.
+	templatesDir = ""
+
+	templatesMu sync.RWMutex
+	templates   = map[string]promptTemplate{}
+)
+
+// loadTemplates replaces the template set with the built-ins plus the
+// templates in dir. A name.json file holds a promptTemplate; a name.md or
+// name.txt file is the prompt, optionally preceded by front-matter (see
+// parseFrontMatter), and without front-matter every placeholder is
+// required. Files that fail to parse are skipped with a warning. A missing
+// dir is only an error when it was given explicitly.
+func loadTemplates(dir string, explicit bool) error {
+	loaded := map[string]promptTemplate{}
+	for _, t := range builtinTemplates {
+		loaded[t.Name] = t
+	}
+	defer func() {
+		templatesMu.Lock()
+		templates = loaded
+		templatesMu.Unlock()
+	}()
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".json" && ext != ".md" && ext != ".txt") {
+			continue
+		}
+		t, err := readTemplateFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			slog.Warn("skipping prompt template", "file", e.Name(), "error", err)
+			continue
+		}
+		loaded[t.Name] = t
+	}
+	return nil
+}
+
+func readTemplateFile(path string) (promptTemplate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return promptTemplate{}, err
+	}
+	ext := filepath.Ext(path)
+	t := promptTemplate{Name: strings.TrimSuffix(filepath.Base(path), ext)}
+	if ext == ".json" {
+		if err := json.Unmarshal(data, &t); err != nil {
+			return promptTemplate{}, err
+		}
+	} else if t, err = parseFrontMatter(t, string(data)); err != nil {
+		return promptTemplate{}, err
+	}
+	if err := t.validate(); err != nil {
+		return promptTemplate{}, err
+	}
+	return t, nil
+}
+
+// parseFrontMatter fills t from a Markdown template. Front-matter is the
+// small YAML subset templates need:
+//
+//	---
+//	name: review
+//	description: Review a diff
+//	arguments:
+//	  - name: diff
+//	    description: the diff to review
+//	    required: true
+//	  - name: lang
+//	    default: Go
+//	---
+//
+// Declared arguments make the template strict; they are optional unless
+// marked required.
+func parseFrontMatter(t promptTemplate, data string) (promptTemplate, error) {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	rest, ok := strings.CutPrefix(data, "---\n")
+	if !ok {
+		t.Prompt = data
+		return t, nil
+	}
+	header, body, ok := strings.Cut(rest, "\n---\n")
+	if !ok {
+		if header, ok = strings.CutSuffix(rest, "\n---"); !ok {
+			return t, errors.New("front-matter is not closed with ---")
+		}
+	}
+	t.Prompt = strings.TrimPrefix(body, "\n")
+
+	inArguments := false
+	arg := ""
+	for n, line := range strings.Split(header, "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		indented := line[0] == ' ' || line[0] == '\t'
+		text := strings.TrimSpace(line)
+		item := false
+		if inArguments && indented {
+			text, item = strings.CutPrefix(text, "- ")
+		}
+		key, value, ok := strings.Cut(text, ":")
+		if !ok {
+			return t, fmt.Errorf("front-matter line %d: expected key: value", n+2)
+		}
+		key, value = strings.TrimSpace(key), unquoteYAML(strings.TrimSpace(value))
+
+		if !indented {
+			inArguments = false
+			switch key {
+			case "name":
+				t.Name = value
+			case "description":
+				t.Description = value
+			case "arguments":
+				if value != "" && value != "[]" {
+					return t, fmt.Errorf("front-matter line %d: arguments must be a list", n+2)
+				}
+				inArguments, t.Variables = true, []string{}
+			default:
+				return t, fmt.Errorf("front-matter line %d: unknown key %q", n+2, key)
+			}
+			continue
+		}
+		if !inArguments {
+			return t, fmt.Errorf("front-matter line %d: unexpected indentation", n+2)
+		}
+		if item {
+			if key != "name" || value == "" {
+				return t, fmt.Errorf("front-matter line %d: each argument must start with name", n+2)
+			}
+			arg = value
+			t.Variables = append(t.Variables, arg)
+			if t.Defaults == nil {
+				t.Defaults = map[string]string{}
+			}
+			// Optional until marked required.
+			t.Defaults[arg] = ""
+			continue
+		}
+		if arg == "" {
+			return t, fmt.Errorf("front-matter line %d: expected - name: under arguments", n+2)
+		}
+		switch key {
+		case "description":
+			if t.Descriptions == nil {
+				t.Descriptions = map[string]string{}
+			}
+			t.Descriptions[arg] = value
+		case "required":
+			required, err := strconv.ParseBool(value)
+			if err != nil {
+				return t, fmt.Errorf("front-matter line %d: required must be true or false", n+2)
+			}
+			if required {
+				delete(t.Defaults, arg)
+			}
+		case "default":
+			t.Defaults[arg] = value
+		default:
+			return t, fmt.Errorf("front-matter line %d: unknown argument key %q", n+2, key)
+		}
+	}
+	return t, nil
+}
+
+func unquoteYAML(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		if s[0] == '"' {
+			if u, err := strconv.Unquote(s); err == nil {
+				return u
+			}
+		}
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func (t promptTemplate) validate() error {
+	if strings.TrimSpace(t.Name) == "" {
+		return errors.New("template name is empty")
+	}
+	if strings.TrimSpace(t.Prompt) == "" {
+		return errors.New("template prompt is empty")
+	}
+	if t.Variables == nil {
+		return nil
+	}
+	for _, name := range t.placeholders() {
+		if !slices.Contains(t.Variables, name) {
+			return fmt.Errorf("placeholder {{%s}} is not in the template's variables", name)
+		}
+	}
+	return nil
+}
+
+// placeholders lists the distinct placeholder names in order of first use.
+func (t promptTemplate) placeholders() []string {
+	var names []string
+	for _, m := range placeholderPattern.FindAllStringSubmatch(t.Prompt, -1) {
+		if !slices.Contains(names, m[1]) {
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// variables are the declared variables of a strict template, or else its
+// placeholders.
+func (t promptTemplate) variables() []string {
+	if t.Variables != nil {
+		return t.Variables
+	}
+	return t.placeholders()
+}
+
+// render substitutes vars into the template in a single pass, so values
+// that themselves contain {{...}} are left alone.
+func (t promptTemplate) render(vars map[string]string) (string, error) {
+	if t.Variables != nil {
+		for _, name := range slices.Sorted(maps.Keys(vars)) {
+			if !slices.Contains(t.Variables, name) {
+				return "", fmt.Errorf("template %s has no variable %q (variables: %s)", t.Name, name, strings.Join(t.Variables, ", "))
+			}
+		}
+	}
+	var missing []string
+	for _, name := range t.variables() {
+		if _, ok := t.Defaults[name]; !ok && strings.TrimSpace(vars[name]) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("template %s is missing required variables: %s", t.Name, strings.Join(missing, ", "))
+	}
+	return placeholderPattern.ReplaceAllStringFunc(t.Prompt, func(s string) string {
+		name := placeholderPattern.FindStringSubmatch(s)[1]
+		if v := vars[name]; strings.TrimSpace(v) != "" {
+			return v
+		}
+		return t.Defaults[name]
+	}), nil
+}
+
+func lookupTemplate(name string) (promptTemplate, error) {
+	templatesMu.RLock()
+	t, ok := templates[name]
+	templatesMu.RUnlock()
+	if !ok {
+		return promptTemplate{}, fmt.Errorf("unknown template %q (available: %s)", name, strings.Join(templateNames(), ", "))
+	}
+	return t, nil
+}
+
+func templateNames() []string {
+	templatesMu.RLock()
+	defer templatesMu.RUnlock()
+	return slices.Sorted(maps.Keys(templates))
+}
+
+// templateHint is the sentence advertising the templates in the handoff
+// tool descriptions.
+func templateHint() string {
+	var parts []string
+	for _, name := range templateNames() {
+		t, err := lookupTemplate(name)
+		if err != nil {
+			continue
+		}
+		part := name
+		if vars := t.variables(); len(vars) > 0 {
+			part += " (" + strings.Join(vars, ", ") + ")"
+		}
+		if t.Description != "" {
+			part += ": " + t.Description
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "Instead of prompt, you can pass template and variables to fill in one of these prompt templates: " + strings.Join(parts, "; ") + "."
+}
+
+// registeredPrompts are the template prompts currently on the server.
+var registeredPrompts []string
+
+// registerTemplatePrompts exposes every template as an MCP prompt, replacing
+// the previous set. The SDK notifies clients of the changed prompt list.
+func registerTemplatePrompts(srv *mcp.Server) {
+	srv.RemovePrompts(registeredPrompts...)
+	registeredPrompts = nil
+	for _, name := range templateNames() {
+		t, err := lookupTemplate(name)
+		if err != nil {
+			continue
+		}
+		prompt := &mcp.Prompt{Name: t.Name, Description: t.Description}
+		for _, v := range t.variables() {
+			_, optional := t.Defaults[v]
+			prompt.Arguments = append(prompt.Arguments, &mcp.PromptArgument{
+				Name:        v,
+				Description: t.Descriptions[v],
+				Required:    !optional,
+			})
+		}
+		srv.AddPrompt(prompt, handleTemplatePrompt)
+		registeredPrompts = append(registeredPrompts, t.Name)
+	}
+}
+
+func handleTemplatePrompt(ctx context.Context, ss *mcp.ServerSession, params *mcp.GetPromptParams) (*mcp.GetPromptResult, error) {
+	t, err := lookupTemplate(params.Name)
+	if err != nil {
+		return nil, err
+	}
+	text, err := t.render(params.Arguments)
+	if err != nil {
+		return nil, err
+	}
+	return &mcp.GetPromptResult{
+		Description: t.Description,
+		Messages:    []*mcp.PromptMessage{{Role: "user", Content: &mcp.TextContent{Text: text}}},
+	}, nil
+}
+
+// templatesDirState summarizes the template files' names, sizes and
+// modification times, so a change to any of them shows up as a new value.
+func templatesDirState(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil {
+			fmt.Fprintf(&b, "%s %d %d\n", e.Name(), info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return b.String()
+}
+
+// watchTemplates reloads the templates when a file in dir changes, then
+// re-registers the prompts and the handoff tools whose descriptions list
+// them. SIGHUP reloads them too (watchSIGHUP).
+func watchTemplates(srv *mcp.Server, dir string, explicit bool) {
+	ticker := time.NewTicker(TEMPLATES_POLL_INTERVAL)
+	state := templatesDirState(dir)
+	go func() {
+		for range ticker.C {
+			s := templatesDirState(dir)
+			if s == state {
+				continue
+			}
+			state = s
+			slog.Debug("prompt templates changed", "dir", dir)
+			if err := loadTemplates(dir, explicit); err != nil {
+				slog.Warn("failed to reload prompt templates", "dir", dir, "error", err)
+			}
+			reregister(srv)
+		}
+	}()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// DEFAULT_TOKEN_WARNING is the default --token-warning: prompts estimated
+// above this many tokens get a warning to tighten them.
+const DEFAULT_TOKEN_WARNING = 25000
+
+var tokenWarning = DEFAULT_TOKEN_WARNING
+
+// estimateTokens guesses how many tokens text takes up. It averages the
+// usual four characters per token with four tokens per three words, which
+// keeps code and dense prose from skewing the estimate either way.
+func estimateTokens(text string) int {
+	chars := utf8.RuneCountInString(text)
+	if chars == 0 {
+		return 0
+	}
+	words := len(strings.Fields(text))
+	byChars := (chars + 3) / 4
+	byWords := (words*4 + 2) / 3
+	return max((byChars+byWords+1)/2, 1)
+}
+
+// promptSizeWarning returns the warning for a prompt of the given
+// estimated size, or "" when it is under --token-warning.
+func promptSizeWarning(ctx context.Context, tokens int) string {
+	threshold := settingsFor(ctx).TokenWarning
+	if threshold <= 0 || tokens <= threshold {
+		return ""
+	}
+	return fmt.Sprintf("Warning: this prompt is about %d tokens, over the %d token warning threshold. Consider tightening it: summarize context and attach only the relevant lines.", tokens, threshold)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{"empty", "", 0},
+		{"whitespace", "   \n", 1},
+		{"one letter", "a", 2},
+		{"prose", "The quick brown fox jumps over the lazy dog.", 12},
+		{"long prose", strings.Repeat("word ", 1000), 1292},
+		{"code", "func main() {\n\tfmt.Println(\"hi\")\n}\n", 8},
+		// Characters, not bytes: each of these is three bytes in UTF-8.
+		{"CJK", "你好世界，这是一个测试。", 3},
+	}
+	for _, tt := range tests {
+		if got := estimateTokens(tt.text); got != tt.want {
+			t.Errorf("%s: estimateTokens = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPromptSizeWarning(t *testing.T) {
+	tests := []struct {
+		threshold, tokens int
+		warn              bool
+	}{
+		{100, 99, false},
+		{100, 100, false},
+		{100, 101, true},
+		{0, 1 << 20, false},
+		{-1, 1 << 20, false},
+	}
+	for _, tt := range tests {
+		ctx := context.WithValue(context.Background(), settingsKey{}, &reloadableSettings{TokenWarning: tt.threshold})
+		got := promptSizeWarning(ctx, tt.tokens)
+		if (got != "") != tt.warn {
+			t.Errorf("threshold %d, %d tokens: warning %q, want one: %v", tt.threshold, tt.tokens, got, tt.warn)
+		}
+		if tt.warn && !strings.Contains(got, "about 101 tokens, over the 100 token warning threshold") {
+			t.Errorf("warning = %q", got)
+		}
+	}
+}
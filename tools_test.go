@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares v, as indented JSON, with testdata/name.
+func checkGolden(t *testing.T, name string, v any) {
+	t.Helper()
+	got, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s differs from %s; run go test -update and review the diff:\n%s", name, path, got)
+	}
+}
+
+// serverTools returns a new server's tools/list.
+func serverTools(t *testing.T) []*mcp.Tool {
+	t.Helper()
+	tools, err := listTools(buildServer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tools
+}
+
+// withBuiltinTemplates gives the tool descriptions the built-in templates
+// and nothing from the user's template directory.
+func withBuiltinTemplates(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		templatesMu.Lock()
+		templates = map[string]promptTemplate{}
+		templatesMu.Unlock()
+	})
+	if err := loadTemplates(t.TempDir(), true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestToolsList(t *testing.T) {
+	withBuiltinTemplates(t)
+	old := toolVariants
+	t.Cleanup(func() { toolVariants = old })
+
+	toolVariants = false
+	tools := serverTools(t)
+	checkGolden(t, "tools_list.golden", tools)
+
+	// --tool-variants adds the variants and changes nothing else.
+	toolVariants = true
+	byName := map[string]*mcp.Tool{}
+	for _, tool := range serverTools(t) {
+		byName[tool.Name] = tool
+	}
+	for _, tool := range tools {
+		if _, ok := byName[tool.Name]; !ok {
+			t.Errorf("%s is missing with --tool-variants", tool.Name)
+		}
+		delete(byName, tool.Name)
+	}
+	for _, v := range handoffVariants {
+		tool, ok := byName[v.Tool]
+		if !ok {
+			t.Errorf("%s is missing with --tool-variants", v.Tool)
+			continue
+		}
+		checkGolden(t, v.Tool+".golden", tool)
+		delete(byName, v.Tool)
+	}
+	for name := range byName {
+		t.Errorf("unexpected tool %s with --tool-variants", name)
+	}
+}
+
+func TestHandoffUnknownTarget(t *testing.T) {
+	captureLog(t)
+	var target *jsonschema.Schema
+	for _, tool := range serverTools(t) {
+		if tool.Name == "handoff_to_chatgpt" {
+			target = tool.InputSchema.Properties["target"]
+		}
+	}
+	if target == nil || len(target.Enum) != len(handoffTargets) {
+		t.Fatalf("target schema = %+v, want an enum of %v", target, targetNames())
+	}
+
+	ctx := context.Background()
+	clientTransport, serverTransport := mcp.NewInMemoryTransports()
+	ss, err := buildServer().Connect(ctx, serverTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	cs, err := mcp.NewClient(&mcp.Implementation{Name: "test"}, nil).Connect(ctx, clientTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	_, err = cs.CallTool(ctx, &mcp.CallToolParams{
+		Name:      "handoff_to_chatgpt",
+		Arguments: map[string]any{"prompt": "hi", "target": "bard"},
+	})
+	if err == nil || !strings.Contains(err.Error(), `unknown target "bard"`) {
+		t.Fatalf("err = %v, want an unknown target error", err)
+	}
+	// The SDK's error type is internal; find its code by name.
+	code := int64(0)
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if v := reflect.ValueOf(e); v.Kind() == reflect.Pointer && v.Elem().Kind() == reflect.Struct {
+			if f := v.Elem().FieldByName("Code"); f.IsValid() {
+				code = f.Int()
+			}
+		}
+	}
+	if code != CODE_INVALID_PARAMS {
+		t.Errorf("error code = %d, want %d", code, CODE_INVALID_PARAMS)
+	}
+}
+
+func TestReregisterConcurrently(t *testing.T) {
+	withBuiltinTemplates(t)
+	srv := buildServer()
+	names := func() []string {
+		tools, err := listTools(srv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, tool := range tools {
+			names = append(names, tool.Name)
+		}
+		return names
+	}
+	want := names()
+
+	// The template watcher and the SIGHUP handler may fire together.
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reregister(srv)
+		}()
+	}
+	wg.Wait()
+	if got := names(); !slices.Equal(got, want) {
+		t.Errorf("tools after concurrent reloads = %v, want %v", got, want)
+	}
+}
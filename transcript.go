@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// TRANSCRIPT_MAX_SIZE is the size at which the transcript is renamed to a
+// dated file and a new one started.
+const TRANSCRIPT_MAX_SIZE = 5 << 20
+
+var (
+	// transcriptFile is the Markdown file handoffs and their responses are
+	// appended to (--transcript-file); "" disables the transcript.
+	transcriptFile = ""
+	// transcriptMu serializes appends within the process; lockFile
+	// serializes them across processes sharing the file.
+	transcriptMu sync.Mutex
+)
+
+// transcriptPath resolves --transcript-file: auto is transcript.md in the
+// data directory, and a relative path resolves against the client's first
+// root, or else the working directory.
+func transcriptPath(ctx context.Context, ss *mcp.ServerSession) (string, error) {
+	if transcriptFile == "auto" {
+		return defaultTranscriptFile()
+	}
+	if filepath.IsAbs(transcriptFile) {
+		return transcriptFile, nil
+	}
+	if roots := clientRoots(ctx, ss); len(roots) > 0 {
+		return filepath.Join(roots[0], transcriptFile), nil
+	}
+	return transcriptFile, nil
+}
+
+// transcriptHandoff formats the section for a new handoff. With
+// --no-history the id is 0 and the section has none.
+func transcriptHandoff(r HandoffRecord, label string) string {
+	var b strings.Builder
+	b.WriteString("## Handoff")
+	if r.ID != 0 {
+		fmt.Fprintf(&b, " %d", r.ID)
+	}
+	if r.Title != "" {
+		b.WriteString(": " + r.Title)
+	}
+	fmt.Fprintf(&b, "\n\n_%s, to %s_\n\n", r.Time.Format(time.RFC3339), label)
+	fence := markdownFence(r.Prompt)
+	fmt.Fprintf(&b, "%s\n%s\n%s\n\n", fence, strings.TrimRight(r.Prompt, "\n"), fence)
+	return b.String()
+}
+
+// transcriptResponse formats the section for a response recorded for
+// handoff id. The response is Markdown already, so it is not fenced.
+func transcriptResponse(id int, title string, revision int, resp HandoffResponse) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "### Response to handoff %d", id)
+	if title != "" {
+		b.WriteString(": " + title)
+	}
+	if revision > 1 {
+		fmt.Fprintf(&b, " (revision %d)", revision)
+	}
+	fmt.Fprintf(&b, "\n\n_%s_\n\n%s\n\n", resp.Time.Format(time.RFC3339), strings.TrimSpace(resp.Text))
+	return b.String()
+}
+
+// appendTranscript appends section to the transcript in one write, under a
+// file lock so concurrent sessions and servers don't interleave. A file
+// over TRANSCRIPT_MAX_SIZE is first renamed to a dated file.
+func appendTranscript(ctx context.Context, ss *mcp.ServerSession, section string) error {
+	path, err := transcriptPath(ctx, ss)
+	if err != nil {
+		return err
+	}
+	transcriptMu.Lock()
+	defer transcriptMu.Unlock()
+
+	if err := makeParentDir(path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	unlock, err := lockFile(f)
+	if err != nil {
+		return fmt.Errorf("failed to lock %s: %v", path, err)
+	}
+	defer unlock()
+
+	// Another process may have rolled the file over while we waited for
+	// the lock, in which case the file we opened is no longer at path.
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if current, err := os.Stat(path); err != nil || !os.SameFile(info, current) {
+		return appendFresh(path, section)
+	}
+	if info.Size() >= TRANSCRIPT_MAX_SIZE {
+		if err := rollTranscript(path); err != nil {
+			return err
+		}
+		return appendFresh(path, section)
+	}
+	_, err = f.WriteString(section)
+	return err
+}
+
+// rollTranscript renames a full transcript to <name>-YYYY-MM-DD<ext>,
+// adding -2, -3, ... when that file exists.
+func rollTranscript(path string) error {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext) + "-" + time.Now().Format("2006-01-02")
+	dated := base + ext
+	for n := 2; ; n++ {
+		if _, err := os.Stat(dated); os.IsNotExist(err) {
+			break
+		}
+		dated = fmt.Sprintf("%s-%d%s", base, n, ext)
+	}
+	return os.Rename(path, dated)
+}
+
+// appendFresh appends to the file at path after a rollover, locking the
+// new file.
+func appendFresh(path, section string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	unlock, err := lockFile(f)
+	if err != nil {
+		return fmt.Errorf("failed to lock %s: %v", path, err)
+	}
+	defer unlock()
+	_, err = f.WriteString(section)
+	return err
+}
+
+// transcribe appends sections to the transcript, if there is one, and
+// returns a warning for the tool result if that failed.
+func transcribe(ctx context.Context, ss *mcp.ServerSession, sections ...string) string {
+	if transcriptFile == "" {
+		return ""
+	}
+	if err := appendTranscript(ctx, ss, strings.Join(sections, "")); err != nil {
+		slog.Warn("failed to write transcript", "path", transcriptFile, "error", err)
+		return "the transcript wasn't updated: " + err.Error()
+	}
+	return ""
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modKernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = modKernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modKernel32.NewProc("UnlockFileEx")
+)
+
+const LOCKFILE_EXCLUSIVE_LOCK = 0x2
+
+// lockFile takes an exclusive lock on the first byte of f, waiting for
+// other holders.
+func lockFile(f *os.File) (func(), error) {
+	var ol syscall.Overlapped
+	r, _, err := procLockFileEx.Call(f.Fd(), LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r == 0 {
+		return nil, err
+	}
+	return func() {
+		var ol syscall.Overlapped
+		procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	}, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+// handoffVariant is a ChatGPT handoff tool whose description is tuned for
+// one kind of prompt (--tool-variants). Every variant shares the
+// handoff_to_chatgpt handler and schema.
+type handoffVariant struct {
+	Tool        string
+	Kind        string
+	Description string
+}
+
+// toolVariants enables the handoffVariants alongside the regular handoff
+// tools (--tool-variants).
+var toolVariants = false
+
+var handoffVariants = []handoffVariant{
+	{
+		Tool: "research_with_chatgpt",
+		Kind: "research",
+		Description: "Hand a research question off to ChatGPT, a GPT-5 thinking model that can browse the web. A good research prompt states the timeframe (e.g. \"2024 onwards\"), the kind of sources wanted (papers, official docs, benchmarks, news), and the scope: what is in and out, and how deep to go. Say what the answer is for and what form it should take, e.g. a comparison table or a ranked list with citations.\n\n" +
+			"Example: \"Research WebAssembly runtime performance improvements from 2024-2025. Use benchmark publications and official release notes; skip blog opinion pieces. Compare Wasmtime, Wasmer and WAMR on startup time and throughput, in a table with links.\"\n\n" +
+			"Pass a short title to label the handoff in the history. After calling this tool, stop and wait for the user to relay ChatGPT's response back to you.",
+	},
+	{
+		Tool: "debug_with_chatgpt",
+		Kind: "debugging",
+		Description: "Hand a debugging problem off to ChatGPT, a GPT-5 thinking model. ChatGPT can't see the code, so include everything it needs: the relevant code snippets (or attachments), the exact error messages and stack traces, the steps that reproduce the problem, what was expected instead, and what has already been tried. Mention the language, framework and versions involved.\n\n" +
+			"Example: \"This Go service leaks memory under load. Heap profile and the handler code are below. It reproduces with `hey -n 100000 http://localhost:8080/upload`; RSS grows by ~50MB per minute and never drops. We already ruled out the connection pool.\"\n\n" +
+			"Pass a short title to label the handoff in the history. After calling this tool, stop and wait for the user to relay ChatGPT's response back to you.",
+	},
+}
+
+// handoffKind is the kind of prompt a variant tool is for, or "" for the
+// regular handoff tools.
+func handoffKind(tool string) string {
+	for _, v := range handoffVariants {
+		if v.Tool == tool {
+			return v.Kind
+		}
+	}
+	return ""
+}
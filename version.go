@@ -0,0 +1,76 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// Build information, set by release builds with
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Anything left empty is filled from debug.ReadBuildInfo, which knows the
+// module version for go install builds and the VCS revision for builds
+// from a checkout.
+var (
+	version   = ""
+	commit    = ""
+	buildDate = ""
+)
+
+// DEV_VERSION is reported when neither -ldflags nor the module says more.
+const DEV_VERSION = "0.0.0-dev"
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+var buildInfo = sync.OnceValue(func() BuildInfo {
+	b := BuildInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		b.Version = cmp.Or(b.Version, DEV_VERSION)
+		return b
+	}
+	if v := info.Main.Version; b.Version == "" && v != "" && v != "(devel)" {
+		b.Version = strings.TrimPrefix(v, "v")
+	}
+	dirty := false
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Commit = cmp.Or(b.Commit, s.Value[:min(len(s.Value), 12)])
+		case "vcs.time":
+			b.BuildDate = cmp.Or(b.BuildDate, s.Value)
+		case "vcs.modified":
+			dirty = s.Value == "true"
+		}
+	}
+	if dirty && commit == "" && b.Commit != "" {
+		b.Commit += "-dirty"
+	}
+	b.Version = cmp.Or(b.Version, DEV_VERSION)
+	return b
+})
+
+// userAgent identifies the server on outbound requests.
+func userAgent() string {
+	return "chatgpt-handoff/" + buildInfo().Version
+}
+
+// printVersion writes the --version output.
+func printVersion(w io.Writer) {
+	b := buildInfo()
+	fmt.Fprintf(w, "chatgpt-handoff %s\n", b.Version)
+	fmt.Fprintf(w, "commit: %s\n", cmp.Or(b.Commit, "unknown"))
+	fmt.Fprintf(w, "built: %s\n", cmp.Or(b.BuildDate, "unknown"))
+	fmt.Fprintf(w, "go: %s\n", b.GoVersion)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// WEBHOOK_TIMEOUT bounds each webhook request; a failed or timed out
+	// request is retried once after WEBHOOK_RETRY_DELAY.
+	WEBHOOK_TIMEOUT     = 10 * time.Second
+	WEBHOOK_RETRY_DELAY = time.Second
+
+	// WEBHOOK_ERROR_BODY is how much of an error response is quoted.
+	WEBHOOK_ERROR_BODY = 300
+)
+
+var (
+	webhookURL = ""
+	// webhookFormat is json (a JSON object) or ntfy (the prompt as the
+	// body, with ntfy's Title, Click and Priority headers).
+	webhookFormat = "json"
+	// webhookHeaders are extra "Name: value" request headers
+	// (--webhook-header), e.g. for a Pushover or ntfy access token.
+	webhookHeaders []string
+	webhookToken   = ""
+	// webhookAlways posts every handoff to the webhook as well as
+	// delivering it as requested (--webhook-always).
+	webhookAlways = false
+)
+
+// WebhookPayload is the JSON body posted with --webhook-format json.
+type WebhookPayload struct {
+	Prompt    string    `json:"prompt"`
+	Title     string    `json:"title,omitempty"`
+	Deeplink  string    `json:"deeplink,omitempty"`
+	Target    string    `json:"target"`
+	Tags      []string  `json:"tags,omitempty"`
+	Priority  string    `json:"priority"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// validateWebhookHeader checks a --webhook-header value.
+func validateWebhookHeader(h string) error {
+	name, _, ok := strings.Cut(h, ":")
+	if !ok || strings.TrimSpace(name) == "" || strings.ContainsAny(name, " \t") {
+		return errors.New(`expected "Name: value"`)
+	}
+	return nil
+}
+
+// postWebhook delivers a handoff to --webhook-url.
+func postWebhook(ctx context.Context, p WebhookPayload) error {
+	var body []byte
+	header := http.Header{}
+	switch webhookFormat {
+	case "ntfy":
+		body = []byte(p.Prompt)
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+		if p.Title != "" {
+			header.Set("Title", p.Title)
+		}
+		if p.Deeplink != "" {
+			header.Set("Click", p.Deeplink)
+		}
+		header.Set("Priority", ntfyPriority(p.Priority))
+	default:
+		var err error
+		if body, err = json.Marshal(p); err != nil {
+			return err
+		}
+		header.Set("Content-Type", "application/json")
+	}
+	for _, h := range webhookHeaders {
+		name, value, _ := strings.Cut(h, ":")
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if webhookToken != "" {
+		header.Set("Authorization", "Bearer "+webhookToken)
+	}
+	_, err := postWithRetry(ctx, webhookURL, header, body)
+	return err
+}
+
+// postWithRetry POSTs body to rawURL and returns the response body of a
+// 2xx response. Network errors, 429 and 5xx responses are retried once.
+func postWithRetry(ctx context.Context, rawURL string, header http.Header, body []byte) ([]byte, error) {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if attempt > 0 {
+			slog.Debug("retrying webhook", "host", urlHost(rawURL), "error", err)
+			if err := sleepContext(ctx, WEBHOOK_RETRY_DELAY); err != nil {
+				return nil, err
+			}
+		}
+		var resp []byte
+		var retry bool
+		resp, retry, err = postOnce(ctx, rawURL, header, body)
+		if err == nil || !retry {
+			return resp, err
+		}
+	}
+	return nil, err
+}
+
+func postOnce(ctx context.Context, rawURL string, header http.Header, body []byte) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, WEBHOOK_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header = header.Clone()
+	req.Header.Set("User-Agent", userAgent())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// Don't leak a secret in the URL (e.g. a Slack webhook path).
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		msg := clipLine(strings.TrimSpace(string(data)), WEBHOOK_ERROR_BODY)
+		err := fmt.Errorf("%s responded %s", urlHost(rawURL), resp.Status)
+		if msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+	}
+	return data, false, nil
+}